@@ -1,19 +1,31 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/bernardoms/user-api/config"
 	_ "github.com/bernardoms/user-api/docs"
+	"github.com/bernardoms/user-api/internal/cache"
+	"github.com/bernardoms/user-api/internal/event"
 	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/job"
 	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/notify"
+	"github.com/bernardoms/user-api/internal/observability"
+	"github.com/bernardoms/user-api/internal/purge"
+	"github.com/bernardoms/user-api/internal/replay"
 	"github.com/bernardoms/user-api/internal/repository"
 	"github.com/gorilla/mux"
-	newrelic "github.com/newrelic/go-agent"
-	"github.com/newrelic/go-agent/_integrations/nrgorilla/v1"
 	"github.com/swaggo/http-swagger"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // @title User Swagger API
@@ -22,47 +34,284 @@ import (
 // @termsOfService http://swagger.io/terms/
 // @BasePath /v1
 func main() {
-	app, errNewRelic := newrelic.NewApplication(
-		newrelic.NewConfig(os.Getenv("NEWRELIC_APP"), os.Getenv("NEWRELIC_LICENSE")),
-	)
+	logging, err := logger.New(config.NewLoggerConfig())
 
-	if errNewRelic != nil {
-		log.Print("Error in new relic agent")
+	if err != nil {
+		log.Fatal("error configuring logger ", err)
+	}
+
+	apm := observability.New(config.NewObservabilityConfig(), logging)
+
+	events, err := event.NewEncoder(config.NewEventsConfig())
+
+	if err != nil {
+		log.Fatal("error configuring events ", err)
+	}
+
+	snsHandler, err := handler.NewSNS(config.NewSnsConfig(), events, logging)
+
+	if err != nil {
+		log.Fatal("error configuring sns ", err)
 	}
 
-	logging := logger.ConfigureLogger()
+	notifier, err := initNotifier(config.NewNotifyConfig(), snsHandler, events, logging)
 
-	snsHandler := handler.NewSNS(config.NewSnsConfig(), logging)
+	if err != nil {
+		log.Fatal("error configuring notify sinks ", err)
+	}
+
+	mongoConfig := config.NewMongoConfig()
+
+	userMongo := initUserMongoCollection(mongoConfig)
+
+	var userRepository repository.UserRepository = userMongo
+
+	adminConfig := config.NewAdminConfig()
+
+	adminHandler := handler.AdminHandler{Logger: logging}
+
+	userCache := initUserCache(config.NewCacheConfig(), userRepository)
+
+	if userCache != nil {
+		userRepository = userCache
+		adminHandler.Cache = userCache
+	}
 
 	userHandler := handler.UserHandler{
-		Repository:    initUserMongoCollection(),
-		NotifyHandler: snsHandler,
-		Logger:        logging}
+		Repository:    userRepository,
+		NotifyHandler: notifier,
+		Logger:        logging,
+		Audit:         repository.MongoAudit{Collection: repository.GetAuditCollection(mongoConfig)},
+		AdminToken:    adminConfig.Token,
+		Import:        config.NewImportConfig(),
+		Replay:        config.NewReplayConfig()}
+
+	if userCache != nil {
+		userHandler.Cache = userCache
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayCommand(userHandler.Replayer(), os.Args[2:])
+		return
+	}
+
+	background, stopBackground := context.WithCancel(context.Background())
+
+	startPurge(background, config.NewRetentionConfig(), userMongo, logging)
+
+	jobs := repository.MongoJobs{Collection: repository.GetJobCollection(mongoConfig)}
+	jobFiles := repository.GetJobFiles(mongoConfig)
+
+	pool := job.NewPool(jobs, userHandler.JobRunners(jobFiles), config.NewJobsConfig(), logging)
+	poolStopped := make(chan struct{})
+	go func() {
+		pool.Run(background)
+		close(poolStopped)
+	}()
+
+	eventHandler := handler.EventHandler{Events: events, Logger: logging}
+
+	jobHandler := handler.JobHandler{Queue: pool, Repository: jobs, Files: jobFiles, Users: &userHandler, Logger: logging}
 
 	r := mux.NewRouter()
+	r.Use(handler.WithRequestID, handler.WithPrincipal)
 
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 	r.HandleFunc("/v1/users", userHandler.GetAllUsers).Methods("GET")
 	r.HandleFunc("/v1/users", userHandler.SaveUser).Methods("POST")
+	r.HandleFunc("/v1/users:batch", userHandler.BatchUsers).Methods("POST")
+	r.HandleFunc("/v1/users/search", userHandler.SearchUsers).Methods("GET")
+	r.HandleFunc("/v1/users/export", userHandler.ExportUsers).Methods("GET")
+	r.HandleFunc("/v1/users/import", userHandler.ImportUsers).Methods("POST")
+	r.HandleFunc("/v1/users/id/{id}", userHandler.GetUserById).Methods("GET")
+	r.HandleFunc("/v1/users/id/{id}", userHandler.UpdateUserById).Methods("PUT")
+	r.HandleFunc("/v1/users/id/{id}", userHandler.PatchUserById).Methods("PATCH")
+	r.HandleFunc("/v1/users/id/{id}", userHandler.DeleteUserById).Methods("DELETE")
+	r.HandleFunc("/v1/users/{nickname}:restore", userHandler.RestoreUser).Methods("POST")
 	r.HandleFunc("/v1/users/{nickname}", userHandler.GetUserByNickname).Methods("GET")
 	r.HandleFunc("/v1/users/{nickname}", userHandler.DeleteUserByNickname).Methods("DELETE")
 	r.HandleFunc("/v1/users/{nickname}", userHandler.UpdateUser).Methods("PUT")
+	r.HandleFunc("/v1/users/{nickname}/history", userHandler.GetUserHistory).Methods("GET")
+	r.HandleFunc("/v1/jobs/import", jobHandler.SubmitImportJob).Methods("POST")
+	r.HandleFunc("/v1/jobs/export", jobHandler.SubmitExportJob).Methods("POST")
+	r.HandleFunc("/v1/jobs/update", jobHandler.SubmitUpdateJob).Methods("POST")
+	r.HandleFunc("/v1/jobs/replay", jobHandler.SubmitReplayJob).Methods("POST")
+	r.HandleFunc("/v1/jobs/{id}:cancel", jobHandler.CancelJob).Methods("POST")
+	r.HandleFunc("/v1/jobs/{id}/result", jobHandler.GetJobResult).Methods("GET")
+	r.HandleFunc("/v1/jobs/{id}", jobHandler.GetJob).Methods("GET")
+	r.HandleFunc("/v1/events/schemas", eventHandler.GetEventSchemas).Methods("GET")
+	r.HandleFunc("/v1/events/schemas/{name}/{version}", eventHandler.GetEventSchema).Methods("GET")
 
-	nrgorilla.InstrumentRoutes(r, app)
+	admin := r.PathPrefix("/v1/admin").Subrouter()
+	admin.Use(handler.AdminAuth(adminConfig.Token))
+	admin.HandleFunc("/log-level", adminHandler.GetLogLevel).Methods("GET")
+	admin.HandleFunc("/log-level", adminHandler.SetLogLevel).Methods("PUT")
+	admin.HandleFunc("/cache/stats", adminHandler.GetCacheStats).Methods("GET")
+
+	apm.Instrument(r)
 
 	fmt.Printf("running server on %d", 8080)
 
-	err := http.ListenAndServe(":8080", r)
+	server := &http.Server{Addr: ":8080", Handler: r}
+	stopped := make(chan struct{})
+	go func() {
+		shutdownOnSignal(server, apm, stopBackground, poolStopped)
+		close(stopped)
+	}()
 
-	if err != nil {
+	err = server.ListenAndServe()
+
+	if err != http.ErrServerClosed {
 		fmt.Printf("error to open port %s with error %s", "8080", err)
+		_ = apm.Shutdown(context.Background())
+		return
+	}
+
+	<-stopped
+}
+
+// shutdownOnSignal stops the server on SIGINT or SIGTERM, letting the requests in flight end. It then stops the
+// background work and waits for the job pool to release the jobs it runs, for another instance to resume them right
+// away, and flushes the spans the APM provider still buffers.
+func shutdownOnSignal(server *http.Server, apm observability.Provider, stopBackground context.CancelFunc, poolStopped <-chan struct{}) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Print("error shutting down the server ", err)
+	}
+
+	stopBackground()
+
+	select {
+	case <-poolStopped:
+	case <-ctx.Done():
+		log.Print("jobs still running at shutdown, they are resumed once their lease expires")
 	}
 
+	if err := apm.Shutdown(ctx); err != nil {
+		log.Print("error shutting down the observability provider ", err)
+	}
 }
 
-func initUserMongoCollection() repository.Mongo {
-	c := config.NewMongoConfig()
+func initUserMongoCollection(c *config.MongoConfig) repository.Mongo {
 	repository.New(c)
 	mongo := repository.Mongo{Collection: repository.GetUserCollection(c)}
+	if err := repository.EnsureNicknameIndex(mongo.Collection); err != nil {
+		log.Fatal("error creating the unique nickname index on users ", err)
+	}
 	return mongo
 }
+
+// startPurge removes deleted users after the retention, with a background job or a mongo TTL index.
+func startPurge(ctx context.Context, c *config.RetentionConfig, userMongo repository.Mongo, logging *logger.Logger) {
+	switch c.PurgeMode {
+	case config.PurgeModeJob:
+		p := purge.Purger{Repository: userMongo, Retention: c.Retention, Interval: c.PurgeInterval, Logger: logging}
+		go p.Run(ctx)
+	case config.PurgeModeTTLIndex:
+		if err := repository.EnsurePurgeIndex(userMongo.Collection, c.Retention); err != nil {
+			log.Print("Error creating purge index on users ", err)
+		}
+	}
+}
+
+// initNotifier builds the sinks notified of the changes. SNS alone, the default, is used as it is, otherwise they
+// are published to together by a fan-out.
+func initNotifier(c *config.NotifyConfig, sns handler.NotifyInterface, events *event.Encoder, logging *logger.Logger) (handler.NotifyInterface, error) {
+	if len(c.Sinks) == 1 && c.Sinks[0] == "sns" && c.Policies["sns"] == config.NotifyRequired && c.Retries["sns"] == 0 {
+		return sns, nil
+	}
+
+	fanout := &notify.Fanout{Events: events, Logger: logging}
+
+	for _, name := range c.Sinks {
+		var publisher notify.Publisher = sns
+
+		if name != "sns" {
+			var err error
+			if publisher, err = notify.NewSink(name, c, events); err != nil {
+				return nil, err
+			}
+		}
+
+		policy := c.Policies[name]
+		if policy != config.NotifyRequired && policy != config.NotifyBestEffort {
+			return nil, fmt.Errorf("unknown policy %s of notify sink %s, it must be %s or %s", policy, name, config.NotifyRequired, config.NotifyBestEffort)
+		}
+
+		fanout.Sinks = append(fanout.Sinks, notify.Sink{Name: name, Publisher: publisher, Policy: policy, Retries: c.Retries[name], Backoff: c.Backoffs[name]})
+	}
+
+	return fanout, nil
+}
+
+// replayCommand publishes again the events selected by its flags, as `main replay -source audit -from
+// 2020-07-01T00:00:00Z`, until they are all published or it is interrupted.
+func replayCommand(replayer *replay.Replayer, args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	source := flags.String("source", model.ReplayAudit, "audit to publish the changes of the history, snapshot to publish the current users")
+	from := flags.String("from", "", "replay what was updated from this RFC 3339 time")
+	to := flags.String("to", "", "replay what was updated before this RFC 3339 time")
+	nickname := flags.String("nickname", "", "replay only the user with this nickname")
+	types := flags.String("types", "", "comma separated event types to replay, all of them when empty")
+	rate := flags.Int("rate", 0, "events published per second, at most REPLAY_RATE")
+	_ = flags.Parse(args)
+
+	request := &model.ReplayRequest{Source: *source, From: timeFlag("from", *from), To: timeFlag("to", *to), Nickname: *nickname, Rate: *rate}
+	if *types != "" {
+		request.Types = strings.Split(*types, ",")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	j := &model.Job{}
+	err := replayer.Run(ctx, request, 0, job.NewProgress(j))
+
+	for _, e := range j.Errors {
+		fmt.Println(e)
+	}
+	fmt.Printf("replayed %d of %d events, %d failed\n", j.Progress.Done, j.Progress.Total, j.Progress.Failed)
+
+	if err != nil {
+		log.Fatal("error replaying events ", err)
+	}
+}
+
+func timeFlag(name string, value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		log.Fatal("invalid -"+name+" ", err)
+	}
+	return &t
+}
+
+func initUserCache(c *config.CacheConfig, userRepository repository.UserRepository) *repository.CachedRepository {
+	var userCache cache.Cache
+
+	switch strings.ToLower(c.Backend) {
+	case "lru":
+		userCache = cache.NewLRU(c.Size)
+	case "redis":
+		userCache = cache.NewRedis(c.RedisAddr, c.RedisPassword, c.RedisDB, c.RedisTimeout, c.RedisPoolSize)
+	default:
+		return nil
+	}
+
+	return repository.NewCachedRepository(userRepository, userCache, c.TTL, c.NegativeTTL)
+}
@@ -0,0 +1,68 @@
+// Command schemagen writes the JSON schemas of the event payloads, to schemas/<name>.<version>.json, and the go file
+// embedding them in the event package. It refuses to change a schema in a way that breaks its consumers.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/bernardoms/user-api/internal/event"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory of the event package")
+	flag.Parse()
+
+	var code bytes.Buffer
+	code.WriteString("// Code generated by schemagen from event.Payloads; DO NOT EDIT.\n\npackage event\n\n")
+	code.WriteString("// schemas are the JSON schemas of the Payloads, by name/version.\nvar schemas = map[string]string{\n")
+
+	for _, p := range event.Payloads {
+		schema, err := event.GenerateSchema(p)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		path := filepath.Join(*dir, "schemas", p.Name+"."+p.Version+".json")
+
+		if previous, err := ioutil.ReadFile(path); err == nil {
+			changes, err := event.Breaking(previous, schema)
+			if err != nil {
+				log.Fatal(path, ": ", err)
+			}
+			if len(changes) > 0 {
+				log.Fatalf("%s %s would break its consumers, add a new version instead: %s", p.Name, p.Version, strings.Join(changes, "; "))
+			}
+		} else if !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, schema, 0644); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Fprintf(&code, "\t%q: `%s`,\n", p.Name+"/"+p.Version, schema)
+	}
+
+	code.WriteString("}\n")
+
+	source, err := format.Source(code.Bytes())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(*dir, "schemas.go"), source, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,13 @@
+package config
+
+import "os"
+
+type AdminConfig struct {
+	Token string
+}
+
+func NewAdminConfig() *AdminConfig {
+	return &AdminConfig{
+		Token: os.Getenv("ADMIN_TOKEN"),
+	}
+}
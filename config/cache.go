@@ -0,0 +1,51 @@
+package config
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+type CacheConfig struct {
+	Backend       string
+	Size          int
+	TTL           time.Duration
+	NegativeTTL   time.Duration
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	RedisTimeout  time.Duration
+	RedisPoolSize int
+}
+
+func NewCacheConfig() *CacheConfig {
+	return &CacheConfig{
+		Backend:       os.Getenv("CACHE_BACKEND"),
+		Size:          intFromEnv("CACHE_SIZE", 10000),
+		TTL:           durationFromEnv("CACHE_TTL", time.Minute),
+		NegativeTTL:   durationFromEnv("CACHE_NEGATIVE_TTL", 10*time.Second),
+		RedisAddr:     os.Getenv("REDIS_ADDR"),
+		RedisPassword: os.Getenv("REDIS_PASSWORD"),
+		RedisDB:       intFromEnv("REDIS_DB", 0),
+		RedisTimeout:  durationFromEnv("REDIS_TIMEOUT", 500*time.Millisecond),
+		RedisPoolSize: intFromEnv("REDIS_POOL_SIZE", 10),
+	}
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// positiveDurationFromEnv reads an interval that can't be 0 or negative, using the fallback instead of them.
+func positiveDurationFromEnv(key string, fallback time.Duration) time.Duration {
+	v := durationFromEnv(key, fallback)
+	if v <= 0 {
+		log.Printf("invalid %s %s, it must be positive, using %s", key, v, fallback)
+		return fallback
+	}
+	return v
+}
@@ -0,0 +1,44 @@
+package config
+
+import "os"
+
+const (
+	// EventModeStructured sends the whole cloud event, attributes and data, as the message
+	EventModeStructured = "structured"
+	// EventModeBinary sends the data as the message and the attributes as its headers, where the sink has them
+	EventModeBinary = "binary"
+)
+
+type EventsConfig struct {
+	Mode string
+	// Source identifies the service in the events it publishes
+	Source string
+	// SchemaUrl is where the schemas of the event data are, each of them at /<name>/<version>
+	SchemaUrl string
+	// Version is the version of the event data published
+	Version string
+}
+
+func NewEventsConfig() *EventsConfig {
+	c := &EventsConfig{
+		Mode:      os.Getenv("EVENT_MODE"),
+		Source:    os.Getenv("EVENT_SOURCE"),
+		SchemaUrl: os.Getenv("EVENT_SCHEMA_URL"),
+		Version:   os.Getenv("EVENT_VERSION"),
+	}
+
+	if c.Mode == "" {
+		c.Mode = EventModeStructured
+	}
+	if c.Source == "" {
+		c.Source = "/user-api"
+	}
+	if c.SchemaUrl == "" {
+		c.SchemaUrl = "http://localhost:8080/v1/events/schemas"
+	}
+	if c.Version == "" {
+		c.Version = "v2"
+	}
+
+	return c
+}
@@ -0,0 +1,20 @@
+package config
+
+import "runtime"
+
+type ImportConfig struct {
+	// BatchSize is how many rows are validated, hashed and inserted at a time
+	BatchSize int
+	// Workers is how many passwords are hashed concurrently
+	Workers int
+	// MaxBytes is the largest file accepted
+	MaxBytes int64
+}
+
+func NewImportConfig() *ImportConfig {
+	return &ImportConfig{
+		BatchSize: positiveIntFromEnv("IMPORT_BATCH_SIZE", 1000),
+		Workers:   positiveIntFromEnv("IMPORT_WORKERS", runtime.NumCPU()),
+		MaxBytes:  int64(intFromEnv("IMPORT_MAX_SIZE_MB", 64)) << 20,
+	}
+}
@@ -0,0 +1,26 @@
+package config
+
+import "time"
+
+type JobsConfig struct {
+	// Workers is how many jobs run at a time in the service
+	Workers int
+	// PollInterval is how often idle workers look for jobs submitted to other instances or left by a stopped one
+	PollInterval time.Duration
+	// Lease is how long a running job stays with its worker without news from it, before another one resumes it
+	Lease time.Duration
+	// MaxAttempts is how many times a job is run, its worker crashing or losing its lease, before it fails
+	MaxAttempts int
+	// CancelCheck is how often, at most, a running job checks between its items whether it was cancelled
+	CancelCheck time.Duration
+}
+
+func NewJobsConfig() *JobsConfig {
+	return &JobsConfig{
+		Workers:      positiveIntFromEnv("JOB_WORKERS", 2),
+		PollInterval: positiveDurationFromEnv("JOB_POLL_INTERVAL", 5*time.Second),
+		Lease:        positiveDurationFromEnv("JOB_LEASE", time.Minute),
+		MaxAttempts:  positiveIntFromEnv("JOB_MAX_ATTEMPTS", 3),
+		CancelCheck:  positiveDurationFromEnv("JOB_CANCEL_CHECK", time.Second),
+	}
+}
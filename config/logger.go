@@ -0,0 +1,45 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+type LoggerConfig struct {
+	Level      string
+	Format     string
+	Output     string
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+}
+
+func NewLoggerConfig() *LoggerConfig {
+	return &LoggerConfig{
+		Level:      os.Getenv("LOG_LEVEL"),
+		Format:     os.Getenv("LOG_FORMAT"),
+		Output:     os.Getenv("LOG_OUTPUT"),
+		File:       os.Getenv("LOG_FILE"),
+		MaxSizeMB:  intFromEnv("LOG_MAX_SIZE_MB", 100),
+		MaxBackups: intFromEnv("LOG_MAX_BACKUPS", 5),
+	}
+}
+
+func intFromEnv(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// positiveIntFromEnv reads a size or a count that can't be 0 or negative, using the fallback instead of them.
+func positiveIntFromEnv(key string, fallback int) int {
+	v := intFromEnv(key, fallback)
+	if v <= 0 {
+		log.Printf("invalid %s %d, it must be positive, using %d", key, v, fallback)
+		return fallback
+	}
+	return v
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// NotifyRequired sinks fail the change they notify when they fail
+	NotifyRequired = "required"
+	// NotifyBestEffort sinks are only logged when they fail
+	NotifyBestEffort = "best-effort"
+)
+
+type NotifyConfig struct {
+	// Sinks are the names of the sinks notified of every change, all at once
+	Sinks []string
+	// Policies are the failure policies of the sinks, by name, NotifyRequired by default
+	Policies map[string]string
+	// Retries are how many times a failing sink is retried, by name
+	Retries map[string]int
+	// Backoffs are how long to wait before retrying a failing sink, doubled at each retry, by name
+	Backoffs map[string]time.Duration
+	// SigningKeys are the keys signing the events of the sinks, by name, see SnsConfig.SigningKeys
+	SigningKeys map[string]string
+	Timeout     time.Duration
+
+	SqsQueueUrl string
+	Region      string
+	Endpoint    string
+
+	KafkaBrokers []string
+	KafkaTopic   string
+
+	NatsUrl     string
+	NatsSubject string
+
+	AmqpUrl        string
+	AmqpExchange   string
+	AmqpRoutingKey string
+
+	WebhookUrl string
+
+	// File is where the file sink appends the notifications, stdout when it is empty or "-"
+	File string
+}
+
+func NewNotifyConfig() *NotifyConfig {
+	c := &NotifyConfig{
+		Sinks:          listFromEnv("NOTIFY_SINKS", []string{"sns"}),
+		Policies:       map[string]string{},
+		Retries:        map[string]int{},
+		Backoffs:       map[string]time.Duration{},
+		SigningKeys:    map[string]string{},
+		Timeout:        durationFromEnv("NOTIFY_TIMEOUT", 5*time.Second),
+		SqsQueueUrl:    os.Getenv("SQS_QUEUE_URL"),
+		Region:         os.Getenv("AWS_REGION"),
+		Endpoint:       os.Getenv("ENDPOINT"),
+		KafkaBrokers:   listFromEnv("KAFKA_BROKERS", nil),
+		KafkaTopic:     os.Getenv("KAFKA_TOPIC"),
+		NatsUrl:        os.Getenv("NATS_URL"),
+		NatsSubject:    os.Getenv("NATS_SUBJECT"),
+		AmqpUrl:        os.Getenv("AMQP_URL"),
+		AmqpExchange:   os.Getenv("AMQP_EXCHANGE"),
+		AmqpRoutingKey: os.Getenv("AMQP_ROUTING_KEY"),
+		WebhookUrl:     os.Getenv("WEBHOOK_URL"),
+		File:           os.Getenv("NOTIFY_FILE"),
+	}
+
+	for _, sink := range c.Sinks {
+		key := strings.ToUpper(sink)
+		c.Policies[sink] = os.Getenv("NOTIFY_" + key + "_POLICY")
+		if c.Policies[sink] == "" {
+			c.Policies[sink] = NotifyRequired
+		}
+		c.Retries[sink] = intFromEnv("NOTIFY_"+key+"_RETRIES", 0)
+		c.Backoffs[sink] = durationFromEnv("NOTIFY_"+key+"_BACKOFF", 200*time.Millisecond)
+		c.SigningKeys[sink] = os.Getenv("NOTIFY_" + key + "_SIGNING_KEYS")
+	}
+
+	return c
+}
+
+// listFromEnv reads a comma separated list, skipping blank values.
+func listFromEnv(key string, fallback []string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return fallback
+	}
+	return values
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultOtlpEndpoint = "http://localhost:4318"
+
+type ObservabilityConfig struct {
+	Provider        string
+	ServiceName     string
+	NewRelicLicense string
+	OtelExporter    string
+	// Otlp configures the otlp exporter, read from the standard OTEL_EXPORTER_OTLP_* variables
+	Otlp OtlpConfig
+}
+
+// OtlpConfig is where the otlp exporter sends the spans. The variables specific to traces take precedence over the
+// general ones, as the OpenTelemetry specification defines.
+type OtlpConfig struct {
+	// Endpoint is the full url the spans are posted to
+	Endpoint string
+	Headers  map[string]string
+	Timeout  time.Duration
+	Protocol string
+}
+
+func NewObservabilityConfig() *ObservabilityConfig {
+	return &ObservabilityConfig{
+		Provider:        os.Getenv("OBSERVABILITY_PROVIDER"),
+		ServiceName:     os.Getenv("NEWRELIC_APP"),
+		NewRelicLicense: os.Getenv("NEWRELIC_LICENSE"),
+		OtelExporter:    os.Getenv("OTEL_EXPORTER"),
+		Otlp:            NewOtlpConfig(),
+	}
+}
+
+func NewOtlpConfig() OtlpConfig {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			base = defaultOtlpEndpoint
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+
+	headers := otlpHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	for k, v := range otlpHeaders(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")) {
+		headers[k] = v
+	}
+
+	timeoutKey := "OTEL_EXPORTER_OTLP_TIMEOUT"
+	if os.Getenv("OTEL_EXPORTER_OTLP_TRACES_TIMEOUT") != "" {
+		timeoutKey = "OTEL_EXPORTER_OTLP_TRACES_TIMEOUT"
+	}
+
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+
+	return OtlpConfig{
+		Endpoint: endpoint,
+		Headers:  headers,
+		// the timeout is in milliseconds
+		Timeout:  time.Duration(positiveIntFromEnv(timeoutKey, 10000)) * time.Millisecond,
+		Protocol: protocol,
+	}
+}
+
+// otlpHeaders parses a list of key=value pairs separated by commas, with url encoded values.
+func otlpHeaders(s string) map[string]string {
+	headers := map[string]string{}
+
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			log.Printf("invalid otlp header %s, it must be key=value, ignoring it", pair)
+			continue
+		}
+
+		value, err := url.QueryUnescape(strings.TrimSpace(kv[1]))
+		if err != nil {
+			log.Printf("invalid otlp header %s, %v, ignoring it", kv[0], err)
+			continue
+		}
+		headers[strings.TrimSpace(kv[0])] = value
+	}
+	return headers
+}
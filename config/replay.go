@@ -0,0 +1,12 @@
+package config
+
+type ReplayConfig struct {
+	// Rate is how many events a replay publishes per second at most
+	Rate int
+}
+
+func NewReplayConfig() *ReplayConfig {
+	return &ReplayConfig{
+		Rate: intFromEnv("REPLAY_RATE", 50),
+	}
+}
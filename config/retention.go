@@ -0,0 +1,31 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	PurgeModeJob      = "job"
+	PurgeModeTTLIndex = "ttl-index"
+	PurgeModeOff      = "off"
+)
+
+type RetentionConfig struct {
+	Retention     time.Duration
+	PurgeInterval time.Duration
+	PurgeMode     string
+}
+
+func NewRetentionConfig() *RetentionConfig {
+	mode := os.Getenv("USER_PURGE_MODE")
+	if mode == "" {
+		mode = PurgeModeJob
+	}
+
+	return &RetentionConfig{
+		Retention:     durationFromEnv("USER_DELETED_RETENTION", 30*24*time.Hour),
+		PurgeInterval: positiveDurationFromEnv("USER_PURGE_INTERVAL", time.Hour),
+		PurgeMode:     mode,
+	}
+}
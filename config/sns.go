@@ -1,17 +1,72 @@
 package config
 
-import "os"
+import (
+	"os"
+	"time"
+)
+
+const (
+	// SnsCredentialsDefault uses the default chain of the aws sdk: environment, shared files, then the instance role
+	SnsCredentialsDefault = "default"
+	// SnsCredentialsStatic uses AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+	SnsCredentialsStatic = "static"
+	// SnsCredentialsProfile uses the AWS_PROFILE profile of the shared files
+	SnsCredentialsProfile = "profile"
+	// SnsCredentialsWebIdentity assumes AWS_ROLE_ARN with the token at AWS_WEB_IDENTITY_TOKEN_FILE
+	SnsCredentialsWebIdentity = "web-identity"
+)
 
 type SnsConfig struct {
 	Topic    string
 	Region   string
 	Endpoint string
+	// Fifo publishes to a FIFO topic, whose arn ends in .fifo, in order for each user
+	Fifo bool
+
+	// MaxRetries are how many times the sdk retries a failed publish
+	MaxRetries int
+	// Timeout bounds a publish, retries included
+	Timeout time.Duration
+	// ConnectTimeout bounds opening a connection to SNS
+	ConnectTimeout time.Duration
+	// MaxIdleConns are the connections kept open for the next publishes
+	MaxIdleConns int
+	// SigningKeys sign the events, as a comma separated list of id:algorithm:base64 key, see package eventsig
+	SigningKeys string
+
+	Credentials     string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Profile         string
+	RoleArn         string
+	RoleSessionName string
+	TokenFile       string
 }
 
 func NewSnsConfig() *SnsConfig {
+	credentials := os.Getenv("SNS_CREDENTIALS")
+	if credentials == "" {
+		credentials = SnsCredentialsDefault
+	}
+
 	return &SnsConfig{
-		Topic:    os.Getenv("SNS_TOPIC"),
-		Region:   os.Getenv("AWS_REGION"),
-		Endpoint: os.Getenv("ENDPOINT"),
+		Topic:           os.Getenv("SNS_TOPIC"),
+		Region:          os.Getenv("AWS_REGION"),
+		Endpoint:        os.Getenv("ENDPOINT"),
+		Fifo:            os.Getenv("SNS_FIFO") == "true",
+		MaxRetries:      intFromEnv("SNS_MAX_RETRIES", 3),
+		Timeout:         positiveDurationFromEnv("SNS_TIMEOUT", 5*time.Second),
+		ConnectTimeout:  positiveDurationFromEnv("SNS_CONNECT_TIMEOUT", time.Second),
+		MaxIdleConns:    intFromEnv("SNS_MAX_IDLE_CONNS", 16),
+		SigningKeys:     os.Getenv("SNS_SIGNING_KEYS"),
+		Credentials:     credentials,
+		AccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Profile:         os.Getenv("AWS_PROFILE"),
+		RoleArn:         os.Getenv("AWS_ROLE_ARN"),
+		RoleSessionName: os.Getenv("AWS_ROLE_SESSION_NAME"),
+		TokenFile:       os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
 	}
 }
@@ -0,0 +1,12 @@
+package cache
+
+import "time"
+
+// Cache stores opaque values by key. A value that is absent or expired is reported with found false.
+type Cache interface {
+	Get(key string) (value []byte, found bool, err error)
+	Set(key string, value []byte, ttl time.Duration) error
+	// Add stores the value only when the key is absent or expired, atomically, reporting whether it did.
+	Add(key string, value []byte, ttl time.Duration) (bool, error)
+	Delete(keys ...string) error
+}
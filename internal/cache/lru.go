@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is an in-process cache holding at most Capacity entries, evicting the least recently used one when full.
+type LRU struct {
+	Capacity int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func NewLRU(capacity int) *LRU {
+	return &LRU{
+		Capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+func (l *LRU) Get(key string) ([]byte, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := e.Value.(*lruEntry)
+	if !entry.expires.IsZero() && !l.now().Before(entry.expires) {
+		l.remove(e)
+		return nil, false, nil
+	}
+
+	l.order.MoveToFront(e)
+	return entry.value, true, nil
+}
+
+func (l *LRU) Set(key string, value []byte, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.set(key, value, ttl)
+	return nil
+}
+
+func (l *LRU) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[key]; ok {
+		entry := e.Value.(*lruEntry)
+		if entry.expires.IsZero() || l.now().Before(entry.expires) {
+			return false, nil
+		}
+	}
+
+	l.set(key, value, ttl)
+	return true, nil
+}
+
+func (l *LRU) set(key string, value []byte, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = l.now().Add(ttl)
+	}
+
+	if e, ok := l.entries[key]; ok {
+		e.Value = &lruEntry{key: key, value: value, expires: expires}
+		l.order.MoveToFront(e)
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+
+	if l.Capacity > 0 && l.order.Len() > l.Capacity {
+		l.remove(l.order.Back())
+	}
+}
+
+func (l *LRU) Delete(keys ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if e, ok := l.entries[key]; ok {
+			l.remove(e)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries, including expired ones not yet removed.
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+func (l *LRU) remove(e *list.Element) {
+	l.order.Remove(e)
+	delete(l.entries, e.Value.(*lruEntry).key)
+}
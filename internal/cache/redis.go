@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+var errNil = errors.New("redis: nil reply")
+
+// Redis is a cache over any server speaking the Redis protocol (RESP). It keeps up to PoolSize idle connections.
+type Redis struct {
+	Addr     string
+	Password string
+	DB       int
+	Timeout  time.Duration
+
+	idle chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func NewRedis(addr string, password string, db int, timeout time.Duration, poolSize int) *Redis {
+	if poolSize <= 0 {
+		poolSize = 1
+	}
+	return &Redis{Addr: addr, Password: password, DB: db, Timeout: timeout, idle: make(chan *redisConn, poolSize)}
+}
+
+func (c *Redis) Get(key string) ([]byte, bool, error) {
+	reply, err := c.do("GET", key)
+	if err == errNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return reply.([]byte), true, nil
+}
+
+func (c *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	}
+	_, err := c.do(args...)
+	return err
+}
+
+// Add is a SET with NX, which the server applies only when the key doesn't exist.
+func (c *Redis) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	}
+	_, err := c.do(append(args, "NX")...)
+	if err == errNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *Redis) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := c.do(append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+// Close closes the idle connections.
+func (c *Redis) Close() error {
+	for {
+		select {
+		case conn := <-c.idle:
+			_ = conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Redis) do(args ...string) (interface{}, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(c.Timeout, args...)
+
+	// A nil or server error reply leaves the connection in a clean state, any other error may not.
+	if _, isServerErr := err.(redisError); err == nil || err == errNil || isServerErr {
+		c.put(conn)
+	} else {
+		_ = conn.Close()
+	}
+	return reply, err
+}
+
+func (c *Redis) get() (*redisConn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+	}
+
+	netConn, err := net.DialTimeout("tcp", c.Addr, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, r: bufio.NewReader(netConn)}
+
+	if c.Password != "" {
+		if _, err := conn.do(c.Timeout, "AUTH", c.Password); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	if c.DB != 0 {
+		if _, err := conn.do(c.Timeout, "SELECT", strconv.Itoa(c.DB)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *Redis) put(conn *redisConn) {
+	select {
+	case c.idle <- conn:
+	default:
+		_ = conn.Close()
+	}
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (conn *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	cmd := make([]byte, 0, 64)
+	cmd = append(cmd, fmt.Sprintf("*%d\r\n", len(args))...)
+	for _, a := range args {
+		cmd = append(cmd, fmt.Sprintf("$%d\r\n", len(a))...)
+		cmd = append(cmd, a...)
+		cmd = append(cmd, "\r\n"...)
+	}
+
+	if _, err := conn.Write(cmd); err != nil {
+		return nil, err
+	}
+	return readReply(conn.r)
+}
+
+// readReply reads one RESP reply: simple strings and bulk strings come back as []byte, integers as int64 and
+// arrays as []interface{}.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return []byte(body), nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = readReply(r)
+			if err != nil && err != errNil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,167 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/pkg/eventsig"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	SpecVersion = "1.0"
+	ContentType = "application/json"
+	// StructuredContentType is the content type of an event sent in structured mode
+	StructuredContentType = "application/cloudevents+json"
+
+	TypeUserUpdated = "com.bernardoms.user.updated"
+
+	// UserSchema is the name of the schema of the user data
+	UserSchema = "user"
+)
+
+// Event is a CloudEvents 1.0 event. Signature is an extension attribute, holding the signatures of the event by the
+// keys of its encoder, see package eventsig.
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	Id              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            time.Time       `json:"time"`
+	Subject         string          `json:"subject"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema"`
+	Signature       string          `json:"signature,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// Attributes are the context attributes of the event, by name, as they are sent in binary mode. The content type
+// is not one of them, transports carry it on their own.
+func (e *Event) Attributes() map[string]string {
+	attributes := map[string]string{
+		"specversion": e.SpecVersion,
+		"id":          e.Id,
+		"source":      e.Source,
+		"type":        e.Type,
+		"time":        e.Time.Format(time.RFC3339Nano),
+		"subject":     e.Subject,
+		"dataschema":  e.DataSchema,
+	}
+	if e.Signature != "" {
+		attributes[eventsig.Attribute] = e.Signature
+	}
+	return attributes
+}
+
+// Encoder wraps the published users in events, with the data at its version, to be sent in its mode. Events are
+// signed with each of its keys, when it has any.
+type Encoder struct {
+	Mode      string
+	Source    string
+	SchemaUrl string
+	Version   string
+	Keys      []eventsig.Key
+}
+
+func NewEncoder(c *config.EventsConfig) (*Encoder, error) {
+	if c.Mode != config.EventModeStructured && c.Mode != config.EventModeBinary {
+		return nil, fmt.Errorf("unknown event mode %s, it must be %s or %s", c.Mode, config.EventModeStructured, config.EventModeBinary)
+	}
+
+	if _, ok := LookupSchema(UserSchema, c.Version); !ok {
+		return nil, fmt.Errorf("unknown event version %s", c.Version)
+	}
+
+	return &Encoder{Mode: c.Mode, Source: c.Source, SchemaUrl: c.SchemaUrl, Version: c.Version}, nil
+}
+
+// WithKeys returns a copy of the encoder signing the events with the keys, for a sink of its own keys. The encoder
+// itself is returned when there are no keys.
+func (e *Encoder) WithKeys(keys []eventsig.Key) (*Encoder, error) {
+	if len(keys) == 0 {
+		return e, nil
+	}
+
+	for _, key := range keys {
+		if !key.CanSign() {
+			return nil, fmt.Errorf("signing key %s is an ed25519 public key, it can't sign", key.Id)
+		}
+	}
+
+	signing := *e
+	signing.Keys = keys
+	return &signing, nil
+}
+
+// Binary tells whether the events are sent in binary mode.
+func (e *Encoder) Binary() bool {
+	return e.Mode == config.EventModeBinary
+}
+
+// UserUpdated is the event of an updated user, about the user id.
+func (e *Encoder) UserUpdated(user *model.User, changes []model.FieldChange) (*Event, error) {
+	var payload interface{}
+
+	switch e.Version {
+	case "v1":
+		payload = NewUserV1(user)
+	case "v2":
+		payload = NewUserV2(user, changes)
+	default:
+		return nil, fmt.Errorf("unknown event version %s", e.Version)
+	}
+
+	data, err := json.Marshal(payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	at := time.Now().UTC()
+	if user.UpdatedAt != nil {
+		at = user.UpdatedAt.UTC()
+	}
+
+	ev := &Event{
+		SpecVersion:     SpecVersion,
+		Id:              primitive.NewObjectID().Hex(),
+		Source:          e.Source,
+		Type:            TypeUserUpdated,
+		Time:            at,
+		Subject:         user.Id.Hex(),
+		DataContentType: ContentType,
+		DataSchema:      e.SchemaUrl + "/" + UserSchema + "/" + e.Version,
+		Data:            data,
+	}
+
+	return e.Sign(ev)
+}
+
+// Sign is a copy of the event signed with the keys of the encoder, unsigned when it has none, so that an event built
+// once is signed by each sink with its own keys.
+func (e *Encoder) Sign(ev *Event) (*Event, error) {
+	signed := *ev
+	signed.Signature = ""
+
+	if len(e.Keys) > 0 {
+		var err error
+		if signed.Signature, err = eventsig.Sign(signed.Attributes(), signed.Data, e.Keys); err != nil {
+			return nil, err
+		}
+	}
+
+	return &signed, nil
+}
+
+// Message is the body of the event in the mode of the encoder: the whole event in structured mode, the data in
+// binary mode, with its content type.
+func (e *Encoder) Message(ev *Event) ([]byte, string, error) {
+	if e.Binary() {
+		return ev.Data, ev.DataContentType, nil
+	}
+
+	body, err := json.Marshal(ev)
+	return body, StructuredContentType, err
+}
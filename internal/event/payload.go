@@ -0,0 +1,103 @@
+package event
+
+//go:generate go run ../../cmd/schemagen -dir .
+
+import (
+	"fmt"
+	"github.com/bernardoms/user-api/internal/model"
+	"reflect"
+	"time"
+)
+
+// Payload is a version of the data of an event. Once published, a version only gets optional fields, and a new
+// version can't remove nor change the fields of the previous one, see Breaking.
+type Payload struct {
+	Name    string
+	Version string
+	Type    reflect.Type
+}
+
+// Payloads are all the versions of the event data, oldest first. Their schemas are generated with go generate.
+var Payloads = []Payload{
+	{Name: UserSchema, Version: "v1", Type: reflect.TypeOf(UserV1{})},
+	{Name: UserSchema, Version: "v2", Type: reflect.TypeOf(UserV2{})},
+}
+
+// UserV1 is the first version of the user data. It isn't what was published before the events were versioned, the
+// whole model.User: it adds the id, and leaves out the password hash and any field added to the model later.
+type UserV1 struct {
+	Id        string     `json:"id"`
+	Email     string     `json:"email"`
+	Country   string     `json:"country"`
+	Nickname  string     `json:"nickname"`
+	LastName  string     `json:"lastName"`
+	FirstName string     `json:"firstName"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	UpdatedBy string     `json:"updatedBy,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// UserV2 adds what changed to the user, when the change is known.
+type UserV2 struct {
+	UserV1
+	// ChangedFields are the names of the changed fields, the password included
+	ChangedFields []string `json:"changedFields,omitempty"`
+	// Changes are the values before and after of the changed fields, but the password
+	Changes []ChangeV2 `json:"changes,omitempty"`
+	// PreviousNickname is the nickname before a rename
+	PreviousNickname string `json:"previousNickname,omitempty"`
+}
+
+type ChangeV2 struct {
+	Field  string `json:"field"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+func NewUserV1(user *model.User) UserV1 {
+	return UserV1{
+		Id:        user.Id.Hex(),
+		Email:     user.Email,
+		Country:   user.Country,
+		Nickname:  user.Nickname,
+		LastName:  user.LastName,
+		FirstName: user.FirstName,
+		CreatedAt: user.CreatedAt,
+		CreatedBy: user.CreatedBy,
+		UpdatedAt: user.UpdatedAt,
+		UpdatedBy: user.UpdatedBy,
+		DeletedAt: user.DeletedAt,
+	}
+}
+
+// NewUserV2 is the user with its changes, nil when they are not known. Password values are never published, only
+// that it changed.
+func NewUserV2(user *model.User, changes []model.FieldChange) UserV2 {
+	payload := UserV2{UserV1: NewUserV1(user)}
+
+	for _, change := range changes {
+		payload.ChangedFields = append(payload.ChangedFields, change.Field)
+
+		if change.Field == "password" {
+			continue
+		}
+
+		c := ChangeV2{Field: change.Field, Before: changeValue(change.Before), After: changeValue(change.After)}
+		payload.Changes = append(payload.Changes, c)
+
+		if change.Field == "nickname" {
+			payload.PreviousNickname = c.Before
+		}
+	}
+
+	return payload
+}
+
+func changeValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
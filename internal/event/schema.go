@@ -0,0 +1,223 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// Schema is the subset of JSON Schema the event payloads are described with.
+type Schema struct {
+	Schema     string             `json:"$schema,omitempty"`
+	Title      string             `json:"title,omitempty"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+}
+
+// GenerateSchema describes a payload from its type: fields are required unless they are omitempty, pointers must be.
+func GenerateSchema(p Payload) ([]byte, error) {
+	s, err := schemaOf(p.Type)
+
+	if err != nil {
+		return nil, fmt.Errorf("schema of %s %s: %v", p.Name, p.Version, err)
+	}
+
+	s.Schema, s.Title = jsonSchemaDraft, p.Name+" "+p.Version
+
+	body, err := json.MarshalIndent(s, "", "  ")
+	return append(body, '\n'), err
+}
+
+func schemaOf(t reflect.Type) (*Schema, error) {
+	if t == reflect.TypeOf(time.Time{}) {
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	case reflect.Slice:
+		items, err := schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		if err := addFields(s, t); err != nil {
+			return nil, err
+		}
+		sort.Strings(s.Required)
+		return s, nil
+	}
+	return nil, fmt.Errorf("%s can't be described", t)
+}
+
+func addFields(s *Schema, t reflect.Type) error {
+	for n := 0; n < t.NumField(); n++ {
+		f := t.Field(n)
+
+		if f.Anonymous {
+			if err := addFields(s, f.Type); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name, options := f.Tag.Get("json"), ""
+		if i := strings.Index(name, ","); i >= 0 {
+			name, options = name[:i], name[i:]
+		}
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			return errors.New(f.Name + " has no json name")
+		}
+
+		omitEmpty := strings.Contains(options, ",omitempty")
+		fieldType := f.Type
+
+		if fieldType.Kind() == reflect.Ptr {
+			if !omitEmpty {
+				return errors.New(f.Name + " is a pointer without omitempty, it would be null")
+			}
+			fieldType = fieldType.Elem()
+		}
+
+		property, err := schemaOf(fieldType)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+
+		s.Properties[name] = property
+		if !omitEmpty {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return nil
+}
+
+// Breaking lists the changes of a schema that break the consumers of the previous one: removed properties, changed
+// types or formats, and properties that became required or optional.
+func Breaking(previous []byte, next []byte) ([]string, error) {
+	var p, n Schema
+
+	if err := json.Unmarshal(previous, &p); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(next, &n); err != nil {
+		return nil, err
+	}
+
+	return breaking("", &p, &n), nil
+}
+
+func breaking(path string, previous *Schema, next *Schema) []string {
+	name := path
+	if name == "" {
+		name = "the payload"
+	}
+
+	if previous.Type != next.Type {
+		return []string{fmt.Sprintf("%s changed from %s to %s", name, previous.Type, next.Type)}
+	}
+
+	var changes []string
+
+	if previous.Format != next.Format {
+		changes = append(changes, fmt.Sprintf("%s changed format from %q to %q", name, previous.Format, next.Format))
+	}
+
+	if previous.Items != nil && next.Items != nil {
+		changes = append(changes, breaking(path+"[]", previous.Items, next.Items)...)
+	}
+
+	wasRequired, isRequired := set(previous.Required), set(next.Required)
+
+	for _, property := range sortedKeys(previous.Properties) {
+		p := join(path, property)
+		nextProperty, ok := next.Properties[property]
+
+		if !ok {
+			changes = append(changes, p+" was removed")
+			continue
+		}
+
+		if wasRequired[property] && !isRequired[property] {
+			changes = append(changes, p+" is no longer required")
+		} else if !wasRequired[property] && isRequired[property] {
+			changes = append(changes, p+" became required")
+		}
+
+		changes = append(changes, breaking(p, previous.Properties[property], nextProperty)...)
+	}
+
+	for _, property := range sortedKeys(next.Properties) {
+		if _, ok := previous.Properties[property]; !ok && isRequired[property] {
+			changes = append(changes, join(path, property)+" was added as required")
+		}
+	}
+
+	return changes
+}
+
+func join(path string, property string) string {
+	if path == "" {
+		return property
+	}
+	return path + "." + property
+}
+
+func set(values []string) map[string]bool {
+	s := make(map[string]bool, len(values))
+	for _, v := range values {
+		s[v] = true
+	}
+	return s
+}
+
+func sortedKeys(m map[string]*Schema) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SchemaRef is a schema served by the api.
+type SchemaRef struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Schemas are the schemas of all the payloads, oldest first.
+func Schemas() []SchemaRef {
+	refs := make([]SchemaRef, 0, len(Payloads))
+	for _, p := range Payloads {
+		refs = append(refs, SchemaRef{Name: p.Name, Version: p.Version})
+	}
+	return refs
+}
+
+// LookupSchema returns the generated schema of a payload version.
+func LookupSchema(name string, version string) ([]byte, bool) {
+	s, ok := schemas[name+"/"+version]
+	return []byte(s), ok
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/validator.v9"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type AdminHandler struct {
+	Logger *logger.Logger
+	Cache  CacheStatsInterface
+}
+
+// AdminAuth only lets through requests carrying "Authorization: Bearer <token>". With an empty token every admin
+// request is refused, so admin routes are closed unless a token is configured.
+func AdminAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !IsAdmin(r, token) {
+				respondWithJson(w, http.StatusUnauthorized, model.ResponseError{Description: "missing or invalid admin token"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsAdmin reports whether the request carries the admin bearer token, as "Bearer <token>". The scheme is case
+// insensitive, the token isn't.
+func IsAdmin(r *http.Request, token string) bool {
+	authorization := r.Header.Get("Authorization")
+
+	if token == "" || len(authorization) < len("Bearer ") || !strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return false
+	}
+
+	given := authorization[len("Bearer "):]
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// GetLogLevel godoc
+// @Summary Retrieves the current log level
+// @Description Retrieves the current log level and, when it is temporary, when it will be reverted
+// @Produce json
+// @Success 200 {object} model.LogLevel
+// @Router /admin/log-level [get]
+// @Tags admin
+func (a *AdminHandler) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	respondWithJson(w, http.StatusOK, a.currentLevel())
+}
+
+// SetLogLevel godoc
+// @Summary Changes the log level at runtime
+// @Description Changes the log level, optionally reverting to the configured one after a ttl like "15m"
+// @Produce json
+// @Param level body model.LogLevel true "New log level"
+// @Success 200 {object} model.LogLevel
+// @Router /admin/log-level [put]
+// @Tags admin
+func (a *AdminHandler) SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var level model.LogLevel
+
+	err := json.NewDecoder(r.Body).Decode(&level)
+
+	if err == nil {
+		err = validator.New().Struct(level)
+	}
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		a.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	var ttl time.Duration
+
+	if level.TTL != "" {
+		ttl, err = time.ParseDuration(level.TTL)
+		if err != nil {
+			respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+			return
+		}
+	}
+
+	err = a.Logger.SetLevel(level.Level, ttl)
+
+	if err != nil {
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	f := map[string]interface{}{"msg": "log level changed to " + level.Level, "ttl": level.TTL}
+	a.Logger.LogWithFields(r, "warn", f)
+
+	respondWithJson(w, http.StatusOK, a.currentLevel())
+}
+
+func (a *AdminHandler) currentLevel() model.LogLevel {
+	level, until := a.Logger.Level()
+	current := model.LogLevel{Level: level}
+	if !until.IsZero() {
+		current.Until = &until
+	}
+	return current
+}
+
+// GetCacheStats godoc
+// @Summary Retrieves the user cache statistics
+// @Description Retrieves hits, misses and hit ratio of the user lookup cache
+// @Produce json
+// @Success 200 {object} model.CacheStats
+// @Router /admin/cache/stats [get]
+// @Tags admin
+func (a *AdminHandler) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	if a.Cache == nil {
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "user cache is disabled"})
+		return
+	}
+	respondWithJson(w, http.StatusOK, a.Cache.Stats())
+}
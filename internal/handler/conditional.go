@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const listCacheControl = "private, no-cache"
+
+// respondWithCacheableJson writes the payload with a strong ETag computed over the body. Requests with a matching
+// If-None-Match get a 304 without body. Lists have no Last-Modified, and If-Modified-Since is ignored, as a list
+// changes without the update time of its users moving when users are deleted or stop matching the filter.
+func respondWithCacheableJson(w http.ResponseWriter, r *http.Request, payload interface{}) {
+	response, _ := json.Marshal(payload)
+
+	sum := sha256.Sum256(response)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("Cache-Control", listCacheControl)
+	w.Header().Set("ETag", etag)
+
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(response)
+}
+
+func notModified(r *http.Request, etag string) bool {
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
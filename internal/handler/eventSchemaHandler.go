@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type EventHandler struct {
+	Events *event.Encoder
+	Logger *logger.Logger
+}
+
+// GetEventSchemas godoc
+// @Summary Lists the schemas of the event data
+// @Description Lists every version of the data of the published events, oldest first, with the url of its JSON
+// @Description schema, the dataschema of the events, and which one is published.
+// @Produce json
+// @Success 200 {object} model.EventSchemas
+// @Router /events/schemas [get]
+// @Tags events
+func (e *EventHandler) GetEventSchemas(w http.ResponseWriter, r *http.Request) {
+	response := model.EventSchemas{Schemas: make([]model.EventSchema, 0)}
+
+	for _, s := range event.Schemas() {
+		response.Schemas = append(response.Schemas, model.EventSchema{
+			Name:      s.Name,
+			Version:   s.Version,
+			Url:       e.Events.SchemaUrl + "/" + s.Name + "/" + s.Version,
+			Published: s.Name == event.UserSchema && s.Version == e.Events.Version,
+		})
+	}
+
+	respondWithJson(w, http.StatusOK, response)
+}
+
+// GetEventSchema godoc
+// @Summary Retrieves the JSON schema of a version of the event data
+// @Produce json
+// @Param name path string true "Schema name, like user"
+// @Param version path string true "Schema version, like v1"
+// @Success 200 {object} object
+// @Failure 404 {object} model.ResponseError
+// @Router /events/schemas/{name}/{version} [get]
+// @Tags events
+func (e *EventHandler) GetEventSchema(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	schema, ok := event.LookupSchema(params["name"], params["version"])
+
+	if !ok {
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "schema " + params["name"] + " " + params["version"] + " not found!"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(schema)
+}
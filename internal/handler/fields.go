@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/bernardoms/user-api/internal/model"
+	"net/url"
+	"strings"
+)
+
+const fieldsParam = "fields"
+
+// selectedFields reads the comma separated fields parameter, rejecting the fields that can't be selected.
+func selectedFields(params url.Values) ([]string, error) {
+	v := params.Get(fieldsParam)
+
+	if v == "" {
+		return nil, nil
+	}
+
+	var fields []string
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if !isUserField(f) {
+			return nil, fmt.Errorf("invalid value %s for parameter fields, it must be any of %s", f, strings.Join(model.UserFields, ", "))
+		}
+		if !contains(fields, f) {
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+// sparse keeps only the given fields of the users json, in the order they were asked for.
+func sparse(users []model.UserResponse, fields []string) []json.RawMessage {
+	results := make([]json.RawMessage, 0, len(users))
+
+	for _, u := range users {
+		results = append(results, sparseUser(u, fields))
+	}
+	return results
+}
+
+func sparseUser(user model.UserResponse, fields []string) json.RawMessage {
+	var all map[string]json.RawMessage
+	b, _ := json.Marshal(user)
+	_ = json.Unmarshal(b, &all)
+
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for _, f := range fields {
+		v, ok := all[f]
+		if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteString(",")
+		}
+		k, _ := json.Marshal(f)
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+	}
+	buf.WriteString("}")
+
+	return buf.Bytes()
+}
+
+func isUserField(field string) bool {
+	return contains(model.UserFields, field)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
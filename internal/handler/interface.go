@@ -2,6 +2,21 @@ package handler
 
 import "github.com/bernardoms/user-api/internal/model"
 
+// NotifyInterface notifies an updated user with its changes, nil when they are not known.
 type NotifyInterface interface {
-	Publish(user *model.User) error
+	Publish(user *model.User, changes []model.FieldChange) error
+}
+
+// UserCacheInterface looks up the users as answered by the api, without their password, from a cache.
+type UserCacheInterface interface {
+	FindResponseByNickname(nickname string) (*model.UserResponse, error)
+}
+
+type CacheStatsInterface interface {
+	Stats() model.CacheStats
+}
+
+// JobQueue queues the jobs to run in the background.
+type JobQueue interface {
+	Submit(job *model.Job) error
 }
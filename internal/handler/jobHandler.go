@@ -0,0 +1,419 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/query"
+	"github.com/bernardoms/user-api/internal/replay"
+	"github.com/bernardoms/user-api/internal/repository"
+	"github.com/bernardoms/user-api/internal/requestctx"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/go-playground/validator.v9"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+const exportFormatParam = "format"
+
+// JobHandler starts the user jobs, which run in the background, and tells how they are going.
+type JobHandler struct {
+	Queue      JobQueue
+	Repository repository.JobRepository
+	Files      repository.FileStore
+	Users      *UserHandler
+	Logger     *logger.Logger
+}
+
+// SubmitImportJob godoc
+// @Summary Starts an user import job
+// @Description Stores the file and imports it in the background, as the user import does. Rows that can't be created
+// @Description are counted as failed, with their error in the job.
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Param dryRun query bool false "Only validate the file"
+// @Success 202 {object} model.Job
+// @Header 202 {string} Location "/v1/jobs/{id}"
+// @Failure 400 {object} model.ResponseError
+// @Failure 415 {object} model.ResponseError
+// @Router /jobs/import [post]
+// @Tags jobs
+func (h *JobHandler) SubmitImportJob(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != csvContentType && mediaType != ndjsonContentType {
+		description := "users can only be imported from " + csvContentType + " or " + ndjsonContentType
+		respondWithJson(w, http.StatusUnsupportedMediaType, model.ResponseError{Description: description})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+	id, out, err := h.Files.Create("import")
+
+	if err == nil {
+		_, err = io.Copy(out, http.MaxBytesReader(w, r.Body, h.Users.importConfig().MaxBytes))
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = h.Files.Delete(id)
+			f := map[string]interface{}{"msg": "invalid import file: " + err.Error()}
+			h.Logger.LogWithFields(r, "info", f)
+			respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: "invalid import file: " + err.Error()})
+			return
+		}
+	}
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		h.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	j := h.newJob(r, model.JobImport, map[string]string{"contentType": mediaType, "dryRun": strconv.FormatBool(dryRun)})
+	j.Input = id
+
+	if !h.submit(w, r, j) {
+		_ = h.Files.Delete(id)
+	}
+}
+
+// SubmitExportJob godoc
+// @Summary Starts an user export job
+// @Description Exports in the background the users matching the filters of the user list to a file, downloaded from
+// @Description the result of the job once it succeeded.
+// @Produce json
+// @Param format query string false "ndjson (default) or csv"
+// @Param filter query string false "Filter expression, as in the user list"
+// @Param fields query string false "Comma separated fields, or csv columns, to export"
+// @Param includeDeleted query bool false "Include deleted users, admin only"
+// @Success 202 {object} model.Job
+// @Header 202 {string} Location "/v1/jobs/{id}"
+// @Failure 400 {object} model.ResponseError
+// @Router /jobs/export [post]
+// @Tags jobs
+func (h *JobHandler) SubmitExportJob(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	var contentType string
+	switch params.Get(exportFormatParam) {
+	case "", "ndjson":
+		contentType = ndjsonContentType
+	case "csv":
+		contentType = csvContentType
+	default:
+		description := "invalid value " + params.Get(exportFormatParam) + " for parameter format, it must be ndjson or csv"
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: description})
+		return
+	}
+
+	params.Del(exportFormatParam)
+	r.URL.RawQuery = params.Encode()
+
+	if _, _, ok := h.Users.listFilter(w, r); !ok {
+		return
+	}
+
+	h.submit(w, r, h.newJob(r, model.JobExport, map[string]string{"query": r.URL.RawQuery, "contentType": contentType}))
+}
+
+// SubmitUpdateJob godoc
+// @Summary Starts a mass update job
+// @Description Sets the given fields of every user matching the filter expression in the background, notifying each
+// @Description updated user. Nicknames and passwords can't be set. Admin only.
+// @Accept json
+// @Produce json
+// @Param update body model.UpdateJobRequest true "Users to update and fields to set"
+// @Success 202 {object} model.Job
+// @Header 202 {string} Location "/v1/jobs/{id}"
+// @Failure 400 {object} model.ResponseError
+// @Failure 403 {object} model.ResponseError
+// @Router /jobs/update [post]
+// @Tags jobs
+func (h *JobHandler) SubmitUpdateJob(w http.ResponseWriter, r *http.Request) {
+	if !IsAdmin(r, h.Users.AdminToken) {
+		respondWithJson(w, http.StatusForbidden, model.ResponseError{Description: "only admins can update users in bulk"})
+		return
+	}
+
+	var request model.UpdateJobRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err == nil && request.Filter == "" {
+		err = errMissingUpdateFilter
+	}
+
+	if err == nil {
+		_, err = query.Parse(request.Filter)
+	}
+
+	if err == nil {
+		err = validateUpdateSet(request.Set)
+	}
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		h.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	set, _ := json.Marshal(request.Set)
+
+	h.submit(w, r, h.newJob(r, model.JobUpdate, map[string]string{"filter": request.Filter, "set": string(set)}))
+}
+
+// SubmitReplayJob godoc
+// @Summary Starts an event replay job
+// @Description Publishes again, in the background, the events of the users to every sink. With the audit source the
+// @Description changes of the history are published as they were notified, with the user as it was after each of
+// @Description them. With the snapshot source the current users are published, without changes. Changes, or users,
+// @Description are selected by the time they were updated at, from from and before to, by nickname and by event type.
+// @Description Events are published at most at REPLAY_RATE per second, rate lowering it. Admin only.
+// @Accept json
+// @Produce json
+// @Param replay body model.ReplayRequest true "Events to replay"
+// @Success 202 {object} model.Job
+// @Header 202 {string} Location "/v1/jobs/{id}"
+// @Failure 400 {object} model.ResponseError
+// @Failure 403 {object} model.ResponseError
+// @Router /jobs/replay [post]
+// @Tags jobs
+func (h *JobHandler) SubmitReplayJob(w http.ResponseWriter, r *http.Request) {
+	if !IsAdmin(r, h.Users.AdminToken) {
+		respondWithJson(w, http.StatusForbidden, model.ResponseError{Description: "only admins can replay events"})
+		return
+	}
+
+	var request model.ReplayRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err == nil {
+		err = replay.Validate(&request)
+	}
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		h.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	params, _ := json.Marshal(request)
+
+	h.submit(w, r, h.newJob(r, model.JobReplay, map[string]string{"request": string(params)}))
+}
+
+// GetJob godoc
+// @Summary Retrieves a job
+// @Description Retrieves the status of a job, its progress, the errors of the items that failed and, once it
+// @Description succeeded, the url of its result when it has one. The total is 0 while unknown. Jobs are only found
+// @Description by the principal that submitted them, and by admins.
+// @Produce json
+// @Param id path string true "Job id"
+// @Success 200 {object} model.Job
+// @Failure 404 {object} model.ResponseError
+// @Router /jobs/{id} [get]
+// @Tags jobs
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.findJob(w, r)
+
+	if !ok {
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, withResultUrl(j))
+}
+
+// GetJobResult godoc
+// @Summary Downloads the result of a job
+// @Description Downloads the file produced by a job that succeeded, as the users of an export. Only the principal that
+// @Description submitted the job, and admins, can download it.
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Param id path string true "Job id"
+// @Success 200 {string} string "The result"
+// @Failure 404 {object} model.ResponseError
+// @Router /jobs/{id}/result [get]
+// @Tags jobs
+func (h *JobHandler) GetJobResult(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.findJob(w, r)
+
+	if !ok {
+		return
+	}
+
+	if j.Status != model.JobSucceeded || j.Result == "" {
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "job " + j.Id.Hex() + " has no result"})
+		return
+	}
+
+	in, err := h.Files.Open(j.Result)
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		h.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	defer in.Close()
+
+	w.Header().Set("Content-Type", j.ResultType)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, in); err != nil {
+		f := map[string]interface{}{"msg": "error sending result of job " + j.Id.Hex() + ": " + err.Error()}
+		h.Logger.LogWithFields(r, "error", f)
+	}
+}
+
+// CancelJob godoc
+// @Summary Cancels a job
+// @Description Cancels a queued job right away, and a running one once its worker notices it, keeping what it did.
+// @Description Only the principal that submitted the job, and admins, can cancel it.
+// @Produce json
+// @Param id path string true "Job id"
+// @Success 200 {object} model.Job
+// @Failure 404 {object} model.ResponseError
+// @Failure 409 {object} model.ResponseError
+// @Router /jobs/{id}:cancel [post]
+// @Tags jobs
+func (h *JobHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	found, ok := h.findJob(w, r)
+
+	if !ok {
+		return
+	}
+
+	id := found.Id
+	j, err := h.Repository.Cancel(id)
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		h.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	if j == nil {
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "job " + id.Hex() + " not found!"})
+		return
+	}
+
+	if j.Finished() && j.Status != model.JobCancelled {
+		respondWithJson(w, http.StatusConflict, model.ResponseError{Description: "job " + id.Hex() + " is already " + j.Status})
+		return
+	}
+
+	f := map[string]interface{}{"msg": "job cancelled", "job": id.Hex(), "status": j.Status}
+	h.Logger.LogWithFields(r, "info", f)
+
+	respondWithJson(w, http.StatusOK, withResultUrl(j))
+}
+
+func (h *JobHandler) newJob(r *http.Request, jobType string, params map[string]string) *model.Job {
+	return &model.Job{
+		Type:      jobType,
+		Params:    params,
+		CreatedBy: requestctx.Principal(r.Context()),
+		RequestId: requestctx.RequestID(r.Context()),
+	}
+}
+
+// submit queues the job and answers with it, telling whether it was queued.
+func (h *JobHandler) submit(w http.ResponseWriter, r *http.Request, j *model.Job) bool {
+	if err := h.Queue.Submit(j); err != nil {
+		f := map[string]interface{}{"msg": err}
+		h.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return false
+	}
+
+	f := map[string]interface{}{"msg": "job submitted", "job": j.Id.Hex(), "type": j.Type}
+	h.Logger.LogWithFields(r, "info", f)
+
+	w.Header().Set("Location", "v1/jobs/"+j.Id.Hex())
+	respondWithJson(w, http.StatusAccepted, j)
+	return true
+}
+
+// findJob loads the job of the id in the path, answering the request itself when it can't or when the job isn't
+// for the request, as if it didn't exist.
+func (h *JobHandler) findJob(w http.ResponseWriter, r *http.Request) (*model.Job, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+
+	if err != nil {
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "job " + mux.Vars(r)["id"] + " not found!"})
+		return nil, false
+	}
+
+	j, err := h.Repository.FindById(id)
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		h.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return nil, false
+	}
+
+	if j == nil || !mayAccess(r, j, h.Users.AdminToken) {
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "job " + id.Hex() + " not found!"})
+		return nil, false
+	}
+
+	return j, true
+}
+
+// mayAccess tells whether the request may see or cancel the job: admins every job, others the jobs their principal
+// submitted. Jobs submitted anonymously are left to the admins, anyone being anonymous.
+func mayAccess(r *http.Request, j *model.Job, adminToken string) bool {
+	if IsAdmin(r, adminToken) {
+		return true
+	}
+
+	principal := requestctx.Principal(r.Context())
+	return principal != requestctx.Anonymous && principal == j.CreatedBy
+}
+
+func withResultUrl(j *model.Job) *model.Job {
+	if j.Status == model.JobSucceeded && j.Result != "" {
+		j.ResultUrl = "v1/jobs/" + j.Id.Hex() + "/result"
+	}
+	return j
+}
+
+var errMissingUpdateFilter = errors.New("the filter of the users to update is required")
+
+// validateUpdateSet checks the fields set by a mass update, which can't be the nickname nor the password.
+func validateUpdateSet(set model.UserPatch) error {
+	if set.Nickname != nil || set.Password != nil {
+		return errors.New("nicknames and passwords can't be updated in bulk")
+	}
+
+	if set.Email == nil && set.Country == nil && set.FirstName == nil && set.LastName == nil {
+		return errors.New("no field to set")
+	}
+
+	if set.Email != nil {
+		if err := validator.New().Var(*set.Email, "required,email"); err != nil {
+			return errors.New("invalid email " + *set.Email)
+		}
+	}
+
+	for _, value := range []*string{set.Country, set.FirstName, set.LastName} {
+		if value != nil && *value == "" {
+			return errors.New("fields can't be set empty")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"github.com/bernardoms/user-api/internal/requestctx"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+)
+
+// PrincipalHeader carries the authenticated caller, set by the gateway in front of the api.
+const PrincipalHeader = "X-Principal"
+
+// RequestIDHeader correlates a request across services. It is generated when the caller doesn't send one.
+const RequestIDHeader = "X-Request-Id"
+
+// WithPrincipal puts the caller from PrincipalHeader in the request context.
+func WithPrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := r.Header.Get(PrincipalHeader); p != "" {
+			r = r.WithContext(requestctx.WithPrincipal(r.Context(), p))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WithRequestID puts the request id in the request context and echoes it in the response.
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = primitive.NewObjectID().Hex()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(requestctx.WithRequestID(r.Context(), id)))
+	})
+}
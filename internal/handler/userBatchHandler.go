@@ -0,0 +1,379 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/repository"
+	"github.com/bernardoms/user-api/internal/requestctx"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/go-playground/validator.v9"
+	"net/http"
+	"strconv"
+)
+
+const maxBatchOperations = 1000
+
+var errBatchRolledBack = errors.New("batch rolled back")
+
+// BatchUsers godoc
+// @Summary Creates, updates and deletes users in a single request
+// @Description Runs a list of operations: create with an user, update setting the fields of set to the user with
+// @Description the nickname, as a PATCH does, or delete the user with the nickname. Each result tells the status the
+// @Description operation would have been answered with on its own. Operations are independent, unless atomic is
+// @Description set: they then run in a mongo transaction, stopping at the first failure, after which none of them is
+// @Description kept. Changes are recorded in the history and updated users are notified once they are kept. Creates
+// @Description and deletes are not notified, as on their own: the only event published is the user update.
+// @Accept json
+// @Produce json
+// @Param batch body model.BatchRequest true "Operations, up to 1000"
+// @Success 200 {object} model.BatchResponse
+// @Failure 400 {object} model.ResponseError
+// @Router /users:batch [post]
+// @Tags users
+func (u *UserHandler) BatchUsers(w http.ResponseWriter, r *http.Request) {
+	var request model.BatchRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err == nil {
+		err = validateBatch(request.Operations)
+	}
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	b := &batch{handler: u, r: r, operations: request.Operations, validate: validator.New()}
+	response := model.BatchResponse{Atomic: request.Atomic, Committed: true}
+
+	if request.Atomic {
+		err = u.Repository.InTransaction(func(tx repository.UserRepository) error {
+			if !b.run(tx, true) {
+				return errBatchRolledBack
+			}
+			return nil
+		})
+
+		if err == errBatchRolledBack {
+			b.rollBack()
+			response.Committed = false
+		} else if err != nil {
+			f := map[string]interface{}{"msg": "error running batch in a transaction: " + err.Error()}
+			u.Logger.LogWithFields(r, "error", f)
+			respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+			return
+		}
+	} else {
+		b.run(u.Repository, false)
+	}
+
+	if response.Committed {
+		b.commit()
+	}
+
+	response.Results = b.results
+	for _, result := range b.results {
+		if result.Status < http.StatusBadRequest {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+
+	f := map[string]interface{}{"msg": fmt.Sprintf("ran batch of %d operations: %d succeeded, %d failed, committed %t",
+		len(b.results), response.Succeeded, response.Failed, response.Committed)}
+	u.Logger.LogWithFields(r, "info", f)
+
+	respondWithJson(w, http.StatusOK, response)
+}
+
+// validateBatch checks that every operation has what it needs before any of them runs.
+func validateBatch(operations []model.BatchOperation) error {
+	if len(operations) == 0 {
+		return errors.New("the batch has no operations")
+	}
+
+	if len(operations) > maxBatchOperations {
+		return fmt.Errorf("the batch has %d operations, it can't have more than %d", len(operations), maxBatchOperations)
+	}
+
+	for n, op := range operations {
+		var err error
+
+		switch op.Op {
+		case model.OperationCreate:
+			if op.User == nil {
+				err = errors.New("the user to create is required")
+			}
+		case model.OperationUpdate:
+			if op.Nickname == "" || op.Set == nil {
+				err = errors.New("the nickname and the fields to set are required")
+			}
+		case model.OperationDelete:
+			if op.Nickname == "" {
+				err = errors.New("the nickname is required")
+			}
+		default:
+			err = fmt.Errorf("unknown op %s, it must be %s, %s or %s", op.Op, model.OperationCreate, model.OperationUpdate, model.OperationDelete)
+		}
+
+		if err != nil {
+			return fmt.Errorf("operation %d: %v", n, err)
+		}
+	}
+	return nil
+}
+
+// batch runs the operations of a batch, keeping the result of each and what to record and notify of the successful
+// ones, once they are kept.
+type batch struct {
+	handler    *UserHandler
+	r          *http.Request
+	operations []model.BatchOperation
+	validate   *validator.Validate
+
+	results []model.BatchResult
+	effects []batchEffect
+}
+
+type batchEffect struct {
+	index     int
+	operation string
+	user      *model.User
+	changes   []model.FieldChange
+}
+
+// run runs the operations on the repository, stopping at the first failure when asked to. It tells whether all of
+// them succeeded. A batch can be run again, as when its transaction is retried.
+func (b *batch) run(repo repository.UserRepository, stopOnFailure bool) bool {
+	b.results, b.effects = make([]model.BatchResult, 0, len(b.operations)), nil
+
+	for n, op := range b.operations {
+		var result model.BatchResult
+		var effect *batchEffect
+
+		switch op.Op {
+		case model.OperationCreate:
+			result, effect = b.create(repo, op)
+		case model.OperationUpdate:
+			result, effect = b.update(repo, op)
+		case model.OperationDelete:
+			result, effect = b.delete(repo, op)
+		}
+
+		b.results = append(b.results, result)
+
+		if effect != nil {
+			effect.index = n
+			b.effects = append(b.effects, *effect)
+		}
+
+		if result.Status >= http.StatusBadRequest && stopOnFailure {
+			return false
+		}
+	}
+	return true
+}
+
+// rollBack marks the operations that succeeded, and the ones not run, as failed by the failure of another one.
+func (b *batch) rollBack() {
+	failed := len(b.results) - 1
+	reason := "rolled back, operation " + strconv.Itoa(failed) + " failed"
+
+	for n := range b.results[:failed] {
+		b.results[n].Status, b.results[n].Error = http.StatusFailedDependency, reason
+	}
+
+	for _, op := range b.operations[len(b.results):] {
+		b.results = append(b.results, model.BatchResult{Op: op.Op, Nickname: batchNickname(op),
+			Status: http.StatusFailedDependency, Error: "not run, operation " + strconv.Itoa(failed) + " failed"})
+	}
+
+	b.effects = nil
+}
+
+// commit records the kept changes in the history and notifies the users updated. Creates and deletes are only
+// recorded, as they are by SaveUser and DeleteUserByNickname, since the only event published is the user update.
+// Updates that change nothing are not written and have no effect to commit.
+func (b *batch) commit() {
+	for _, e := range b.effects {
+		b.handler.recordAudit(b.r, e.operation, e.user.Id, e.user.Nickname, e.changes)
+
+		if e.operation != model.OperationUpdate {
+			continue
+		}
+
+		if err := b.handler.NotifyHandler.Publish(e.user, e.changes); err != nil {
+			f := map[string]interface{}{"msg": err}
+			b.handler.Logger.LogWithFields(b.r, "error", f)
+			b.results[e.index].Status, b.results[e.index].Error = http.StatusInternalServerError, "updated but not notified: "+err.Error()
+		}
+	}
+}
+
+func (b *batch) create(repo repository.UserRepository, op model.BatchOperation) (model.BatchResult, *batchEffect) {
+	result := model.BatchResult{Op: op.Op, Nickname: op.User.Nickname}
+
+	if err := b.validate.Struct(op.User); err != nil {
+		return failed(result, http.StatusBadRequest, err), nil
+	}
+
+	if err := model.CheckNickname(op.User.Nickname); err != nil {
+		return failed(result, http.StatusBadRequest, err), nil
+	}
+
+	existing, err := repo.FindByNickname(op.User.Nickname)
+
+	if err != nil {
+		return failed(result, http.StatusInternalServerError, err), nil
+	}
+
+	if existing != nil {
+		return failed(result, http.StatusConflict, errors.New("user with nick name "+op.User.Nickname+" already exist!")), nil
+	}
+
+	user := op.User.User()
+	user.Password = hashAndSalt([]byte(user.Password))
+	user.Id = primitive.NewObjectID()
+	user.CreatedBy = requestctx.Principal(b.r.Context())
+	user.UpdatedBy = user.CreatedBy
+
+	if _, err := repo.Save(user); repository.IsDuplicateKey(err) {
+		return failed(result, http.StatusConflict, errors.New("user with nick name "+user.Nickname+" already exist!")), nil
+	} else if err != nil {
+		return failed(result, http.StatusInternalServerError, err), nil
+	}
+
+	result.Status, result.Id = http.StatusCreated, user.Id.Hex()
+
+	return result, &batchEffect{operation: model.OperationCreate, user: user,
+		changes: append(model.Diff(nil, user), model.PasswordChange(false))}
+}
+
+func (b *batch) update(repo repository.UserRepository, op model.BatchOperation) (model.BatchResult, *batchEffect) {
+	result := model.BatchResult{Op: op.Op, Nickname: op.Nickname}
+
+	before, err := repo.FindByNickname(op.Nickname)
+
+	if err != nil {
+		return failed(result, http.StatusInternalServerError, err), nil
+	}
+
+	if before == nil {
+		return failed(result, http.StatusNotFound, errors.New("user with nickname "+op.Nickname+" not found!")), nil
+	}
+
+	user := op.Set.Apply(before)
+
+	if err := b.validate.Struct(user); err != nil {
+		return failed(result, http.StatusBadRequest, err), nil
+	}
+
+	if user.Nickname != before.Nickname {
+		if err := model.CheckNickname(user.Nickname); err != nil {
+			return failed(result, http.StatusBadRequest, err), nil
+		}
+
+		taken, err := repo.FindByNickname(user.Nickname)
+
+		if err != nil {
+			return failed(result, http.StatusInternalServerError, err), nil
+		}
+
+		if taken != nil {
+			return failed(result, http.StatusConflict, errors.New("user with nick name "+user.Nickname+" already exist!")), nil
+		}
+	}
+
+	passwordChanged := op.Set.Password != nil &&
+		bcrypt.CompareHashAndPassword([]byte(before.Password), []byte(*op.Set.Password)) != nil
+
+	if passwordChanged {
+		user.Password = hashAndSalt([]byte(*op.Set.Password))
+	} else {
+		user.Password = before.Password
+	}
+
+	changes := model.Diff(before, user)
+	if passwordChanged {
+		changes = append(changes, model.PasswordChange(before.Password != ""))
+	}
+
+	result.Status, result.Id = http.StatusOK, before.Id.Hex()
+
+	if len(changes) == 0 {
+		return result, nil
+	}
+
+	user.UpdatedBy = requestctx.Principal(b.r.Context())
+
+	written, err := repo.FindAndUpdateById(before.Id, user)
+
+	if repository.IsDuplicateKey(err) {
+		return failed(result, http.StatusConflict, errors.New("user with nick name "+user.Nickname+" already exist!")), nil
+	}
+
+	if err != nil {
+		return failed(result, http.StatusInternalServerError, err), nil
+	}
+
+	if written == nil {
+		return failed(result, http.StatusNotFound, errors.New("user with nickname "+op.Nickname+" not found!")), nil
+	}
+
+	// the user may have been updated since it was read, the changes are those of this write
+	changes = model.Diff(written, user)
+	if user.Password != written.Password {
+		changes = append(changes, model.PasswordChange(written.Password != ""))
+	}
+
+	if len(changes) == 0 {
+		return result, nil
+	}
+
+	return result, &batchEffect{operation: model.OperationUpdate, user: user, changes: changes}
+}
+
+func (b *batch) delete(repo repository.UserRepository, op model.BatchOperation) (model.BatchResult, *batchEffect) {
+	result := model.BatchResult{Op: op.Op, Nickname: op.Nickname}
+
+	before, err := repo.FindByNickname(op.Nickname)
+
+	if err == nil && before != nil {
+		var deleted int64
+		deleted, err = repo.Delete(op.Nickname)
+		if deleted == 0 {
+			before = nil
+		}
+	}
+
+	if err != nil {
+		return failed(result, http.StatusInternalServerError, err), nil
+	}
+
+	if before == nil {
+		return failed(result, http.StatusNotFound, errors.New("user with nickname "+op.Nickname+" not found!")), nil
+	}
+
+	result.Status, result.Id = http.StatusNoContent, before.Id.Hex()
+
+	return result, &batchEffect{operation: model.OperationDelete, user: before, changes: model.Diff(before, nil)}
+}
+
+func failed(result model.BatchResult, status int, err error) model.BatchResult {
+	result.Status, result.Error = status, err.Error()
+	return result
+}
+
+func batchNickname(op model.BatchOperation) string {
+	if op.Op == model.OperationCreate && op.User != nil {
+		return op.User.Nickname
+	}
+	return op.Nickname
+}
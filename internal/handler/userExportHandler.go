@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"github.com/bernardoms/user-api/internal/model"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+	csvContentType    = "text/csv"
+
+	// RecordCountTrailer is the trailer telling how many users were exported.
+	RecordCountTrailer = "X-Record-Count"
+	// ExportErrorTrailer is the trailer holding the error stopping an export once users were sent.
+	ExportErrorTrailer = "X-Export-Error"
+
+	exportFlushEvery = 1000
+)
+
+// ExportUsers godoc
+// @Summary Exports users
+// @Description Streams all the users matching the filters of the user list, one per line as json or as csv rows
+// @Description with a header, chosen by the Accept header. The response is gzipped when accepted. The number of
+// @Description users is sent in the X-Record-Count trailer, along with X-Export-Error when the export failed midway.
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Param Accept header string false "application/x-ndjson (default) or text/csv"
+// @Param Accept-Encoding header string false "gzip to compress the export"
+// @Param filter query string false "Filter expression, as in the user list"
+// @Param fields query string false "Comma separated fields, or csv columns, to export"
+// @Param includeDeleted query bool false "Include deleted users, admin only"
+// @Success 200 {string} string "The users"
+// @Failure 400 {object} model.ResponseError
+// @Failure 406 {object} model.ResponseError
+// @Router /users/export [get]
+// @Tags users
+func (u *UserHandler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	contentType, ok := negotiateExport(r.Header.Get("Accept"))
+
+	if !ok {
+		description := "users can only be exported as " + ndjsonContentType + " or " + csvContentType
+		respondWithJson(w, http.StatusNotAcceptable, model.ResponseError{Description: description})
+		return
+	}
+
+	filter, fields, ok := u.listFilter(w, r)
+
+	if !ok {
+		return
+	}
+
+	filter.Fields = fields
+
+	if len(fields) == 0 {
+		fields = model.UserFields
+	}
+
+	e := &export{w: w, out: w, contentType: contentType, fields: fields, gzip: acceptsGzip(r.Header.Get("Accept-Encoding"))}
+
+	err := u.Repository.Stream(filter, e.write)
+
+	if err == nil {
+		err = e.close()
+	}
+
+	if err != nil && !e.started {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	if err != nil {
+		f := map[string]interface{}{"msg": "export stopped after " + strconv.Itoa(e.count) + " users: " + err.Error()}
+		u.Logger.LogWithFields(r, "error", f)
+		w.Header().Set(ExportErrorTrailer, err.Error())
+		_ = e.close()
+	}
+
+	w.Header().Set(RecordCountTrailer, strconv.Itoa(e.count))
+}
+
+// export writes the users as they are read. The response only starts with the first user, or at the end of an
+// empty export, so an error reading the first users can still be answered with a 500. Without w, the users are only
+// written to out, as in the export jobs.
+type export struct {
+	w           http.ResponseWriter
+	out         io.Writer
+	contentType string
+	fields      []string
+	gzip        bool
+
+	started bool
+	closed  bool
+	gz      *gzip.Writer
+	csv     *csv.Writer
+	count   int
+}
+
+func (e *export) start() error {
+	e.started = true
+
+	if e.w != nil {
+		e.startResponse()
+	}
+
+	if e.contentType != csvContentType {
+		return nil
+	}
+
+	e.csv = csv.NewWriter(e.out)
+	return e.csv.Write(e.fields)
+}
+
+func (e *export) startResponse() {
+	h := e.w.Header()
+	h.Set("Content-Type", e.contentType)
+	h.Set("Vary", "Accept, Accept-Encoding")
+	h.Set("Trailer", RecordCountTrailer+", "+ExportErrorTrailer)
+
+	if e.gzip {
+		h.Set("Content-Encoding", "gzip")
+		e.gz = gzip.NewWriter(e.w)
+		e.out = e.gz
+	}
+
+	e.w.WriteHeader(http.StatusOK)
+}
+
+func (e *export) write(user *model.User) error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	response := model.NewUserResponse(user)
+
+	var err error
+	if e.csv != nil {
+		err = e.csv.Write(csvRecord(response, e.fields))
+	} else {
+		_, err = e.out.Write(append(sparseUser(response, e.fields), '\n'))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	e.count++
+	if e.count%exportFlushEvery == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *export) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if e.gz != nil {
+		if err := e.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// close ends the export, starting it first when there were no users.
+func (e *export) close() error {
+	if e.closed {
+		return nil
+	}
+
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	e.closed = true
+
+	err := e.flush()
+
+	if e.gz != nil {
+		if gzErr := e.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	return err
+}
+
+func csvRecord(user model.UserResponse, fields []string) []string {
+	record := make([]string, 0, len(fields))
+	for _, f := range fields {
+		record = append(record, csvValue(user, f))
+	}
+	return record
+}
+
+func csvValue(user model.UserResponse, field string) string {
+	switch field {
+	case "id":
+		return user.Id.Hex()
+	case "email":
+		return user.Email
+	case "country":
+		return user.Country
+	case "nickname":
+		return user.Nickname
+	case "lastName":
+		return user.LastName
+	case "firstName":
+		return user.FirstName
+	case "createdAt":
+		return csvTime(user.CreatedAt)
+	case "createdBy":
+		return user.CreatedBy
+	case "updatedAt":
+		return csvTime(user.UpdatedAt)
+	case "updatedBy":
+		return user.UpdatedBy
+	case "deletedAt":
+		return csvTime(user.DeletedAt)
+	}
+	return ""
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// negotiateExport picks the export format of the Accept header, the one with the highest quality. Without header,
+// or for */*, users are exported as ndjson.
+func negotiateExport(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return ndjsonContentType, true
+	}
+
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, quality := mediaRange(part)
+
+		var candidate string
+		switch mediaType {
+		case ndjsonContentType, "application/*", "*/*":
+			candidate = ndjsonContentType
+		case csvContentType, "text/*":
+			candidate = csvContentType
+		}
+
+		if candidate != "" && quality > bestQuality {
+			best, bestQuality = candidate, quality
+		}
+	}
+	return best, best != ""
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, quality := mediaRange(part)
+		if (coding == "gzip" || coding == "*") && quality > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaRange splits an element of an Accept header in its lowercased value and quality, 1 when not given.
+func mediaRange(part string) (string, float64) {
+	params := strings.Split(part, ";")
+	quality := 1.0
+
+	for _, p := range params[1:] {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "q=") {
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(p, "q="), 64); err == nil {
+				quality = q
+			}
+		}
+	}
+
+	return strings.ToLower(strings.TrimSpace(params[0])), quality
+}
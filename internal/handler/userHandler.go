@@ -2,9 +2,12 @@ package handler
 
 import (
 	"encoding/json"
+	"github.com/bernardoms/user-api/config"
 	"github.com/bernardoms/user-api/internal/logger"
 	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/query"
 	"github.com/bernardoms/user-api/internal/repository"
+	"github.com/bernardoms/user-api/internal/requestctx"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,34 +15,79 @@ import (
 	"gopkg.in/go-playground/validator.v9"
 	"log"
 	"net/http"
+	"net/url"
+	"reflect"
+	"time"
 )
 
-var decoder = schema.NewDecoder()
+var decoder = newDecoder()
+
+func newDecoder() *schema.Decoder {
+	d := schema.NewDecoder()
+	d.IgnoreUnknownKeys(true)
+	d.RegisterConverter(time.Time{}, func(value string) reflect.Value {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(t)
+	})
+	return d
+}
 
 type UserHandler struct {
 	Repository    repository.UserRepository
 	NotifyHandler NotifyInterface
 	Logger        *logger.Logger
+	// Audit records the history of changes, it is skipped when nil
+	Audit repository.AuditRepository
+	// AdminToken grants access to deleted users
+	AdminToken string
+	// Import configures the user imports, read from the environment when nil
+	Import *config.ImportConfig
+	// Replay configures the event replays, read from the environment when nil
+	Replay *config.ReplayConfig
+	// Cache serves the users read by nickname, they are read from the Repository when nil
+	Cache UserCacheInterface
 }
 
 // GetAllUsers godoc
 // @Summary Retrieves user based on a given filter
-// @Description Get all users
+// @Description Get all users. Field parameters take comma separated values (country=UK,BR), negated with a leading !
+// @Description (country=!UK), prefixes ending with * (nickname=bob*) and, on timestamps, ranges from..to of dates
+// @Description or RFC 3339 times (createdAt=2020-01-01..2020-02-01). The filter parameter combines such comparisons
+// @Description with and, or, not and parentheses: country=UK and (nickname=bob* or not email=bob@test.com).
 // @Produce json
 // @Param nickname query string false "User nickname"
-// @Success 200 {object} model.User
+// @Param email query string false "User email"
+// @Param firstName query string false "User first name"
+// @Param lastName query string false "User last name"
+// @Param country query string false "User country"
+// @Param createdAt query string false "Creation date or range"
+// @Param updatedAt query string false "Update date or range"
+// @Param filter query string false "Filter expression"
+// @Param fields query string false "Comma separated fields to return, all but the password by default"
+// @Param createdSince query string false "Created at or after, RFC 3339"
+// @Param createdBefore query string false "Created before, RFC 3339"
+// @Param updatedSince query string false "Updated at or after, RFC 3339"
+// @Param updatedBefore query string false "Updated before, RFC 3339"
+// @Param includeDeleted query bool false "Include deleted users, admin only"
+// @Param If-None-Match header string false "ETag of a previous response"
+// @Success 200 {array} model.UserResponse
+// @Success 304
+// @Failure 400 {object} model.ResponseError
+// @Header 200 {string} ETag "Strong validator of the list"
 // @Router /users [get]
 // @Tags users
 func (u *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	filter := new(model.Filter)
-	err := decoder.Decode(filter, r.URL.Query())
+	filter, fields, ok := u.listFilter(w, r)
 
-	if err != nil {
-		f := map[string]interface{}{"msg": "Error in GET parameters " + err.Error(), "parameters": r.URL.Query()}
-		u.Logger.LogWithFields(r, "error", f)
-		log.Println("Error in GET parameters : ", err)
+	if !ok {
+		return
 	}
 
+	filter.Fields = fields
+
 	results, err := u.Repository.FindAllByFilter(filter)
 
 	if err != nil {
@@ -49,7 +97,50 @@ func (u *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithJson(w, http.StatusOK, results)
+	if len(fields) > 0 {
+		respondWithCacheableJson(w, r, sparse(model.NewUserResponses(results), fields))
+		return
+	}
+
+	respondWithCacheableJson(w, r, model.NewUserResponses(results))
+}
+
+// listFilter reads the filters and the fields to return of a request listing users, answering the request itself
+// when they are invalid or not allowed.
+func (u *UserHandler) listFilter(w http.ResponseWriter, r *http.Request) (*model.Filter, []string, bool) {
+	filter, fields, err := parseListFilter(r.URL.Query())
+
+	if err != nil {
+		f := map[string]interface{}{"msg": "Error in GET parameters " + err.Error(), "parameters": r.URL.Query()}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: "invalid query parameters: " + err.Error()})
+		return nil, nil, false
+	}
+
+	if filter.IncludeDeleted && !IsAdmin(r, u.AdminToken) {
+		respondWithJson(w, http.StatusForbidden, model.ResponseError{Description: "only admins can list deleted users"})
+		return nil, nil, false
+	}
+
+	return filter, fields, true
+}
+
+// parseListFilter reads the filters and the fields to return of the parameters listing users.
+func parseListFilter(params url.Values) (*model.Filter, []string, error) {
+	filter := new(model.Filter)
+	err := decoder.Decode(filter, params)
+
+	if err == nil {
+		filter.Query, err = query.FromParams(params)
+	}
+
+	var fields []string
+
+	if err == nil {
+		fields, err = selectedFields(params)
+	}
+
+	return filter, fields, err
 }
 
 // GetAllUsers godoc
@@ -57,13 +148,13 @@ func (u *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 // @Description Retrieves an user by a given nickname
 // @Produce json
 // @Param nickname path string true "User nickname"
-// @Success 200 {object} model.User
+// @Success 200 {object} model.UserResponse
 // @Router /users/{nickname} [get]
 // @Tags users
 func (u *UserHandler) GetUserByNickname(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	result, err := u.Repository.FindByNickname(vars["nickname"])
+	result, err := u.findResponse(vars["nickname"])
 
 	if err != nil {
 		f := map[string]interface{}{"msg": err}
@@ -81,18 +172,51 @@ func (u *UserHandler) GetUserByNickname(w http.ResponseWriter, r *http.Request)
 	respondWithJson(w, http.StatusOK, result)
 }
 
+// findResponse returns the user with the nickname as answered by the api, from the cache when there is one.
+func (u *UserHandler) findResponse(nickname string) (*model.UserResponse, error) {
+	if u.Cache != nil {
+		return u.Cache.FindResponseByNickname(nickname)
+	}
+
+	user, err := u.Repository.FindByNickname(nickname)
+
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	response := model.NewUserResponse(user)
+	return &response, nil
+}
+
 // DeleteUserByNickname godoc
 // @Summary Deletes an user by a given nickname
-// @Description Deletes an user by a given nickname
+// @Description Deletes an user by a given nickname. The user is hidden and can be restored until it is purged.
 // @Produce json
 // @Param nickname path string true "User nickname"
+// @Param idempotent query bool false "Answer 204 instead of 404 when there is no user with the nickname"
 // @Success 204
+// @Failure 404 {object} model.ResponseError
 // @Router /users/{nickname} [delete]
 // @Tags users
 func (u *UserHandler) DeleteUserByNickname(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	err := u.Repository.Delete(vars["nickname"])
+	before, err := u.auditedBefore(vars["nickname"])
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	u.deleteUser(w, r, vars["nickname"], before)
+}
+
+// deleteUser deletes the user with the nickname. before is the user being deleted, recorded in the history when
+// known.
+func (u *UserHandler) deleteUser(w http.ResponseWriter, r *http.Request, nickname string, before *model.User) {
+	deleted, err := u.Repository.Delete(nickname)
 
 	if err != nil {
 		f := map[string]interface{}{"msg": err}
@@ -101,24 +225,63 @@ func (u *UserHandler) DeleteUserByNickname(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if deleted == 0 {
+		if r.URL.Query().Get("idempotent") == "true" {
+			respondWithEmpty(w, http.StatusNoContent, "")
+			return
+		}
+		f := map[string]interface{}{"msg": "user with nickname " + nickname + " not found!", "nickname": nickname}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "user with nickname " + nickname + " not found!"})
+		return
+	}
+
+	if before != nil {
+		u.recordAudit(r, model.OperationDelete, before.Id, before.Nickname, model.Diff(before, nil))
+	}
+
 	respondWithEmpty(w, http.StatusNoContent, "")
 }
 
-// SaveUser godoc
-// @Summary create an user
-// @Description create an user
+// RestoreUser godoc
+// @Summary Restores a deleted user
+// @Description Restores the most recently deleted user with the nickname, admin only
 // @Produce json
-// @Param user body model.User true "Create user"
-// @Success 201
-// @Header 201 {string} Location "/v1/users/{nickname}
-// @Router /users [post]
+// @Param nickname path string true "User nickname"
+// @Success 204
+// @Failure 404 {object} model.ResponseError
+// @Failure 409 {object} model.ResponseError
+// @Router /users/{nickname}:restore [post]
 // @Tags users
-func (u *UserHandler) SaveUser(w http.ResponseWriter, r *http.Request) {
-	v := validator.New()
+func (u *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
 
-	var user *model.User
+	if !IsAdmin(r, u.AdminToken) {
+		respondWithJson(w, http.StatusForbidden, model.ResponseError{Description: "only admins can restore users"})
+		return
+	}
 
-	err := json.NewDecoder(r.Body).Decode(&user)
+	active, err := u.Repository.FindByNickname(vars["nickname"])
+
+	if err == nil && active != nil {
+		f := map[string]interface{}{"msg": "user with nick name " + vars["nickname"] + " already exist!"}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusConflict, model.ResponseError{Description: "user with nick name " + vars["nickname"] + " already exist!"})
+		return
+	}
+
+	var restored *model.User
+
+	if err == nil {
+		restored, err = u.Repository.Restore(vars["nickname"])
+	}
+
+	if repository.IsDuplicateKey(err) {
+		f := map[string]interface{}{"msg": "user with nick name " + vars["nickname"] + " already exist!"}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusConflict, model.ResponseError{Description: "user with nick name " + vars["nickname"] + " already exist!"})
+		return
+	}
 
 	if err != nil {
 		f := map[string]interface{}{"msg": err}
@@ -127,9 +290,36 @@ func (u *UserHandler) SaveUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = v.Struct(user)
+	if restored == nil {
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "deleted user with nickname " + vars["nickname"] + " not found!"})
+		return
+	}
 
-	if err != nil {
+	u.recordAudit(r, model.OperationRestore, restored.Id, restored.Nickname, model.Diff(nil, restored))
+
+	respondWithEmpty(w, http.StatusNoContent, "")
+}
+
+// SaveUser godoc
+// @Summary create an user
+// @Description create an user
+// @Produce json
+// @Param user body model.UserRequest true "Create user"
+// @Success 201
+// @Header 201 {string} Location "/v1/users/id/{id}"
+// @Failure 400 {object} model.ResponseError
+// @Router /users [post]
+// @Tags users
+func (u *UserHandler) SaveUser(w http.ResponseWriter, r *http.Request) {
+	request, ok := u.decodeUserRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	user := request.User()
+
+	if err := model.CheckNickname(user.Nickname); err != nil {
 		f := map[string]interface{}{"msg": err}
 		u.Logger.LogWithFields(r, "info", f)
 		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
@@ -153,6 +343,8 @@ func (u *UserHandler) SaveUser(w http.ResponseWriter, r *http.Request) {
 
 	user.Password = hashAndSalt([]byte(user.Password))
 	user.Id = primitive.NewObjectID()
+	user.CreatedBy = requestctx.Principal(r.Context())
+	user.UpdatedBy = user.CreatedBy
 
 	inserted, err := u.Repository.Save(user)
 
@@ -161,22 +353,33 @@ func (u *UserHandler) SaveUser(w http.ResponseWriter, r *http.Request) {
 		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
 		return
 	}
-	respondWithEmpty(w, http.StatusCreated, "v1/users/"+inserted.Nickname)
+
+	u.recordAudit(r, model.OperationCreate, user.Id, user.Nickname, append(model.Diff(nil, user), model.PasswordChange(false)))
+
+	respondWithEmpty(w, http.StatusCreated, "v1/users/id/"+inserted.Id.Hex())
 }
 
 // UpdateUser godoc
 // @Summary Update an user by a given nickname and notify to a topic
-// @Description Update an user by a given nickname and notify to a topic
+// @Description Update an user by a given nickname and notify what changed to a topic. Nothing is notified when
+// @Description nothing changed.
 // @Produce json
 // @Param nickname path string true "User nickname"
+// @Param user body model.UserRequest true "Update user"
 // @Success 204
+// @Failure 400 {object} model.ResponseError
 // @Router /users/{nickname} [put]
 // @Tags users
 func (u *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	var user *model.User
 	vars := mux.Vars(r)
 
-	err := json.NewDecoder(r.Body).Decode(&user)
+	request, ok := u.decodeUserRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	before, err := u.Repository.FindByNickname(vars["nickname"])
 
 	if err != nil {
 		f := map[string]interface{}{"msg": err}
@@ -185,25 +388,130 @@ func (u *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user.Password = hashAndSalt([]byte(user.Password))
+	if before == nil {
+		respondWithEmpty(w, http.StatusNoContent, "")
+		return
+	}
+
+	u.updateUser(w, r, before, request.User(), true)
+}
+
+// decodeUserRequest reads and validates the user sent in the body, answering the request with a 400 when it can't.
+func (u *UserHandler) decodeUserRequest(w http.ResponseWriter, r *http.Request) (*model.UserRequest, bool) {
+	var request model.UserRequest
 
-	result, err := u.Repository.UpdateByNickname(vars["nickname"], user)
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err == nil {
+		err = validator.New().Struct(request)
+	}
 
 	if err != nil {
 		f := map[string]interface{}{"msg": err}
-		u.Logger.LogWithFields(r, "error", f)
-		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+		return nil, false
+	}
+
+	return &request, true
+}
+
+// updateUser replaces the user read as before and notifies what changed. When hashPassword is false the password
+// of the user is already hashed. Nothing is written, recorded or notified when nothing changed, so the update time
+// of the user stays the same. Otherwise the user is written by its id, reading it as it was right before in the same
+// operation, so the changes are those of this update, even when others update the user at the same time. Renames
+// to a nickname already taken are answered with a 409, and to a reserved one with a 400.
+func (u *UserHandler) updateUser(w http.ResponseWriter, r *http.Request, before *model.User, user *model.User, hashPassword bool) {
+	user.Id, user.CreatedAt, user.CreatedBy = before.Id, before.CreatedAt, before.CreatedBy
+
+	plainPassword := user.Password
+
+	if hashPassword && bcrypt.CompareHashAndPassword([]byte(before.Password), []byte(plainPassword)) == nil {
+		user.Password = before.Password
+	} else if hashPassword {
+		user.Password = hashAndSalt([]byte(plainPassword))
+	}
+
+	if len(model.Diff(before, user)) == 0 && user.Password == before.Password {
+		f := map[string]interface{}{"msg": "user " + user.Nickname + " is unchanged, not updating it"}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithEmpty(w, http.StatusNoContent, "")
 		return
 	}
 
-	if result > 0 {
-		err := u.NotifyHandler.Publish(user)
+	if user.Nickname != before.Nickname {
+		if err := model.CheckNickname(user.Nickname); err != nil {
+			f := map[string]interface{}{"msg": err}
+			u.Logger.LogWithFields(r, "info", f)
+			respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+			return
+		}
+
+		taken, err := u.Repository.FindByNickname(user.Nickname)
+
 		if err != nil {
 			f := map[string]interface{}{"msg": err}
 			u.Logger.LogWithFields(r, "error", f)
 			respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
 			return
 		}
+
+		if taken != nil {
+			f := map[string]interface{}{"msg": "user with nick name " + user.Nickname + " already exist!"}
+			u.Logger.LogWithFields(r, "info", f)
+			respondWithJson(w, http.StatusConflict, model.ResponseError{Description: "user with nick name " + user.Nickname + " already exist!"})
+			return
+		}
+	}
+
+	user.UpdatedBy = requestctx.Principal(r.Context())
+
+	before, err := u.Repository.FindAndUpdateById(before.Id, user)
+
+	if repository.IsDuplicateKey(err) {
+		f := map[string]interface{}{"msg": "user with nick name " + user.Nickname + " already exist!"}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusConflict, model.ResponseError{Description: "user with nick name " + user.Nickname + " already exist!"})
+		return
+	}
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	if before == nil {
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "user with id " + user.Id.Hex() + " not found!"})
+		return
+	}
+
+	passwordChanged := user.Password != before.Password
+	if hashPassword && passwordChanged {
+		passwordChanged = bcrypt.CompareHashAndPassword([]byte(before.Password), []byte(plainPassword)) != nil
+	}
+
+	changes := model.Diff(before, user)
+	if passwordChanged {
+		changes = append(changes, model.PasswordChange(before.Password != ""))
+	}
+
+	if len(changes) == 0 {
+		f := map[string]interface{}{"msg": "user " + user.Nickname + " is unchanged, not notifying it"}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithEmpty(w, http.StatusNoContent, "")
+		return
+	}
+
+	u.recordAudit(r, model.OperationUpdate, before.Id, user.Nickname, changes)
+
+	err = u.NotifyHandler.Publish(user, changes)
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return
 	}
 	respondWithEmpty(w, http.StatusNoContent, "")
 }
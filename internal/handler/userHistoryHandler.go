@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/requestctx"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// maxPage bounds how many entries a page skips, which mongo reads through
+	maxPage = 10000
+)
+
+// GetUserHistory godoc
+// @Summary Retrieves the change history of an user
+// @Description Retrieves who changed an user, when and what, most recent first. Sensitive values are masked.
+// @Produce json
+// @Param nickname path string true "User nickname"
+// @Param page query int false "Page, from 1 to 10000"
+// @Param size query int false "Page size, up to 100"
+// @Success 200 {object} model.AuditPage
+// @Router /users/{nickname}/history [get]
+// @Tags users
+func (u *UserHandler) GetUserHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if u.Audit == nil {
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "user history is disabled"})
+		return
+	}
+
+	page, size, err := pagination(r)
+
+	if err != nil {
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	var userId primitive.ObjectID
+
+	user, err := u.Repository.FindByNickname(vars["nickname"])
+
+	if err == nil && user != nil {
+		userId = user.Id
+	}
+
+	var entries []model.AuditEntry
+	var total int64
+
+	if err == nil {
+		entries, total, err = u.Audit.FindByUser(userId, vars["nickname"], page, size)
+	}
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, model.AuditPage{Items: entries, Page: page, Size: size, Total: total})
+}
+
+// auditedBefore loads the state of an user before a change, only when changes are audited.
+func (u *UserHandler) auditedBefore(nickname string) (*model.User, error) {
+	if u.Audit == nil {
+		return nil, nil
+	}
+	return u.Repository.FindByNickname(nickname)
+}
+
+// recordAudit appends an entry to the user history. The change is already done, so a failure is only logged.
+func (u *UserHandler) recordAudit(r *http.Request, operation string, userId primitive.ObjectID, nickname string, changes []model.FieldChange) {
+	u.recordAuditIn(r.Context(), r, operation, userId, nickname, changes)
+}
+
+// recordAuditIn records a change made for the principal and request of the context, out of a request when r is nil.
+func (u *UserHandler) recordAuditIn(ctx context.Context, r *http.Request, operation string, userId primitive.ObjectID, nickname string, changes []model.FieldChange) {
+	if u.Audit == nil {
+		return
+	}
+
+	err := u.Audit.Record(&model.AuditEntry{
+		UserId:    userId,
+		Nickname:  nickname,
+		Operation: operation,
+		Actor:     requestctx.Principal(ctx),
+		RequestId: requestctx.RequestID(ctx),
+		Changes:   changes,
+	})
+
+	if err != nil {
+		f := map[string]interface{}{"msg": "error recording " + operation + " of user " + nickname + " in history: " + err.Error()}
+		u.Logger.LogWithFields(r, "error", f)
+	}
+}
+
+func pagination(r *http.Request) (int, int, error) {
+	page, size := 1, defaultPageSize
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 1 || p > maxPage {
+			return 0, 0, fmt.Errorf("invalid value %s for parameter page, it must be between 1 and %d", v, maxPage)
+		}
+		page = p
+	}
+
+	if v := r.URL.Query().Get("size"); v != "" {
+		s, err := strconv.Atoi(v)
+		if err != nil || s < 1 || s > maxPageSize {
+			return 0, 0, fmt.Errorf("invalid value %s for parameter size, it must be between 1 and %d", v, maxPageSize)
+		}
+		size = s
+	}
+
+	return page, size, nil
+}
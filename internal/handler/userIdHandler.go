@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/go-playground/validator.v9"
+	"net/http"
+)
+
+// GetUserById godoc
+// @Summary Retrieves an user by its id
+// @Description Retrieves an user by its immutable id, which is kept across nickname changes
+// @Produce json
+// @Param id path string true "User id"
+// @Success 200 {object} model.UserResponse
+// @Failure 400 {object} model.ResponseError
+// @Failure 404 {object} model.ResponseError
+// @Router /users/id/{id} [get]
+// @Tags users
+func (u *UserHandler) GetUserById(w http.ResponseWriter, r *http.Request) {
+	user, ok := u.findById(w, r)
+
+	if !ok {
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, model.NewUserResponse(user))
+}
+
+// UpdateUserById godoc
+// @Summary Update an user by its id and notify to a topic
+// @Description Update an user by its id and notify to a topic
+// @Produce json
+// @Param id path string true "User id"
+// @Param user body model.UserRequest true "Update user"
+// @Success 204
+// @Failure 400 {object} model.ResponseError
+// @Failure 404 {object} model.ResponseError
+// @Failure 409 {object} model.ResponseError
+// @Router /users/id/{id} [put]
+// @Tags users
+func (u *UserHandler) UpdateUserById(w http.ResponseWriter, r *http.Request) {
+	request, ok := u.decodeUserRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	before, ok := u.findById(w, r)
+
+	if !ok {
+		return
+	}
+
+	u.updateUser(w, r, before, request.User(), true)
+}
+
+// PatchUserById godoc
+// @Summary Partially update an user by its id and notify to a topic
+// @Description Update only the fields sent, by the user id, and notify to a topic
+// @Produce json
+// @Param id path string true "User id"
+// @Param user body model.UserPatch true "Fields to update"
+// @Success 204
+// @Failure 400 {object} model.ResponseError
+// @Failure 404 {object} model.ResponseError
+// @Failure 409 {object} model.ResponseError
+// @Router /users/id/{id} [patch]
+// @Tags users
+func (u *UserHandler) PatchUserById(w http.ResponseWriter, r *http.Request) {
+	var patch model.UserPatch
+
+	err := json.NewDecoder(r.Body).Decode(&patch)
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	before, ok := u.findById(w, r)
+
+	if !ok {
+		return
+	}
+
+	user := patch.Apply(before)
+
+	err = validator.New().Struct(user)
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	u.updateUser(w, r, before, user, patch.Password != nil)
+}
+
+// DeleteUserById godoc
+// @Summary Deletes an user by its id
+// @Description Deletes an user by its id. The user is hidden and can be restored until it is purged.
+// @Produce json
+// @Param id path string true "User id"
+// @Param idempotent query bool false "Answer 204 instead of 404 when there is no user with the id"
+// @Success 204
+// @Failure 404 {object} model.ResponseError
+// @Router /users/id/{id} [delete]
+// @Tags users
+func (u *UserHandler) DeleteUserById(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+
+	if err != nil {
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: "invalid user id " + mux.Vars(r)["id"]})
+		return
+	}
+
+	before, err := u.Repository.DeleteById(id)
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	if before == nil && r.URL.Query().Get("idempotent") == "true" {
+		respondWithEmpty(w, http.StatusNoContent, "")
+		return
+	}
+
+	if before == nil {
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "user with id " + id.Hex() + " not found!"})
+		return
+	}
+
+	u.recordAudit(r, model.OperationDelete, before.Id, before.Nickname, model.Diff(before, nil))
+
+	respondWithEmpty(w, http.StatusNoContent, "")
+}
+
+// findById loads the user of the id in the path, answering the request itself when it can't.
+func (u *UserHandler) findById(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	vars := mux.Vars(r)
+
+	id, err := primitive.ObjectIDFromHex(vars["id"])
+
+	if err != nil {
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: "invalid user id " + vars["id"]})
+		return nil, false
+	}
+
+	user, err := u.Repository.FindById(id)
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return nil, false
+	}
+
+	if user == nil {
+		f := map[string]interface{}{"msg": "user with id " + vars["id"] + " not found!", "id": vars["id"]}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusNotFound, model.ResponseError{Description: "user with id " + vars["id"] + " not found!"})
+		return nil, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,400 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/repository"
+	"github.com/bernardoms/user-api/internal/requestctx"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/go-playground/validator.v9"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const maxImportLineBytes = 1 << 20
+
+// importColumns are the csv columns of an import, in any order.
+var importColumns = []string{"email", "country", "nickname", "lastName", "firstName", "password"}
+
+// ImportUsers godoc
+// @Summary Imports users
+// @Description Creates the users of a csv file, with a header naming the columns email, country, nickname, lastName,
+// @Description firstName and password, or of a file with an user json per line. Each row is validated as when
+// @Description creating an user and rows with a nickname already taken, in the file or by an existing user, are
+// @Description conflicts. The report tells the outcome of each row. With dryRun nothing is created.
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Param dryRun query bool false "Only validate the file"
+// @Success 200 {object} model.ImportReport
+// @Failure 400 {object} model.ImportReport
+// @Failure 415 {object} model.ResponseError
+// @Router /users/import [post]
+// @Tags users
+func (u *UserHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	c := u.importConfig()
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+	rows, err := newImportReader(r.Header.Get("Content-Type"), http.MaxBytesReader(w, r.Body, c.MaxBytes))
+
+	if err == errUnsupportedImport {
+		description := "users can only be imported from " + csvContentType + " or " + ndjsonContentType
+		respondWithJson(w, http.StatusUnsupportedMediaType, model.ResponseError{Description: description})
+		return
+	}
+
+	if err != nil {
+		f := map[string]interface{}{"msg": "invalid import file: " + err.Error()}
+		u.Logger.LogWithFields(r, "info", f)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: "invalid import file: " + err.Error()})
+		return
+	}
+
+	report := &model.ImportReport{DryRun: dryRun, Rows: []model.ImportRow{}}
+	i := &importer{handler: u, ctx: r.Context(), r: r, config: c, dryRun: dryRun, onRow: report.Add, seen: map[string]bool{}}
+
+	for {
+		batch, err := readBatch(rows, c.BatchSize)
+
+		if len(batch) > 0 {
+			i.importBatch(batch)
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			report.Error = "invalid import file: " + err.Error()
+			f := map[string]interface{}{"msg": report.Error}
+			u.Logger.LogWithFields(r, "info", f)
+			respondWithJson(w, http.StatusBadRequest, report)
+			return
+		}
+	}
+
+	f := map[string]interface{}{"msg": fmt.Sprintf("imported users: %d created, %d valid, %d conflicted, %d invalid, %d failed",
+		report.Created, report.Valid, report.Conflicted, report.Invalid, report.Failed)}
+	u.Logger.LogWithFields(r, "info", f)
+
+	respondWithJson(w, http.StatusOK, report)
+}
+
+// importer imports the rows of a file batch by batch, passing the outcome of each row to onRow. It acts for the
+// principal of the context, r being the request importing the file, or nil in a job.
+type importer struct {
+	handler *UserHandler
+	ctx     context.Context
+	r       *http.Request
+	config  *config.ImportConfig
+	dryRun  bool
+	onRow   func(row model.ImportRow)
+	// seen holds the nicknames of the previous rows, which can't be used again
+	seen map[string]bool
+}
+
+func (i *importer) importBatch(batch []importRow) {
+	v := validator.New()
+	outcomes := make([]model.ImportRow, len(batch))
+
+	var pending []int
+	var nicknames []string
+
+	for n, row := range batch {
+		outcomes[n] = model.ImportRow{Line: row.line, Nickname: row.request.Nickname}
+
+		err := row.err
+		if err == nil {
+			err = v.Struct(row.request)
+		}
+		if err == nil {
+			err = model.CheckNickname(row.request.Nickname)
+		}
+
+		switch {
+		case err != nil:
+			outcomes[n].Status, outcomes[n].Error = model.ImportInvalid, err.Error()
+		case i.seen[row.request.Nickname]:
+			outcomes[n].Status, outcomes[n].Error = model.ImportConflict, "nickname "+row.request.Nickname+" is repeated in the file"
+		default:
+			i.seen[row.request.Nickname] = true
+			pending = append(pending, n)
+			nicknames = append(nicknames, row.request.Nickname)
+		}
+	}
+
+	taken, err := i.handler.Repository.FindNicknames(nicknames)
+
+	if err != nil {
+		i.fail(outcomes, pending, err)
+		i.add(outcomes)
+		return
+	}
+
+	takenNicknames := map[string]bool{}
+	for _, t := range taken {
+		takenNicknames[t] = true
+	}
+
+	var users []*model.User
+	var indexes []int
+
+	for _, n := range pending {
+		if takenNicknames[batch[n].request.Nickname] {
+			outcomes[n].Status, outcomes[n].Error = model.ImportConflict, "user with nick name "+batch[n].request.Nickname+" already exist!"
+			continue
+		}
+		if i.dryRun {
+			outcomes[n].Status = model.ImportValid
+			continue
+		}
+
+		user := batch[n].request.User()
+		user.Id = primitive.NewObjectID()
+		user.CreatedBy = requestctx.Principal(i.ctx)
+		user.UpdatedBy = user.CreatedBy
+
+		users = append(users, user)
+		indexes = append(indexes, n)
+	}
+
+	if len(users) > 0 {
+		i.save(outcomes, users, indexes)
+	}
+
+	i.add(outcomes)
+}
+
+func (i *importer) add(outcomes []model.ImportRow) {
+	for _, outcome := range outcomes {
+		i.onRow(outcome)
+	}
+}
+
+// save hashes the passwords of the users and inserts them, the users being the rows at indexes of the batch.
+func (i *importer) save(outcomes []model.ImportRow, users []*model.User, indexes []int) {
+	hashPasswords(users, i.config.Workers)
+
+	errs, err := i.handler.Repository.SaveMany(users)
+
+	if err != nil {
+		i.fail(outcomes, indexes, err)
+		return
+	}
+
+	for n, user := range users {
+		outcome := &outcomes[indexes[n]]
+
+		switch {
+		case errs[n] == nil:
+			outcome.Status, outcome.Id = model.ImportCreated, user.Id.Hex()
+			i.handler.recordAuditIn(i.ctx, i.r, model.OperationCreate, user.Id, user.Nickname, append(model.Diff(nil, user), model.PasswordChange(false)))
+		case repository.IsDuplicateKey(errs[n]):
+			outcome.Status, outcome.Error = model.ImportConflict, errs[n].Error()
+		default:
+			outcome.Status, outcome.Error = model.ImportFailed, errs[n].Error()
+		}
+	}
+}
+
+func (i *importer) fail(outcomes []model.ImportRow, indexes []int, err error) {
+	f := map[string]interface{}{"msg": "error importing users: " + err.Error()}
+	i.handler.Logger.LogWithFields(i.r, "error", f)
+
+	for _, n := range indexes {
+		outcomes[n].Status, outcomes[n].Error = model.ImportFailed, err.Error()
+	}
+}
+
+// hashPasswords replaces the plain passwords of the users by their hash, hashing with a pool of workers.
+func hashPasswords(users []*model.User, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan *model.User)
+	var wg sync.WaitGroup
+
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				user.Password = hashAndSalt([]byte(user.Password))
+			}
+		}()
+	}
+
+	for _, user := range users {
+		jobs <- user
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// importRow is a row of an import file, err telling why it couldn't be read.
+type importRow struct {
+	line    int
+	request model.UserRequest
+	err     error
+}
+
+// importReader reads the rows of an import file one by one, returning io.EOF after the last. Other errors stop the
+// import, while rows that can't be read are returned with their error.
+type importReader interface {
+	next() (importRow, error)
+}
+
+var errUnsupportedImport = errors.New("unsupported import content type")
+
+func newImportReader(contentType string, body io.Reader) (importReader, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case csvContentType:
+		return newCsvImport(body)
+	case ndjsonContentType:
+		s := bufio.NewScanner(body)
+		s.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+		return &ndjsonImport{scanner: s}, nil
+	}
+	return nil, errUnsupportedImport
+}
+
+// readBatch reads up to size rows, fewer at the end of the file.
+func readBatch(rows importReader, size int) ([]importRow, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("the import batch size must be positive, not %d", size)
+	}
+
+	var batch []importRow
+	for len(batch) < size {
+		row, err := rows.next()
+		if err != nil {
+			return batch, err
+		}
+		batch = append(batch, row)
+	}
+	return batch, nil
+}
+
+type csvImport struct {
+	reader  *csv.Reader
+	columns []string
+	line    int
+}
+
+func newCsvImport(body io.Reader) (*csvImport, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+
+	if err == io.EOF {
+		return nil, errors.New("missing csv header")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(header))
+	for _, column := range header {
+		column = strings.TrimSpace(column)
+		if !contains(importColumns, column) {
+			return nil, fmt.Errorf("unknown column %s, columns must be any of %s", column, strings.Join(importColumns, ", "))
+		}
+		if contains(columns, column) {
+			return nil, fmt.Errorf("repeated column %s", column)
+		}
+		columns = append(columns, column)
+	}
+
+	return &csvImport{reader: reader, columns: columns, line: 1}, nil
+}
+
+func (c *csvImport) next() (importRow, error) {
+	record, err := c.reader.Read()
+
+	if err == io.EOF {
+		return importRow{}, err
+	}
+
+	c.line++
+
+	if pe, ok := err.(*csv.ParseError); ok {
+		return importRow{line: c.line, err: pe.Err}, nil
+	}
+
+	if err != nil {
+		return importRow{}, err
+	}
+
+	if len(record) != len(c.columns) {
+		return importRow{line: c.line, err: fmt.Errorf("expected %d columns, found %d", len(c.columns), len(record))}, nil
+	}
+
+	var request model.UserRequest
+	for n, column := range c.columns {
+		value := strings.TrimSpace(record[n])
+		switch column {
+		case "email":
+			request.Email = value
+		case "country":
+			request.Country = value
+		case "nickname":
+			request.Nickname = value
+		case "lastName":
+			request.LastName = value
+		case "firstName":
+			request.FirstName = value
+		case "password":
+			request.Password = record[n]
+		}
+	}
+
+	return importRow{line: c.line, request: request}, nil
+}
+
+type ndjsonImport struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (n *ndjsonImport) next() (importRow, error) {
+	for n.scanner.Scan() {
+		n.line++
+
+		line := bytes.TrimSpace(n.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var request model.UserRequest
+		d := json.NewDecoder(bytes.NewReader(line))
+		d.DisallowUnknownFields()
+
+		if err := d.Decode(&request); err != nil {
+			return importRow{line: n.line, err: err}, nil
+		}
+		return importRow{line: n.line, request: request}, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
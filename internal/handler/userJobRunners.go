@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/job"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/query"
+	"github.com/bernardoms/user-api/internal/replay"
+	"github.com/bernardoms/user-api/internal/repository"
+	"github.com/bernardoms/user-api/internal/requestctx"
+	"gopkg.in/go-playground/validator.v9"
+	"io"
+	"net/url"
+)
+
+// JobRunners returns the runners of the user jobs, reading and writing their files in the store.
+func (u *UserHandler) JobRunners(files repository.FileStore) map[string]job.Runner {
+	return map[string]job.Runner{
+		model.JobImport: job.RunnerFunc(func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+			return u.runImport(ctx, files, j, progress)
+		}),
+		model.JobExport: job.RunnerFunc(func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+			return u.runExport(ctx, files, j, progress)
+		}),
+		model.JobUpdate: job.RunnerFunc(u.runUpdate),
+		model.JobReplay: job.RunnerFunc(u.runReplay),
+	}
+}
+
+// Replayer publishes again the events of the users to the sinks of the handler.
+func (u *UserHandler) Replayer() *replay.Replayer {
+	c := u.Replay
+	if c == nil {
+		c = config.NewReplayConfig()
+	}
+	return &replay.Replayer{Users: u.Repository, Audit: u.Audit, Publisher: u.NotifyHandler, Rate: c.Rate}
+}
+
+// runReplay publishes again the events of the job, resuming after the last one published.
+func (u *UserHandler) runReplay(ctx context.Context, j *model.Job, progress *job.Progress) error {
+	var request model.ReplayRequest
+
+	if err := json.Unmarshal([]byte(j.Params["request"]), &request); err != nil {
+		return err
+	}
+
+	return u.Replayer().Run(ctx, &request, j.Checkpoint, progress)
+}
+
+func (u *UserHandler) importConfig() *config.ImportConfig {
+	if u.Import == nil {
+		return config.NewImportConfig()
+	}
+	return u.Import
+}
+
+// runImport imports the file of the job as ImportUsers does. The rows before the checkpoint were already imported
+// by a previous run and are skipped. Rows that are not created, or found valid in a dry run, fail with their error.
+func (u *UserHandler) runImport(ctx context.Context, files repository.FileStore, j *model.Job, progress *job.Progress) error {
+	c := u.importConfig()
+
+	in, err := files.Open(j.Input)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	rows, err := newImportReader(j.Params["contentType"], in)
+
+	if err != nil {
+		return errors.New("invalid import file: " + err.Error())
+	}
+
+	i := &importer{handler: u, ctx: ctx, config: c, dryRun: j.Params["dryRun"] == "true", seen: map[string]bool{},
+		onRow: func(row model.ImportRow) {
+			if row.Status == model.ImportCreated || row.Status == model.ImportValid {
+				progress.Add(1, 0)
+				return
+			}
+			progress.Add(1, 1)
+			progress.Fail(fmt.Sprintf("line %d: %s: %s", row.Line, row.Status, row.Error))
+		}}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := readBatch(rows, c.BatchSize)
+
+		for len(batch) > 0 && int64(batch[0].line) <= j.Checkpoint {
+			batch = batch[1:]
+		}
+
+		if len(batch) > 0 {
+			i.importBatch(batch)
+			progress.Checkpoint(int64(batch[len(batch)-1].line))
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return errors.New("invalid import file: " + err.Error())
+		}
+	}
+}
+
+// runExport exports the users matching the filters of the job to a new file, its result. It starts over when
+// resumed, the users having changed since.
+func (u *UserHandler) runExport(ctx context.Context, files repository.FileStore, j *model.Job, progress *job.Progress) error {
+	params, err := url.ParseQuery(j.Params["query"])
+
+	if err != nil {
+		return err
+	}
+
+	filter, fields, err := parseListFilter(params)
+
+	if err != nil {
+		return err
+	}
+
+	filter.Fields = fields
+
+	if len(fields) == 0 {
+		fields = model.UserFields
+	}
+
+	total, err := u.Repository.Count(filter)
+
+	if err != nil {
+		return err
+	}
+
+	progress.Reset(total)
+
+	if j.Result != "" {
+		_ = files.Delete(j.Result)
+		progress.SetResult("", "")
+	}
+
+	id, out, err := files.Create("users-" + j.Id.Hex())
+
+	if err != nil {
+		return err
+	}
+
+	e := &export{out: out, contentType: j.Params["contentType"], fields: fields}
+
+	err = u.Repository.Stream(filter, func(user *model.User) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := e.write(user); err != nil {
+			return err
+		}
+		progress.Add(1, 0)
+		return nil
+	})
+
+	if err == nil {
+		err = e.close()
+	}
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = files.Delete(id)
+		return err
+	}
+
+	progress.SetResult(id, e.contentType)
+	return nil
+}
+
+// runUpdate sets the fields of the job to every user matching its filter, notifying each change. Users already
+// holding the values are left as they are, so a resumed update doesn't change them twice.
+func (u *UserHandler) runUpdate(ctx context.Context, j *model.Job, progress *job.Progress) error {
+	q, err := query.Parse(j.Params["filter"])
+
+	if err != nil {
+		return err
+	}
+
+	var patch model.UserPatch
+
+	if err := json.Unmarshal([]byte(j.Params["set"]), &patch); err != nil {
+		return err
+	}
+
+	filter := &model.Filter{Query: q, Fields: []string{"nickname"}}
+
+	total, err := u.Repository.Count(filter)
+
+	if err != nil {
+		return err
+	}
+
+	progress.Reset(total)
+
+	v := validator.New()
+
+	return u.Repository.Stream(filter, func(found *model.User) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := u.updateFromJob(ctx, v, found.Nickname, patch)
+
+		if err != nil {
+			progress.Add(1, 1)
+			progress.Fail(found.Nickname + ": " + err.Error())
+			return nil
+		}
+
+		progress.Add(1, 0)
+		return nil
+	})
+}
+
+func (u *UserHandler) updateFromJob(ctx context.Context, v *validator.Validate, nickname string, patch model.UserPatch) error {
+	before, err := u.Repository.FindByNickname(nickname)
+
+	if err != nil {
+		return err
+	}
+
+	if before == nil {
+		return errors.New("user not found")
+	}
+
+	user := patch.Apply(before)
+	changes := model.Diff(before, user)
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := v.Struct(user); err != nil {
+		return err
+	}
+
+	user.UpdatedBy = requestctx.Principal(ctx)
+
+	before, err = u.Repository.FindAndUpdateById(before.Id, user)
+
+	if err != nil {
+		return err
+	}
+
+	if before == nil {
+		return errors.New("user not found")
+	}
+
+	// the user may have been updated since it was read, the changes are those of this write
+	changes = model.Diff(before, user)
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	u.recordAuditIn(ctx, nil, model.OperationUpdate, before.Id, user.Nickname, changes)
+
+	if err := u.NotifyHandler.Publish(user, changes); err != nil {
+		return errors.New("updated but not notified: " + err.Error())
+	}
+	return nil
+}
@@ -1,55 +1,220 @@
 package handler
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
 	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/event"
 	"github.com/bernardoms/user-api/internal/logger"
 	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/pkg/eventsig"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Sns publishes the updated users to a topic, in cloud events. Its client, and the connections it keeps, are shared
+// by all publishes. On a FIFO topic the events of an user are grouped by its id, so they are delivered in order, and
+// deduplicated by the version of the user, so a retried publish is delivered once, while replayed events are
+// deduplicated by their own id. Events are signed with the SNS_SIGNING_KEYS.
 type Sns struct {
-	Topic    string
-	Region   string
-	Endpoint string
-	Logger   *logger.Logger
+	Topic   string
+	Fifo    bool
+	Client  snsiface.SNSAPI
+	Events  *event.Encoder
+	Timeout time.Duration
+	Logger  *logger.Logger
 }
 
-func NewSNS(config *config.SnsConfig, logger *logger.Logger) *Sns {
+func NewSNS(config *config.SnsConfig, events *event.Encoder, logger *logger.Logger) (*Sns, error) {
+	if config.Fifo && !strings.HasSuffix(config.Topic, ".fifo") {
+		return nil, errors.New("SNS_FIFO needs a FIFO topic, " + config.Topic + " doesn't end in .fifo")
+	}
+
+	keys, err := eventsig.ParseKeys(config.SigningKeys)
+
+	if err == nil {
+		events, err = events.WithKeys(keys)
+	}
+
+	if err != nil {
+		return nil, errors.New("invalid SNS_SIGNING_KEYS: " + err.Error())
+	}
+
+	sess, err := newAWSSession(config)
+
+	if err != nil {
+		return nil, err
+	}
+
 	newSns := new(Sns)
 	newSns.Topic = config.Topic
-	newSns.Region = config.Region
-	newSns.Endpoint = config.Endpoint
+	newSns.Fifo = config.Fifo
+	newSns.Client = sns.New(sess)
+	newSns.Events = events
+	newSns.Timeout = config.Timeout
 	newSns.Logger = logger
-	return newSns
+	return newSns, nil
+}
+
+// newAWSSession builds the session of the SNS client, with its retries, its pooled connections and the credentials
+// of the config. Credentials are only resolved, and refreshed, when a publish needs them.
+func newAWSSession(c *config.SnsConfig) (*session.Session, error) {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: c.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConns,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   c.ConnectTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	awsConfig := aws.NewConfig().
+		WithRegion(c.Region).
+		WithMaxRetries(c.MaxRetries).
+		WithHTTPClient(&http.Client{Transport: transport})
+
+	if c.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(c.Endpoint)
+	}
+
+	options := session.Options{Config: *awsConfig}
+
+	switch c.Credentials {
+	case config.SnsCredentialsDefault:
+	case config.SnsCredentialsStatic:
+		if c.AccessKeyId == "" || c.SecretAccessKey == "" {
+			return nil, errors.New("static sns credentials need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		options.Config.Credentials = credentials.NewStaticCredentials(c.AccessKeyId, c.SecretAccessKey, c.SessionToken)
+	case config.SnsCredentialsProfile:
+		if c.Profile == "" {
+			return nil, errors.New("profile sns credentials need AWS_PROFILE")
+		}
+		options.Profile = c.Profile
+		options.SharedConfigState = session.SharedConfigEnable
+	case config.SnsCredentialsWebIdentity:
+		if c.RoleArn == "" || c.TokenFile == "" {
+			return nil, errors.New("web identity sns credentials need AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE")
+		}
+	default:
+		return nil, fmt.Errorf("unknown sns credentials %s, it must be %s, %s, %s or %s", c.Credentials,
+			config.SnsCredentialsDefault, config.SnsCredentialsStatic, config.SnsCredentialsProfile, config.SnsCredentialsWebIdentity)
+	}
+
+	sess, err := session.NewSessionWithOptions(options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Credentials == config.SnsCredentialsWebIdentity {
+		sess.Config.Credentials = stscreds.NewWebIdentityCredentials(sess, c.RoleArn, c.RoleSessionName, c.TokenFile)
+	}
+
+	return sess, nil
 }
 
-func (s Sns) Publish(user *model.User) error {
-	sess := session.Must(session.NewSession(&aws.Config{
-		Endpoint: aws.String(s.Endpoint),
-		Region:   aws.String(s.Region)},
-	))
+// deduplicationId is the same for all the publishes of a change of an user, the user id and the time of the change,
+// or the event id when that time is not known. Replayed events are deduplicated by event id, or SNS would drop them
+// as retries of the events first published within its deduplication interval.
+func deduplicationId(user *model.User, ev *event.Event, replay bool) string {
+	if user.UpdatedAt == nil || replay {
+		return ev.Id
+	}
+	return user.Id.Hex() + "-" + strconv.FormatInt(user.UpdatedAt.UnixNano(), 10)
+}
 
-	svc := sns.New(sess)
-	message, err := json.Marshal(user)
+// Publish sends the event of the updated user, with its changes when known. In binary mode its attributes are the message attributes, named
+// ce_<attribute>.
+func (s Sns) Publish(user *model.User, changes []model.FieldChange) error {
+	ev, err := s.Events.UserUpdated(user, changes)
 
 	if err != nil {
 		f := map[string]interface{}{"msg": err}
 		s.Logger.LogWithFields(nil, "error", f)
+		return err
 	}
 
-	_, err = svc.Publish(&sns.PublishInput{
+	return s.send(user, ev, false)
+}
+
+// PublishReplay sends the event of an user replayed, which is never deduplicated with the event first published.
+func (s Sns) PublishReplay(user *model.User, changes []model.FieldChange) error {
+	ev, err := s.Events.UserUpdated(user, changes)
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		s.Logger.LogWithFields(nil, "error", f)
+		return err
+	}
+
+	return s.send(user, ev, true)
+}
+
+// PublishEvent sends an event already built, signed with the keys of SNS, as the other sinks of a fan-out do.
+func (s Sns) PublishEvent(user *model.User, ev *event.Event) error {
+	return s.send(user, ev, false)
+}
+
+// PublishReplayEvent sends the event of an user replayed, already built.
+func (s Sns) PublishReplayEvent(user *model.User, ev *event.Event) error {
+	return s.send(user, ev, true)
+}
+
+func (s Sns) send(user *model.User, ev *event.Event, replay bool) error {
+	ev, err := s.Events.Sign(ev)
+
+	var message []byte
+	if err == nil {
+		message, _, err = s.Events.Message(ev)
+	}
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		s.Logger.LogWithFields(nil, "error", f)
+		return err
+	}
+
+	input := &sns.PublishInput{
 		Message:  aws.String(string(message)),
 		TopicArn: aws.String(s.Topic),
-	})
+	}
+
+	if s.Fifo {
+		input.MessageGroupId = aws.String(user.Id.Hex())
+		input.MessageDeduplicationId = aws.String(deduplicationId(user, ev, replay))
+	}
+
+	if s.Events.Binary() {
+		input.MessageAttributes = map[string]*sns.MessageAttributeValue{
+			"ce_datacontenttype": {DataType: aws.String("String"), StringValue: aws.String(ev.DataContentType)},
+		}
+		for name, value := range ev.Attributes() {
+			input.MessageAttributes["ce_"+name] = &sns.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	_, err = s.Client.PublishWithContext(ctx, input)
 
 	if err != nil {
 		f := map[string]interface{}{"msg": err}
 		s.Logger.LogWithFields(nil, "error", f)
 	} else {
-		f := map[string]interface{}{"msg": "notifying updated user " + string(message)}
+		f := map[string]interface{}{"msg": "notifying updated user " + string(ev.Data) + " in event " + ev.Id}
 		s.Logger.LogWithFields(nil, "info", f)
 	}
 
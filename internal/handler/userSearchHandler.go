@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/search"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// SearchUsers godoc
+// @Summary Searches users
+// @Description Searches users by nickname, names and email. The text mode matches whole words, most relevant first,
+// @Description while the prefix and substring modes match the start or any part of the nickname and names. Case and
+// @Description diacritics are ignored, and the matching fields are returned with the matches wrapped in <em> tags.
+// @Produce json
+// @Param q query string true "Search terms, up to 200 characters"
+// @Param mode query string false "text (default), prefix or substring"
+// @Param page query int false "Page, from 1 to 10000"
+// @Param size query int false "Page size, up to 100"
+// @Success 200 {object} model.SearchPage
+// @Failure 400 {object} model.ResponseError
+// @Router /users/search [get]
+// @Tags users
+func (u *UserHandler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	if q == "" {
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: "missing parameter q"})
+		return
+	}
+
+	if utf8.RuneCountInString(q) > search.MaxQueryLength {
+		description := fmt.Sprintf("parameter q is longer than %d characters", search.MaxQueryLength)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: description})
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+
+	if mode == "" {
+		mode = model.SearchText
+	}
+
+	if !model.IsSearchMode(mode) {
+		description := fmt.Sprintf("invalid value %s for parameter mode, it must be %s, %s or %s", mode,
+			model.SearchText, model.SearchPrefix, model.SearchSubstring)
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: description})
+		return
+	}
+
+	page, size, err := pagination(r)
+
+	if err != nil {
+		respondWithJson(w, http.StatusBadRequest, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	hits, total, err := u.Repository.Search(&model.UserSearch{Query: q, Mode: mode, Page: page, Size: size})
+
+	if err != nil {
+		f := map[string]interface{}{"msg": err}
+		u.Logger.LogWithFields(r, "error", f)
+		respondWithJson(w, http.StatusInternalServerError, model.ResponseError{Description: err.Error()})
+		return
+	}
+
+	terms, match := searchTerms(q, mode)
+
+	items := make([]model.SearchHit, 0, len(hits))
+	for i := range hits {
+		items = append(items, model.SearchHit{
+			UserResponse: model.NewUserResponse(&hits[i].User),
+			Score:        hits[i].Score,
+			Highlights:   highlights(&hits[i].User, terms, match, mode == model.SearchText),
+		})
+	}
+
+	respondWithJson(w, http.StatusOK, model.SearchPage{Items: items, Page: page, Size: size, Total: total})
+}
+
+// searchTerms splits the query in the terms to highlight. Text searches match words, leaving out the negated ones,
+// while the other modes match the query as a whole.
+func searchTerms(q string, mode string) ([]string, search.Match) {
+	switch mode {
+	case model.SearchPrefix:
+		return []string{q}, search.FieldStart
+	case model.SearchSubstring:
+		return []string{q}, search.Anywhere
+	}
+
+	var terms []string
+	for _, t := range strings.Fields(strings.ReplaceAll(q, "\"", " ")) {
+		if !strings.HasPrefix(t, "-") {
+			terms = append(terms, t)
+		}
+	}
+	return terms, search.WordStart
+}
+
+func highlights(user *model.User, terms []string, match search.Match, withEmail bool) map[string]string {
+	fields := map[string]string{
+		"nickname":  user.Nickname,
+		"firstName": user.FirstName,
+		"lastName":  user.LastName,
+	}
+	if withEmail {
+		fields["email"] = user.Email
+	}
+
+	h := map[string]string{}
+	for name, value := range fields {
+		if v := search.Highlight(value, terms, match); v != "" {
+			h[name] = v
+		}
+	}
+	return h
+}
@@ -0,0 +1,343 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/repository"
+	"github.com/bernardoms/user-api/internal/requestctx"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Runner does the work of a type of job, reporting its progress as it goes. It must stop when the context is done,
+// and can be run again on a job it already started, which it resumes from the checkpoint of the job.
+type Runner interface {
+	Run(ctx context.Context, job *model.Job, progress *Progress) error
+}
+
+// RunnerFunc adapts a function to a Runner.
+type RunnerFunc func(ctx context.Context, job *model.Job, progress *Progress) error
+
+func (f RunnerFunc) Run(ctx context.Context, job *model.Job, progress *Progress) error {
+	return f(ctx, job, progress)
+}
+
+// Pool runs the jobs stored in the repository with a fixed number of workers. The jobs are leased to the pool
+// while they run, and given back when the pool stops, so they are resumed after a restart or by another instance.
+type Pool struct {
+	Repository repository.JobRepository
+	Runners    map[string]Runner
+	Config     *config.JobsConfig
+	Logger     *logger.Logger
+	// Owner identifies the pool in the leases of its jobs
+	Owner string
+
+	wake chan struct{}
+}
+
+func NewPool(r repository.JobRepository, runners map[string]Runner, c *config.JobsConfig, l *logger.Logger) *Pool {
+	host, _ := os.Hostname()
+
+	return &Pool{
+		Repository: r,
+		Runners:    runners,
+		Config:     c,
+		Logger:     l,
+		Owner:      fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex()),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Submit queues a job, waking an idle worker to run it.
+func (p *Pool) Submit(job *model.Job) error {
+	if _, ok := p.Runners[job.Type]; !ok {
+		return fmt.Errorf("unknown job type %s", job.Type)
+	}
+
+	job.Status = model.JobQueued
+
+	if err := p.Repository.Create(job); err != nil {
+		return err
+	}
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run starts the workers and waits for them to stop, once the context is done.
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for n := 0; n < p.Config.Workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context) {
+	ticker := time.NewTicker(p.Config.PollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		job, err := p.Repository.Claim(p.Owner, time.Now().Add(p.Config.Lease))
+
+		if err != nil {
+			f := map[string]interface{}{"msg": "error claiming job: " + err.Error()}
+			p.Logger.LogWithFields(nil, "error", f)
+		}
+
+		if job != nil {
+			p.RunJob(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunJob runs a job claimed by the pool until it is over, cancelled, or the pool stops.
+func (p *Pool) RunJob(ctx context.Context, job *model.Job) {
+	f := map[string]interface{}{"msg": "running job", "job": job.Id.Hex(), "type": job.Type, "attempt": job.Attempts}
+	p.Logger.LogWithFields(nil, "info", f)
+
+	runner, ok := p.Runners[job.Type]
+
+	if !ok {
+		job.Status, job.Error = model.JobFailed, "unknown job type "+job.Type
+		p.finish(job)
+		return
+	}
+
+	if p.Config.MaxAttempts > 0 && job.Attempts > p.Config.MaxAttempts {
+		job.Status, job.Error = model.JobFailed, fmt.Sprintf("the job was stopped %d times without finishing, giving up", p.Config.MaxAttempts)
+		p.finish(job)
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(requestctx.WithRequestID(requestctx.WithPrincipal(ctx, job.CreatedBy), job.RequestId))
+	defer cancel()
+
+	progress := NewProgress(job)
+	progress.items = make(chan struct{}, 1)
+	h := &heartbeat{pool: p, progress: progress, cancel: cancel, done: make(chan struct{}), stopped: make(chan struct{})}
+	go h.run()
+
+	err := p.run(jobCtx, runner, job, progress)
+
+	close(h.done)
+	<-h.stopped
+
+	switch {
+	case h.lost:
+		f := map[string]interface{}{"msg": "job lease lost, leaving it to its new worker", "job": job.Id.Hex()}
+		p.Logger.LogWithFields(nil, "error", f)
+		return
+	case h.cancelled:
+		job.Status = model.JobCancelled
+	case err != nil && ctx.Err() != nil:
+		p.release(job)
+		return
+	case err != nil:
+		job.Status, job.Error = model.JobFailed, err.Error()
+	default:
+		job.Status = model.JobSucceeded
+	}
+
+	p.finish(job)
+}
+
+// run runs the job, turning a panic of the runner into its error, so a broken job fails instead of the service.
+func (p *Pool) run(ctx context.Context, runner Runner, job *model.Job, progress *Progress) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			f := map[string]interface{}{"msg": fmt.Sprintf("job panicked: %v", r), "job": job.Id.Hex(), "stack": string(debug.Stack())}
+			p.Logger.LogWithFields(nil, "error", f)
+			err = fmt.Errorf("the job panicked: %v", r)
+		}
+	}()
+
+	return runner.Run(ctx, job, progress)
+}
+
+func (p *Pool) finish(job *model.Job) {
+	if err := p.Repository.Finish(job); err != nil {
+		f := map[string]interface{}{"msg": "error finishing job: " + err.Error(), "job": job.Id.Hex()}
+		p.Logger.LogWithFields(nil, "error", f)
+		return
+	}
+
+	f := map[string]interface{}{"msg": "job " + job.Status, "job": job.Id.Hex(), "done": job.Progress.Done, "failed": job.Progress.Failed}
+	p.Logger.LogWithFields(nil, "info", f)
+}
+
+func (p *Pool) release(job *model.Job) {
+	if err := p.Repository.Release(job); err != nil {
+		f := map[string]interface{}{"msg": "error releasing job: " + err.Error(), "job": job.Id.Hex()}
+		p.Logger.LogWithFields(nil, "error", f)
+	}
+}
+
+// heartbeat saves the progress of a running job and renews its lease, a third of the lease at a time. It cancels
+// the job when it was asked to, or when the lease was lost and the job may already run elsewhere. Between the
+// heartbeats, it checks whether the job was cancelled as the items of the job are done, at most every CancelCheck.
+type heartbeat struct {
+	pool     *Pool
+	progress *Progress
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopped  chan struct{}
+
+	lost      bool
+	cancelled bool
+}
+
+func (h *heartbeat) run() {
+	defer close(h.stopped)
+
+	ticker := time.NewTicker(h.pool.Config.Lease / 3)
+	defer ticker.Stop()
+
+	checked := time.Now()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-h.progress.items:
+			if time.Since(checked) < h.pool.Config.CancelCheck {
+				continue
+			}
+			checked = time.Now()
+
+			if h.cancelRequested() {
+				h.cancelled = true
+				h.cancel()
+				return
+			}
+			continue
+		case <-ticker.C:
+		}
+
+		checked = time.Now()
+		snapshot := h.progress.snapshot()
+		stored, err := h.pool.Repository.Heartbeat(&snapshot, time.Now().Add(h.pool.Config.Lease))
+
+		if err != nil {
+			f := map[string]interface{}{"msg": "error renewing job lease: " + err.Error(), "job": snapshot.Id.Hex()}
+			h.pool.Logger.LogWithFields(nil, "error", f)
+			continue
+		}
+
+		if stored == nil {
+			h.lost = true
+			h.cancel()
+			return
+		}
+
+		if stored.CancelRequested {
+			h.cancelled = true
+			h.cancel()
+			return
+		}
+	}
+}
+
+func (h *heartbeat) cancelRequested() bool {
+	stored, err := h.pool.Repository.FindById(h.progress.job.Id)
+
+	if err != nil {
+		f := map[string]interface{}{"msg": "error checking job cancellation: " + err.Error(), "job": h.progress.job.Id.Hex()}
+		h.pool.Logger.LogWithFields(nil, "error", f)
+		return false
+	}
+
+	return stored != nil && stored.CancelRequested
+}
+
+// Progress is how a runner reports its progress, which is saved with each heartbeat of the job.
+type Progress struct {
+	mu  sync.Mutex
+	job *model.Job
+	// items tells the heartbeat of the job that items were done
+	items chan struct{}
+}
+
+func NewProgress(job *model.Job) *Progress {
+	return &Progress{job: job}
+}
+
+// Reset starts counting again, for runners that redo the whole job when resumed.
+func (p *Progress) Reset(total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Progress = model.JobProgress{Total: total}
+	p.job.Errors = nil
+}
+
+func (p *Progress) SetTotal(total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Progress.Total = total
+}
+
+// Add counts items done, of which failed ones failed.
+func (p *Progress) Add(done int64, failed int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Progress.Done += done
+	p.job.Progress.Failed += failed
+
+	select {
+	case p.items <- struct{}{}:
+	default:
+	}
+}
+
+// Fail keeps the error of an item, up to model.MaxJobErrors of them.
+func (p *Progress) Fail(msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.job.Errors) < model.MaxJobErrors {
+		p.job.Errors = append(p.job.Errors, msg)
+	}
+}
+
+// Checkpoint records how far the job went, to resume from there.
+func (p *Progress) Checkpoint(checkpoint int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Checkpoint = checkpoint
+}
+
+// SetResult records the file the job produced.
+func (p *Progress) SetResult(id string, contentType string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Result, p.job.ResultType = id, contentType
+}
+
+func (p *Progress) snapshot() model.Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snapshot := *p.job
+	snapshot.Errors = append([]string(nil), p.job.Errors...)
+	return snapshot
+}
@@ -0,0 +1,36 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HumanFormatter writes one readable line per entry: time, padded level, message and the sorted fields.
+type HumanFormatter struct{}
+
+func (HumanFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	b := &bytes.Buffer{}
+
+	b.WriteString(entry.Time.Format(time.RFC3339))
+	b.WriteString(" ")
+	b.WriteString(fmt.Sprintf("%-7s", strings.ToUpper(entry.Level.String())))
+	b.WriteString(" ")
+	b.WriteString(entry.Message)
+
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		b.WriteString(fmt.Sprintf(" %s=%v", k, entry.Data[k]))
+	}
+	b.WriteString("\n")
+
+	return b.Bytes(), nil
+}
@@ -2,26 +2,145 @@ package logger
 
 import (
 	"fmt"
+	"github.com/bernardoms/user-api/config"
 	"github.com/sirupsen/logrus"
+	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Logger struct {
-	Log *logrus.Logger
+	Log   *logrus.Logger
+	level *levelState
 }
 
+// levelState keeps the configured level so a temporary level set at runtime can be reverted. The generation counts
+// the levels set, so a revert firing while a newer level is being set knows it is stale.
+type levelState struct {
+	sync.Mutex
+	base       logrus.Level
+	revert     *time.Timer
+	until      time.Time
+	generation uint64
+}
+
+// ConfigureLogger returns the default logger: info level, JSON format, stdout.
 func ConfigureLogger() *Logger {
 	logger := new(Logger)
 	logger.Log = logrus.New()
 	logger.Log.Formatter = &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
 	logger.Log.Level = logrus.InfoLevel
 	logger.Log.Out = os.Stdout
+	logger.level = &levelState{base: logrus.InfoLevel}
 	return logger
 }
 
+// New returns a logger with the level, format and output of the config. Empty values keep the defaults of
+// ConfigureLogger.
+func New(c *config.LoggerConfig) (*Logger, error) {
+	logger := ConfigureLogger()
+
+	if c.Level != "" {
+		level, err := logrus.ParseLevel(c.Level)
+		if err != nil {
+			return nil, err
+		}
+		logger.Log.Level = level
+		logger.level.base = level
+	}
+
+	formatter, err := newFormatter(c.Format)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log.Formatter = formatter
+
+	out, err := newOutput(c)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log.Out = out
+
+	return logger, nil
+}
+
+func newFormatter(format string) (logrus.Formatter, error) {
+	switch strings.ToLower(format) {
+	case "", "json":
+		return &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}, nil
+	case "logfmt":
+		return &logrus.TextFormatter{DisableColors: true, FullTimestamp: true, TimestampFormat: time.RFC3339Nano}, nil
+	case "text":
+		return &HumanFormatter{}, nil
+	}
+	return nil, fmt.Errorf("unknown log format %s", format)
+}
+
+func newOutput(c *config.LoggerConfig) (io.Writer, error) {
+	switch strings.ToLower(c.Output) {
+	case "", "stdout":
+		return os.Stdout, nil
+	case "file":
+		if c.File == "" {
+			return nil, fmt.Errorf("log output file needs a LOG_FILE path")
+		}
+		return NewRotatingFile(c.File, int64(c.MaxSizeMB)*1024*1024, c.MaxBackups)
+	}
+	return nil, fmt.Errorf("unknown log output %s", c.Output)
+}
+
+// SetLevel changes the level at runtime. With a positive ttl the configured level is restored once it expires,
+// otherwise the new level becomes the configured one.
+func (l Logger) SetLevel(level string, ttl time.Duration) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	l.level.Lock()
+	defer l.level.Unlock()
+
+	// a revert already firing can't be stopped anymore, it does nothing once the generation moved on
+	l.level.generation++
+	generation := l.level.generation
+
+	if l.level.revert != nil {
+		l.level.revert.Stop()
+		l.level.revert = nil
+		l.level.until = time.Time{}
+	}
+
+	l.Log.SetLevel(parsed)
+
+	if ttl <= 0 {
+		l.level.base = parsed
+		return nil
+	}
+
+	l.level.until = time.Now().Add(ttl)
+	l.level.revert = time.AfterFunc(ttl, func() {
+		l.level.Lock()
+		defer l.level.Unlock()
+		if l.level.generation != generation {
+			return
+		}
+		l.Log.SetLevel(l.level.base)
+		l.level.revert = nil
+		l.level.until = time.Time{}
+	})
+	return nil
+}
+
+// Level returns the current level and, when it is temporary, the time it will be reverted.
+func (l Logger) Level() (string, time.Time) {
+	l.level.Lock()
+	defer l.level.Unlock()
+	return l.Log.GetLevel().String(), l.level.until
+}
+
 func (l Logger) LogWithFields(req *http.Request, level string, fields map[string]interface{}) {
 	msg := ""
 	_, ok := fields["msg"]
@@ -32,7 +151,7 @@ func (l Logger) LogWithFields(req *http.Request, level string, fields map[string
 
 	if req != nil {
 		fields["path"] = req.URL.Path
-		fields["header"] = req.Header
+		fields["header"] = redact(req.Header)
 		fields["reqMethod"] = req.Method
 	}
 
@@ -60,3 +179,17 @@ func (l Logger) LogWithFields(req *http.Request, level string, fields map[string
 	}
 	logEntry.Info(msg) // Default level
 }
+
+// sensitiveHeaders are logged redacted, they carry the admin token and the sessions.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+// redact copies the header with the values of the sensitive ones hidden.
+func redact(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range sensitiveHeaders {
+		if _, ok := redacted[name]; ok {
+			redacted[name] = []string{"[REDACTED]"}
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,116 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an io.Writer over a file that is renamed to path.1 (shifting older backups up to path.N) once
+// it would grow past MaxBytes.
+type RotatingFile struct {
+	Path       string
+	MaxBytes   int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{Path: path, MaxBytes: maxBytes, MaxBackups: maxBackups}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// a failed rotation leaves no file when even the one at Path couldn't be opened again
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.MaxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxBytes {
+		if err := r.rotate(); err != nil {
+			if r.file == nil {
+				return 0, err
+			}
+			// the lines keep going to the file, past MaxBytes, until a later rotation succeeds
+			fmt.Fprintf(os.Stderr, "Failed to rotate the log file %s, %v\n", r.Path, err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+// rotate moves the file to the first backup and opens a new one at Path. When the backups can't be moved the file
+// at Path is opened again and the error returned. The file is nil only when it couldn't be opened at all.
+func (r *RotatingFile) rotate() error {
+	err := r.file.Close()
+	r.file = nil
+
+	if err == nil {
+		err = r.shift()
+	}
+
+	if openErr := r.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// shift moves the file at Path to the first backup and each backup to the next one, dropping the last one. Without
+// backups the file is removed.
+func (r *RotatingFile) shift() error {
+	if r.MaxBackups <= 0 {
+		if err := os.Remove(r.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	_ = os.Remove(r.backup(r.MaxBackups))
+	for i := r.MaxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(r.backup(i), r.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(r.Path, r.backup(1))
+}
+
+func (r *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", r.Path, i)
+}
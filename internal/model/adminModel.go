@@ -0,0 +1,9 @@
+package model
+
+import "time"
+
+type LogLevel struct {
+	Level string     `json:"level" validate:"required"`
+	TTL   string     `json:"ttl,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+}
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	OperationCreate  = "create"
+	OperationUpdate  = "update"
+	OperationDelete  = "delete"
+	OperationRestore = "restore"
+)
+
+// Masked replaces the values of sensitive fields in audit entries.
+const Masked = "***"
+
+type AuditEntry struct {
+	Id        primitive.ObjectID `json:"-" bson:"_id"`
+	UserId    primitive.ObjectID `json:"-" bson:"userId"`
+	Nickname  string             `json:"nickname" bson:"nickname"`
+	Operation string             `json:"operation" bson:"operation"`
+	Actor     string             `json:"actor" bson:"actor"`
+	RequestId string             `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+	Changes   []FieldChange      `json:"changes" bson:"changes"`
+}
+
+type FieldChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After  interface{} `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// AuditFilter selects the entries of the history done with any of the operations, from From and before To, of the
+// users with the nickname. Empty fields select all the entries.
+type AuditFilter struct {
+	Nickname   string
+	Operations []string
+	From       *time.Time
+	To         *time.Time
+}
+
+// Matches tells whether the filter selects the entry, as the audit repository does.
+func (f *AuditFilter) Matches(entry *AuditEntry) bool {
+	if f.Nickname != "" && entry.Nickname != f.Nickname {
+		return false
+	}
+
+	if len(f.Operations) > 0 {
+		found := false
+		for _, operation := range f.Operations {
+			found = found || operation == entry.Operation
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.From != nil && entry.Timestamp.Before(*f.From) {
+		return false
+	}
+	return f.To == nil || entry.Timestamp.Before(*f.To)
+}
+
+type AuditPage struct {
+	Items []AuditEntry `json:"items"`
+	Page  int          `json:"page"`
+	Size  int          `json:"size"`
+	Total int64        `json:"total"`
+}
+
+// Diff returns the changes of the user fields clients can set, from before to after. Either may be nil, for a
+// user created or deleted. The password is left out: it is stored hashed, so only the caller knowing the plain
+// text can tell whether it changed.
+func Diff(before *User, after *User) []FieldChange {
+	if before == nil {
+		before = &User{}
+	}
+	if after == nil {
+		after = &User{}
+	}
+
+	fields := []struct {
+		name          string
+		before, after string
+	}{
+		{"email", before.Email, after.Email},
+		{"country", before.Country, after.Country},
+		{"nickname", before.Nickname, after.Nickname},
+		{"lastName", before.LastName, after.LastName},
+		{"firstName", before.FirstName, after.FirstName},
+	}
+
+	changes := make([]FieldChange, 0)
+	for _, f := range fields {
+		if f.before != f.after {
+			changes = append(changes, FieldChange{Field: f.name, Before: emptyAsNil(f.before), After: emptyAsNil(f.after)})
+		}
+	}
+	return changes
+}
+
+// PasswordChange is the masked change recorded when a password is set or replaced.
+func PasswordChange(hadPassword bool) FieldChange {
+	change := FieldChange{Field: "password", After: Masked}
+	if hadPassword {
+		change.Before = Masked
+	}
+	return change
+}
+
+// Revert undoes the changes on the user, setting the fields back to their values before. The password, masked in
+// the changes, is left as it is.
+func Revert(user *User, changes []FieldChange) {
+	for _, change := range changes {
+		before, _ := change.Before.(string)
+		setField(user, change.Field, before)
+	}
+}
+
+// Reapply does again the changes Revert undid, setting the fields to their values after.
+func Reapply(user *User, changes []FieldChange) {
+	for _, change := range changes {
+		after, _ := change.After.(string)
+		setField(user, change.Field, after)
+	}
+}
+
+func setField(user *User, field string, value string) {
+	switch field {
+	case "email":
+		user.Email = value
+	case "country":
+		user.Country = value
+	case "nickname":
+		user.Nickname = value
+	case "lastName":
+		user.LastName = value
+	case "firstName":
+		user.FirstName = value
+	}
+}
+
+func emptyAsNil(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
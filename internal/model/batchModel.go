@@ -0,0 +1,35 @@
+package model
+
+// BatchRequest is a list of operations on users, the n-th result answering the n-th operation. With Atomic, the
+// operations are run in a transaction and none of them is kept when one fails.
+type BatchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation creates User, sets the fields of Set to the user with Nickname, or deletes it, as Op is
+// OperationCreate, OperationUpdate or OperationDelete.
+type BatchOperation struct {
+	Op       string       `json:"op"`
+	Nickname string       `json:"nickname,omitempty"`
+	User     *UserRequest `json:"user,omitempty"`
+	Set      *UserPatch   `json:"set,omitempty"`
+}
+
+type BatchResult struct {
+	Op       string `json:"op"`
+	Nickname string `json:"nickname,omitempty"`
+	// Status is the http status the operation would have been answered with on its own
+	Status int    `json:"status"`
+	Id     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Atomic bool `json:"atomic"`
+	// Committed tells whether the changes of the successful operations were kept
+	Committed bool          `json:"committed"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
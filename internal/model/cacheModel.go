@@ -0,0 +1,9 @@
+package model
+
+type CacheStats struct {
+	Hits         uint64  `json:"hits"`
+	Misses       uint64  `json:"misses"`
+	NegativeHits uint64  `json:"negativeHits"`
+	Errors       uint64  `json:"errors"`
+	HitRatio     float64 `json:"hitRatio"`
+}
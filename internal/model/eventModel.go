@@ -0,0 +1,15 @@
+package model
+
+// EventSchema is a version of the data of the published events.
+type EventSchema struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Url is the dataschema of the events with this version
+	Url string `json:"url"`
+	// Published tells whether the events are published with this version
+	Published bool `json:"published"`
+}
+
+type EventSchemas struct {
+	Schemas []EventSchema `json:"schemas"`
+}
@@ -0,0 +1,49 @@
+package model
+
+const (
+	ImportCreated  = "created"
+	ImportValid    = "valid"
+	ImportConflict = "conflict"
+	ImportInvalid  = "invalid"
+	ImportFailed   = "failed"
+)
+
+// ImportReport tells what happened to each row of an import. On a dry run nothing is created, and the rows that
+// would be are valid instead. Error is set when the file couldn't be read to the end, the following rows being left
+// out.
+type ImportReport struct {
+	DryRun     bool        `json:"dryRun"`
+	Created    int         `json:"created"`
+	Valid      int         `json:"valid"`
+	Conflicted int         `json:"conflicted"`
+	Invalid    int         `json:"invalid"`
+	Failed     int         `json:"failed"`
+	Error      string      `json:"error,omitempty"`
+	Rows       []ImportRow `json:"rows"`
+}
+
+// ImportRow is the outcome of a row, Line being its line in the file, counting the csv header.
+type ImportRow struct {
+	Line     int    `json:"line"`
+	Nickname string `json:"nickname,omitempty"`
+	Status   string `json:"status"`
+	Id       string `json:"id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Add appends the row to the report, counting its status.
+func (r *ImportReport) Add(row ImportRow) {
+	switch row.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportValid:
+		r.Valid++
+	case ImportConflict:
+		r.Conflicted++
+	case ImportInvalid:
+		r.Invalid++
+	case ImportFailed:
+		r.Failed++
+	}
+	r.Rows = append(r.Rows, row)
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+const (
+	JobImport = "import"
+	JobExport = "export"
+	JobUpdate = "update"
+	JobReplay = "replay"
+)
+
+// MaxJobErrors is how many errors of the items of a job are kept, the following ones being only counted.
+const MaxJobErrors = 100
+
+// Job is a long running operation, run in the background by a worker. A running job is leased to its worker until
+// LeaseUntil, which the worker keeps extending, so that the jobs of a stopped worker are resumed by another.
+type Job struct {
+	Id       primitive.ObjectID `json:"id" bson:"_id"`
+	Type     string             `json:"type" bson:"type"`
+	Status   string             `json:"status" bson:"status"`
+	Params   map[string]string  `json:"params,omitempty" bson:"params,omitempty"`
+	Progress JobProgress        `json:"progress" bson:"progress"`
+	// Errors holds the first errors of the items of the job, and Error the one stopping it
+	Errors []string `json:"errors,omitempty" bson:"errors,omitempty"`
+	Error  string   `json:"error,omitempty" bson:"error,omitempty"`
+	// ResultUrl is where the result of the job, of type ResultType, can be downloaded once it is done
+	ResultUrl       string `json:"resultUrl,omitempty" bson:"-"`
+	ResultType      string `json:"resultType,omitempty" bson:"resultType,omitempty"`
+	CancelRequested bool   `json:"cancelRequested,omitempty" bson:"cancelRequested,omitempty"`
+	Attempts        int    `json:"attempts" bson:"attempts"`
+
+	// Input and Result are the ids of the files read and written by the job
+	Input  string `json:"-" bson:"input,omitempty"`
+	Result string `json:"-" bson:"result,omitempty"`
+	// Checkpoint is how far the job went, for it to resume from there
+	Checkpoint int64      `json:"-" bson:"checkpoint,omitempty"`
+	Owner      string     `json:"-" bson:"owner,omitempty"`
+	LeaseUntil *time.Time `json:"-" bson:"leaseUntil,omitempty"`
+
+	CreatedAt  *time.Time `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+	CreatedBy  string     `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	RequestId  string     `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+}
+
+// JobProgress counts the items of a job, Total being 0 when unknown. Failed items are also done.
+type JobProgress struct {
+	Total  int64 `json:"total" bson:"total"`
+	Done   int64 `json:"done" bson:"done"`
+	Failed int64 `json:"failed" bson:"failed"`
+}
+
+// Finished tells whether the job is over, whatever its outcome.
+func (j *Job) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// UpdateJobRequest is the body of a mass update, setting the given fields of all the users matching the filter
+// expression. Nicknames and passwords can't be set.
+type UpdateJobRequest struct {
+	Filter string    `json:"filter"`
+	Set    UserPatch `json:"set"`
+}
+
+const (
+	ReplayAudit    = "audit"
+	ReplaySnapshot = "snapshot"
+)
+
+// ReplayRequest publishes again the events of the users, from the history of their changes (audit) or as snapshots
+// of their current state (snapshot). Changes, or users for snapshots, are selected by the time they were updated at,
+// from From and before To, by nickname and by event type. Rate lowers the events published per second.
+type ReplayRequest struct {
+	Source   string     `json:"source"`
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+	Nickname string     `json:"nickname,omitempty"`
+	Types    []string   `json:"types,omitempty"`
+	Rate     int        `json:"rate,omitempty"`
+}
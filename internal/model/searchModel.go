@@ -0,0 +1,41 @@
+package model
+
+const (
+	SearchText      = "text"
+	SearchPrefix    = "prefix"
+	SearchSubstring = "substring"
+)
+
+// UserSearch is a search over the users, Query being matched according to Mode.
+type UserSearch struct {
+	Query string
+	Mode  string
+	Page  int
+	Size  int
+}
+
+// UserHit is an user found by a search, Score being the text relevance, only set by text searches.
+type UserHit struct {
+	User  `bson:",inline"`
+	Score float64 `bson:"score,omitempty"`
+}
+
+// SearchHit is an user found by a search as returned by the api. Highlights holds the matching fields with the
+// matches wrapped in <em> tags.
+type SearchHit struct {
+	UserResponse
+	Score      float64           `json:"score,omitempty"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+type SearchPage struct {
+	Items []SearchHit `json:"items"`
+	Page  int         `json:"page"`
+	Size  int         `json:"size"`
+	Total int64       `json:"total"`
+}
+
+// IsSearchMode tells whether the mode is one of the supported search modes.
+func IsSearchMode(mode string) bool {
+	return mode == SearchText || mode == SearchPrefix || mode == SearchSubstring
+}
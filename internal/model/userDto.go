@@ -0,0 +1,67 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// UserRequest is the body creating or replacing an user, the only one holding a plain password.
+type UserRequest struct {
+	Email     string `json:"email" validate:"required,email"`
+	Country   string `json:"country" validate:"required"`
+	Nickname  string `json:"nickname" validate:"required"`
+	LastName  string `json:"lastName" validate:"required"`
+	FirstName string `json:"firstName" validate:"required"`
+	Password  string `json:"password" validate:"required"`
+}
+
+// UserResponse is an user as returned by the api. The password is write-only, so it has none.
+type UserResponse struct {
+	Id        primitive.ObjectID `json:"id"`
+	Email     string             `json:"email"`
+	Country   string             `json:"country"`
+	Nickname  string             `json:"nickname"`
+	LastName  string             `json:"lastName"`
+	FirstName string             `json:"firstName"`
+	CreatedAt *time.Time         `json:"createdAt,omitempty"`
+	CreatedBy string             `json:"createdBy,omitempty"`
+	UpdatedAt *time.Time         `json:"updatedAt,omitempty"`
+	UpdatedBy string             `json:"updatedBy,omitempty"`
+	DeletedAt *time.Time         `json:"deletedAt,omitempty"`
+}
+
+// User returns the user to store for the request, with the password still in plain text.
+func (r UserRequest) User() *User {
+	return &User{
+		Email:     r.Email,
+		Country:   r.Country,
+		Nickname:  r.Nickname,
+		LastName:  r.LastName,
+		FirstName: r.FirstName,
+		Password:  r.Password,
+	}
+}
+
+func NewUserResponse(user *User) UserResponse {
+	return UserResponse{
+		Id:        user.Id,
+		Email:     user.Email,
+		Country:   user.Country,
+		Nickname:  user.Nickname,
+		LastName:  user.LastName,
+		FirstName: user.FirstName,
+		CreatedAt: user.CreatedAt,
+		CreatedBy: user.CreatedBy,
+		UpdatedAt: user.UpdatedAt,
+		UpdatedBy: user.UpdatedBy,
+		DeletedAt: user.DeletedAt,
+	}
+}
+
+func NewUserResponses(users []User) []UserResponse {
+	responses := make([]UserResponse, 0, len(users))
+	for i := range users {
+		responses = append(responses, NewUserResponse(&users[i]))
+	}
+	return responses
+}
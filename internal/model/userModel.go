@@ -1,25 +1,98 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"errors"
+	"github.com/bernardoms/user-api/internal/query"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
 
+// User is an user as stored. It is never read from nor written to the api as is, see UserRequest and UserResponse.
 type User struct {
-	Id        primitive.ObjectID `json:"-" bson:"_id"`
+	Id        primitive.ObjectID `json:"id" bson:"_id"`
 	Email     string             `json:"email" bson:"email" validate:"required,email"`
 	Country   string             `json:"country" bson:"country" validate:"required"`
 	Nickname  string             `json:"nickname" bson:"nickname" validate:"required"`
 	LastName  string             `json:"lastName" bson:"lastName" validate:"required"`
 	FirstName string             `json:"firstName" bson:"firstName" validate:"required"`
-	Password  string             `json:"password,omitempty" bson:"password" validate:"required"`
+	Password  string             `json:"-" bson:"password" validate:"required"`
+	CreatedAt *time.Time         `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+	CreatedBy string             `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	UpdatedAt *time.Time         `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+	UpdatedBy string             `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	DeletedAt *time.Time         `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	// Active is set while the user isn't deleted, for the unique index on the nicknames of the active users
+	Active bool `json:"-" bson:"active,omitempty"`
 }
 
+// ReservedNicknames are the paths under /v1/users that aren't users, such as /v1/users/search. No user can take
+// them, it couldn't be read, updated nor deleted by its nickname.
+var ReservedNicknames = []string{"export", "id", "import", "search"}
+
+// CheckNickname fails when the nickname is reserved. Users keep the nicknames they already have, so it is only
+// checked when a nickname is taken, on creations and renames.
+func CheckNickname(nickname string) error {
+	for _, reserved := range ReservedNicknames {
+		if nickname == reserved {
+			return errors.New("nickname " + nickname + " is reserved")
+		}
+	}
+	return nil
+}
+
+// UserFields are the fields of an user that can be selected. The password is never one of them.
+var UserFields = []string{"id", "email", "country", "nickname", "lastName", "firstName", "createdAt", "createdBy",
+	"updatedAt", "updatedBy", "deletedAt"}
+
 type ResponseError struct {
 	Description string `json:"description"`
 }
 
 type Filter struct {
-	Email     string `schema:"email"`
-	Country   string `schema:"country"`
-	Nickname  string `schema:"nickname"`
-	LastName  string `schema:"lastName"`
-	FirstName string `schema:"firstName"`
+	// Query holds the conditions on the user fields, parsed from their parameters and the filter expression.
+	Query query.Node `schema:"-"`
+	// Fields restricts the fields of the users returned, all of them when empty.
+	Fields []string `schema:"-"`
+
+	CreatedSince  *time.Time `schema:"createdSince"`
+	CreatedBefore *time.Time `schema:"createdBefore"`
+	UpdatedSince  *time.Time `schema:"updatedSince"`
+	UpdatedBefore *time.Time `schema:"updatedBefore"`
+
+	IncludeDeleted bool `schema:"includeDeleted"`
+}
+
+// UserPatch holds the fields of a partial update, nil fields are left as they are.
+type UserPatch struct {
+	Email     *string `json:"email"`
+	Country   *string `json:"country"`
+	Nickname  *string `json:"nickname"`
+	LastName  *string `json:"lastName"`
+	FirstName *string `json:"firstName"`
+	Password  *string `json:"password"`
+}
+
+// Apply returns a copy of the user with the patched fields replaced.
+func (p UserPatch) Apply(user *User) *User {
+	patched := *user
+
+	if p.Email != nil {
+		patched.Email = *p.Email
+	}
+	if p.Country != nil {
+		patched.Country = *p.Country
+	}
+	if p.Nickname != nil {
+		patched.Nickname = *p.Nickname
+	}
+	if p.LastName != nil {
+		patched.LastName = *p.LastName
+	}
+	if p.FirstName != nil {
+		patched.FirstName = *p.FirstName
+	}
+	if p.Password != nil {
+		patched.Password = *p.Password
+	}
+	return &patched
 }
@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"errors"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/streadway/amqp"
+	"sync"
+	"time"
+)
+
+// AMQPChannel publishes messages, returning once the broker has confirmed them, and closes the connection it is on.
+type AMQPChannel interface {
+	Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// AMQP publishes the notifications to an exchange, such as a RabbitMQ one. The channel is opened on the first
+// publish and opened again when it was closed, as when the broker restarts. It is in confirm mode, so a publish only
+// succeeds once the broker has the message. In binary mode the event attributes are headers named
+// cloudEvents:<attribute>, as the amqp binding of cloud events does.
+type AMQP struct {
+	Exchange   string
+	RoutingKey string
+	Events     *event.Encoder
+	// Connect opens the channel
+	Connect func() (AMQPChannel, error)
+
+	mu      sync.Mutex
+	channel AMQPChannel
+}
+
+func NewAMQP(url string, exchange string, routingKey string, timeout time.Duration, events *event.Encoder) (*AMQP, error) {
+	if url == "" {
+		return nil, errors.New("the amqp sink needs AMQP_URL")
+	}
+
+	connect := func() (AMQPChannel, error) {
+		conn, err := amqp.Dial(url)
+		if err != nil {
+			return nil, err
+		}
+		channel, err := conn.Channel()
+		if err == nil {
+			err = channel.Confirm(false)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		confirms := channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+		return &amqpConfirmChannel{conn: conn, channel: channel, confirms: confirms, timeout: timeout}, nil
+	}
+
+	return &AMQP{Exchange: exchange, RoutingKey: routingKey, Events: events, Connect: connect}, nil
+}
+
+func (a *AMQP) Publish(user *model.User, changes []model.FieldChange) error {
+	return publish(a, a.Events, user, changes)
+}
+
+func (a *AMQP) PublishEvent(user *model.User, ev *event.Event) error {
+	ev, body, contentType, err := encode(a.Events, ev)
+
+	if err != nil {
+		return err
+	}
+
+	publishing := amqp.Publishing{ContentType: contentType, DeliveryMode: amqp.Persistent, MessageId: ev.Id, Body: body}
+
+	if a.Events.Binary() {
+		publishing.Headers = amqp.Table{}
+		for name, value := range ev.Attributes() {
+			publishing.Headers["cloudEvents:"+name] = value
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if a.channel == nil {
+			if a.channel, err = a.Connect(); err != nil {
+				return err
+			}
+		}
+
+		err = a.channel.Publish(a.Exchange, a.RoutingKey, false, false, publishing)
+
+		if err != amqp.ErrClosed || attempt > 0 {
+			return err
+		}
+
+		// the connection of a closed channel may still be open, it is closed so it doesn't leak
+		_ = a.channel.Close()
+		a.channel = nil
+	}
+}
+
+// amqpConfirmChannel is a channel in confirm mode, on a connection of its own. Publishes are not concurrent, the
+// AMQP sink holds its lock, so the next confirmation is the one of the message just published.
+type amqpConfirmChannel struct {
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+	timeout  time.Duration
+}
+
+func (c *amqpConfirmChannel) Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error {
+	if err := c.channel.Publish(exchange, key, mandatory, immediate, msg); err != nil {
+		return err
+	}
+
+	select {
+	case confirm, ok := <-c.confirms:
+		if !ok {
+			return amqp.ErrClosed
+		}
+		if !confirm.Ack {
+			return errors.New("the amqp broker didn't take the message")
+		}
+		return nil
+	case <-time.After(c.timeout):
+		// a late confirmation would be taken for the one of the next message, so the channel is not used again
+		_ = c.Close()
+		return errors.New("timed out waiting for the amqp broker to confirm the message")
+	}
+}
+
+func (c *amqpConfirmChannel) Close() error {
+	_ = c.channel.Close()
+	return c.conn.Close()
+}
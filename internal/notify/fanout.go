@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"fmt"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink is a publisher of a fan-out, with what to do when it fails.
+type Sink struct {
+	Name      string
+	Publisher Publisher
+	// Policy is config.NotifyRequired or config.NotifyBestEffort
+	Policy string
+	// Retries is how many times a failing publish is tried again before giving up
+	Retries int
+	// Backoff is the wait before the first retry, doubled before each of the next ones up to MaxBackoff, so a broker
+	// has time to come back
+	Backoff time.Duration
+}
+
+// MaxBackoff is the longest wait between two retries of a sink, unless its backoff itself is longer.
+const MaxBackoff = 30 * time.Second
+
+// RetryBackoff is the wait before the retry of the attempt, counted from 1.
+func (s Sink) RetryBackoff(attempt int) time.Duration {
+	if s.Backoff >= MaxBackoff {
+		return s.Backoff
+	}
+
+	wait := s.Backoff
+	for n := 1; n < attempt && wait < MaxBackoff; n++ {
+		wait *= 2
+	}
+
+	if wait > MaxBackoff {
+		return MaxBackoff
+	}
+	return wait
+}
+
+// Fanout publishes to all its sinks at once. It fails when a required sink fails, after its retries, while the
+// failures of best effort sinks are only logged. With Events the event is built once and the same one, with the same
+// id, is sent to every sink that can send an event already built.
+type Fanout struct {
+	Sinks  []Sink
+	Events *event.Encoder
+	Logger *logger.Logger
+}
+
+func (f *Fanout) Publish(user *model.User, changes []model.FieldChange) error {
+	return f.publishAll(user, changes, false)
+}
+
+// PublishReplay publishes the event of an user replayed, sent as a replay to the sinks telling them apart.
+func (f *Fanout) PublishReplay(user *model.User, changes []model.FieldChange) error {
+	return f.publishAll(user, changes, true)
+}
+
+func (f *Fanout) publishAll(user *model.User, changes []model.FieldChange, replay bool) error {
+	var ev *event.Event
+
+	if f.Events != nil {
+		var err error
+		if ev, err = f.Events.UserUpdated(user, changes); err != nil {
+			return err
+		}
+	}
+
+	errs := make([]error, len(f.Sinks))
+
+	var wg sync.WaitGroup
+	for n := range f.Sinks {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			errs[n] = f.publish(f.Sinks[n], user, changes, ev, replay)
+		}(n)
+	}
+	wg.Wait()
+
+	var failed []string
+	for n, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		sink := f.Sinks[n]
+		level := "error"
+		if sink.Policy == config.NotifyBestEffort {
+			level = "warning"
+		} else {
+			failed = append(failed, sink.Name+": "+err.Error())
+		}
+
+		fields := map[string]interface{}{"msg": "error notifying user " + user.Nickname + " to " + sink.Name + ": " + err.Error(), "sink": sink.Name}
+		f.Logger.LogWithFields(nil, level, fields)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("notifying failed on %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (f *Fanout) publish(sink Sink, user *model.User, changes []model.FieldChange, ev *event.Event, replay bool) error {
+	var err error
+	for attempt := 0; attempt <= sink.Retries; attempt++ {
+		if attempt > 0 && sink.Backoff > 0 {
+			time.Sleep(sink.RetryBackoff(attempt))
+		}
+
+		if p, ok := sink.Publisher.(ReplayPublisher); ok && ev != nil && replay {
+			err = p.PublishReplayEvent(user, ev)
+		} else if p, ok := sink.Publisher.(EventPublisher); ok && ev != nil {
+			err = p.PublishEvent(user, ev)
+		} else {
+			err = sink.Publisher.Publish(user, changes)
+		}
+
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
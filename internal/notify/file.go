@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"encoding/json"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/model"
+	"io"
+	"os"
+	"sync"
+)
+
+// File appends the notifications to a writer, one structured event per line, as a local sink for development.
+type File struct {
+	Writer io.Writer
+	Events *event.Encoder
+
+	mu sync.Mutex
+}
+
+// NewFile appends to the file at path, or writes to stdout when path is empty or "-".
+func NewFile(path string, events *event.Encoder) (*File, error) {
+	if path == "" || path == "-" {
+		return &File{Writer: os.Stdout, Events: events}, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{Writer: f, Events: events}, nil
+}
+
+func (f *File) Publish(user *model.User, changes []model.FieldChange) error {
+	return publish(f, f.Events, user, changes)
+}
+
+func (f *File) PublishEvent(user *model.User, ev *event.Event) error {
+	ev, err := f.Events.Sign(ev)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(ev)
+
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err = f.Writer.Write(append(body, '\n'))
+	return err
+}
@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/segmentio/kafka-go"
+	"time"
+)
+
+// KafkaWriter writes messages to a topic, as a kafka.Writer does.
+type KafkaWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+}
+
+// Kafka writes the notifications to a topic, keyed by user id so that the changes of an user stay in order. In
+// binary mode the event attributes are headers named ce_<attribute>, as the kafka binding of cloud events does.
+type Kafka struct {
+	Writer  KafkaWriter
+	Timeout time.Duration
+	Events  *event.Encoder
+}
+
+func NewKafka(brokers []string, topic string, timeout time.Duration, events *event.Encoder) (*Kafka, error) {
+	if len(brokers) == 0 || topic == "" {
+		return nil, errors.New("the kafka sink needs KAFKA_BROKERS and KAFKA_TOPIC")
+	}
+
+	w := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      brokers,
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: -1,
+		WriteTimeout: timeout,
+	})
+
+	return &Kafka{Writer: w, Timeout: timeout, Events: events}, nil
+}
+
+func (k *Kafka) Publish(user *model.User, changes []model.FieldChange) error {
+	return publish(k, k.Events, user, changes)
+}
+
+func (k *Kafka) PublishEvent(user *model.User, ev *event.Event) error {
+	ev, body, contentType, err := encode(k.Events, ev)
+
+	if err != nil {
+		return err
+	}
+
+	m := kafka.Message{Key: []byte(user.Id.Hex()), Value: body, Headers: []kafka.Header{{Key: "content-type", Value: []byte(contentType)}}}
+
+	if k.Events.Binary() {
+		for name, value := range ev.Attributes() {
+			m.Headers = append(m.Headers, kafka.Header{Key: "ce_" + name, Value: []byte(value)})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), k.Timeout)
+	defer cancel()
+
+	return k.Writer.WriteMessages(ctx, m)
+}
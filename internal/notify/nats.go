@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"time"
+)
+
+// NATS publishes the notifications to a subject, waiting for the server to have them. Its messages have no headers,
+// so the events are always structured. The connection is opened on the first publish, as the AMQP sink does, so an
+// unreachable server only fails the publishes, not the start of the service, and is then reconnected to forever.
+type NATS struct {
+	Subject string
+	Timeout time.Duration
+	Events  *event.Encoder
+	// Connect opens the connection
+	Connect func() (*nats.Conn, error)
+
+	mu   sync.Mutex
+	conn *nats.Conn
+}
+
+func NewNATS(url string, subject string, timeout time.Duration, events *event.Encoder) (*NATS, error) {
+	if url == "" || subject == "" {
+		return nil, errors.New("the nats sink needs NATS_URL and NATS_SUBJECT")
+	}
+
+	connect := func() (*nats.Conn, error) {
+		return nats.Connect(url, nats.Timeout(timeout), nats.MaxReconnects(-1))
+	}
+
+	return &NATS{Subject: subject, Timeout: timeout, Events: events, Connect: connect}, nil
+}
+
+// connection returns the connection, opening it when it isn't yet or was closed.
+func (n *NATS) connection() (*nats.Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil || n.conn.IsClosed() {
+		conn, err := n.Connect()
+		if err != nil {
+			return nil, err
+		}
+		n.conn = conn
+	}
+	return n.conn, nil
+}
+
+// Close closes the connection, if it was opened.
+func (n *NATS) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn != nil {
+		n.conn.Close()
+	}
+}
+
+func (n *NATS) Publish(user *model.User, changes []model.FieldChange) error {
+	return publish(n, n.Events, user, changes)
+}
+
+func (n *NATS) PublishEvent(user *model.User, ev *event.Event) error {
+	ev, err := n.Events.Sign(ev)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(ev)
+
+	if err != nil {
+		return err
+	}
+
+	conn, err := n.connection()
+
+	if err != nil {
+		return err
+	}
+
+	if err := conn.Publish(n.Subject, body); err != nil {
+		return err
+	}
+
+	return conn.FlushTimeout(n.Timeout)
+}
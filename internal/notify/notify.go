@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"fmt"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/pkg/eventsig"
+)
+
+// Publisher notifies the changes of an user to a sink, in cloud events.
+type Publisher interface {
+	Publish(user *model.User, changes []model.FieldChange) error
+}
+
+// EventPublisher is a publisher that can send an event already built, so that all the sinks of a fan-out send the
+// same event, with the same id, each signing it with its own keys.
+type EventPublisher interface {
+	PublishEvent(user *model.User, ev *event.Event) error
+}
+
+// ReplayPublisher is a publisher that can send the event of a replay, which sinks deduplicating the events by the
+// version of the user, as SNS FIFO topics do, must not take for a retry of the event first published.
+type ReplayPublisher interface {
+	PublishReplayEvent(user *model.User, ev *event.Event) error
+}
+
+// NewSink builds the sink with the name from the config, signing its events with its own keys. SNS is not one of
+// them, it is built by the handler.
+func NewSink(name string, c *config.NotifyConfig, events *event.Encoder) (Publisher, error) {
+	keys, err := eventsig.ParseKeys(c.SigningKeys[name])
+
+	if err == nil {
+		events, err = events.WithKeys(keys)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing keys of notify sink %s: %v", name, err)
+	}
+
+	switch name {
+	case "sqs":
+		return NewSQS(c.SqsQueueUrl, c.Region, c.Endpoint, c.Timeout, events)
+	case "kafka":
+		return NewKafka(c.KafkaBrokers, c.KafkaTopic, c.Timeout, events)
+	case "nats":
+		return NewNATS(c.NatsUrl, c.NatsSubject, c.Timeout, events)
+	case "amqp":
+		return NewAMQP(c.AmqpUrl, c.AmqpExchange, c.AmqpRoutingKey, c.Timeout, events)
+	case "webhook":
+		return NewWebhook(c.WebhookUrl, c.Timeout, events)
+	case "file":
+		return NewFile(c.File, events)
+	}
+	return nil, fmt.Errorf("unknown notify sink %s", name)
+}
+
+// publish builds the event of an updated user and publishes it, for a sink used on its own.
+func publish(p EventPublisher, events *event.Encoder, user *model.User, changes []model.FieldChange) error {
+	ev, err := events.UserUpdated(user, changes)
+
+	if err != nil {
+		return err
+	}
+
+	return p.PublishEvent(user, ev)
+}
+
+// encode is the event signed with the keys of the sink and its message, with the content type of the message.
+func encode(events *event.Encoder, ev *event.Event) (*event.Event, []byte, string, error) {
+	ev, err := events.Sign(ev)
+
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	body, contentType, err := events.Message(ev)
+	return ev, body, contentType, err
+}
@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/model"
+	"time"
+)
+
+// SQS sends the notifications straight to a queue, without a topic. In binary mode the event attributes are the
+// message attributes, named ce_<attribute> as on SNS. Its client has the default retries and connections of the aws
+// sdk, the SNS_* ones are only for SNS.
+type SQS struct {
+	Client   sqsiface.SQSAPI
+	QueueUrl string
+	Timeout  time.Duration
+	Events   *event.Encoder
+}
+
+func NewSQS(queueUrl string, region string, endpoint string, timeout time.Duration, events *event.Encoder) (*SQS, error) {
+	if queueUrl == "" {
+		return nil, errors.New("the sqs sink needs SQS_QUEUE_URL")
+	}
+
+	c := &aws.Config{Region: aws.String(region)}
+	if endpoint != "" {
+		c.Endpoint = aws.String(endpoint)
+	}
+
+	sess, err := session.NewSession(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQS{Client: sqs.New(sess), QueueUrl: queueUrl, Timeout: timeout, Events: events}, nil
+}
+
+func (s *SQS) Publish(user *model.User, changes []model.FieldChange) error {
+	return publish(s, s.Events, user, changes)
+}
+
+func (s *SQS) PublishEvent(user *model.User, ev *event.Event) error {
+	ev, body, contentType, err := encode(s.Events, ev)
+
+	if err != nil {
+		return err
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(s.QueueUrl),
+		MessageBody: aws.String(string(body)),
+	}
+
+	if s.Events.Binary() {
+		input.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+			"ce_datacontenttype": {DataType: aws.String("String"), StringValue: aws.String(contentType)},
+		}
+		for name, value := range ev.Attributes() {
+			input.MessageAttributes["ce_"+name] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	_, err = s.Client.SendMessageWithContext(ctx, input)
+
+	return err
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/model"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Webhook posts the notifications to an url, which must answer with a 2xx status. In binary mode the event
+// attributes are headers named ce-<attribute>, as the http binding of cloud events does.
+type Webhook struct {
+	Url    string
+	Client *http.Client
+	Events *event.Encoder
+}
+
+func NewWebhook(url string, timeout time.Duration, events *event.Encoder) (*Webhook, error) {
+	if url == "" {
+		return nil, errors.New("the webhook sink needs WEBHOOK_URL")
+	}
+
+	return &Webhook{Url: url, Client: &http.Client{Timeout: timeout}, Events: events}, nil
+}
+
+func (h *Webhook) Publish(user *model.User, changes []model.FieldChange) error {
+	return publish(h, h.Events, user, changes)
+}
+
+func (h *Webhook) PublishEvent(user *model.User, ev *event.Event) error {
+	ev, body, contentType, err := encode(h.Events, ev)
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.Url, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	if h.Events.Binary() {
+		for name, value := range ev.Attributes() {
+			req.Header.Set("ce-"+name, value)
+		}
+	}
+
+	resp, err := h.Client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	// drained for the connection to be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
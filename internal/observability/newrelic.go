@@ -0,0 +1,37 @@
+package observability
+
+import (
+	"context"
+	"github.com/bernardoms/user-api/config"
+	"github.com/gorilla/mux"
+	newrelic "github.com/newrelic/go-agent"
+	"github.com/newrelic/go-agent/_integrations/nrgorilla/v1"
+	"time"
+)
+
+type NewRelic struct {
+	App newrelic.Application
+}
+
+func NewNewRelic(c *config.ObservabilityConfig) (*NewRelic, error) {
+	app, err := newrelic.NewApplication(newrelic.NewConfig(c.ServiceName, c.NewRelicLicense))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &NewRelic{App: app}, nil
+}
+
+func (n *NewRelic) Instrument(r *mux.Router) {
+	nrgorilla.InstrumentRoutes(r, n.App)
+}
+
+func (n *NewRelic) Shutdown(ctx context.Context) error {
+	timeout := defaultShutdownTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	n.App.Shutdown(timeout)
+	return nil
+}
@@ -0,0 +1,14 @@
+package observability
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+)
+
+type Noop struct{}
+
+func (Noop) Instrument(r *mux.Router) {}
+
+func (Noop) Shutdown(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,60 @@
+package observability
+
+import (
+	"context"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/gorilla/mux"
+	"strings"
+	"time"
+)
+
+const defaultShutdownTimeout = 5 * time.Second
+
+const (
+	ProviderNewRelic = "newrelic"
+	ProviderOtel     = "otel"
+	ProviderNoop     = "none"
+)
+
+// Provider hides the APM vendor from the rest of the service, so handlers never import an SDK.
+type Provider interface {
+	// Instrument wraps every route registered on the router, so it must be called after the routes are added.
+	Instrument(r *mux.Router)
+	Shutdown(ctx context.Context) error
+}
+
+// New builds the provider chosen in the config. When no provider is set, New Relic is used only if a license is
+// configured; any provider that fails to start falls back to the no-op one, so the api still serves requests.
+func New(c *config.ObservabilityConfig, l *logger.Logger) Provider {
+	name := strings.ToLower(c.Provider)
+
+	if name == "" {
+		name = ProviderNoop
+		if c.NewRelicLicense != "" {
+			name = ProviderNewRelic
+		}
+	}
+
+	var provider Provider
+	var err error
+
+	switch name {
+	case ProviderNewRelic:
+		provider, err = NewNewRelic(c)
+	case ProviderOtel:
+		provider, err = NewOtel(c)
+	case ProviderNoop:
+		return Noop{}
+	default:
+		l.LogWithFields(nil, "warn", map[string]interface{}{"msg": "unknown observability provider " + c.Provider + ", using none"})
+		return Noop{}
+	}
+
+	if err != nil {
+		l.LogWithFields(nil, "error", map[string]interface{}{"msg": "error starting " + name + " observability provider, using none", "error": err.Error()})
+		return Noop{}
+	}
+
+	return provider
+}
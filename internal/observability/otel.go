@@ -0,0 +1,129 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+	"github.com/bernardoms/user-api/config"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strings"
+)
+
+type Otel struct {
+	TracerProvider *sdktrace.TracerProvider
+	tracer         trace.Tracer
+	propagator     propagation.TextMapPropagator
+}
+
+// NewOtel builds a tracer provider exporting to the exporter named in the config, "otlp", "stdout" or "none".
+func NewOtel(c *config.ObservabilityConfig) (*Otel, error) {
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(serviceResource(c.ServiceName))}
+
+	switch strings.ToLower(c.OtelExporter) {
+	case "", "none":
+	case "otlp":
+		exporter, err := NewOTLP(c.Otlp)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	case "stdout":
+		exporter, err := stdouttrace.New()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	default:
+		return nil, fmt.Errorf("unknown otel exporter %s", c.OtelExporter)
+	}
+
+	return NewOtelWithProvider(sdktrace.NewTracerProvider(options...), c.ServiceName), nil
+}
+
+// NewOtelWithProvider registers the provider and the W3C trace context and baggage propagators as the global ones,
+// so the spans of the requests continue the traces of their callers.
+func NewOtelWithProvider(tp *sdktrace.TracerProvider, serviceName string) *Otel {
+	propagator := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+
+	return &Otel{TracerProvider: tp, tracer: tp.Tracer(serviceNameOr(serviceName)), propagator: propagator}
+}
+
+// serviceResource describes the service on the spans exported, over the defaults of the sdk.
+func serviceResource(serviceName string) *resource.Resource {
+	merged, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes("", attribute.String("service.name", serviceNameOr(serviceName))))
+	if err != nil {
+		return resource.Default()
+	}
+	return merged
+}
+
+func serviceNameOr(serviceName string) string {
+	if serviceName == "" {
+		return "user-api"
+	}
+	return serviceName
+}
+
+func (o *Otel) Instrument(r *mux.Router) {
+	r.Use(o.middleware)
+}
+
+func (o *Otel) Shutdown(ctx context.Context) error {
+	return o.TracerProvider.Shutdown(ctx)
+}
+
+func (o *Otel) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				name = tpl
+			}
+		}
+
+		ctx := o.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := o.tracer.Start(ctx, r.Method+" "+name, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", name),
+			attribute.Int("http.status_code", rec.status),
+		)
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush lets streaming handlers, as the export, flush through the recorder.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
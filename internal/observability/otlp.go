@@ -0,0 +1,316 @@
+package observability
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bernardoms/user-api/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OTLP exports spans with the OTLP/HTTP protocol in its JSON encoding, which collectors accept on /v1/traces next
+// to the protobuf one.
+type OTLP struct {
+	Endpoint string
+	Headers  map[string]string
+	Client   *http.Client
+}
+
+// NewOTLP builds the exporter of the config. Only the http/json protocol is supported.
+func NewOTLP(c config.OtlpConfig) (*OTLP, error) {
+	if c.Protocol != "" && c.Protocol != "http/json" {
+		return nil, fmt.Errorf("unsupported otlp protocol %s, only http/json is", c.Protocol)
+	}
+	return &OTLP{Endpoint: c.Endpoint, Headers: c.Headers, Client: &http.Client{Timeout: c.Timeout}}, nil
+}
+
+func (o *OTLP) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp export to %s answered %d", o.Endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// Shutdown has nothing to release, the spans are sent as they are exported.
+func (o *OTLP) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// The OTLP JSON encoding: trace and span ids are hex, 64-bit integers are strings and enums are numbers.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	SchemaUrl  string           `json:"schemaUrl,omitempty"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope     otlpScope  `json:"scope"`
+	Spans     []otlpSpan `json:"spans"`
+	SchemaUrl string     `json:"schemaUrl,omitempty"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId                string         `json:"traceId"`
+	SpanId                 string         `json:"spanId"`
+	TraceState             string         `json:"traceState,omitempty"`
+	ParentSpanId           string         `json:"parentSpanId,omitempty"`
+	Name                   string         `json:"name"`
+	Kind                   int            `json:"kind"`
+	StartTimeUnixNano      string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano        string         `json:"endTimeUnixNano"`
+	Attributes             []otlpKeyValue `json:"attributes,omitempty"`
+	DroppedAttributesCount int            `json:"droppedAttributesCount,omitempty"`
+	Events                 []otlpEvent    `json:"events,omitempty"`
+	DroppedEventsCount     int            `json:"droppedEventsCount,omitempty"`
+	Links                  []otlpLink     `json:"links,omitempty"`
+	DroppedLinksCount      int            `json:"droppedLinksCount,omitempty"`
+	Status                 otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano           string         `json:"timeUnixNano"`
+	Name                   string         `json:"name"`
+	Attributes             []otlpKeyValue `json:"attributes,omitempty"`
+	DroppedAttributesCount int            `json:"droppedAttributesCount,omitempty"`
+}
+
+type otlpLink struct {
+	TraceId                string         `json:"traceId"`
+	SpanId                 string         `json:"spanId"`
+	TraceState             string         `json:"traceState,omitempty"`
+	Attributes             []otlpKeyValue `json:"attributes,omitempty"`
+	DroppedAttributesCount int            `json:"droppedAttributesCount,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+// otlpRequest groups the spans by resource and instrumentation library, keeping the order they ended in.
+func otlpRequest(spans []sdktrace.ReadOnlySpan) otlpTraces {
+	var request otlpTraces
+
+	resources := map[attribute.Distinct]int{}
+	scopes := map[attribute.Distinct]map[instrumentation.Library]int{}
+
+	for _, span := range spans {
+		res := span.Resource()
+		if res == nil {
+			res = resource.Empty()
+		}
+		key := res.Equivalent()
+
+		r, ok := resources[key]
+		if !ok {
+			r = len(request.ResourceSpans)
+			resources[key] = r
+			scopes[key] = map[instrumentation.Library]int{}
+			request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+				Resource:  otlpResource{Attributes: otlpAttributes(res.Attributes())},
+				SchemaUrl: res.SchemaURL(),
+			})
+		}
+
+		library := span.InstrumentationLibrary()
+		s, ok := scopes[key][library]
+		if !ok {
+			s = len(request.ResourceSpans[r].ScopeSpans)
+			scopes[key][library] = s
+			request.ResourceSpans[r].ScopeSpans = append(request.ResourceSpans[r].ScopeSpans, otlpScopeSpans{
+				Scope:     otlpScope{Name: library.Name, Version: library.Version},
+				SchemaUrl: library.SchemaURL,
+			})
+		}
+
+		scope := &request.ResourceSpans[r].ScopeSpans[s]
+		scope.Spans = append(scope.Spans, newOtlpSpan(span))
+	}
+	return request
+}
+
+func newOtlpSpan(span sdktrace.ReadOnlySpan) otlpSpan {
+	sc := span.SpanContext()
+
+	s := otlpSpan{
+		TraceId:                sc.TraceID().String(),
+		SpanId:                 sc.SpanID().String(),
+		TraceState:             sc.TraceState().String(),
+		Name:                   span.Name(),
+		Kind:                   int(span.SpanKind()),
+		StartTimeUnixNano:      unixNano(span.StartTime()),
+		EndTimeUnixNano:        unixNano(span.EndTime()),
+		Attributes:             otlpAttributes(span.Attributes()),
+		DroppedAttributesCount: span.DroppedAttributes(),
+		DroppedEventsCount:     span.DroppedEvents(),
+		DroppedLinksCount:      span.DroppedLinks(),
+		Status:                 otlpStatusOf(span.Status()),
+	}
+
+	if parent := span.Parent(); parent.IsValid() {
+		s.ParentSpanId = parent.SpanID().String()
+	}
+
+	for _, e := range span.Events() {
+		s.Events = append(s.Events, otlpEvent{TimeUnixNano: unixNano(e.Time), Name: e.Name,
+			Attributes: otlpAttributes(e.Attributes), DroppedAttributesCount: e.DroppedAttributeCount})
+	}
+
+	for _, l := range span.Links() {
+		s.Links = append(s.Links, otlpLink{TraceId: l.SpanContext.TraceID().String(), SpanId: l.SpanContext.SpanID().String(),
+			TraceState: l.SpanContext.TraceState().String(), Attributes: otlpAttributes(l.Attributes),
+			DroppedAttributesCount: l.DroppedAttributeCount})
+	}
+	return s
+}
+
+// otlpStatusOf maps the status codes, which OTLP numbers as unset 0, ok 1 and error 2.
+func otlpStatusOf(status sdktrace.Status) otlpStatus {
+	switch status.Code {
+	case codes.Ok:
+		return otlpStatus{Code: 1}
+	case codes.Error:
+		return otlpStatus{Code: 2, Message: status.Description}
+	}
+	return otlpStatus{}
+}
+
+func otlpAttributes(attributes []attribute.KeyValue) []otlpKeyValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	kvs := make([]otlpKeyValue, 0, len(attributes))
+	for _, kv := range attributes {
+		kvs = append(kvs, otlpKeyValue{Key: string(kv.Key), Value: otlpValue(kv.Value)})
+	}
+	return kvs
+}
+
+func otlpValue(v attribute.Value) otlpAnyValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		b := v.AsBool()
+		return otlpAnyValue{BoolValue: &b}
+	case attribute.INT64:
+		return otlpInt(v.AsInt64())
+	case attribute.FLOAT64:
+		f := v.AsFloat64()
+		return otlpAnyValue{DoubleValue: &f}
+	case attribute.BOOLSLICE:
+		var values []otlpAnyValue
+		for _, b := range v.AsBoolSlice() {
+			b := b
+			values = append(values, otlpAnyValue{BoolValue: &b})
+		}
+		return otlpArray(values)
+	case attribute.INT64SLICE:
+		var values []otlpAnyValue
+		for _, i := range v.AsInt64Slice() {
+			values = append(values, otlpInt(i))
+		}
+		return otlpArray(values)
+	case attribute.FLOAT64SLICE:
+		var values []otlpAnyValue
+		for _, f := range v.AsFloat64Slice() {
+			f := f
+			values = append(values, otlpAnyValue{DoubleValue: &f})
+		}
+		return otlpArray(values)
+	case attribute.STRINGSLICE:
+		var values []otlpAnyValue
+		for _, s := range v.AsStringSlice() {
+			s := s
+			values = append(values, otlpAnyValue{StringValue: &s})
+		}
+		return otlpArray(values)
+	}
+	s := v.Emit()
+	return otlpAnyValue{StringValue: &s}
+}
+
+func otlpInt(i int64) otlpAnyValue {
+	s := strconv.FormatInt(i, 10)
+	return otlpAnyValue{IntValue: &s}
+}
+
+func otlpArray(values []otlpAnyValue) otlpAnyValue {
+	if values == nil {
+		values = []otlpAnyValue{}
+	}
+	return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+}
+
+func unixNano(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,47 @@
+package purge
+
+import (
+	"context"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/repository"
+	"time"
+)
+
+// Purger hard-deletes, every Interval, the users deleted more than Retention ago.
+type Purger struct {
+	Repository repository.UserRepository
+	Retention  time.Duration
+	Interval   time.Duration
+	Logger     *logger.Logger
+}
+
+// Run purges once right away and then every Interval, until the context is done.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.PurgeOnce(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Purger) PurgeOnce(now time.Time) {
+	purged, err := p.Repository.Purge(now.Add(-p.Retention))
+
+	if err != nil {
+		f := map[string]interface{}{"msg": "error purging deleted users: " + err.Error()}
+		p.Logger.LogWithFields(nil, "error", f)
+		return
+	}
+
+	if purged > 0 {
+		f := map[string]interface{}{"msg": "purged deleted users", "purged": purged}
+		p.Logger.LogWithFields(nil, "info", f)
+	}
+}
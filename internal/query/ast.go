@@ -0,0 +1,57 @@
+// Package query parses the user filters of the api into a tree of conditions, independent of the database, that
+// each repository translates to its own query language.
+package query
+
+import "time"
+
+// Node is a condition on the users. Fields are named as in the json of an user.
+type Node interface {
+	node()
+}
+
+// And matches the users matching all of its nodes.
+type And struct {
+	Nodes []Node
+}
+
+// Or matches the users matching any of its nodes.
+type Or struct {
+	Nodes []Node
+}
+
+// Not matches the users not matching its node.
+type Not struct {
+	Node Node
+}
+
+// In matches the users whose field equals any of the values.
+type In struct {
+	Field  string
+	Values []string
+}
+
+// Prefix matches the users whose field starts with the value.
+type Prefix struct {
+	Field string
+	Value string
+}
+
+// Range matches the users whose time field is at or after From and strictly before To, either bound being optional.
+type Range struct {
+	Field string
+	From  *time.Time
+	To    *time.Time
+}
+
+func (And) node()    {}
+func (Or) node()     {}
+func (Not) node()    {}
+func (In) node()     {}
+func (Prefix) node() {}
+func (Range) node()  {}
+
+// StringFields can be matched by value, value lists and prefixes.
+var StringFields = []string{"nickname", "email", "firstName", "lastName", "country"}
+
+// TimeFields can be matched by date ranges.
+var TimeFields = []string{"createdAt", "updatedAt"}
@@ -0,0 +1,102 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	eof tokenKind = iota
+	word
+	quoted
+	openParen
+	closeParen
+	comma
+	equals
+	notEquals
+	bang
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == word && strings.EqualFold(t.text, keyword)
+}
+
+// tokenize splits a filter in tokens. Words run until a space or one of ( ) , = ! ", and double quoted values may
+// hold any of them, escaping " and \ with a backslash.
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+
+	runes := []rune(input)
+	offsets := make([]int, len(runes)+1)
+	for i, offset := 0, 0; i < len(runes); i++ {
+		offsets[i] = offset
+		offset += len(string(runes[i]))
+		offsets[i+1] = offset
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := offsets[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: openParen, text: "(", pos: start})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: closeParen, text: ")", pos: start})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: comma, text: ",", pos: start})
+			i++
+		case r == '=':
+			tokens = append(tokens, token{kind: equals, text: "=", pos: start})
+			i++
+		case r == '!' && i+1 < len(runes) && runes[i+1] == '=':
+			tokens = append(tokens, token{kind: notEquals, text: "!=", pos: start})
+			i += 2
+		case r == '!':
+			tokens = append(tokens, token{kind: bang, text: "!", pos: start})
+			i++
+		case r == '"':
+			first := i
+			var b strings.Builder
+			closed := false
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					b.WriteRune(runes[i])
+					continue
+				}
+				if runes[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				b.WriteRune(runes[i])
+			}
+			if !closed {
+				return nil, &SyntaxError{Pos: start + 1, Token: string(runes[first:]), Msg: "unterminated quoted value"}
+			}
+			tokens = append(tokens, token{kind: quoted, text: b.String(), pos: start})
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("(),=!\"", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: word, text: string(runes[i:j]), pos: start})
+			i = j
+		}
+	}
+
+	return append(tokens, token{kind: eof, pos: len(input)}), nil
+}
@@ -0,0 +1,410 @@
+package query
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// FilterParam is the query parameter holding a filter expression.
+const FilterParam = "filter"
+
+const dateLayout = "2006-01-02"
+
+// MaxLength is the longest filter or value parsed, in bytes.
+const MaxLength = 2048
+
+// MaxDepth is how deep parentheses and not can nest. MongoDB rejects queries nested deeper than 100 levels, and each
+// of these takes up to two.
+const MaxDepth = 32
+
+// SyntaxError tells what is wrong with a filter and where, Pos being the 1-based position of Token in it.
+type SyntaxError struct {
+	Pos   int
+	Token string
+	Msg   string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at position %d, the end of the filter", e.Msg, e.Pos)
+	}
+	return fmt.Sprintf("%s at position %d near %q", e.Msg, e.Pos, e.Token)
+}
+
+// Parse parses a filter expression made of comparisons combined with and, or, not and parentheses, and binds
+// tighter than or:
+//
+//	country=UK,BR and (nickname=bob* or not email="bob@test.com") and createdAt=2020-01-01..2020-02-01
+//
+// A comparison is a field, = or != and a comma separated list of values, matching any of them. A ! before the list
+// negates it, a trailing * matches a prefix and time fields take ranges from..to, either bound being optional, of
+// dates or RFC 3339 times. A single date matches the whole day.
+func Parse(expression string) (Node, error) {
+	p, err := newParser(expression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := p.or()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != eof {
+		return nil, p.errorAt(t, "expected and, or or the end of the filter")
+	}
+
+	return n, nil
+}
+
+// ParseValue parses the value of a field given as its own parameter, such as country=UK,BR, with the syntax of the
+// right side of a comparison.
+func ParseValue(field string, value string) (Node, error) {
+	p, err := newParser(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isField(field) {
+		return nil, &SyntaxError{Pos: 1, Token: field, Msg: "unknown field"}
+	}
+
+	n, err := p.values(field, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != eof {
+		return nil, p.errorAt(t, "expected , or the end of the value")
+	}
+
+	return n, nil
+}
+
+// FromParams builds the condition of the field parameters and the filter expression of a request, all of which
+// must match. It returns nil when there is none.
+func FromParams(params url.Values) (Node, error) {
+	var nodes []Node
+
+	for _, field := range append(append([]string{}, StringFields...), TimeFields...) {
+		v := params.Get(field)
+		if v == "" {
+			continue
+		}
+		n, err := ParseValue(field, v)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", field, err)
+		}
+		nodes = append(nodes, n)
+	}
+
+	if v := params.Get(FilterParam); v != "" {
+		n, err := Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", FilterParam, err)
+		}
+		nodes = append(nodes, n)
+	}
+
+	switch len(nodes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return nodes[0], nil
+	}
+	return And{Nodes: nodes}, nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+	length int
+	depth  int
+}
+
+func newParser(input string) (*parser, error) {
+	if len(input) > MaxLength {
+		return nil, fmt.Errorf("longer than the %d bytes allowed", MaxLength)
+	}
+
+	tokens, err := tokenize(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &parser{tokens: tokens, length: len(input)}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != eof {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) errorAt(t token, msg string) error {
+	return &SyntaxError{Pos: t.pos + 1, Token: t.text, Msg: msg}
+}
+
+// nest goes one level deeper at the not or the parenthesis t, failing past MaxDepth. leave goes back up.
+func (p *parser) nest(t token) error {
+	p.depth++
+	if p.depth > MaxDepth {
+		return p.errorAt(t, fmt.Sprintf("nested deeper than %d levels", MaxDepth))
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) or() (Node, error) {
+	n, err := p.and()
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{n}
+	for p.peek().isKeyword("or") {
+		p.take()
+		n, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return Or{Nodes: nodes}, nil
+}
+
+func (p *parser) and() (Node, error) {
+	n, err := p.unary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{n}
+	for p.peek().isKeyword("and") {
+		p.take()
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return And{Nodes: nodes}, nil
+}
+
+func (p *parser) unary() (Node, error) {
+	if p.peek().isKeyword("not") {
+		if err := p.nest(p.take()); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Node: n}, nil
+	}
+
+	if p.peek().kind == openParen {
+		if err := p.nest(p.take()); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.take(); t.kind != closeParen {
+			return nil, p.errorAt(t, "expected )")
+		}
+		return n, nil
+	}
+
+	return p.comparison()
+}
+
+func (p *parser) comparison() (Node, error) {
+	field := p.take()
+
+	if field.kind != word {
+		return nil, p.errorAt(field, "expected a field")
+	}
+
+	if !isField(field.text) {
+		return nil, p.errorAt(field, "unknown field")
+	}
+
+	switch op := p.take(); op.kind {
+	case equals:
+		return p.values(field.text, false)
+	case notEquals:
+		return p.values(field.text, true)
+	default:
+		return nil, p.errorAt(op, "expected = or !=")
+	}
+}
+
+// values parses a comma separated list of values of the field, optionally negated with a leading !.
+func (p *parser) values(field string, negated bool) (Node, error) {
+	if p.peek().kind == bang {
+		t := p.take()
+		if negated {
+			return nil, p.errorAt(t, "unexpected ! after !=")
+		}
+		negated = true
+	}
+
+	var nodes []Node
+	var in *In
+
+	for {
+		t := p.take()
+
+		if t.kind != word && t.kind != quoted {
+			return nil, p.errorAt(t, "expected a value")
+		}
+
+		n, err := p.value(field, t)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if v, ok := n.(In); ok && in != nil {
+			in.Values = append(in.Values, v.Values...)
+		} else if ok {
+			in = &v
+		} else {
+			nodes = append(nodes, n)
+		}
+
+		if p.peek().kind != comma {
+			break
+		}
+		p.take()
+	}
+
+	if in != nil {
+		nodes = append([]Node{*in}, nodes...)
+	}
+
+	var n Node = Or{Nodes: nodes}
+	if len(nodes) == 1 {
+		n = nodes[0]
+	}
+
+	if negated {
+		return Not{Node: n}, nil
+	}
+	return n, nil
+}
+
+func (p *parser) value(field string, t token) (Node, error) {
+	if isTimeField(field) {
+		return p.timeRange(field, t)
+	}
+
+	if t.kind == word && strings.HasSuffix(t.text, "*") {
+		prefix := strings.TrimSuffix(t.text, "*")
+		if prefix == "" || strings.Contains(prefix, "*") {
+			return nil, p.errorAt(t, "invalid prefix")
+		}
+		return Prefix{Field: field, Value: prefix}, nil
+	}
+
+	return In{Field: field, Values: []string{t.text}}, nil
+}
+
+func (p *parser) timeRange(field string, t token) (Node, error) {
+	sep := strings.Index(t.text, "..")
+
+	if sep < 0 {
+		start, precision, err := parseTime(t.text)
+		if err != nil {
+			return nil, p.errorAt(t, "invalid date, expected YYYY-MM-DD or RFC 3339")
+		}
+		end := start.Add(precision)
+		return Range{Field: field, From: &start, To: &end}, nil
+	}
+
+	r := Range{Field: field}
+	from, to := t.text[:sep], t.text[sep+2:]
+
+	if from != "" {
+		start, _, err := parseTime(from)
+		if err != nil {
+			return nil, p.errorAt(t, "invalid start of range, expected YYYY-MM-DD or RFC 3339")
+		}
+		r.From = &start
+	}
+
+	if to != "" {
+		end, _, err := parseTime(to)
+		if err != nil {
+			return nil, p.errorAt(t, "invalid end of range, expected YYYY-MM-DD or RFC 3339")
+		}
+		r.To = &end
+	}
+
+	if r.From == nil && r.To == nil {
+		return nil, p.errorAt(t, "range without bounds")
+	}
+
+	return r, nil
+}
+
+// parseTime parses a date or a RFC 3339 time, returning how long the value lasts: a day for a date and the
+// millisecond times are kept with otherwise.
+func parseTime(s string) (time.Time, time.Duration, error) {
+	if t, err := time.Parse(dateLayout, s); err == nil {
+		return t, 24 * time.Hour, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+
+	return t.UTC(), time.Millisecond, err
+}
+
+func isField(field string) bool {
+	return contains(StringFields, field) || isTimeField(field)
+}
+
+func isTimeField(field string) bool {
+	return contains(TimeFields, field)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,333 @@
+// Package replay publishes again the events of the users, for consumers to get the ones they missed or mishandled.
+// Events are rebuilt from the history of the changes, or synthesized from the current state of the users, and
+// published at a limited rate not to flood the sinks and their consumers.
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/query"
+	"github.com/bernardoms/user-api/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strings"
+	"time"
+)
+
+// operations are the operations of the history published as each type of event.
+var operations = map[string]string{
+	event.TypeUserUpdated: model.OperationUpdate,
+}
+
+// Publisher notifies an user with its changes, nil when they are not known.
+type Publisher interface {
+	Publish(user *model.User, changes []model.FieldChange) error
+}
+
+// ReplayPublisher is a Publisher telling replayed events apart from the ones first published, so that sinks
+// deduplicating the events by the version of the user, as SNS FIFO topics do, don't drop them.
+type ReplayPublisher interface {
+	PublishReplay(user *model.User, changes []model.FieldChange) error
+}
+
+// Progress is how a replay reports how far it went, as jobs do.
+type Progress interface {
+	Reset(total int64)
+	Add(done int64, failed int64)
+	Fail(msg string)
+	Checkpoint(checkpoint int64)
+}
+
+// Replayer publishes the events selected by replay requests, at most Rate per second, or as fast as it can when
+// Rate is 0.
+type Replayer struct {
+	Users     repository.UserRepository
+	Audit     repository.AuditRepository
+	Publisher Publisher
+	Rate      int
+}
+
+// Validate checks the request before it is run.
+func Validate(r *model.ReplayRequest) error {
+	if r.Source != model.ReplayAudit && r.Source != model.ReplaySnapshot {
+		return fmt.Errorf("unknown replay source %s, it must be %s or %s", r.Source, model.ReplayAudit, model.ReplaySnapshot)
+	}
+
+	for _, t := range r.Types {
+		if _, ok := operations[t]; !ok {
+			return fmt.Errorf("unknown event type %s, it must be one of %s", t, strings.Join(types(), ", "))
+		}
+	}
+
+	if r.From != nil && r.To != nil && !r.From.Before(*r.To) {
+		return errors.New("the replay must start before it ends")
+	}
+
+	if r.Rate < 0 {
+		return errors.New("the replay rate can't be negative")
+	}
+	return nil
+}
+
+func types() []string {
+	t := make([]string, 0, len(operations))
+	for name := range operations {
+		t = append(t, name)
+	}
+	sort.Strings(t)
+	return t
+}
+
+// Run publishes the events of the request, skipping the first ones, already published by a previous run. Events
+// that can't be published are counted as failed, with their error, and the replay goes on.
+func (p *Replayer) Run(ctx context.Context, r *model.ReplayRequest, checkpoint int64, progress Progress) error {
+	if err := Validate(r); err != nil {
+		return err
+	}
+
+	rate := p.Rate
+	if r.Rate > 0 && (rate <= 0 || r.Rate < rate) {
+		rate = r.Rate
+	}
+
+	t := &throttle{ctx: ctx}
+	if rate > 0 {
+		t.interval = time.Second / time.Duration(rate)
+	}
+
+	if r.Source == model.ReplayAudit {
+		return p.replayAudit(r, checkpoint, progress, t)
+	}
+	return p.replaySnapshots(r, checkpoint, progress, t)
+}
+
+// replayAudit publishes the changes of the history as they were notified. The user as it was after each change is
+// its current state with the later changes reverted. Entries without changes were never notified and are skipped.
+func (p *Replayer) replayAudit(r *model.ReplayRequest, checkpoint int64, progress Progress, t *throttle) error {
+	if p.Audit == nil {
+		return errors.New("the history is not recorded, only snapshots can be replayed")
+	}
+
+	filter := &model.AuditFilter{Nickname: r.Nickname, From: r.From, To: r.To}
+	for _, name := range typesOrAll(r.Types) {
+		filter.Operations = append(filter.Operations, operations[name])
+	}
+
+	total, err := p.Audit.Count(filter)
+
+	if err != nil {
+		return err
+	}
+
+	progress.Reset(total)
+
+	var n int64
+	users := map[primitive.ObjectID]*userHistory{}
+
+	return p.Audit.Stream(filter, func(entry *model.AuditEntry) error {
+		if n++; n <= checkpoint {
+			progress.Add(1, 0)
+			return nil
+		}
+
+		if len(entry.Changes) == 0 {
+			p.report(progress, n, "", nil)
+			return nil
+		}
+
+		user, err := p.userAt(users, filter, entry)
+
+		if err == nil {
+			if err := t.wait(); err != nil {
+				return err
+			}
+			err = p.publish(user, entry.Changes)
+		}
+
+		p.report(progress, n, entry.Nickname+" at "+entry.Timestamp.Format(time.RFC3339Nano), err)
+		return nil
+	})
+}
+
+// userHistory is an user as it was right after an entry of the history, with the entries recorded after it, oldest
+// first. The user is nil when it no longer exists.
+type userHistory struct {
+	user  *model.User
+	later []model.AuditEntry
+}
+
+// userAt rebuilds the user as it was right after the change of the entry. Each user is read once, at its first
+// entry, reverting its later changes newest first, then moved forward through them as its next entries come. An
+// user is forgotten once none of its later entries can be replayed, so only the users with entries still to come are
+// kept.
+func (p *Replayer) userAt(users map[primitive.ObjectID]*userHistory, filter *model.AuditFilter, entry *model.AuditEntry) (*model.User, error) {
+	h := users[entry.UserId]
+
+	if h == nil || !h.forward(entry) {
+		var err error
+
+		if h, err = p.load(filter, entry); err != nil {
+			return nil, err
+		}
+
+		users[entry.UserId] = h
+	}
+
+	if len(h.later) == 0 {
+		delete(users, entry.UserId)
+	}
+
+	if h.user == nil {
+		return nil, errors.New("the user no longer exists")
+	}
+
+	user := *h.user
+	at := entry.Timestamp
+	user.UpdatedAt, user.UpdatedBy, user.Password = &at, entry.Actor, ""
+
+	return &user, nil
+}
+
+// load reads the user of the entry as it is, and reverts the changes recorded after the entry. The later entries are
+// kept up to the last one the filter replays, the user is never moved past it.
+func (p *Replayer) load(filter *model.AuditFilter, entry *model.AuditEntry) (*userHistory, error) {
+	user, err := p.Users.FindById(entry.UserId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	later, err := p.Audit.FindAfter(entry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user != nil {
+		for _, change := range later {
+			model.Revert(user, change.Changes)
+		}
+	}
+
+	for i, j := 0, len(later)-1; i < j; i, j = i+1, j-1 {
+		later[i], later[j] = later[j], later[i]
+	}
+
+	replayed := 0
+	for n := range later {
+		if len(later[n].Changes) > 0 && filter.Matches(&later[n]) {
+			replayed = n + 1
+		}
+	}
+
+	return &userHistory{user: user, later: later[:replayed]}, nil
+}
+
+// forward reapplies the changes up to the entry, telling whether the entry was one of the later ones. Entries
+// recorded after the user was loaded are not, and the user must be loaded again. An user that no longer exists is
+// only moved past the entry.
+func (h *userHistory) forward(entry *model.AuditEntry) bool {
+	for n, later := range h.later {
+		if later.Id != entry.Id {
+			continue
+		}
+
+		if h.user != nil {
+			for _, change := range h.later[:n+1] {
+				model.Reapply(h.user, change.Changes)
+			}
+		}
+
+		h.later = h.later[n+1:]
+		return true
+	}
+	return false
+}
+
+// replaySnapshots publishes the current state of the users updated in the time range, without changes.
+func (p *Replayer) replaySnapshots(r *model.ReplayRequest, checkpoint int64, progress Progress, t *throttle) error {
+	filter := &model.Filter{UpdatedSince: r.From, UpdatedBefore: r.To}
+	if r.Nickname != "" {
+		filter.Query = query.In{Field: "nickname", Values: []string{r.Nickname}}
+	}
+
+	total, err := p.Users.Count(filter)
+
+	if err != nil {
+		return err
+	}
+
+	progress.Reset(total)
+
+	var n int64
+
+	return p.Users.Stream(filter, func(user *model.User) error {
+		if n++; n <= checkpoint {
+			progress.Add(1, 0)
+			return nil
+		}
+
+		if err := t.wait(); err != nil {
+			return err
+		}
+
+		p.report(progress, n, user.Nickname, p.publish(user, nil))
+		return nil
+	})
+}
+
+// publish publishes the user as a replay when the publisher tells replays apart.
+func (p *Replayer) publish(user *model.User, changes []model.FieldChange) error {
+	if r, ok := p.Publisher.(ReplayPublisher); ok {
+		return r.PublishReplay(user, changes)
+	}
+	return p.Publisher.Publish(user, changes)
+}
+
+func (p *Replayer) report(progress Progress, n int64, item string, err error) {
+	if err != nil {
+		progress.Add(1, 1)
+		progress.Fail(item + ": " + err.Error())
+	} else {
+		progress.Add(1, 0)
+	}
+	progress.Checkpoint(n)
+}
+
+func typesOrAll(t []string) []string {
+	if len(t) == 0 {
+		return types()
+	}
+	return t
+}
+
+// throttle spaces the events by its interval, waiting until the next one is due or the context is done.
+type throttle struct {
+	ctx      context.Context
+	interval time.Duration
+	next     time.Time
+}
+
+func (t *throttle) wait() error {
+	now := time.Now()
+
+	if t.next.After(now) {
+		timer := time.NewTimer(t.next.Sub(now))
+		defer timer.Stop()
+
+		select {
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		case <-timer.C:
+		}
+		now = t.next
+	} else if err := t.ctx.Err(); err != nil {
+		return err
+	}
+
+	t.next = now.Add(t.interval)
+	return nil
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+// MongoAudit keeps the append-only history of user changes. Entries are only ever inserted.
+type MongoAudit struct {
+	Collection *mongo.Collection
+}
+
+func GetAuditCollection(mongoConfig *config.MongoConfig) *mongo.Collection {
+	c := session.Database(mongoConfig.Database).Collection("user_audit")
+	_, _ = c.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys: bsonx.Doc{{Key: "userId", Value: bsonx.Int32(1)}, {Key: "timestamp", Value: bsonx.Int32(-1)}},
+		},
+		{
+			Keys: bsonx.Doc{{Key: "nickname", Value: bsonx.Int32(1)}, {Key: "timestamp", Value: bsonx.Int32(-1)}},
+		},
+	})
+	return c
+}
+
+func (m MongoAudit) Record(entry *model.AuditEntry) error {
+	if entry.Id.IsZero() {
+		entry.Id = primitive.NewObjectID()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = now()
+	}
+
+	_, err := m.Collection.InsertOne(context.TODO(), entry)
+
+	return err
+}
+
+// FindByUser returns a page of the history of a user, most recent first. Entries are matched by user id when it
+// is known, which follows the user across renames, and by nickname otherwise.
+func (m MongoAudit) FindByUser(userId primitive.ObjectID, nickname string, page int, size int) ([]model.AuditEntry, int64, error) {
+	filter := bson.M{"nickname": nickname}
+	if !userId.IsZero() {
+		filter = bson.M{"userId": userId}
+	}
+
+	total, err := m.Collection.CountDocuments(context.TODO(), filter)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(page-1) * int64(size)).
+		SetLimit(int64(size))
+
+	cur, err := m.Collection.Find(context.TODO(), filter, opts)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := make([]model.AuditEntry, 0)
+	err = cur.All(context.TODO(), &results)
+
+	return results, total, err
+}
+
+// Count returns how many entries match the filter.
+func (m MongoAudit) Count(auditFilter *model.AuditFilter) (int64, error) {
+	return m.Collection.CountDocuments(context.TODO(), auditQuery(auditFilter))
+}
+
+// Stream calls fn with each entry matching the filter, oldest first, stopping at the first error.
+func (m MongoAudit) Stream(auditFilter *model.AuditFilter, fn func(entry *model.AuditEntry) error) error {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(streamBatchSize)
+
+	cur, err := m.Collection.Find(context.TODO(), auditQuery(auditFilter), opts)
+
+	if err != nil {
+		return err
+	}
+
+	defer cur.Close(context.TODO())
+
+	for cur.Next(context.TODO()) {
+		var entry model.AuditEntry
+		if err := cur.Decode(&entry); err != nil {
+			return err
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// FindAfter returns the entries of the user of the entry recorded after it, most recent first.
+func (m MongoAudit) FindAfter(entry *model.AuditEntry) ([]model.AuditEntry, error) {
+	filter := bson.M{"userId": entry.UserId, "$or": bson.A{
+		bson.M{"timestamp": bson.M{"$gt": entry.Timestamp}},
+		bson.M{"timestamp": entry.Timestamp, "_id": bson.M{"$gt": entry.Id}},
+	}}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}})
+
+	cur, err := m.Collection.Find(context.TODO(), filter, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]model.AuditEntry, 0)
+	err = cur.All(context.TODO(), &results)
+
+	return results, err
+}
+
+func auditQuery(auditFilter *model.AuditFilter) bson.M {
+	filter := bson.M{}
+
+	if auditFilter.Nickname != "" {
+		filter["nickname"] = auditFilter.Nickname
+	}
+
+	if len(auditFilter.Operations) > 0 {
+		filter["operation"] = bson.M{"$in": auditFilter.Operations}
+	}
+
+	timestamp := bson.M{}
+	if auditFilter.From != nil {
+		timestamp["$gte"] = *auditFilter.From
+	}
+	if auditFilter.To != nil {
+		timestamp["$lt"] = *auditFilter.To
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	return filter
+}
@@ -0,0 +1,310 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/bernardoms/user-api/internal/cache"
+	"github.com/bernardoms/user-api/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const nicknameKeyPrefix = "user:nickname:"
+
+// invalidationTTL is how long the nicknames written are marked as invalidated. It must outlast the reads in flight
+// during the write, which would otherwise cache the user as it was before.
+const invalidationTTL = 5 * time.Second
+
+// invalidated is the mark of the nicknames just written, never a valid encoded user.
+var invalidated = []byte{0}
+
+// CachedRepository is a read-through cache of the users returned by the api, looked up by nickname, in front of
+// another UserRepository. Only the response shape of the users is cached, never their password hash, and
+// FindByNickname, used before writes, always reads the repository. Lookups of unknown nicknames are cached too, for
+// NegativeTTL, and every write marks the nicknames it touches as invalidated for a short while. Reads only add to the
+// cache keys that are absent, atomically, so a read that started before the write can't replace the mark with a
+// stale user. A failing cache is logged and bypassed, it never fails the request.
+type CachedRepository struct {
+	// counters first, they must be 64-bit aligned for sync/atomic
+	hits         uint64
+	misses       uint64
+	negativeHits uint64
+	errors       uint64
+
+	Repository  UserRepository
+	Cache       cache.Cache
+	TTL         time.Duration
+	NegativeTTL time.Duration
+}
+
+func NewCachedRepository(repository UserRepository, c cache.Cache, ttl time.Duration, negativeTTL time.Duration) *CachedRepository {
+	return &CachedRepository{Repository: repository, Cache: c, TTL: ttl, NegativeTTL: negativeTTL}
+}
+
+// FindResponseByNickname returns the user with the nickname as answered by the api, from the cache when it can.
+func (c *CachedRepository) FindResponseByNickname(nickname string) (*model.UserResponse, error) {
+	cached, found, err := c.Cache.Get(nicknameKeyPrefix + nickname)
+
+	if err != nil {
+		c.cacheError(err)
+	}
+
+	if found && bytes.Equal(cached, invalidated) {
+		atomic.AddUint64(&c.misses, 1)
+		return c.findResponse(nickname)
+	}
+
+	if found {
+		// an empty value is a cached "not found"
+		if len(cached) == 0 {
+			atomic.AddUint64(&c.negativeHits, 1)
+			return nil, nil
+		}
+
+		var response model.UserResponse
+		if err := json.Unmarshal(cached, &response); err == nil {
+			atomic.AddUint64(&c.hits, 1)
+			return &response, nil
+		}
+		c.cacheError(err)
+	}
+
+	atomic.AddUint64(&c.misses, 1)
+
+	response, err := c.findResponse(nickname)
+
+	if err != nil {
+		return response, err
+	}
+
+	if response == nil {
+		if c.NegativeTTL > 0 {
+			c.add(nickname, []byte{}, c.NegativeTTL)
+		}
+		return response, err
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		c.cacheError(err)
+		return response, nil
+	}
+	c.add(nickname, encoded, c.TTL)
+
+	return response, nil
+}
+
+// FindByNickname reads the repository. The user returned carries its password hash and is the base of writes, so it
+// is never cached.
+func (c *CachedRepository) FindByNickname(nickname string) (*model.User, error) {
+	return c.Repository.FindByNickname(nickname)
+}
+
+func (c *CachedRepository) FindById(id primitive.ObjectID) (*model.User, error) {
+	return c.Repository.FindById(id)
+}
+
+func (c *CachedRepository) Save(user *model.User) (*model.User, error) {
+	saved, err := c.Repository.Save(user)
+	c.invalidate(user.Nickname)
+	return saved, err
+}
+
+func (c *CachedRepository) SaveMany(users []*model.User) ([]error, error) {
+	errs, err := c.Repository.SaveMany(users)
+	nicknames := make([]string, 0, len(users))
+	for _, user := range users {
+		nicknames = append(nicknames, user.Nickname)
+	}
+	c.invalidate(nicknames...)
+	return errs, err
+}
+
+func (c *CachedRepository) FindNicknames(nicknames []string) ([]string, error) {
+	return c.Repository.FindNicknames(nicknames)
+}
+
+func (c *CachedRepository) UpdateByNickname(nickname string, user *model.User) (int64, error) {
+	updated, err := c.Repository.UpdateByNickname(nickname, user)
+	c.invalidate(nickname, user.Nickname)
+	return updated, err
+}
+
+func (c *CachedRepository) FindAndUpdateByNickname(nickname string, user *model.User) (*model.User, error) {
+	before, err := c.Repository.FindAndUpdateByNickname(nickname, user)
+	c.invalidate(nickname, user.Nickname)
+	return before, err
+}
+
+func (c *CachedRepository) FindAndUpdateById(id primitive.ObjectID, user *model.User) (*model.User, error) {
+	before, err := c.Repository.FindAndUpdateById(id, user)
+	c.invalidate(user.Nickname, nicknameOf(before))
+	return before, err
+}
+
+func (c *CachedRepository) DeleteById(id primitive.ObjectID) (*model.User, error) {
+	before, err := c.Repository.DeleteById(id)
+	c.invalidate(nicknameOf(before))
+	return before, err
+}
+
+func (c *CachedRepository) Delete(nickname string) (int64, error) {
+	deleted, err := c.Repository.Delete(nickname)
+	c.invalidate(nickname)
+	return deleted, err
+}
+
+func (c *CachedRepository) Restore(nickname string) (*model.User, error) {
+	restored, err := c.Repository.Restore(nickname)
+	c.invalidate(nickname)
+	return restored, err
+}
+
+func (c *CachedRepository) Purge(deletedBefore time.Time) (int64, error) {
+	return c.Repository.Purge(deletedBefore)
+}
+
+func (c *CachedRepository) FindAll() ([]*model.User, error) {
+	return c.Repository.FindAll()
+}
+
+func (c *CachedRepository) FindAllByFilter(filter *model.Filter) ([]model.User, error) {
+	return c.Repository.FindAllByFilter(filter)
+}
+
+func (c *CachedRepository) Stream(filter *model.Filter, fn func(user *model.User) error) error {
+	return c.Repository.Stream(filter, fn)
+}
+
+// InTransaction runs fn on the transaction without the cache, which could otherwise keep users that are never
+// committed. The nicknames written are dropped from the cache once the transaction is over.
+func (c *CachedRepository) InTransaction(fn func(tx UserRepository) error) error {
+	var written []string
+
+	err := c.Repository.InTransaction(func(tx UserRepository) error {
+		w := &transactionWrites{UserRepository: tx}
+		defer func() { written = append(written, w.nicknames...) }()
+		return fn(w)
+	})
+
+	c.invalidate(written...)
+	return err
+}
+
+func (c *CachedRepository) Count(filter *model.Filter) (int64, error) {
+	return c.Repository.Count(filter)
+}
+
+func (c *CachedRepository) Search(search *model.UserSearch) ([]model.UserHit, int64, error) {
+	return c.Repository.Search(search)
+}
+
+func (c *CachedRepository) Stats() model.CacheStats {
+	stats := model.CacheStats{
+		Hits:         atomic.LoadUint64(&c.hits),
+		Misses:       atomic.LoadUint64(&c.misses),
+		NegativeHits: atomic.LoadUint64(&c.negativeHits),
+		Errors:       atomic.LoadUint64(&c.errors),
+	}
+
+	if total := stats.Hits + stats.NegativeHits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits+stats.NegativeHits) / float64(total)
+	}
+	return stats
+}
+
+// findResponse reads the user from the repository, without its password.
+func (c *CachedRepository) findResponse(nickname string) (*model.UserResponse, error) {
+	user, err := c.Repository.FindByNickname(nickname)
+
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	response := model.NewUserResponse(user)
+	return &response, nil
+}
+
+// add caches the value read from the repository when the key is absent. A nickname invalidated by a write since the
+// read keeps its mark, and a write after the add replaces the value with the mark.
+func (c *CachedRepository) add(nickname string, value []byte, ttl time.Duration) {
+	if _, err := c.Cache.Add(nicknameKeyPrefix+nickname, value, ttl); err != nil {
+		c.cacheError(err)
+	}
+}
+
+func (c *CachedRepository) invalidate(nicknames ...string) {
+	for _, n := range nicknames {
+		if n == "" {
+			continue
+		}
+		if err := c.Cache.Set(nicknameKeyPrefix+n, invalidated, invalidationTTL); err != nil {
+			c.cacheError(err)
+		}
+	}
+}
+
+// nicknameOf is the nickname of the user, empty for nil ones.
+func nicknameOf(user *model.User) string {
+	if user == nil {
+		return ""
+	}
+	return user.Nickname
+}
+
+func (c *CachedRepository) cacheError(err error) {
+	atomic.AddUint64(&c.errors, 1)
+	log.Print("Error on user cache ", err)
+}
+
+// transactionWrites records the nicknames written through a repository.
+type transactionWrites struct {
+	UserRepository
+	nicknames []string
+}
+
+func (t *transactionWrites) Save(user *model.User) (*model.User, error) {
+	t.nicknames = append(t.nicknames, user.Nickname)
+	return t.UserRepository.Save(user)
+}
+
+func (t *transactionWrites) SaveMany(users []*model.User) ([]error, error) {
+	for _, user := range users {
+		t.nicknames = append(t.nicknames, user.Nickname)
+	}
+	return t.UserRepository.SaveMany(users)
+}
+
+func (t *transactionWrites) UpdateByNickname(nickname string, user *model.User) (int64, error) {
+	t.nicknames = append(t.nicknames, nickname, user.Nickname)
+	return t.UserRepository.UpdateByNickname(nickname, user)
+}
+
+func (t *transactionWrites) FindAndUpdateByNickname(nickname string, user *model.User) (*model.User, error) {
+	t.nicknames = append(t.nicknames, nickname, user.Nickname)
+	return t.UserRepository.FindAndUpdateByNickname(nickname, user)
+}
+
+func (t *transactionWrites) FindAndUpdateById(id primitive.ObjectID, user *model.User) (*model.User, error) {
+	before, err := t.UserRepository.FindAndUpdateById(id, user)
+	t.nicknames = append(t.nicknames, user.Nickname, nicknameOf(before))
+	return before, err
+}
+
+func (t *transactionWrites) DeleteById(id primitive.ObjectID) (*model.User, error) {
+	before, err := t.UserRepository.DeleteById(id)
+	t.nicknames = append(t.nicknames, nicknameOf(before))
+	return before, err
+}
+
+func (t *transactionWrites) Delete(nickname string) (int64, error) {
+	t.nicknames = append(t.nicknames, nickname)
+	return t.UserRepository.Delete(nickname)
+}
+
+func (t *transactionWrites) Restore(nickname string) (*model.User, error) {
+	t.nicknames = append(t.nicknames, nickname)
+	return t.UserRepository.Restore(nickname)
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"github.com/bernardoms/user-api/config"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+)
+
+// GridFS keeps the files of the jobs in mongo, so that any instance of the service can read them.
+type GridFS struct {
+	Bucket *gridfs.Bucket
+}
+
+func GetJobFiles(mongoConfig *config.MongoConfig) *GridFS {
+	b, _ := gridfs.NewBucket(session.Database(mongoConfig.Database), options.GridFSBucket().SetName("job_files"))
+	return &GridFS{Bucket: b}
+}
+
+// Create starts a new file, which is only stored once the writer is closed.
+func (g *GridFS) Create(name string) (string, io.WriteCloser, error) {
+	s, err := g.Bucket.OpenUploadStream(name)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s.FileID.(primitive.ObjectID).Hex(), s, nil
+}
+
+func (g *GridFS) Open(id string) (io.ReadCloser, error) {
+	fileId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return g.Bucket.OpenDownloadStream(fileId)
+}
+
+func (g *GridFS) Delete(id string) error {
+	fileId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return err
+	}
+
+	return g.Bucket.Delete(fileId)
+}
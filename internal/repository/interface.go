@@ -2,13 +2,53 @@ package repository
 
 import (
 	"github.com/bernardoms/user-api/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"time"
 )
 
 type UserRepository interface {
 	UpdateByNickname(nickname string, user *model.User) (int64, error)
+	FindAndUpdateByNickname(nickname string, user *model.User) (*model.User, error)
+	FindAndUpdateById(id primitive.ObjectID, user *model.User) (*model.User, error)
 	Save(user *model.User) (*model.User, error)
+	SaveMany(users []*model.User) ([]error, error)
+	FindNicknames(nicknames []string) ([]string, error)
 	FindByNickname(nickname string) (*model.User, error)
+	FindById(id primitive.ObjectID) (*model.User, error)
 	FindAll() ([]*model.User, error)
 	FindAllByFilter(filter *model.Filter) ([]model.User, error)
-	Delete(nickname string) error
+	Stream(filter *model.Filter, fn func(user *model.User) error) error
+	Count(filter *model.Filter) (int64, error)
+	Search(search *model.UserSearch) ([]model.UserHit, int64, error)
+	Delete(nickname string) (int64, error)
+	DeleteById(id primitive.ObjectID) (*model.User, error)
+	Restore(nickname string) (*model.User, error)
+	Purge(deletedBefore time.Time) (int64, error)
+	InTransaction(fn func(tx UserRepository) error) error
+}
+
+type JobRepository interface {
+	Create(job *model.Job) error
+	FindById(id primitive.ObjectID) (*model.Job, error)
+	Claim(owner string, leaseUntil time.Time) (*model.Job, error)
+	Heartbeat(job *model.Job, leaseUntil time.Time) (*model.Job, error)
+	Finish(job *model.Job) error
+	Release(job *model.Job) error
+	Cancel(id primitive.ObjectID) (*model.Job, error)
+}
+
+// FileStore keeps the files read and written by jobs.
+type FileStore interface {
+	Create(name string) (string, io.WriteCloser, error)
+	Open(id string) (io.ReadCloser, error)
+	Delete(id string) error
+}
+
+type AuditRepository interface {
+	Record(entry *model.AuditEntry) error
+	FindByUser(userId primitive.ObjectID, nickname string, page int, size int) ([]model.AuditEntry, int64, error)
+	Count(filter *model.AuditFilter) (int64, error)
+	Stream(filter *model.AuditFilter, fn func(entry *model.AuditEntry) error) error
+	FindAfter(entry *model.AuditEntry) ([]model.AuditEntry, error)
 }
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+	"time"
+)
+
+// MongoJobs keeps the background jobs. Workers claim them with an atomic update, so a job only runs once at a time
+// even with several instances of the service.
+type MongoJobs struct {
+	Collection *mongo.Collection
+}
+
+func GetJobCollection(mongoConfig *config.MongoConfig) *mongo.Collection {
+	c := session.Database(mongoConfig.Database).Collection("jobs")
+	_, _ = c.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bsonx.Doc{{Key: "status", Value: bsonx.Int32(1)}, {Key: "createdAt", Value: bsonx.Int32(1)}},
+	})
+	return c
+}
+
+func (m MongoJobs) Create(job *model.Job) error {
+	if job.Id.IsZero() {
+		job.Id = primitive.NewObjectID()
+	}
+	if job.CreatedAt == nil {
+		now := now()
+		job.CreatedAt = &now
+	}
+
+	_, err := m.Collection.InsertOne(context.TODO(), job)
+
+	return err
+}
+
+func (m MongoJobs) FindById(id primitive.ObjectID) (*model.Job, error) {
+	var result *model.Job
+
+	err := m.Collection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&result)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	return result, err
+}
+
+// Claim leases to the owner the oldest queued job, or a running one whose worker stopped renewing its lease, and
+// returns it, or nil when there is none.
+func (m MongoJobs) Claim(owner string, leaseUntil time.Time) (*model.Job, error) {
+	var result *model.Job
+
+	now := now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": model.JobQueued},
+		bson.M{"status": model.JobRunning, "leaseUntil": bson.M{"$lt": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{"status": model.JobRunning, "owner": owner, "leaseUntil": leaseUntil},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"createdAt": 1}).
+		SetReturnDocument(options.After)
+
+	err := m.Collection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&result)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	if err == nil && result.StartedAt == nil {
+		result.StartedAt = &now
+		_, err = m.Collection.UpdateOne(context.TODO(), bson.M{"_id": result.Id}, bson.M{"$set": bson.M{"startedAt": now}})
+	}
+
+	return result, err
+}
+
+// Heartbeat saves the progress of a job and extends the lease of its owner. It returns the job as stored, to see
+// whether it was cancelled, or nil when the owner lost its lease.
+func (m MongoJobs) Heartbeat(job *model.Job, leaseUntil time.Time) (*model.Job, error) {
+	var result *model.Job
+
+	filter := bson.M{"_id": job.Id, "owner": job.Owner, "status": model.JobRunning}
+	update := bson.M{"$set": bson.M{
+		"leaseUntil": leaseUntil,
+		"progress":   job.Progress,
+		"errors":     job.Errors,
+		"checkpoint": job.Checkpoint,
+		"result":     job.Result,
+		"resultType": job.ResultType,
+	}}
+
+	err := m.Collection.FindOneAndUpdate(context.TODO(), filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&result)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	return result, err
+}
+
+// Finish stores the outcome of a job and releases it, when its owner still holds it.
+func (m MongoJobs) Finish(job *model.Job) error {
+	now := now()
+	job.FinishedAt = &now
+
+	filter := bson.M{"_id": job.Id, "owner": job.Owner}
+	update := bson.M{
+		"$set": bson.M{
+			"status":     job.Status,
+			"progress":   job.Progress,
+			"errors":     job.Errors,
+			"error":      job.Error,
+			"checkpoint": job.Checkpoint,
+			"result":     job.Result,
+			"resultType": job.ResultType,
+			"finishedAt": now,
+		},
+		"$unset": bson.M{"owner": "", "leaseUntil": ""},
+	}
+
+	_, err := m.Collection.UpdateOne(context.TODO(), filter, update)
+
+	return err
+}
+
+// Release gives a running job back, to be resumed by any worker, as when the service stops. The attempt it was
+// claimed for isn't counted.
+func (m MongoJobs) Release(job *model.Job) error {
+	filter := bson.M{"_id": job.Id, "owner": job.Owner, "status": model.JobRunning}
+	update := bson.M{
+		"$set":   bson.M{"status": model.JobQueued, "progress": job.Progress, "errors": job.Errors, "checkpoint": job.Checkpoint},
+		"$unset": bson.M{"owner": "", "leaseUntil": ""},
+		"$inc":   bson.M{"attempts": -1},
+	}
+
+	_, err := m.Collection.UpdateOne(context.TODO(), filter, update)
+
+	return err
+}
+
+// Cancel cancels a queued job right away and asks the worker of a running one to stop it. It returns the job as
+// stored afterwards, or nil when there is none.
+func (m MongoJobs) Cancel(id primitive.ObjectID) (*model.Job, error) {
+	now := now()
+
+	_, err := m.Collection.UpdateOne(context.TODO(), bson.M{"_id": id, "status": model.JobQueued},
+		bson.M{"$set": bson.M{"status": model.JobCancelled, "finishedAt": now}})
+
+	if err == nil {
+		_, err = m.Collection.UpdateOne(context.TODO(), bson.M{"_id": id, "status": model.JobRunning},
+			bson.M{"$set": bson.M{"cancelRequested": true}})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.FindById(id)
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"github.com/bernardoms/user-api/internal/query"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+)
+
+// MongoQuery translates a filter condition to a mongo query. The fields of an user keep their json names in mongo.
+func MongoQuery(node query.Node) bson.M {
+	switch n := node.(type) {
+	case query.And:
+		return bson.M{"$and": mongoQueries(n.Nodes)}
+	case query.Or:
+		return bson.M{"$or": mongoQueries(n.Nodes)}
+	case query.Not:
+		return bson.M{"$nor": bson.A{MongoQuery(n.Node)}}
+	case query.In:
+		if len(n.Values) == 1 {
+			return bson.M{n.Field: n.Values[0]}
+		}
+		return bson.M{n.Field: bson.M{"$in": n.Values}}
+	case query.Prefix:
+		return bson.M{n.Field: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(n.Value)}}
+	case query.Range:
+		return bson.M{n.Field: timeRange(n.From, n.To)}
+	}
+	return bson.M{}
+}
+
+func mongoQueries(nodes []query.Node) bson.A {
+	a := bson.A{}
+	for _, n := range nodes {
+		a = append(a, MongoQuery(n))
+	}
+	return a
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"github.com/bernardoms/user-api/config"
 	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/search"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/x/bsonx"
 	"log"
+	"time"
 )
 
 type SessionCreator struct {
@@ -17,10 +20,18 @@ type SessionCreator struct {
 
 type Mongo struct {
 	Collection *mongo.Collection
+	// ctx is the session of the transaction the repository is bound to, if any
+	ctx context.Context
 }
 
 var session *mongo.Client
 
+// streamBatchSize is how many users a streaming cursor fetches at a time.
+const streamBatchSize = 500
+
+// notDeleted matches the users without a deletedAt tombstone.
+var notDeleted = bson.M{"$exists": false}
+
 func New(config *config.MongoConfig) {
 
 	client, err := mongo.NewClient(options.Client().ApplyURI(config.MongoURI))
@@ -46,25 +57,81 @@ func New(config *config.MongoConfig) {
 
 func GetUserCollection(mongoConfig *config.MongoConfig) *mongo.Collection {
 	c := session.Database(mongoConfig.Database).Collection("users")
-	_, _ = c.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
-		{
-			Keys: bsonx.Doc{{Key: "nickname", Value: bsonx.String("text")}},
-		},
-		{
-			Keys: bsonx.Doc{{Key: "email", Value: bsonx.String("text")}},
+	// a collection has at most one text index, the older one on the nickname alone is replaced
+	_, _ = c.Indexes().DropOne(context.Background(), "nickname_text")
+	_, _ = c.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bsonx.Doc{
+			{Key: "nickname", Value: bsonx.String("text")},
+			{Key: "email", Value: bsonx.String("text")},
+			{Key: "firstName", Value: bsonx.String("text")},
+			{Key: "lastName", Value: bsonx.String("text")},
 		},
+		Options: options.Index().
+			SetName("user_text").
+			SetWeights(bson.M{"nickname": 10, "firstName": 5, "lastName": 5, "email": 1}).
+			SetDefaultLanguage("none"),
 	})
 	return c
 }
 
+// NicknameIndex is the name of the unique index on the nicknames of the active users.
+const NicknameIndex = "nickname_unique"
+
+// EnsureNicknameIndex makes the nicknames of the users that aren't deleted unique, so that concurrent creations,
+// imports, renames and restores can't take the same nickname, the loser failing with a duplicate key error. Partial
+// indexes can't select documents without a field, so the index is on the users marked active, the users stored
+// before the mark being marked first.
+func EnsureNicknameIndex(c *mongo.Collection) error {
+	unmarked := bson.M{"active": bson.M{"$exists": false}, "deletedAt": notDeleted}
+
+	if _, err := c.UpdateMany(context.Background(), unmarked, bson.M{"$set": bson.M{"active": true}}); err != nil {
+		return err
+	}
+
+	_, err := c.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bsonx.Doc{{Key: "nickname", Value: bsonx.Int32(1)}},
+		Options: options.Index().
+			SetName(NicknameIndex).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"active": true}),
+	})
+	return err
+}
+
+func (m Mongo) context() context.Context {
+	if m.ctx == nil {
+		return context.TODO()
+	}
+	return m.ctx
+}
+
+// InTransaction runs fn in a transaction, with a repository whose reads and writes are part of it. The transaction
+// is committed when fn returns nil and aborted otherwise. fn may be run again when the transaction has to be retried.
+// Transactions need mongo to run as a replica set.
+func (m Mongo) InTransaction(fn func(tx UserRepository) error) error {
+	s, err := m.Collection.Database().Client().StartSession()
+
+	if err != nil {
+		return err
+	}
+
+	defer s.EndSession(context.TODO())
+
+	_, err = s.WithTransaction(context.TODO(), func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(Mongo{Collection: m.Collection, ctx: sc})
+	})
+
+	return err
+}
+
 func (m Mongo) FindAll() ([]*model.User, error) {
 	var results []*model.User
 
-	cur, err := m.Collection.Find(context.TODO(), bson.D{})
+	cur, err := m.Collection.Find(m.context(), bson.M{"deletedAt": notDeleted})
 
 	if err == nil {
 
-		for cur.Next(context.TODO()) {
+		for cur.Next(m.context()) {
 			var elem model.User
 			err := cur.Decode(&elem)
 			if err != nil {
@@ -77,39 +144,169 @@ func (m Mongo) FindAll() ([]*model.User, error) {
 }
 
 func (m Mongo) Save(user *model.User) (*model.User, error) {
+	now := now()
+	user.CreatedAt = &now
+	user.UpdatedAt = &now
+	user.Active = true
 
-	_, err := m.Collection.InsertOne(context.TODO(), &user)
+	_, err := m.Collection.InsertOne(m.context(), &user)
 
 	return user, err
 }
 
+// SaveMany inserts the users in a single unordered batch, so that a failing user doesn't stop the others. It returns
+// the error of each user, nil for the inserted ones, and an error when the whole batch failed.
+func (m Mongo) SaveMany(users []*model.User) ([]error, error) {
+	now := now()
+	docs := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		user.CreatedAt = &now
+		user.UpdatedAt = &now
+		user.Active = true
+		docs = append(docs, user)
+	}
+
+	errs := make([]error, len(users))
+
+	_, err := m.Collection.InsertMany(m.context(), docs, options.InsertMany().SetOrdered(false))
+
+	if bwe, ok := err.(mongo.BulkWriteException); ok && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			errs[we.Index] = we
+		}
+		return errs, nil
+	}
+
+	return errs, err
+}
+
+// FindNicknames returns which of the nicknames are taken by users not deleted.
+func (m Mongo) FindNicknames(nicknames []string) ([]string, error) {
+	taken := make([]string, 0)
+
+	if len(nicknames) == 0 {
+		return taken, nil
+	}
+
+	filter := bson.M{"nickname": bson.M{"$in": nicknames}, "deletedAt": notDeleted}
+
+	cur, err := m.Collection.Find(m.context(), filter, options.Find().SetProjection(bson.M{"_id": 0, "nickname": 1}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(m.context())
+
+	for cur.Next(m.context()) {
+		var elem model.User
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		taken = append(taken, elem.Nickname)
+	}
+	return taken, cur.Err()
+}
+
+// IsDuplicateKey tells whether a write failed on an unique index.
+func IsDuplicateKey(err error) bool {
+	switch e := err.(type) {
+	case mongo.BulkWriteError:
+		return e.Code == 11000
+	case mongo.WriteError:
+		return e.Code == 11000
+	case mongo.CommandError:
+		return e.Code == 11000
+	}
+	return false
+}
+
 func (m Mongo) FindByNickname(nickname string) (*model.User, error) {
 	var result *model.User
 
-	cur, err := m.Collection.Find(context.TODO(), bson.M{"nickname": nickname})
+	cur, err := m.Collection.Find(m.context(), bson.M{"nickname": nickname, "deletedAt": notDeleted})
 
 	if err != nil {
 		return &model.User{}, err
 	}
 
-	if cur.Next(context.TODO()) != false {
+	if cur.Next(m.context()) != false {
 		err = cur.Decode(&result)
 	}
 
 	return result, err
 }
 
+func (m Mongo) FindById(id primitive.ObjectID) (*model.User, error) {
+	var result *model.User
+
+	err := m.Collection.FindOne(m.context(), bson.M{"_id": id, "deletedAt": notDeleted}).Decode(&result)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	return result, err
+}
+
 func (m Mongo) UpdateByNickname(nickname string, user *model.User) (int64, error) {
-	filter := bson.M{"nickname": nickname}
+	filter := bson.M{"nickname": nickname, "deletedAt": notDeleted}
+
+	r, err := m.Collection.UpdateOne(m.context(), filter, userUpdate(user))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return r.MatchedCount, err
+}
+
+// FindAndUpdateByNickname updates the user as UpdateByNickname does, returning it as it was right before the update,
+// in the same operation, or nil when there is no user with the nickname.
+func (m Mongo) FindAndUpdateByNickname(nickname string, user *model.User) (*model.User, error) {
+	return m.findAndUpdate(bson.M{"nickname": nickname, "deletedAt": notDeleted}, user)
+}
+
+// FindAndUpdateById updates the user with the id as FindAndUpdateByNickname does, whatever its nickname is.
+func (m Mongo) FindAndUpdateById(id primitive.ObjectID, user *model.User) (*model.User, error) {
+	return m.findAndUpdate(bson.M{"_id": id, "deletedAt": notDeleted}, user)
+}
+
+func (m Mongo) findAndUpdate(filter bson.M, user *model.User) (*model.User, error) {
+	var before *model.User
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	err := m.Collection.FindOneAndUpdate(m.context(), filter, userUpdate(user), opts).Decode(&before)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	return before, err
+}
+
+// userUpdate sets the fields of the user that can be updated, and when it was.
+func userUpdate(user *model.User) bson.M {
+	now := now()
+	user.UpdatedAt = &now
 
-	update := bson.M{"$set": bson.M{"nickname": user.Nickname,
+	return bson.M{"$set": bson.M{"nickname": user.Nickname,
 		"country":   user.Country,
-		"firstname": user.FirstName,
-		"lastname":  user.LastName,
+		"firstName": user.FirstName,
+		"lastName":  user.LastName,
 		"password":  user.Password,
-		"email":     user.Email}}
+		"email":     user.Email,
+		"updatedAt": now,
+		"updatedBy": user.UpdatedBy}}
+}
+
+// Delete only sets the deletedAt tombstone, hiding the user until it is restored or purged. It returns how many
+// users were deleted, 0 when there was no user with the nickname.
+func (m Mongo) Delete(nickname string) (int64, error) {
+	filter := bson.M{"nickname": nickname, "deletedAt": notDeleted}
 
-	r, err := m.Collection.UpdateOne(context.TODO(), filter, update)
+	r, err := m.Collection.UpdateOne(m.context(), filter, deletion())
 
 	if err != nil {
 		return 0, err
@@ -118,25 +315,76 @@ func (m Mongo) UpdateByNickname(nickname string, user *model.User) (int64, error
 	return r.MatchedCount, err
 }
 
-func (m Mongo) Delete(nickname string) error {
-	filter := bson.M{"nickname": nickname}
+// deletion sets the tombstone of an user, which is no longer active.
+func deletion() bson.M {
+	return bson.M{"$set": bson.M{"deletedAt": now()}, "$unset": bson.M{"active": ""}}
+}
+
+// DeleteById deletes the user with the id as Delete does, returning it as it was, or nil when there is none.
+func (m Mongo) DeleteById(id primitive.ObjectID) (*model.User, error) {
+	var before *model.User
+
+	filter := bson.M{"_id": id, "deletedAt": notDeleted}
+
+	err := m.Collection.FindOneAndUpdate(m.context(), filter, deletion()).Decode(&before)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	return before, err
+}
+
+// Restore removes the tombstone of the most recently deleted user with the nickname, returning it, or nil when
+// there is none. It fails with a duplicate key error when an active user took the nickname.
+func (m Mongo) Restore(nickname string) (*model.User, error) {
+	var result *model.User
+
+	filter := bson.M{"nickname": nickname, "deletedAt": bson.M{"$exists": true}}
+	update := bson.M{"$unset": bson.M{"deletedAt": ""}, "$set": bson.M{"updatedAt": now(), "active": true}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"deletedAt": -1}).
+		SetReturnDocument(options.After)
+
+	err := m.Collection.FindOneAndUpdate(m.context(), filter, update, opts).Decode(&result)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
 
-	_, err := m.Collection.DeleteOne(context.TODO(), filter)
+	return result, err
+}
 
+// Purge hard-deletes the users deleted before the given time.
+func (m Mongo) Purge(deletedBefore time.Time) (int64, error) {
+	r, err := m.Collection.DeleteMany(m.context(), bson.M{"deletedAt": bson.M{"$lt": deletedBefore}})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return r.DeletedCount, err
+}
+
+// EnsurePurgeIndex lets mongo itself remove deleted users once retention has passed since their deletion.
+func EnsurePurgeIndex(c *mongo.Collection, retention time.Duration) error {
+	_, err := c.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bsonx.Doc{{Key: "deletedAt", Value: bsonx.Int32(1)}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retention / time.Second)).SetName("deletedAt_ttl"),
+	})
 	return err
 }
 
 func (m Mongo) FindAllByFilter(userFilter *model.Filter) ([]model.User, error) {
-	filter := bson.M{}
 	var results = make([]model.User, 0)
 
-	filter = mountFilter(filter, userFilter)
+	filter, opts := findAllQuery(userFilter)
 
-	cur, err := m.Collection.Find(context.TODO(), filter)
+	cur, err := m.Collection.Find(m.context(), filter, opts)
 
 	if err == nil {
 
-		for cur.Next(context.TODO()) {
+		for cur.Next(m.context()) {
 			var elem model.User
 			err := cur.Decode(&elem)
 			if err != nil {
@@ -150,20 +398,149 @@ func (m Mongo) FindAllByFilter(userFilter *model.Filter) ([]model.User, error) {
 
 }
 
-func mountFilter(filter bson.M, userFilter *model.Filter) bson.M {
+// Stream calls fn with each user matching the filter, in id order, reading them one at a time from a cursor so the
+// users are never all in memory. It stops at the first error, of mongo or fn.
+func (m Mongo) Stream(userFilter *model.Filter, fn func(user *model.User) error) error {
+	filter, opts := findAllQuery(userFilter)
+
+	cur, err := m.Collection.Find(m.context(), filter, opts.SetSort(bson.M{"_id": 1}).SetBatchSize(streamBatchSize))
+
+	if err != nil {
+		return err
+	}
+
+	defer cur.Close(m.context())
+
+	for cur.Next(m.context()) {
+		var elem model.User
+		if err := cur.Decode(&elem); err != nil {
+			return err
+		}
+		elem.Password = ""
+		if err := fn(&elem); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// Count returns how many users match the filter.
+func (m Mongo) Count(userFilter *model.Filter) (int64, error) {
+	filter, _ := findAllQuery(userFilter)
+
+	return m.Collection.CountDocuments(m.context(), filter)
+}
+
+func findAllQuery(userFilter *model.Filter) (bson.M, *options.FindOptions) {
+	filter := mountFilter(bson.M{}, userFilter)
+
+	if userFilter == nil || !userFilter.IncludeDeleted {
+		filter["deletedAt"] = notDeleted
+	}
+
+	var fields []string
 	if userFilter != nil {
-		if userFilter.Nickname != "" {
-			filter["nickname"] = userFilter.Nickname
+		fields = userFilter.Fields
+	}
+
+	return filter, options.Find().SetProjection(projection(fields))
+}
+
+// Search returns a page of the users matching the search. Text searches use the text index, most relevant first,
+// while prefix and substring searches match the nickname and names regardless of case and diacritics.
+func (m Mongo) Search(userSearch *model.UserSearch) ([]model.UserHit, int64, error) {
+	filter := bson.M{"deletedAt": notDeleted}
+
+	opts := options.Find().
+		SetSkip(int64(userSearch.Page-1) * int64(userSearch.Size)).
+		SetLimit(int64(userSearch.Size))
+
+	if userSearch.Mode == model.SearchText {
+		filter["$text"] = bson.M{"$search": userSearch.Query, "$caseSensitive": false, "$diacriticSensitive": false}
+		score := bson.M{"$meta": "textScore"}
+		opts.SetProjection(bson.M{"password": 0, "score": score}).
+			SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}})
+	} else {
+		match := search.Anywhere
+		if userSearch.Mode == model.SearchPrefix {
+			match = search.FieldStart
+		}
+		re := primitive.Regex{Pattern: search.Pattern(userSearch.Query, match), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"nickname": re}, bson.M{"firstName": re}, bson.M{"lastName": re}}
+		opts.SetProjection(bson.M{"password": 0}).
+			SetSort(bson.D{{Key: "nickname", Value: 1}, {Key: "_id", Value: 1}})
+	}
+
+	total, err := m.Collection.CountDocuments(m.context(), filter)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cur, err := m.Collection.Find(m.context(), filter, opts)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := make([]model.UserHit, 0)
+	err = cur.All(m.context(), &results)
+
+	return results, total, err
+}
+
+// projection reads only the given fields of the users, or all of them when there are none. The password is never
+// read, whatever the fields asked for.
+func projection(fields []string) bson.M {
+	if len(fields) == 0 {
+		return bson.M{"password": 0}
+	}
+
+	p := bson.M{"_id": 0}
+	for _, f := range fields {
+		switch f {
+		case "password":
+		case "id":
+			p["_id"] = 1
+		default:
+			p[f] = 1
 		}
-		if userFilter.LastName != "" {
-			filter["lastName"] = userFilter.LastName
+	}
+	return p
+}
+
+func mountFilter(filter bson.M, userFilter *model.Filter) bson.M {
+	if userFilter != nil {
+		if userFilter.Query != nil {
+			filter["$and"] = bson.A{MongoQuery(userFilter.Query)}
 		}
-		if userFilter.Email != "" {
-			filter["email"] = userFilter.Email
+		if r := timeRange(userFilter.CreatedSince, userFilter.CreatedBefore); r != nil {
+			filter["createdAt"] = r
 		}
-		if userFilter.Country != "" {
-			filter["country"] = userFilter.Country
+		if r := timeRange(userFilter.UpdatedSince, userFilter.UpdatedBefore); r != nil {
+			filter["updatedAt"] = r
 		}
 	}
 	return filter
 }
+
+// timeRange matches times at or after since and strictly before before, either bound being optional.
+func timeRange(since *time.Time, before *time.Time) bson.M {
+	if since == nil && before == nil {
+		return nil
+	}
+
+	r := bson.M{}
+	if since != nil {
+		r["$gte"] = *since
+	}
+	if before != nil {
+		r["$lt"] = *before
+	}
+	return r
+}
+
+// now is truncated to milliseconds, the precision mongo keeps, so a saved user equals the one read back.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
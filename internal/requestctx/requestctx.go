@@ -0,0 +1,36 @@
+package requestctx
+
+import "context"
+
+const Anonymous = "anonymous"
+
+type key int
+
+const (
+	principalKey key = iota
+	requestIDKey
+)
+
+// WithPrincipal returns a copy of the context carrying who is acting in the request.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// Principal returns who is acting in the request, or Anonymous when nobody was set.
+func Principal(ctx context.Context) string {
+	if p, ok := ctx.Value(principalKey).(string); ok && p != "" {
+		return p
+	}
+	return Anonymous
+}
+
+// WithRequestID returns a copy of the context carrying the id correlating everything done for one request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the id of the request, or an empty string outside of one.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
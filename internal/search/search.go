@@ -0,0 +1,149 @@
+// Package search folds case and diacritics out of user names, so that "jose" finds "José", and builds the mongo
+// patterns and highlights of the user search.
+package search
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Match tells where a term must start to match a value.
+type Match int
+
+const (
+	// Anywhere matches a term inside a value.
+	Anywhere Match = iota
+	// WordStart matches a term at the start of any word of a value.
+	WordStart
+	// FieldStart matches a term at the start of a value.
+	FieldStart
+)
+
+// MaxQueryLength is the longest search, in characters. Each letter of it is a class of the mongo pattern.
+const MaxQueryLength = 200
+
+const (
+	highlightOpen  = "<em>"
+	highlightClose = "</em>"
+)
+
+// variants holds, for each folded letter, the accented letters folding to it.
+var variants = foldVariants()
+
+// Fold lowercases a string and strips the diacritics of its letters. Every rune folds to exactly one rune, so
+// positions in the folded string are positions in the original.
+func Fold(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = foldRune(r)
+	}
+	return string(runes)
+}
+
+// Pattern returns a case-insensitive regular expression, for mongo, matching the term regardless of diacritics.
+func Pattern(term string, match Match) string {
+	var b strings.Builder
+
+	if match == FieldStart {
+		b.WriteString("^")
+	}
+
+	for _, r := range Fold(term) {
+		v, ok := variants[r]
+		if !ok {
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			continue
+		}
+		b.WriteString("[")
+		b.WriteRune(r)
+		b.WriteString(string(v))
+		b.WriteString("]")
+	}
+
+	return b.String()
+}
+
+// Highlight wraps the parts of the value matching any of the terms in <em> tags, folding case and diacritics. The
+// value is HTML escaped, so the tags are the only markup of the highlight. It returns an empty string when nothing
+// matches.
+func Highlight(value string, terms []string, match Match) string {
+	original := []rune(value)
+	folded := []rune(Fold(value))
+	marked := make([]bool, len(folded))
+	found := false
+
+	for _, term := range terms {
+		t := []rune(Fold(term))
+		if len(t) == 0 {
+			continue
+		}
+		for i := 0; i+len(t) <= len(folded); i++ {
+			if !startsAt(folded, i, match) || string(folded[i:i+len(t)]) != string(t) {
+				continue
+			}
+			for j := i; j < i+len(t); j++ {
+				marked[j] = true
+			}
+			found = true
+		}
+	}
+
+	if !found {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, r := range original {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(highlightOpen)
+		}
+		b.WriteString(html.EscapeString(string(r)))
+		if marked[i] && (i == len(original)-1 || !marked[i+1]) {
+			b.WriteString(highlightClose)
+		}
+	}
+	return b.String()
+}
+
+func startsAt(runes []rune, i int, match Match) bool {
+	switch match {
+	case FieldStart:
+		return i == 0
+	case WordStart:
+		return i == 0 || !isWordRune(runes[i-1])
+	default:
+		return true
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// foldRune keeps the base letter of the canonical decomposition of the rune, lowercased.
+func foldRune(r rune) rune {
+	if r < unicode.MaxASCII {
+		return unicode.ToLower(r)
+	}
+	for _, base := range norm.NFD.String(string(r)) {
+		return unicode.ToLower(base)
+	}
+	return r
+}
+
+// foldVariants groups the latin letters with diacritics by the letter they fold to.
+func foldVariants() map[rune][]rune {
+	v := map[rune][]rune{}
+	for r := rune(0xC0); r <= 0x24F; r++ {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if f := foldRune(r); f != unicode.ToLower(r) {
+			v[f] = append(v[f], r)
+		}
+	}
+	return v
+}
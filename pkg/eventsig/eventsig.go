@@ -0,0 +1,289 @@
+// Package eventsig signs the user events and lets their consumers verify them, with HMAC-SHA256 or Ed25519 keys.
+//
+// An event is signed over its canonical form: its context attributes but the content type and the signature,
+// sorted by name, one per line as "4:type=27:com.bernardoms.user.updated", the name and the value each prefixed by
+// its length in bytes, then an empty line and its data. The lengths keep the form unambiguous whatever the values
+// hold. Key ids only have letters, digits, '.', '_' and '-'. The signatures are sent in the signature
+// extension attribute, as "keyid=signature" pairs separated by commas, the signatures in unpadded base64 url
+// encoding. An event is signed with every key of the publisher, so keys are rotated by adding the new key, waiting
+// for the consumers to know it, then removing the old one.
+//
+// Consumers of structured events verify the body with VerifyStructured, and consumers of binary events the data
+// with the attributes, ce_ and ce- prefixes stripped, with Verify:
+//
+//	keys, err := eventsig.ParseKeys(os.Getenv("USER_EVENT_KEYS"))
+//	...
+//	if err := eventsig.VerifyStructured(body, keys); err != nil {
+//		// reject the event
+//	}
+//
+// The package only depends on the standard library.
+package eventsig
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	HMACSHA256 = "hmac-sha256"
+	Ed25519    = "ed25519"
+
+	// Attribute is the name of the extension attribute holding the signatures
+	Attribute = "signature"
+)
+
+var (
+	// ErrUnsigned is returned for events without signature
+	ErrUnsigned = errors.New("the event is not signed")
+	// ErrUnknownKey is returned when none of the signatures is by a known key
+	ErrUnknownKey = errors.New("the event is not signed by a known key")
+	// ErrInvalidSignature is returned when a signature by a known key doesn't match the event
+	ErrInvalidSignature = errors.New("the signature of the event is invalid")
+)
+
+// Key is a key signing or verifying events. HMAC keys do both, with their secret. Ed25519 keys sign with their
+// private key and verify with their public key, which is all the consumers need.
+type Key struct {
+	Id        string
+	Algorithm string
+
+	secret  []byte
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{Id: id, Algorithm: HMACSHA256, secret: secret}
+}
+
+func NewEd25519PrivateKey(id string, private ed25519.PrivateKey) Key {
+	return Key{Id: id, Algorithm: Ed25519, private: private, public: private.Public().(ed25519.PublicKey)}
+}
+
+func NewEd25519PublicKey(id string, public ed25519.PublicKey) Key {
+	return Key{Id: id, Algorithm: Ed25519, public: public}
+}
+
+// ParseKeys reads a comma separated list of keys, each as "id:algorithm:key" with the key in standard base64: the
+// secret of HMAC keys, of at least 32 bytes, and the 64 bytes private key or the 32 bytes public key of Ed25519 keys.
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+	ids := map[string]bool{}
+
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, errors.New("invalid signing key, it must be id:algorithm:base64 key")
+		}
+
+		id, algorithm := parts[0], parts[1]
+
+		if !validId(id) {
+			return nil, fmt.Errorf("invalid signing key id %s, it can only have letters, digits, '.', '_' and '-'", id)
+		}
+
+		if ids[id] {
+			return nil, fmt.Errorf("signing key %s is repeated", id)
+		}
+		ids[id] = true
+
+		raw, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s is not base64: %v", id, err)
+		}
+
+		switch {
+		case algorithm == HMACSHA256 && len(raw) >= 32:
+			keys = append(keys, NewHMACKey(id, raw))
+		case algorithm == HMACSHA256:
+			return nil, fmt.Errorf("signing key %s is too short, hmac-sha256 keys need at least 32 bytes", id)
+		case algorithm == Ed25519 && len(raw) == ed25519.PrivateKeySize:
+			keys = append(keys, NewEd25519PrivateKey(id, raw))
+		case algorithm == Ed25519 && len(raw) == ed25519.PublicKeySize:
+			keys = append(keys, NewEd25519PublicKey(id, raw))
+		case algorithm == Ed25519:
+			return nil, fmt.Errorf("signing key %s must be a 64 bytes ed25519 private key or a 32 bytes public key", id)
+		default:
+			return nil, fmt.Errorf("unknown algorithm %s of signing key %s, it must be %s or %s", algorithm, id, HMACSHA256, Ed25519)
+		}
+	}
+	return keys, nil
+}
+
+func validId(id string) bool {
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return id != ""
+}
+
+// CanSign tells whether the key can sign, verifying keys only having an Ed25519 public key.
+func (k Key) CanSign() bool {
+	return k.Algorithm == HMACSHA256 || k.private != nil
+}
+
+func (k Key) sign(canonical []byte) []byte {
+	if k.Algorithm == HMACSHA256 {
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(canonical)
+		return mac.Sum(nil)
+	}
+	return ed25519.Sign(k.private, canonical)
+}
+
+func (k Key) verify(canonical []byte, signature []byte) bool {
+	if k.Algorithm == HMACSHA256 {
+		return hmac.Equal(k.sign(canonical), signature)
+	}
+	return k.public != nil && ed25519.Verify(k.public, canonical, signature)
+}
+
+// Canonical is the signed form of an event, of its attributes by name and its data.
+func Canonical(attributes map[string]string, data []byte) []byte {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		if name != Attribute && name != "datacontenttype" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		value := attributes[name]
+		b.WriteString(fmt.Sprintf("%d:%s=%d:%s\n", len(name), name, len(value), value))
+	}
+	b.WriteString("\n")
+	b.Write(data)
+
+	return []byte(b.String())
+}
+
+// Sign returns the value of the signature attribute of the event, signed with each of the keys.
+func Sign(attributes map[string]string, data []byte, keys []Key) (string, error) {
+	canonical := Canonical(attributes, data)
+	signatures := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		if !validId(key.Id) {
+			return "", fmt.Errorf("invalid signing key id %s, it can only have letters, digits, '.', '_' and '-'", key.Id)
+		}
+		if !key.CanSign() {
+			return "", fmt.Errorf("signing key %s is an ed25519 public key, it can't sign", key.Id)
+		}
+		signatures = append(signatures, key.Id+"="+base64.RawURLEncoding.EncodeToString(key.sign(canonical)))
+	}
+	return strings.Join(signatures, ","), nil
+}
+
+// Verify checks that the event, of the attributes, the signature included, and the data, is signed by one of the
+// keys.
+func Verify(attributes map[string]string, data []byte, keys []Key) error {
+	value := attributes[Attribute]
+
+	if value == "" {
+		return ErrUnsigned
+	}
+
+	byId := map[string]Key{}
+	for _, key := range keys {
+		byId[key.Id] = key
+	}
+
+	canonical := Canonical(attributes, data)
+	known := false
+
+	for _, pair := range strings.Split(value, ",") {
+		n := strings.Index(pair, "=")
+		if n < 0 {
+			return ErrInvalidSignature
+		}
+
+		key, ok := byId[pair[:n]]
+		if !ok {
+			continue
+		}
+		known = true
+
+		signature, err := base64.RawURLEncoding.DecodeString(pair[n+1:])
+		if err == nil && key.verify(canonical, signature) {
+			return nil
+		}
+	}
+
+	if !known {
+		return ErrUnknownKey
+	}
+	return ErrInvalidSignature
+}
+
+// VerifyStructured checks that the event, a structured mode json, is signed by one of the keys. Events with a
+// repeated member, or with an attribute that isn't a string, are rejected, their signed form being unclear.
+func VerifyStructured(body []byte, keys []Key) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t != json.Delim('{') {
+		return errors.New("the event is not a json object")
+	}
+
+	attributes := map[string]string{}
+	var data json.RawMessage
+	seen := map[string]bool{}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		name := t.(string)
+		if seen[name] {
+			return fmt.Errorf("the event has the member %s more than once", name)
+		}
+		seen[name] = true
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+
+		if name == "data" {
+			data = raw
+			continue
+		}
+
+		var value string
+		if raw[0] != '"' || json.Unmarshal(raw, &value) != nil {
+			return fmt.Errorf("the event attribute %s is not a string", name)
+		}
+		attributes[name] = value
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("the event has data after its json object")
+	}
+
+	return Verify(attributes, data, keys)
+}
@@ -10,16 +10,24 @@ import (
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"testing"
 )
 
+var serverManaged = regexp.MustCompile(`"id":"[^"]*",|,"(createdAt|createdBy|updatedAt|updatedBy)":"[^"]*"`)
+
+// withoutServerManaged drops the fields set by the server, which change on every run.
+func withoutServerManaged(body string) string {
+	return serverManaged.ReplaceAllString(body, "")
+}
+
 func TestGetAllUsersSuccessNoFilter(t *testing.T) {
 
 	c := config.NewMongoConfig()
 	repository.New(c)
 
 	mongo := &repository.Mongo{Collection: repository.GetUserCollection(c)}
-	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+	sns, _ := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
 
 	h := handler.UserHandler{Repository: mongo, NotifyHandler: sns, Logger: logger.ConfigureLogger()}
 
@@ -29,7 +37,7 @@ func TestGetAllUsersSuccessNoFilter(t *testing.T) {
 	h.GetAllUsers(w, r)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, "[{\"email\":\"test2@test.com\",\"country\":\"UK\",\"nickname\":\"testnick2\",\"lastName\":\"lastName2\",\"firstName\":\"firstName2\"},{\"email\":\"test1@test.com\",\"country\":\"UK\",\"nickname\":\"testnick1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\"}]", w.Body.String())
+	assert.Equal(t, "[{\"email\":\"test2@test.com\",\"country\":\"UK\",\"nickname\":\"testnick2\",\"lastName\":\"lastName2\",\"firstName\":\"firstName2\"},{\"email\":\"test1@test.com\",\"country\":\"UK\",\"nickname\":\"testnick1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\"}]", withoutServerManaged(w.Body.String()))
 }
 
 func TestGetAllUsersSuccessFilters(t *testing.T) {
@@ -38,7 +46,7 @@ func TestGetAllUsersSuccessFilters(t *testing.T) {
 	repository.New(c)
 
 	mongo := &repository.Mongo{Collection: repository.GetUserCollection(c)}
-	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+	sns, _ := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
 
 	h := handler.UserHandler{Repository: mongo, NotifyHandler: sns, Logger: logger.ConfigureLogger()}
 
@@ -49,7 +57,7 @@ func TestGetAllUsersSuccessFilters(t *testing.T) {
 	h.GetAllUsers(w, r)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, "[{\"email\":\"test1@test.com\",\"country\":\"UK\",\"nickname\":\"testnick1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\"}]", w.Body.String())
+	assert.Equal(t, "[{\"email\":\"test1@test.com\",\"country\":\"UK\",\"nickname\":\"testnick1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\"}]", withoutServerManaged(w.Body.String()))
 }
 
 func TestGetUserByNickNameSuccess(t *testing.T) {
@@ -58,7 +66,7 @@ func TestGetUserByNickNameSuccess(t *testing.T) {
 	repository.New(c)
 
 	mongo := &repository.Mongo{Collection: repository.GetUserCollection(c)}
-	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+	sns, _ := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
 
 	h := handler.UserHandler{Repository: mongo, NotifyHandler: sns, Logger: logger.ConfigureLogger()}
 
@@ -75,7 +83,7 @@ func TestGetUserByNickNameSuccess(t *testing.T) {
 	h.GetUserByNickname(w, r)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, "{\"email\":\"test1@test.com\",\"country\":\"UK\",\"nickname\":\"testnick1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\"}", w.Body.String())
+	assert.Equal(t, "{\"email\":\"test1@test.com\",\"country\":\"UK\",\"nickname\":\"testnick1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\"}", withoutServerManaged(w.Body.String()))
 }
 
 func TestGetUserByNickNameNotFound(t *testing.T) {
@@ -84,7 +92,7 @@ func TestGetUserByNickNameNotFound(t *testing.T) {
 	repository.New(c)
 
 	mongo := &repository.Mongo{Collection: repository.GetUserCollection(c)}
-	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+	sns, _ := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
 
 	h := handler.UserHandler{Repository: mongo, NotifyHandler: sns, Logger: logger.ConfigureLogger()}
 
@@ -104,13 +112,13 @@ func TestGetUserByNickNameNotFound(t *testing.T) {
 	assert.Equal(t, "{\"description\":\"user with nickname testnickname not found!\"}", w.Body.String())
 }
 
-func TestDeleteUserByNickName(t *testing.T) {
+func TestDeleteUserByNickNameNotFound(t *testing.T) {
 
 	c := config.NewMongoConfig()
 	repository.New(c)
 
 	mongo := &repository.Mongo{Collection: repository.GetUserCollection(c)}
-	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+	sns, _ := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
 
 	h := handler.UserHandler{Repository: mongo, NotifyHandler: sns, Logger: logger.ConfigureLogger()}
 
@@ -126,8 +134,8 @@ func TestDeleteUserByNickName(t *testing.T) {
 
 	h.DeleteUserByNickname(w, r)
 
-	assert.Equal(t, http.StatusNoContent, w.Code)
-	assert.Equal(t, "", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "{\"description\":\"user with nickname testnickname not found!\"}", w.Body.String())
 }
 
 func TestSaveUserSuccess(t *testing.T) {
@@ -136,7 +144,7 @@ func TestSaveUserSuccess(t *testing.T) {
 	repository.New(c)
 
 	mongo := &repository.Mongo{Collection: repository.GetUserCollection(c)}
-	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+	sns, _ := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
 
 	h := handler.UserHandler{Repository: mongo, NotifyHandler: sns, Logger: logger.ConfigureLogger()}
 
@@ -149,10 +157,10 @@ func TestSaveUserSuccess(t *testing.T) {
 
 	assert.Equal(t, http.StatusCreated, w.Code)
 	assert.Equal(t, "", w.Body.String())
-	assert.Equal(t, "v1/users/testnickname3", w.Header().Get("Location"))
+	assert.Regexp(t, "^v1/users/id/[0-9a-f]{24}$", w.Header().Get("Location"))
 
 	//clean database
-	mongo.Delete("testnickname3")
+	_, _ = mongo.Delete("testnickname3")
 }
 
 func TestSaveValidationError(t *testing.T) {
@@ -160,7 +168,7 @@ func TestSaveValidationError(t *testing.T) {
 	repository.New(c)
 
 	mongo := &repository.Mongo{Collection: repository.GetUserCollection(c)}
-	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+	sns, _ := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
 
 	h := handler.UserHandler{Repository: mongo, NotifyHandler: sns, Logger: logger.ConfigureLogger()}
 
@@ -173,7 +181,7 @@ func TestSaveValidationError(t *testing.T) {
 	h.SaveUser(w, r)
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
-	assert.Equal(t, "{\"description\":\"Key: 'User.Email' Error:Field validation for 'Email' failed on the 'required' tag\\nKey: 'User.Country' Error:Field validation for 'Country' failed on the 'required' tag\\nKey: 'User.Nickname' Error:Field validation for 'Nickname' failed on the 'required' tag\\nKey: 'User.LastName' Error:Field validation for 'LastName' failed on the 'required' tag\\nKey: 'User.FirstName' Error:Field validation for 'FirstName' failed on the 'required' tag\\nKey: 'User.Password' Error:Field validation for 'Password' failed on the 'required' tag\"}", w.Body.String())
+	assert.Equal(t, "{\"description\":\"Key: 'UserRequest.Email' Error:Field validation for 'Email' failed on the 'required' tag\\nKey: 'UserRequest.Country' Error:Field validation for 'Country' failed on the 'required' tag\\nKey: 'UserRequest.Nickname' Error:Field validation for 'Nickname' failed on the 'required' tag\\nKey: 'UserRequest.LastName' Error:Field validation for 'LastName' failed on the 'required' tag\\nKey: 'UserRequest.FirstName' Error:Field validation for 'FirstName' failed on the 'required' tag\\nKey: 'UserRequest.Password' Error:Field validation for 'Password' failed on the 'required' tag\"}", w.Body.String())
 }
 
 //func TestSaveUserNickNameAlreadyExists(t *testing.T) {
@@ -182,7 +190,7 @@ func TestSaveValidationError(t *testing.T) {
 //	repository.New(c)
 //
 //	mongo := &repository.Mongo{Collection: repository.GetUserCollection(c)}
-//	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+//	sns, _ := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
 //
 //	h := handler.UserHandler{Repository: mongo, NotifyHandler: sns, Logger: logger.ConfigureLogger()}
 //
@@ -204,7 +212,7 @@ func TestUpdateUserSuccess(t *testing.T) {
 	repository.New(c)
 
 	mongo := &repository.Mongo{Collection: repository.GetUserCollection(c)}
-	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+	sns, _ := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
 
 	h := handler.UserHandler{Repository: mongo, NotifyHandler: sns, Logger: logger.ConfigureLogger()}
 
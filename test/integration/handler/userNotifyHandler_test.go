@@ -2,6 +2,7 @@ package handler
 
 import (
 	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/event"
 	"github.com/bernardoms/user-api/internal/handler"
 	"github.com/bernardoms/user-api/internal/logger"
 	"github.com/bernardoms/user-api/internal/model"
@@ -9,9 +10,15 @@ import (
 	"testing"
 )
 
+func events() *event.Encoder {
+	e, _ := event.NewEncoder(config.NewEventsConfig())
+	return e
+}
+
 func TestPublishUserSuccess(t *testing.T) {
 
-	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+	sns, err := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
+	assert.Nil(t, err)
 
 	user := new(model.User)
 	user.Email = "test@test.com"
@@ -21,14 +28,15 @@ func TestPublishUserSuccess(t *testing.T) {
 	user.Password = "password"
 	user.Nickname = "testnickname"
 
-	err := sns.Publish(user)
+	err = sns.Publish(user, nil)
 
 	assert.Equal(t, nil, err, "exception on publish to sns")
 }
 
 func TestPublishUserFail(t *testing.T) {
 
-	sns := handler.NewSNS(config.NewSnsConfig(), logger.ConfigureLogger())
+	sns, err := handler.NewSNS(config.NewSnsConfig(), events(), logger.ConfigureLogger())
+	assert.Nil(t, err)
 	sns.Topic = "failed-topic"
 
 	user := new(model.User)
@@ -39,7 +47,7 @@ func TestPublishUserFail(t *testing.T) {
 	user.Password = "password"
 	user.Nickname = "testnickname"
 
-	err := sns.Publish(user)
+	err = sns.Publish(user, nil)
 
 	assert.Error(t, err)
 }
@@ -1,9 +1,12 @@
 package integration
 
 import (
+	"context"
 	"github.com/bernardoms/user-api/config"
 	"github.com/bernardoms/user-api/internal/model"
 	"github.com/bernardoms/user-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
 	"os"
 	"testing"
 )
@@ -17,6 +20,10 @@ func TestMain(m *testing.M) {
 	repository.New(c)
 	i = &repository.Mongo{Collection: repository.GetUserCollection(c)}
 
+	if err := repository.EnsureNicknameIndex(i.Collection); err != nil {
+		panic(err)
+	}
+
 	user := model.User{
 		Email:     "test1@test.com",
 		FirstName: "firstName",
@@ -39,3 +46,31 @@ func TestMain(m *testing.M) {
 
 	os.Exit(m.Run())
 }
+
+func TestNicknameIndexExists(t *testing.T) {
+	cursor, err := i.Collection.Indexes().List(context.Background())
+
+	assert.Nil(t, err)
+
+	var indexes []bson.M
+
+	assert.Nil(t, cursor.All(context.Background(), &indexes))
+
+	var index bson.M
+
+	for _, candidate := range indexes {
+		if candidate["name"] == repository.NicknameIndex {
+			index = candidate
+		}
+	}
+
+	assert.NotNil(t, index)
+	assert.Equal(t, true, index["unique"])
+	assert.Equal(t, bson.M{"active": true}, index["partialFilterExpression"])
+}
+
+func TestNicknameIndexRejectsActiveDuplicates(t *testing.T) {
+	_, err := i.Save(&model.User{Email: "test3@test.com", Nickname: "testnick1", Country: "UK"})
+
+	assert.True(t, repository.IsDuplicateKey(err))
+}
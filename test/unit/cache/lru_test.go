@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"github.com/bernardoms/user-api/internal/cache"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLRUGetSet(t *testing.T) {
+	c := cache.NewLRU(10)
+
+	_ = c.Set("key", []byte("value"), 0)
+	value, found, err := c.Get("key")
+
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestLRUMiss(t *testing.T) {
+	c := cache.NewLRU(10)
+
+	_, found, err := c.Get("key")
+
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := cache.NewLRU(2)
+
+	_ = c.Set("a", []byte("1"), 0)
+	_ = c.Set("b", []byte("2"), 0)
+	_, _, _ = c.Get("a")
+	_ = c.Set("c", []byte("3"), 0)
+
+	_, foundA, _ := c.Get("a")
+	_, foundB, _ := c.Get("b")
+	_, foundC, _ := c.Get("c")
+
+	assert.True(t, foundA)
+	assert.False(t, foundB)
+	assert.True(t, foundC)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestLRUExpires(t *testing.T) {
+	c := cache.NewLRU(10)
+
+	_ = c.Set("key", []byte("value"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	_, found, _ := c.Get("key")
+
+	assert.False(t, found)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestLRUDelete(t *testing.T) {
+	c := cache.NewLRU(10)
+
+	_ = c.Set("a", []byte("1"), 0)
+	_ = c.Set("b", []byte("2"), 0)
+	_ = c.Delete("a", "b", "unknown")
+
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestLRUAddOnlyWhenAbsent(t *testing.T) {
+	c := cache.NewLRU(10)
+
+	_ = c.Set("key", []byte("1"), 0)
+	added, err := c.Add("key", []byte("2"), 0)
+	value, _, _ := c.Get("key")
+
+	assert.Nil(t, err)
+	assert.False(t, added)
+	assert.Equal(t, []byte("1"), value)
+
+	_ = c.Set("expired", []byte("1"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	added, _ = c.Add("expired", []byte("2"), 0)
+	value, _, _ = c.Get("expired")
+
+	assert.True(t, added)
+	assert.Equal(t, []byte("2"), value)
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"github.com/bernardoms/user-api/internal/cache"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRedisGetSetDelete(t *testing.T) {
+	server, _ := mock.NewRedisServer("")
+	defer server.Close()
+
+	c := cache.NewRedis(server.Addr, "", 0, time.Second, 2)
+	defer c.Close()
+
+	err := c.Set("key", []byte("value\r\nwith crlf"), time.Minute)
+	assert.Nil(t, err)
+
+	value, found, err := c.Get("key")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("value\r\nwith crlf"), value)
+
+	err = c.Delete("key")
+	assert.Nil(t, err)
+
+	_, found, err = c.Get("key")
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func TestRedisEmptyValue(t *testing.T) {
+	server, _ := mock.NewRedisServer("")
+	defer server.Close()
+
+	c := cache.NewRedis(server.Addr, "", 0, time.Second, 2)
+	defer c.Close()
+
+	_ = c.Set("key", []byte{}, time.Minute)
+	value, found, err := c.Get("key")
+
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Empty(t, value)
+}
+
+func TestRedisExpires(t *testing.T) {
+	server, _ := mock.NewRedisServer("")
+	defer server.Close()
+
+	c := cache.NewRedis(server.Addr, "", 0, time.Second, 2)
+	defer c.Close()
+
+	_ = c.Set("key", []byte("value"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	_, found, err := c.Get("key")
+
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func TestRedisAddOnlyWhenAbsent(t *testing.T) {
+	server, _ := mock.NewRedisServer("")
+	defer server.Close()
+
+	c := cache.NewRedis(server.Addr, "", 0, time.Second, 2)
+	defer c.Close()
+
+	added, err := c.Add("key", []byte("1"), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, added)
+
+	added, err = c.Add("key", []byte("2"), time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, added)
+
+	value, _, _ := c.Get("key")
+	assert.Equal(t, []byte("1"), value)
+}
+
+func TestRedisAuthAndSelect(t *testing.T) {
+	server, _ := mock.NewRedisServer("secret")
+	defer server.Close()
+
+	c := cache.NewRedis(server.Addr, "secret", 2, time.Second, 2)
+	defer c.Close()
+
+	err := c.Set("key", []byte("value"), 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"AUTH", "SELECT", "SET"}, server.Commands())
+}
+
+func TestRedisWrongPassword(t *testing.T) {
+	server, _ := mock.NewRedisServer("secret")
+	defer server.Close()
+
+	c := cache.NewRedis(server.Addr, "wrong", 0, time.Second, 2)
+	defer c.Close()
+
+	_, _, err := c.Get("key")
+
+	assert.EqualError(t, err, "redis: WRONGPASS invalid password")
+}
+
+func TestRedisReusesConnections(t *testing.T) {
+	server, _ := mock.NewRedisServer("secret")
+	defer server.Close()
+
+	c := cache.NewRedis(server.Addr, "secret", 0, time.Second, 2)
+	defer c.Close()
+
+	_ = c.Set("key", []byte("value"), 0)
+	_, _, _ = c.Get("key")
+	_ = c.Delete("key")
+
+	assert.Equal(t, []string{"AUTH", "SET", "GET", "DEL"}, server.Commands())
+}
+
+func TestRedisUnreachable(t *testing.T) {
+	c := cache.NewRedis("127.0.0.1:1", "", 0, 100*time.Millisecond, 2)
+
+	_, _, err := c.Get("key")
+
+	assert.Error(t, err)
+}
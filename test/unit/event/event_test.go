@@ -0,0 +1,117 @@
+package event
+
+import (
+	"encoding/json"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/pkg/eventsig"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func encoder(mode string) *event.Encoder {
+	e, _ := event.NewEncoder(&config.EventsConfig{Mode: mode, Source: "/user-api", SchemaUrl: "https://schemas", Version: "v1"})
+	return e
+}
+
+func TestUserUpdatedEvent(t *testing.T) {
+	updatedAt := time.Date(2020, 7, 1, 10, 0, 0, 0, time.FixedZone("BRT", -3*3600))
+	user := &model.User{Id: primitive.NewObjectID(), Nickname: "test1", Password: "hash", UpdatedAt: &updatedAt}
+
+	ev, err := encoder(config.EventModeStructured).UserUpdated(user, nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1.0", ev.SpecVersion)
+	assert.Len(t, ev.Id, 24)
+	assert.Equal(t, "/user-api", ev.Source)
+	assert.Equal(t, "com.bernardoms.user.updated", ev.Type)
+	assert.Equal(t, updatedAt.UTC(), ev.Time)
+	assert.Equal(t, user.Id.Hex(), ev.Subject)
+	assert.Equal(t, "application/json", ev.DataContentType)
+	assert.Equal(t, "https://schemas/user/v1", ev.DataSchema)
+	assert.NotContains(t, string(ev.Data), "hash")
+}
+
+func TestStructuredMessageIsTheWholeEvent(t *testing.T) {
+	e := encoder(config.EventModeStructured)
+	ev, _ := e.UserUpdated(&model.User{Nickname: "test1"}, nil)
+
+	body, contentType, err := e.Message(ev)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "application/cloudevents+json", contentType)
+
+	var fields map[string]json.RawMessage
+	assert.Nil(t, json.Unmarshal(body, &fields))
+	for _, attribute := range []string{"specversion", "id", "source", "type", "time", "subject", "datacontenttype", "dataschema", "data"} {
+		assert.Contains(t, fields, attribute)
+	}
+}
+
+func TestBinaryMessageIsTheData(t *testing.T) {
+	e := encoder(config.EventModeBinary)
+	ev, _ := e.UserUpdated(&model.User{Nickname: "test1"}, nil)
+
+	body, contentType, err := e.Message(ev)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, []byte(ev.Data), body)
+	assert.Equal(t, ev.Time.Format(time.RFC3339Nano), ev.Attributes()["time"])
+	assert.NotContains(t, ev.Attributes(), "datacontenttype")
+}
+
+func TestUnknownMode(t *testing.T) {
+	_, err := event.NewEncoder(&config.EventsConfig{Mode: "batched"})
+
+	assert.EqualError(t, err, "unknown event mode batched, it must be structured or binary")
+}
+
+func TestUserUpdatedEventAtVersion2(t *testing.T) {
+	e, err := event.NewEncoder(&config.EventsConfig{Mode: config.EventModeStructured, Source: "/user-api", SchemaUrl: "https://schemas", Version: "v2"})
+	assert.Nil(t, err)
+
+	ev, _ := e.UserUpdated(&model.User{Nickname: "test1"}, nil)
+
+	assert.Equal(t, "https://schemas/user/v2", ev.DataSchema)
+	assert.JSONEq(t, `{"id":"000000000000000000000000","email":"","country":"","nickname":"test1","lastName":"","firstName":""}`, string(ev.Data))
+}
+
+func TestUnknownVersion(t *testing.T) {
+	_, err := event.NewEncoder(&config.EventsConfig{Mode: config.EventModeStructured, Version: "v9"})
+
+	assert.EqualError(t, err, "unknown event version v9")
+}
+
+func TestUserUpdatedEventAtVersion2HasTheChanges(t *testing.T) {
+	e, _ := event.NewEncoder(&config.EventsConfig{Mode: config.EventModeStructured, Source: "/user-api", SchemaUrl: "https://schemas", Version: "v2"})
+	before := &model.User{Nickname: "test1", Country: "UK", Email: "test@test.com"}
+	user := &model.User{Nickname: "test2", Country: "BR", Email: "test@test.com"}
+	changes := append(model.Diff(before, user), model.PasswordChange(true))
+
+	ev, _ := e.UserUpdated(user, changes)
+
+	var data event.UserV2
+	assert.Nil(t, json.Unmarshal(ev.Data, &data))
+	assert.Equal(t, []string{"country", "nickname", "password"}, data.ChangedFields)
+	assert.Equal(t, []event.ChangeV2{{Field: "country", Before: "UK", After: "BR"}, {Field: "nickname", Before: "test1", After: "test2"}}, data.Changes)
+	assert.Equal(t, "test1", data.PreviousNickname)
+	assert.NotContains(t, string(ev.Data), model.Masked)
+}
+
+func TestEncoderWithKeysSignsTheEvents(t *testing.T) {
+	key := eventsig.NewHMACKey("k1", []byte("0123456789abcdef0123456789abcdef"))
+	e, err := encoder(config.EventModeBinary).WithKeys([]eventsig.Key{key})
+	assert.Nil(t, err)
+
+	ev, _ := e.UserUpdated(&model.User{Id: primitive.NewObjectID(), Nickname: "test1"}, nil)
+
+	assert.Contains(t, ev.Attributes(), eventsig.Attribute)
+	assert.Nil(t, eventsig.Verify(ev.Attributes(), ev.Data, []eventsig.Key{key}))
+
+	unsigned, _ := encoder(config.EventModeBinary).UserUpdated(&model.User{Nickname: "test1"}, nil)
+	assert.NotContains(t, unsigned.Attributes(), eventsig.Attribute)
+}
@@ -0,0 +1,108 @@
+package event
+
+import (
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSchemasAreUpToDate fails when a payload changed without running go generate ./internal/event.
+func TestSchemasAreUpToDate(t *testing.T) {
+	for _, p := range event.Payloads {
+		generated, err := event.GenerateSchema(p)
+		assert.Nil(t, err)
+
+		embedded, ok := event.LookupSchema(p.Name, p.Version)
+		assert.True(t, ok, p.Name+" "+p.Version)
+		assert.Equal(t, string(generated), string(embedded), p.Name+" "+p.Version)
+
+		file, err := ioutil.ReadFile(filepath.Join("..", "..", "..", "internal", "event", "schemas", p.Name+"."+p.Version+".json"))
+		assert.Nil(t, err)
+		assert.Equal(t, string(generated), string(file), p.Name+" "+p.Version)
+	}
+}
+
+// TestSchemasAreBackwardCompatible fails when a payload breaks the consumers of its published schema, or of the
+// previous version.
+func TestSchemasAreBackwardCompatible(t *testing.T) {
+	previous := map[string][]byte{}
+
+	for _, p := range event.Payloads {
+		generated, _ := event.GenerateSchema(p)
+
+		embedded, _ := event.LookupSchema(p.Name, p.Version)
+		changes, err := event.Breaking(embedded, generated)
+		assert.Nil(t, err)
+		assert.Empty(t, changes, p.Name+" "+p.Version+" breaks its published schema")
+
+		if before, ok := previous[p.Name]; ok {
+			changes, err := event.Breaking(before, generated)
+			assert.Nil(t, err)
+			assert.Empty(t, changes, p.Name+" "+p.Version+" breaks the previous version")
+		}
+		previous[p.Name] = generated
+	}
+}
+
+type accountV1 struct {
+	Id      string     `json:"id"`
+	Email   string     `json:"email"`
+	Country string     `json:"country,omitempty"`
+	Age     int        `json:"age,omitempty"`
+	Tags    []string   `json:"tags,omitempty"`
+	Born    *time.Time `json:"born,omitempty"`
+}
+
+type accountV2 struct {
+	Id       string   `json:"id,omitempty"`
+	Country  string   `json:"country"`
+	Age      string   `json:"age,omitempty"`
+	Tags     []int    `json:"tags,omitempty"`
+	Born     string   `json:"born,omitempty"`
+	Language string   `json:"language"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+func TestBreakingFindsEveryBreakingChange(t *testing.T) {
+	v1, err := event.GenerateSchema(event.Payload{Name: "account", Version: "v1", Type: reflect.TypeOf(accountV1{})})
+	assert.Nil(t, err)
+	v2, err := event.GenerateSchema(event.Payload{Name: "account", Version: "v2", Type: reflect.TypeOf(accountV2{})})
+	assert.Nil(t, err)
+
+	changes, err := event.Breaking(v1, v2)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"age changed from integer to string",
+		`born changed format from "date-time" to ""`,
+		"country became required",
+		"email was removed",
+		"id is no longer required",
+		"tags[] changed from string to integer",
+		"language was added as required",
+	}, changes)
+}
+
+func TestBreakingAllowsOptionalAdditions(t *testing.T) {
+	v1, _ := event.GenerateSchema(event.Payload{Name: "user", Version: "v1", Type: reflect.TypeOf(event.UserV1{})})
+	v2, _ := event.GenerateSchema(event.Payload{Name: "user", Version: "v2", Type: reflect.TypeOf(event.UserV2{})})
+
+	changes, err := event.Breaking(v1, v2)
+
+	assert.Nil(t, err)
+	assert.Empty(t, changes)
+}
+
+type nullable struct {
+	Born *time.Time `json:"born"`
+}
+
+func TestGenerateSchemaRejectsNullFields(t *testing.T) {
+	_, err := event.GenerateSchema(event.Payload{Name: "nullable", Version: "v1", Type: reflect.TypeOf(nullable{})})
+
+	assert.EqualError(t, err, "schema of nullable v1: Born is a pointer without omitempty, it would be null")
+}
@@ -0,0 +1,147 @@
+package eventsig
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"github.com/bernardoms/user-api/pkg/eventsig"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func attributes() map[string]string {
+	return map[string]string{"specversion": "1.0", "id": "1", "source": "/user-api", "type": "com.bernardoms.user.updated",
+		"time": "2020-07-01T10:00:00Z", "subject": "5ea7208049e00ddb76994ede", "dataschema": "https://schemas/user/v2"}
+}
+
+func signed(t *testing.T, data []byte, keys ...eventsig.Key) map[string]string {
+	a := attributes()
+	signature, err := eventsig.Sign(a, data, keys)
+	assert.Nil(t, err)
+	a[eventsig.Attribute] = signature
+	return a
+}
+
+func TestCanonicalSortsTheAttributes(t *testing.T) {
+	a := attributes()
+	a["datacontenttype"] = "application/json"
+	a[eventsig.Attribute] = "k1=abc"
+
+	canonical := eventsig.Canonical(a, []byte(`{"nickname":"bob"}`))
+
+	assert.Equal(t, "10:dataschema=23:https://schemas/user/v2\n2:id=1:1\n6:source=9:/user-api\n11:specversion=3:1.0\n"+
+		"7:subject=24:5ea7208049e00ddb76994ede\n4:time=20:2020-07-01T10:00:00Z\n4:type=27:com.bernardoms.user.updated\n\n"+
+		`{"nickname":"bob"}`, string(canonical))
+}
+
+func TestCanonicalIsUnambiguous(t *testing.T) {
+	smuggled := map[string]string{"id": "1", "subject": "bob\ntype=com.bernardoms.user.deleted"}
+	split := map[string]string{"id": "1", "subject": "bob", "type": "com.bernardoms.user.deleted"}
+
+	assert.NotEqual(t, eventsig.Canonical(split, nil), eventsig.Canonical(smuggled, nil))
+	assert.NotEqual(t, eventsig.Canonical(map[string]string{"a": "b=c"}, nil), eventsig.Canonical(map[string]string{"a=b": "c"}, nil))
+}
+
+func TestHMACSignature(t *testing.T) {
+	key := eventsig.NewHMACKey("2020-07", secret)
+	data := []byte(`{"nickname":"bob"}`)
+	a := signed(t, data, key)
+
+	assert.True(t, strings.HasPrefix(a[eventsig.Attribute], "2020-07="))
+	assert.Nil(t, eventsig.Verify(a, data, []eventsig.Key{key}))
+	assert.Equal(t, eventsig.ErrInvalidSignature, eventsig.Verify(a, []byte(`{"nickname":"eve"}`), []eventsig.Key{key}))
+
+	a["subject"] = "another"
+	assert.Equal(t, eventsig.ErrInvalidSignature, eventsig.Verify(a, data, []eventsig.Key{key}))
+}
+
+func TestEd25519SignatureIsVerifiedWithThePublicKey(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	data := []byte(`{"nickname":"bob"}`)
+	a := signed(t, data, eventsig.NewEd25519PrivateKey("ed", private))
+
+	assert.Nil(t, eventsig.Verify(a, data, []eventsig.Key{eventsig.NewEd25519PublicKey("ed", public)}))
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	assert.Equal(t, eventsig.ErrInvalidSignature, eventsig.Verify(a, data, []eventsig.Key{eventsig.NewEd25519PublicKey("ed", other)}))
+}
+
+func TestRotationSignsWithEveryKey(t *testing.T) {
+	old, current := eventsig.NewHMACKey("old", secret), eventsig.NewHMACKey("new", []byte("fedcba9876543210fedcba9876543210"))
+	data := []byte(`{}`)
+	a := signed(t, data, current, old)
+
+	assert.Nil(t, eventsig.Verify(a, data, []eventsig.Key{old}))
+	assert.Nil(t, eventsig.Verify(a, data, []eventsig.Key{current}))
+	assert.Equal(t, eventsig.ErrUnknownKey, eventsig.Verify(a, data, []eventsig.Key{eventsig.NewHMACKey("other", secret)}))
+	assert.Equal(t, eventsig.ErrUnsigned, eventsig.Verify(attributes(), data, []eventsig.Key{old}))
+}
+
+func TestVerifyStructured(t *testing.T) {
+	key := eventsig.NewHMACKey("k1", secret)
+	a := signed(t, []byte(`{"nickname":"bob"}`), key)
+
+	body := `{"specversion":"1.0","id":"1","source":"/user-api","type":"com.bernardoms.user.updated",` +
+		`"time":"2020-07-01T10:00:00Z","subject":"5ea7208049e00ddb76994ede","datacontenttype":"application/json",` +
+		`"dataschema":"https://schemas/user/v2","signature":"` + a[eventsig.Attribute] + `","data":{"nickname":"bob"}}`
+
+	assert.Nil(t, eventsig.VerifyStructured([]byte(body), []eventsig.Key{key}))
+	assert.Equal(t, eventsig.ErrInvalidSignature, eventsig.VerifyStructured([]byte(strings.Replace(body, "bob", "eve", 1)), []eventsig.Key{key}))
+
+	repeated := strings.Replace(body, `"id":"1"`, `"id":"1","id":"2"`, 1)
+	assert.EqualError(t, eventsig.VerifyStructured([]byte(repeated), []eventsig.Key{key}), "the event has the member id more than once")
+
+	for _, value := range []string{`1`, `null`, `true`, `{"a":"b"}`} {
+		extended := strings.Replace(body, `"data":`, `"retries":`+value+`,"data":`, 1)
+		assert.EqualError(t, eventsig.VerifyStructured([]byte(extended), []eventsig.Key{key}), "the event attribute retries is not a string", value)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	spec := "k1:hmac-sha256:" + base64.StdEncoding.EncodeToString(secret) +
+		", k2:ed25519:" + base64.StdEncoding.EncodeToString(private) +
+		",k3:ed25519:" + base64.StdEncoding.EncodeToString(public)
+
+	keys, err := eventsig.ParseKeys(spec)
+
+	assert.Nil(t, err)
+	assert.Len(t, keys, 3)
+	assert.Equal(t, []string{"k1", "k2", "k3"}, []string{keys[0].Id, keys[1].Id, keys[2].Id})
+	assert.True(t, keys[0].CanSign())
+	assert.True(t, keys[1].CanSign())
+	assert.False(t, keys[2].CanSign())
+
+	_, err = eventsig.Sign(attributes(), nil, keys)
+	assert.EqualError(t, err, "signing key k3 is an ed25519 public key, it can't sign")
+
+	keys, err = eventsig.ParseKeys("")
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestParseKeysRejectsInvalidKeys(t *testing.T) {
+	cases := map[string]string{
+		"k1":                      "invalid signing key, it must be id:algorithm:base64 key",
+		"k1:hmac-sha256:???":      "signing key k1 is not base64: illegal base64 data at input byte 0",
+		"k1:hmac-sha256:c2hvcnQ=": "signing key k1 is too short, hmac-sha256 keys need at least 32 bytes",
+		"k1:ed25519:c2hvcnQ=":     "signing key k1 must be a 64 bytes ed25519 private key or a 32 bytes public key",
+		"k1:rsa:c2hvcnQ=":         "unknown algorithm rsa of signing key k1, it must be hmac-sha256 or ed25519",
+		"k=1:rsa:c2hvcnQ=":        "invalid signing key id k=1, it can only have letters, digits, '.', '_' and '-'",
+		"k 1:rsa:c2hvcnQ=":        "invalid signing key id k 1, it can only have letters, digits, '.', '_' and '-'",
+	}
+
+	for spec, description := range cases {
+		_, err := eventsig.ParseKeys(spec)
+		assert.EqualError(t, err, description, spec)
+	}
+
+	_, err := eventsig.Sign(attributes(), nil, []eventsig.Key{eventsig.NewHMACKey("k=1", secret)})
+	assert.EqualError(t, err, "invalid signing key id k=1, it can only have letters, digits, '.', '_' and '-'")
+
+	hmacKey := "k1:hmac-sha256:" + base64.StdEncoding.EncodeToString(secret)
+	_, err = eventsig.ParseKeys(hmacKey + "," + hmacKey)
+	assert.EqualError(t, err, "signing key k1 is repeated")
+}
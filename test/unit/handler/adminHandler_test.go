@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/bernardoms/user-api/internal/cache"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/repository"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func adminRouter(token string) *mux.Router {
+	h := handler.AdminHandler{Logger: logger.ConfigureLogger()}
+
+	r := mux.NewRouter()
+	admin := r.PathPrefix("/v1/admin").Subrouter()
+	admin.Use(handler.AdminAuth(token))
+	admin.HandleFunc("/log-level", h.GetLogLevel).Methods("GET")
+	admin.HandleFunc("/log-level", h.SetLogLevel).Methods("PUT")
+	return r
+}
+
+func TestAdminWithoutToken(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/v1/admin/log-level", nil)
+	w := httptest.NewRecorder()
+
+	adminRouter("secret").ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "{\"description\":\"missing or invalid admin token\"}", w.Body.String())
+}
+
+func TestAdminWithoutBearerScheme(t *testing.T) {
+	for _, authorization := range []string{"secret", "Basic secret", "Bearersecret", "Bearer Secret"} {
+		r, _ := http.NewRequest("GET", "/v1/admin/log-level", nil)
+		r.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+
+		adminRouter("secret").ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, authorization)
+	}
+}
+
+func TestAdminWithoutConfiguredToken(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/v1/admin/log-level", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+
+	adminRouter("").ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestGetLogLevel(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/v1/admin/log-level", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	adminRouter("secret").ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"level\":\"info\"}", w.Body.String())
+}
+
+func TestSetLogLevelWithTTL(t *testing.T) {
+	r, _ := http.NewRequest("PUT", "/v1/admin/log-level", bytes.NewBuffer([]byte("{\"level\":\"debug\",\"ttl\":\"10m\"}")))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	adminRouter("secret").ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "\"level\":\"debug\"")
+	assert.Contains(t, w.Body.String(), "\"until\":")
+}
+
+func TestSetLogLevelInvalidLevel(t *testing.T) {
+	r, _ := http.NewRequest("PUT", "/v1/admin/log-level", bytes.NewBuffer([]byte("{\"level\":\"loud\"}")))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	adminRouter("secret").ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSetLogLevelInvalidTTL(t *testing.T) {
+	r, _ := http.NewRequest("PUT", "/v1/admin/log-level", bytes.NewBuffer([]byte("{\"level\":\"debug\",\"ttl\":\"soon\"}")))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	adminRouter("secret").ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetCacheStatsDisabled(t *testing.T) {
+	h := handler.AdminHandler{Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/admin/cache/stats", nil)
+	w := httptest.NewRecorder()
+
+	h.GetCacheStats(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "{\"description\":\"user cache is disabled\"}", w.Body.String())
+}
+
+func TestGetCacheStats(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(&model.User{Nickname: "test1"}, nil)
+
+	cached := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+	_, _ = cached.FindResponseByNickname("test1")
+	_, _ = cached.FindResponseByNickname("test1")
+
+	h := handler.AdminHandler{Logger: logger.ConfigureLogger(), Cache: cached}
+
+	r, _ := http.NewRequest("GET", "/v1/admin/cache/stats", nil)
+	w := httptest.NewRecorder()
+
+	h.GetCacheStats(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"hits\":1,\"misses\":1,\"negativeHits\":0,\"errors\":0,\"hitRatio\":0.5}", w.Body.String())
+}
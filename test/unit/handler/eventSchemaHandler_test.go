@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func eventHandler() handler.EventHandler {
+	events := &event.Encoder{Mode: config.EventModeStructured, SchemaUrl: "http://localhost:8080/v1/events/schemas", Version: "v2"}
+	return handler.EventHandler{Events: events, Logger: logger.ConfigureLogger()}
+}
+
+func TestGetEventSchemas(t *testing.T) {
+	h := eventHandler()
+
+	r, _ := http.NewRequest("GET", "/v1/events/schemas", nil)
+	w := httptest.NewRecorder()
+	h.GetEventSchemas(w, r)
+
+	var response model.EventSchemas
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []model.EventSchema{
+		{Name: "user", Version: "v1", Url: "http://localhost:8080/v1/events/schemas/user/v1"},
+		{Name: "user", Version: "v2", Url: "http://localhost:8080/v1/events/schemas/user/v2", Published: true},
+	}, response.Schemas)
+}
+
+func TestGetEventSchema(t *testing.T) {
+	h := eventHandler()
+
+	r, _ := http.NewRequest("GET", "/v1/events/schemas/user/v1", nil)
+	r = mux.SetURLVars(r, map[string]string{"name": "user", "version": "v1"})
+	w := httptest.NewRecorder()
+	h.GetEventSchema(w, r)
+
+	var schema event.Schema
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &schema))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/schema+json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "user v1", schema.Title)
+	assert.Contains(t, schema.Properties, "nickname")
+	assert.NotContains(t, schema.Properties, "password")
+}
+
+func TestGetEventSchemaNotFound(t *testing.T) {
+	h := eventHandler()
+
+	r, _ := http.NewRequest("GET", "/v1/events/schemas/user/v9", nil)
+	r = mux.SetURLVars(r, map[string]string{"name": "user", "version": "v9"})
+	w := httptest.NewRecorder()
+	h.GetEventSchema(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, `{"description":"schema user v9 not found!"}`, w.Body.String())
+}
@@ -0,0 +1,391 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/job"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/query"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func jobHandler(mongoMock *mock.MongoMock, jobMock *mock.JobMock, queue *mock.JobQueueMock, files *mock.FileStore) handler.JobHandler {
+	users := &handler.UserHandler{Repository: mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(),
+		AdminToken: "secret", Import: &config.ImportConfig{BatchSize: 2, Workers: 2, MaxBytes: 1 << 20}}
+	return handler.JobHandler{Queue: queue, Repository: jobMock, Files: files, Users: users, Logger: logger.ConfigureLogger()}
+}
+
+func TestSubmitImportJobStoresFile(t *testing.T) {
+	queue := mock.JobQueueMock{}
+	files := mock.NewFileStore()
+	h := jobHandler(&mock.MongoMock{}, &mock.JobMock{}, &queue, files)
+
+	var submitted *model.Job
+	queue.On("Submit", mock2.MatchedBy(func(j *model.Job) bool {
+		submitted = j
+		return j.Type == model.JobImport && j.Params["contentType"] == "text/csv" && j.Params["dryRun"] == "true" &&
+			j.CreatedBy == "anonymous"
+	})).Return(nil)
+
+	r, _ := http.NewRequest("POST", "/v1/jobs/import?dryRun=true", bytes.NewBufferString("nickname\nbob\n"))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+
+	h.SubmitImportJob(w, r)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "v1/jobs/"+submitted.Id.Hex(), w.Header().Get("Location"))
+	assert.Equal(t, "nickname\nbob\n", string(files.Files[submitted.Input]))
+}
+
+func TestSubmitImportJobUnsupportedType(t *testing.T) {
+	h := jobHandler(&mock.MongoMock{}, &mock.JobMock{}, &mock.JobQueueMock{}, mock.NewFileStore())
+
+	r, _ := http.NewRequest("POST", "/v1/jobs/import", bytes.NewBufferString("{}"))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	h.SubmitImportJob(w, r)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestSubmitExportJob(t *testing.T) {
+	queue := mock.JobQueueMock{}
+	h := jobHandler(&mock.MongoMock{}, &mock.JobMock{}, &queue, mock.NewFileStore())
+
+	queue.On("Submit", mock2.MatchedBy(func(j *model.Job) bool {
+		return j.Type == model.JobExport && j.Params["contentType"] == "text/csv" && j.Params["query"] == "country=UK"
+	})).Return(nil)
+
+	r, _ := http.NewRequest("POST", "/v1/jobs/export?format=csv&country=UK", nil)
+	w := httptest.NewRecorder()
+
+	h.SubmitExportJob(w, r)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	queue.AssertExpectations(t)
+}
+
+func TestSubmitExportJobInvalidFilter(t *testing.T) {
+	h := jobHandler(&mock.MongoMock{}, &mock.JobMock{}, &mock.JobQueueMock{}, mock.NewFileStore())
+
+	for _, target := range []string{"/v1/jobs/export?format=xml", "/v1/jobs/export?filter=country=", "/v1/jobs/export?fields=password"} {
+		r, _ := http.NewRequest("POST", target, nil)
+		w := httptest.NewRecorder()
+
+		h.SubmitExportJob(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+}
+
+func TestSubmitUpdateJob(t *testing.T) {
+	queue := mock.JobQueueMock{}
+	h := jobHandler(&mock.MongoMock{}, &mock.JobMock{}, &queue, mock.NewFileStore())
+
+	queue.On("Submit", mock2.MatchedBy(func(j *model.Job) bool {
+		return j.Type == model.JobUpdate && j.Params["filter"] == "country=UK" &&
+			j.Params["set"] == `{"email":null,"country":"BR","nickname":null,"lastName":null,"firstName":null,"password":null}`
+	})).Return(nil)
+
+	r, _ := http.NewRequest("POST", "/v1/jobs/update", bytes.NewBufferString(`{"filter":"country=UK","set":{"country":"BR"}}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	h.SubmitUpdateJob(w, r)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	queue.AssertExpectations(t)
+}
+
+func TestSubmitUpdateJobRejected(t *testing.T) {
+	h := jobHandler(&mock.MongoMock{}, &mock.JobMock{}, &mock.JobQueueMock{}, mock.NewFileStore())
+
+	cases := map[string]string{
+		`{"set":{"country":"BR"}}`:                            "the filter of the users to update is required",
+		`{"filter":"country=","set":{"country":"BR"}}`:        "expected a value at position 9, the end of the filter",
+		`{"filter":"country=UK","set":{"nickname":"bob"}}`:    "nicknames and passwords can't be updated in bulk",
+		`{"filter":"country=UK","set":{}}`:                    "no field to set",
+		`{"filter":"country=UK","set":{"email":"not email"}}`: "invalid email not email",
+	}
+
+	for body, description := range cases {
+		r, _ := http.NewRequest("POST", "/v1/jobs/update", bytes.NewBufferString(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+
+		h.SubmitUpdateJob(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), description, body)
+	}
+
+	r, _ := http.NewRequest("POST", "/v1/jobs/update", bytes.NewBufferString(`{"filter":"country=UK","set":{"country":"BR"}}`))
+	w := httptest.NewRecorder()
+
+	h.SubmitUpdateJob(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestSubmitReplayJob(t *testing.T) {
+	queue := mock.JobQueueMock{}
+	h := jobHandler(&mock.MongoMock{}, &mock.JobMock{}, &queue, mock.NewFileStore())
+
+	queue.On("Submit", mock2.MatchedBy(func(j *model.Job) bool {
+		return j.Type == model.JobReplay &&
+			j.Params["request"] == `{"source":"audit","from":"2020-07-01T00:00:00Z","nickname":"bob","rate":5}`
+	})).Return(nil)
+
+	body := `{"source":"audit","from":"2020-07-01T00:00:00Z","nickname":"bob","rate":5}`
+	r, _ := http.NewRequest("POST", "/v1/jobs/replay", bytes.NewBufferString(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	h.SubmitReplayJob(w, r)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	queue.AssertExpectations(t)
+}
+
+func TestSubmitReplayJobRejected(t *testing.T) {
+	h := jobHandler(&mock.MongoMock{}, &mock.JobMock{}, &mock.JobQueueMock{}, mock.NewFileStore())
+
+	cases := map[string]string{
+		`{"source":"outbox"}`: "unknown replay source outbox, it must be audit or snapshot",
+		`{"source":"audit","types":["com.bernardoms.user.deleted"]}`:                   "unknown event type com.bernardoms.user.deleted",
+		`{"source":"audit","from":"2020-07-02T00:00:00Z","to":"2020-07-01T00:00:00Z"}`: "the replay must start before it ends",
+		`{"source":"snapshot","rate":-1}`:                                              "the replay rate can't be negative",
+		`{"source":"snapshot","from":"yesterday"}`:                                     "parsing time",
+	}
+
+	for body, description := range cases {
+		r, _ := http.NewRequest("POST", "/v1/jobs/replay", bytes.NewBufferString(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+
+		h.SubmitReplayJob(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), description, body)
+	}
+
+	r, _ := http.NewRequest("POST", "/v1/jobs/replay", bytes.NewBufferString(`{"source":"snapshot"}`))
+	w := httptest.NewRecorder()
+
+	h.SubmitReplayJob(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestGetJob(t *testing.T) {
+	jobMock := mock.JobMock{}
+	h := jobHandler(&mock.MongoMock{}, &jobMock, &mock.JobQueueMock{}, mock.NewFileStore())
+
+	j := &model.Job{Id: primitive.NewObjectID(), Type: model.JobExport, Status: model.JobSucceeded, Result: "file",
+		ResultType: "text/csv", Progress: model.JobProgress{Total: 2, Done: 2}}
+	jobMock.On("FindById", j.Id).Return(j, nil)
+
+	r, _ := http.NewRequest("GET", "/v1/jobs/"+j.Id.Hex(), nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r = mux.SetURLVars(r, map[string]string{"id": j.Id.Hex()})
+	w := httptest.NewRecorder()
+
+	h.GetJob(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body map[string]interface{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "v1/jobs/"+j.Id.Hex()+"/result", body["resultUrl"])
+	assert.Equal(t, map[string]interface{}{"total": 2.0, "done": 2.0, "failed": 0.0}, body["progress"])
+	assert.NotContains(t, body, "result")
+}
+
+func TestGetJobNotFound(t *testing.T) {
+	jobMock := mock.JobMock{}
+	h := jobHandler(&mock.MongoMock{}, &jobMock, &mock.JobQueueMock{}, mock.NewFileStore())
+
+	id := primitive.NewObjectID()
+	jobMock.On("FindById", id).Return((*model.Job)(nil), nil)
+
+	for _, v := range []string{id.Hex(), "not-an-id"} {
+		r, _ := http.NewRequest("GET", "/v1/jobs/"+v, nil)
+		r = mux.SetURLVars(r, map[string]string{"id": v})
+		w := httptest.NewRecorder()
+
+		h.GetJob(w, r)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+}
+
+func TestGetJobResult(t *testing.T) {
+	jobMock := mock.JobMock{}
+	files := mock.NewFileStore()
+	h := jobHandler(&mock.MongoMock{}, &jobMock, &mock.JobQueueMock{}, files)
+
+	j := &model.Job{Id: primitive.NewObjectID(), Status: model.JobSucceeded, Result: files.Put("nickname\nbob\n"), ResultType: "text/csv"}
+	jobMock.On("FindById", j.Id).Return(j, nil)
+
+	r, _ := http.NewRequest("GET", "/v1/jobs/"+j.Id.Hex()+"/result", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r = mux.SetURLVars(r, map[string]string{"id": j.Id.Hex()})
+	w := httptest.NewRecorder()
+
+	h.GetJobResult(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "nickname\nbob\n", w.Body.String())
+}
+
+func TestCancelJob(t *testing.T) {
+	jobMock := mock.JobMock{}
+	h := jobHandler(&mock.MongoMock{}, &jobMock, &mock.JobQueueMock{}, mock.NewFileStore())
+
+	running := &model.Job{Id: primitive.NewObjectID(), Status: model.JobRunning, CancelRequested: true}
+	done := &model.Job{Id: primitive.NewObjectID(), Status: model.JobSucceeded}
+	jobMock.On("FindById", running.Id).Return(running, nil)
+	jobMock.On("FindById", done.Id).Return(done, nil)
+	jobMock.On("Cancel", running.Id).Return(running, nil)
+	jobMock.On("Cancel", done.Id).Return(done, nil)
+
+	for j, code := range map[*model.Job]int{running: http.StatusOK, done: http.StatusConflict} {
+		r, _ := http.NewRequest("POST", "/v1/jobs/"+j.Id.Hex()+":cancel", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		r = mux.SetURLVars(r, map[string]string{"id": j.Id.Hex()})
+		w := httptest.NewRecorder()
+
+		h.CancelJob(w, r)
+
+		assert.Equal(t, code, w.Code)
+	}
+}
+
+func TestJobsAreOnlyForTheirPrincipal(t *testing.T) {
+	jobMock := mock.JobMock{}
+	h := jobHandler(&mock.MongoMock{}, &jobMock, &mock.JobQueueMock{}, mock.NewFileStore())
+
+	j := &model.Job{Id: primitive.NewObjectID(), Type: model.JobExport, Status: model.JobRunning, CreatedBy: "alice"}
+	jobMock.On("FindById", j.Id).Return(j, nil)
+	jobMock.On("Cancel", j.Id).Return(j, nil)
+
+	for principal, code := range map[string]int{"alice": http.StatusOK, "bob": http.StatusNotFound, "": http.StatusNotFound} {
+		for _, route := range []struct {
+			method  string
+			path    string
+			handler http.HandlerFunc
+		}{
+			{"GET", "/v1/jobs/" + j.Id.Hex(), h.GetJob},
+			{"POST", "/v1/jobs/" + j.Id.Hex() + ":cancel", h.CancelJob},
+		} {
+			r, _ := http.NewRequest(route.method, route.path, nil)
+			if principal != "" {
+				r.Header.Set(handler.PrincipalHeader, principal)
+			}
+			r = mux.SetURLVars(r, map[string]string{"id": j.Id.Hex()})
+			w := httptest.NewRecorder()
+
+			handler.WithPrincipal(route.handler).ServeHTTP(w, r)
+
+			assert.Equal(t, code, w.Code, principal+" "+route.method+" "+route.path)
+		}
+	}
+
+	jobMock.AssertNumberOfCalls(t, "Cancel", 1)
+}
+
+func runJob(t *testing.T, u *handler.UserHandler, files *mock.FileStore, j *model.Job) error {
+	t.Helper()
+	return u.JobRunners(files)[j.Type].Run(context.Background(), j, job.NewProgress(j))
+}
+
+func TestExportJobRunner(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	files := mock.NewFileStore()
+	u := &handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	filter := &model.Filter{Query: countryUK(), Fields: []string{"nickname"}}
+	mongoMock.On("Count", filter).Return(int64(2), nil)
+	mongoMock.On("Stream", filter, mock2.Anything).Return([]model.User{{Nickname: "bob"}, {Nickname: "ann"}}, nil)
+
+	j := &model.Job{Id: primitive.NewObjectID(), Type: model.JobExport,
+		Params: map[string]string{"query": "country=UK&fields=nickname", "contentType": "text/csv"}}
+
+	assert.Nil(t, runJob(t, u, files, j))
+
+	assert.Equal(t, model.JobProgress{Total: 2, Done: 2}, j.Progress)
+	assert.Equal(t, "text/csv", j.ResultType)
+	assert.Equal(t, "nickname\nbob\nann\n", string(files.Files[j.Result]))
+}
+
+func TestImportJobRunnerResumesAfterCheckpoint(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	files := mock.NewFileStore()
+	u := &handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(),
+		Import: &config.ImportConfig{BatchSize: 2, Workers: 1, MaxBytes: 1 << 20}}
+
+	file := "nickname,email,country,firstName,lastName,password\n" +
+		"old,old@test.com,UK,First,Last,secret\n" +
+		"new,new@test.com,UK,First,Last,secret\n" +
+		"bad,not an email,UK,First,Last,secret\n"
+
+	mongoMock.On("FindNicknames", []string{"new"}).Return([]string{}, nil)
+	mongoMock.On("FindNicknames", []string(nil)).Return([]string{}, nil)
+	mongoMock.On("SaveMany", mock2.MatchedBy(func(users []*model.User) bool {
+		return len(users) == 1 && users[0].Nickname == "new"
+	})).Return([]error{nil}, nil)
+
+	j := &model.Job{Id: primitive.NewObjectID(), Type: model.JobImport, Input: files.Put(file), Checkpoint: 2,
+		Progress: model.JobProgress{Done: 1}, Params: map[string]string{"contentType": "text/csv"}}
+
+	assert.Nil(t, runJob(t, u, files, j))
+
+	assert.Equal(t, model.JobProgress{Done: 3, Failed: 1}, j.Progress)
+	assert.Equal(t, int64(4), j.Checkpoint)
+	assert.Len(t, j.Errors, 1)
+	assert.Contains(t, j.Errors[0], "line 4: invalid: ")
+}
+
+func TestUpdateJobRunner(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	notifyMock := mock.NotifyMock{}
+	u := &handler.UserHandler{Repository: &mongoMock, NotifyHandler: &notifyMock, Logger: logger.ConfigureLogger()}
+
+	filter := &model.Filter{Query: query.In{Field: "country", Values: []string{"UK"}}, Fields: []string{"nickname"}}
+	mongoMock.On("Count", filter).Return(int64(3), nil)
+	mongoMock.On("Stream", filter, mock2.Anything).Return([]model.User{{Nickname: "bob"}, {Nickname: "ann"}, {Nickname: "gone"}}, nil)
+	bob := &model.User{Id: primitive.NewObjectID(), Nickname: "bob", Country: "UK", Email: "bob@test.com",
+		FirstName: "Bob", LastName: "Lee", Password: "hash"}
+	mongoMock.On("FindByNickname", "bob").Return(bob, nil)
+	mongoMock.On("FindByNickname", "ann").Return(&model.User{Nickname: "ann", Country: "BR", Email: "ann@test.com",
+		FirstName: "Ann", LastName: "Lee", Password: "hash"}, nil)
+	mongoMock.On("FindByNickname", "gone").Return((*model.User)(nil), nil)
+	mongoMock.On("FindAndUpdateById", bob.Id, mock2.MatchedBy(func(user *model.User) bool {
+		return user.Country == "BR" && user.Password == "hash" && user.UpdatedBy == "anonymous"
+	})).Return(bob, nil)
+	notifyMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+
+	j := &model.Job{Id: primitive.NewObjectID(), Type: model.JobUpdate,
+		Params: map[string]string{"filter": "country=UK", "set": `{"country":"BR"}`}}
+
+	assert.Nil(t, runJob(t, u, nil, j))
+
+	assert.Equal(t, model.JobProgress{Total: 3, Done: 3, Failed: 1}, j.Progress)
+	assert.Equal(t, []string{"gone: user not found"}, j.Errors)
+	mongoMock.AssertNumberOfCalls(t, "FindAndUpdateById", 1)
+	notifyMock.AssertNumberOfCalls(t, "Publish", 1)
+}
@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/job"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/replay"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNoEndpointReturnsPasswords calls every user endpoint, through the routes of the api, with users holding a
+// password hash, which must never show up in a response.
+func TestNoEndpointReturnsPasswords(t *testing.T) {
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+
+	user := storedUser()
+	user.Password = string(hash)
+
+	mongoMock := mock.MongoMock{}
+	snsMock := mock.NotifyMock{}
+	auditMock := mock.AuditMock{}
+
+	mongoMock.On("FindAllByFilter", mock2.Anything).Return([]model.User{*user}, nil)
+	mongoMock.On("FindByNickname", "test1").Return(user, nil)
+	mongoMock.On("FindByNickname", "created").Return((*model.User)(nil), nil)
+	mongoMock.On("FindById", user.Id).Return(user, nil)
+	mongoMock.On("Search", mock2.Anything).Return([]model.UserHit{{User: *user, Score: 1}}, int64(1), nil)
+	mongoMock.On("Save", mock2.Anything).Return(user, nil)
+	mongoMock.On("FindAndUpdateById", user.Id, mock2.Anything).Return(user, nil)
+	mongoMock.On("Delete", "test1").Return(int64(1), nil)
+	mongoMock.On("DeleteById", user.Id).Return(user, nil)
+	snsMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+	auditMock.On("Record", mock2.Anything).Return(nil)
+	auditMock.On("FindByUser", user.Id, "test1", 1, 20).Return([]model.AuditEntry{
+		{UserId: user.Id, Nickname: "test1", Operation: model.OperationCreate, Changes: []model.FieldChange{model.PasswordChange(false)}},
+	}, int64(1), nil)
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger(), Audit: &auditMock, AdminToken: "secret"}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/users", h.GetAllUsers).Methods("GET")
+	r.HandleFunc("/v1/users", h.SaveUser).Methods("POST")
+	r.HandleFunc("/v1/users/search", h.SearchUsers).Methods("GET")
+	r.HandleFunc("/v1/users/id/{id}", h.GetUserById).Methods("GET")
+	r.HandleFunc("/v1/users/id/{id}", h.UpdateUserById).Methods("PUT")
+	r.HandleFunc("/v1/users/id/{id}", h.PatchUserById).Methods("PATCH")
+	r.HandleFunc("/v1/users/id/{id}", h.DeleteUserById).Methods("DELETE")
+	r.HandleFunc("/v1/users/{nickname}:restore", h.RestoreUser).Methods("POST")
+	r.HandleFunc("/v1/users/{nickname}", h.GetUserByNickname).Methods("GET")
+	r.HandleFunc("/v1/users/{nickname}", h.UpdateUser).Methods("PUT")
+	r.HandleFunc("/v1/users/{nickname}", h.DeleteUserByNickname).Methods("DELETE")
+	r.HandleFunc("/v1/users/{nickname}/history", h.GetUserHistory).Methods("GET")
+
+	body := `{"email":"test@test.com", "country" : "UK", "lastName" : "lastName", "firstName":"firstName", "password":"password", "nickname": "%s"}`
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/v1/users", ""},
+		{"GET", "/v1/users?includeDeleted=true", ""},
+		{"GET", "/v1/users?fields=id,nickname,email", ""},
+		{"GET", "/v1/users/search?q=test1", ""},
+		{"GET", "/v1/users/search?q=te&mode=prefix", ""},
+		{"GET", "/v1/users/test1", ""},
+		{"GET", "/v1/users/id/" + userId, ""},
+		{"GET", "/v1/users/test1/history", ""},
+		{"POST", "/v1/users", strings.Replace(body, "%s", "created", 1)},
+		{"PUT", "/v1/users/test1", strings.Replace(body, "%s", "test1", 1)},
+		{"PUT", "/v1/users/id/" + userId, strings.Replace(body, "%s", "test1", 1)},
+		{"PATCH", "/v1/users/id/" + userId, `{"country":"BR"}`},
+		{"DELETE", "/v1/users/test1", ""},
+		{"DELETE", "/v1/users/id/" + userId, ""},
+	}
+
+	for _, req := range requests {
+		rq, _ := http.NewRequest(req.method, req.path, bytes.NewBufferString(req.body))
+		rq.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+
+		r.ServeHTTP(w, rq)
+
+		assert.Less(t, w.Code, 300, req.method+" "+req.path)
+		assert.NotContains(t, w.Body.String(), string(hash), req.method+" "+req.path)
+		assert.NotContains(t, w.Body.String(), "\"password\":\"", req.method+" "+req.path)
+	}
+}
+
+// hashedUser is a stored user holding the hash of its password, which is returned too.
+func hashedUser() (*model.User, string) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	user := storedUser()
+	user.Password = string(hash)
+	return user, string(hash)
+}
+
+// assertNoPassword checks that the output holds neither the hash nor any of the plain passwords.
+func assertNoPassword(t *testing.T, output string, hash string, name string) {
+	t.Helper()
+	assert.NotContains(t, output, hash, name)
+	assert.NotContains(t, output, "\"password\":\"", name)
+	for _, plain := range []string{"imported-secret", "batched-secret"} {
+		assert.NotContains(t, output, plain, name)
+	}
+}
+
+// TestNoExportImportOrBatchReturnsPasswords exports users holding a password hash, and imports and batches users
+// with passwords, none of which must show up in the exported files or in the results.
+func TestNoExportImportOrBatchReturnsPasswords(t *testing.T) {
+
+	user, hash := hashedUser()
+
+	mongoMock := mock.MongoMock{}
+	auditMock := mock.AuditMock{}
+	snsMock := mock.NotifyMock{}
+
+	mongoMock.On("Stream", mock2.Anything).Return([]model.User{*user}, nil)
+	mongoMock.On("FindNicknames", mock2.Anything).Return([]string{"test1"}, nil)
+	mongoMock.On("SaveMany", mock2.Anything).Return([]error{nil}, nil)
+	mongoMock.On("FindByNickname", "test1").Return(user, nil)
+	mongoMock.On("FindByNickname", "batched").Return((*model.User)(nil), nil)
+	mongoMock.On("Save", mock2.Anything).Return(user, nil)
+	mongoMock.On("FindAndUpdateById", user.Id, mock2.Anything).Return(user, nil)
+	snsMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+	auditMock.On("Record", mock2.Anything).Return(nil)
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger(), Audit: &auditMock,
+		Import: &config.ImportConfig{BatchSize: 10, Workers: 1, MaxBytes: 1 << 20}}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/users:batch", h.BatchUsers).Methods("POST")
+	r.HandleFunc("/v1/users/export", h.ExportUsers).Methods("GET")
+	r.HandleFunc("/v1/users/import", h.ImportUsers).Methods("POST")
+
+	file := "nickname,email,country,firstName,lastName,password\n" +
+		"imported,imported@test.com,UK,First,Last,imported-secret\n" +
+		"invalid,not an email,UK,First,Last,imported-secret\n" +
+		"test1,test@test.com,UK,First,Last,imported-secret\n"
+
+	batch := `{"operations":[
+		{"op":"create","user":{"nickname":"batched","email":"b@test.com","country":"UK","firstName":"B","lastName":"B","password":"batched-secret"}},
+		{"op":"update","nickname":"test1","set":{"password":"batched-secret"}},
+		{"op":"create","user":{"nickname":"test1","email":"b@test.com","country":"UK","firstName":"B","lastName":"B","password":"batched-secret"}}
+	]}`
+
+	requests := []struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+	}{
+		{"GET", "/v1/users/export", "application/x-ndjson", ""},
+		{"GET", "/v1/users/export", "text/csv", ""},
+		{"POST", "/v1/users/import", "text/csv", file},
+		{"POST", "/v1/users/import?dryRun=true", "text/csv", file},
+		{"POST", "/v1/users:batch", "application/json", batch},
+	}
+
+	for _, req := range requests {
+		rq, _ := http.NewRequest(req.method, req.path, bytes.NewBufferString(req.body))
+		rq.Header.Set("Accept", req.contentType)
+		rq.Header.Set("Content-Type", req.contentType)
+		w := httptest.NewRecorder()
+
+		r.ServeHTTP(w, rq)
+
+		assert.Less(t, w.Code, 300, req.method+" "+req.path)
+		assert.Contains(t, w.Body.String(), "test1", req.method+" "+req.path)
+		assertNoPassword(t, w.Body.String(), hash, req.method+" "+req.path)
+	}
+
+}
+
+// TestNoJobResultReturnsPasswords runs export and import jobs on users with passwords, then reads the jobs and the
+// files they produced.
+func TestNoJobResultReturnsPasswords(t *testing.T) {
+
+	user, hash := hashedUser()
+
+	mongoMock := mock.MongoMock{}
+	jobMock := mock.JobMock{}
+	files := mock.NewFileStore()
+
+	mongoMock.On("Count", mock2.Anything).Return(int64(1), nil)
+	mongoMock.On("Stream", mock2.Anything).Return([]model.User{*user}, nil)
+	mongoMock.On("FindNicknames", mock2.Anything).Return([]string{"test1"}, nil)
+	mongoMock.On("SaveMany", mock2.Anything).Return([]error{nil}, nil)
+
+	h := jobHandler(&mongoMock, &jobMock, &mock.JobQueueMock{}, files)
+
+	input := "nickname,email,country,firstName,lastName,password\n" +
+		"imported,imported@test.com,UK,First,Last,imported-secret\n" +
+		"invalid,not an email,UK,First,Last,imported-secret\n" +
+		"test1,test@test.com,UK,First,Last,imported-secret\n"
+
+	jobs := []*model.Job{
+		{Id: primitive.NewObjectID(), Type: model.JobExport, Params: map[string]string{"contentType": "application/x-ndjson"}},
+		{Id: primitive.NewObjectID(), Type: model.JobExport, Params: map[string]string{"contentType": "text/csv"}},
+		{Id: primitive.NewObjectID(), Type: model.JobImport, Input: files.Put(input), Params: map[string]string{"contentType": "text/csv"}},
+	}
+
+	for _, j := range jobs {
+		assert.Nil(t, runJob(t, h.Users, files, j))
+		j.Status = model.JobSucceeded
+		jobMock.On("FindById", j.Id).Return(j, nil)
+
+		r, _ := http.NewRequest("GET", "/v1/jobs/"+j.Id.Hex(), nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		r = mux.SetURLVars(r, map[string]string{"id": j.Id.Hex()})
+		w := httptest.NewRecorder()
+
+		h.GetJob(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assertNoPassword(t, w.Body.String(), hash, j.Type+" job")
+
+		if j.Result == "" {
+			continue
+		}
+
+		w = httptest.NewRecorder()
+		h.GetJobResult(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, w.Body.String())
+		assertNoPassword(t, w.Body.String(), hash, j.Type+" job result "+j.Params["contentType"])
+	}
+}
+
+// TestNoEventOrReplayHoldsPasswords encodes the events of users holding a password hash, published as they change
+// or replayed from the history and from the current users, in every version and mode.
+func TestNoEventOrReplayHoldsPasswords(t *testing.T) {
+
+	user, hash := hashedUser()
+	changes := []model.FieldChange{{Field: "country", Before: "BR", After: "UK"}, model.PasswordChange(true)}
+
+	mongoMock := mock.MongoMock{}
+	auditMock := mock.AuditMock{}
+	notifyMock := mock.NotifyMock{}
+
+	entry := model.AuditEntry{Id: primitive.NewObjectID(), UserId: user.Id, Nickname: "test1", Operation: model.OperationUpdate,
+		Timestamp: time.Now(), Changes: changes}
+
+	mongoMock.On("FindById", user.Id).Return(user, nil)
+	mongoMock.On("Count", mock2.Anything).Return(int64(1), nil)
+	mongoMock.On("Stream", mock2.Anything).Return([]model.User{*user}, nil)
+	auditMock.On("Count", mock2.Anything).Return(int64(1), nil)
+	auditMock.On("Stream", mock2.Anything).Return([]model.AuditEntry{entry}, nil)
+	auditMock.On("FindAfter", mock2.Anything).Return([]model.AuditEntry{}, nil)
+	notifyMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+
+	p := &replay.Replayer{Users: &mongoMock, Audit: &auditMock, Publisher: &notifyMock}
+
+	for _, source := range []string{model.ReplayAudit, model.ReplaySnapshot} {
+		err := p.Run(context.Background(), &model.ReplayRequest{Source: source}, 0, job.NewProgress(&model.Job{}))
+		assert.Nil(t, err, source)
+	}
+
+	published := []*model.User{user}
+	for _, call := range notifyMock.Calls {
+		published = append(published, call.Arguments.Get(0).(*model.User))
+	}
+
+	assert.Len(t, published, 3)
+	assert.Empty(t, published[1].Password, "a user rebuilt from the history")
+
+	for _, version := range []string{"v1", "v2"} {
+		for _, mode := range []string{config.EventModeStructured, config.EventModeBinary} {
+			encoder, err := event.NewEncoder(&config.EventsConfig{Mode: mode, Source: "/user-api", SchemaUrl: "https://schemas", Version: version})
+			assert.Nil(t, err)
+
+			for _, u := range published {
+				ev, err := encoder.UserUpdated(u, changes)
+				assert.Nil(t, err)
+
+				body, _, err := encoder.Message(ev)
+				assert.Nil(t, err)
+				assertNoPassword(t, string(body), hash, version+" "+mode)
+			}
+		}
+	}
+}
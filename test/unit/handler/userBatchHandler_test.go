@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const batchBody = `{"atomic":%t,"operations":[
+	{"op":"create","user":{"nickname":"new","email":"new@test.com","country":"UK","firstName":"New","lastName":"User","password":"secret"}},
+	{"op":"update","nickname":"test1","set":{"country":"BR"}},
+	{"op":"delete","nickname":"missing"},
+	{"op":"create","user":{"nickname":"test1","email":"test@test.com","country":"UK","firstName":"New","lastName":"User","password":"secret"}}
+]}`
+
+func batchRequest(atomic bool) *http.Request {
+	r, _ := http.NewRequest("POST", "/v1/users:batch", bytes.NewBufferString(fmt.Sprintf(batchBody, atomic)))
+	return r
+}
+
+func batchResponse(t *testing.T, w *httptest.ResponseRecorder) model.BatchResponse {
+	var response model.BatchResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func statuses(response model.BatchResponse) []int {
+	s := make([]int, 0, len(response.Results))
+	for _, result := range response.Results {
+		s = append(s, result.Status)
+	}
+	return s
+}
+
+func batchMocks() (*mock.MongoMock, *mock.NotifyMock) {
+	var notFound *model.User
+	mongoMock := &mock.MongoMock{}
+	mongoMock.On("FindByNickname", "new").Return(notFound, nil)
+	mongoMock.On("FindByNickname", "missing").Return(notFound, nil)
+	mongoMock.On("FindByNickname", "test1").Return(storedUser(), nil)
+	mongoMock.On("Save", mock2.Anything).Return(storedUser(), nil)
+	mongoMock.On("FindAndUpdateById", storedUser().Id, mock2.MatchedBy(func(user *model.User) bool {
+		return user.Country == "BR" && user.Email == "test@test.com" && user.Password == "hash"
+	})).Return(storedUser(), nil)
+
+	notifyMock := &mock.NotifyMock{}
+	notifyMock.On("Publish", mock2.MatchedBy(func(user *model.User) bool { return user.Nickname == "test1" }), mock2.Anything).Return(nil)
+
+	return mongoMock, notifyMock
+}
+
+func TestBatchUsersRunsEveryOperation(t *testing.T) {
+	mongoMock, notifyMock := batchMocks()
+	h := handler.UserHandler{Repository: mongoMock, NotifyHandler: notifyMock, Logger: logger.ConfigureLogger()}
+
+	w := httptest.NewRecorder()
+	h.BatchUsers(w, batchRequest(false))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	response := batchResponse(t, w)
+	assert.Equal(t, []int{201, 200, 404, 409}, statuses(response))
+	assert.True(t, response.Committed)
+	assert.Equal(t, 2, response.Succeeded)
+	assert.Equal(t, 2, response.Failed)
+	assert.Len(t, response.Results[0].Id, 24)
+	assert.Equal(t, "user with nick name test1 already exist!", response.Results[3].Error)
+	// only the update is notified, the create isn't
+	notifyMock.AssertNumberOfCalls(t, "Publish", 1)
+	mongoMock.AssertNotCalled(t, "InTransaction")
+}
+
+func TestBatchUsersAtomicRollsBack(t *testing.T) {
+	mongoMock, notifyMock := batchMocks()
+	h := handler.UserHandler{Repository: mongoMock, NotifyHandler: notifyMock, Logger: logger.ConfigureLogger()}
+
+	w := httptest.NewRecorder()
+	h.BatchUsers(w, batchRequest(true))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	response := batchResponse(t, w)
+	assert.Equal(t, []int{424, 424, 404, 424}, statuses(response))
+	assert.False(t, response.Committed)
+	assert.Equal(t, "rolled back, operation 2 failed", response.Results[0].Error)
+	assert.Equal(t, "not run, operation 2 failed", response.Results[3].Error)
+	assert.Equal(t, 4, response.Failed)
+	notifyMock.AssertNotCalled(t, "Publish", mock2.Anything, mock2.Anything)
+}
+
+func TestBatchUsersAtomicCommits(t *testing.T) {
+	mongoMock, notifyMock := batchMocks()
+	mongoMock.On("InTransaction").Return(nil)
+	h := handler.UserHandler{Repository: mongoMock, NotifyHandler: notifyMock, Logger: logger.ConfigureLogger()}
+
+	body := `{"atomic":true,"operations":[{"op":"update","nickname":"test1","set":{"country":"BR"}},{"op":"delete","nickname":"test1"}]}`
+	mongoMock.On("Delete", "test1").Return(int64(1), nil)
+
+	r, _ := http.NewRequest("POST", "/v1/users:batch", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	h.BatchUsers(w, r)
+
+	response := batchResponse(t, w)
+	assert.Equal(t, []int{200, 204}, statuses(response))
+	assert.True(t, response.Committed)
+	notifyMock.AssertNumberOfCalls(t, "Publish", 1)
+}
+
+func TestBatchUsersTransactionError(t *testing.T) {
+	mongoMock, notifyMock := batchMocks()
+	mongoMock.On("InTransaction").Return(errors.New("transactions need a replica set"))
+	h := handler.UserHandler{Repository: mongoMock, NotifyHandler: notifyMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("POST", "/v1/users:batch", bytes.NewBufferString(`{"atomic":true,"operations":[{"op":"update","nickname":"test1","set":{"country":"BR"}}]}`))
+	w := httptest.NewRecorder()
+	h.BatchUsers(w, r)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	notifyMock.AssertNotCalled(t, "Publish", mock2.Anything, mock2.Anything)
+}
+
+func TestBatchUsersInvalid(t *testing.T) {
+	h := handler.UserHandler{Repository: &mock.MongoMock{}, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	cases := map[string]string{
+		`{"operations":[]}`:                               "the batch has no operations",
+		`{"operations":[{"op":"restore"}]}`:               "operation 0: unknown op restore, it must be create, update or delete",
+		`{"operations":[{"op":"update","nickname":"a"}]}`: "operation 0: the nickname and the fields to set are required",
+		`{"operations":[{"op":"delete"}]}`:                "operation 0: the nickname is required",
+		`{"operations":[{"op":"create"}]}`:                "operation 0: the user to create is required",
+	}
+
+	for body, description := range cases {
+		r, _ := http.NewRequest("POST", "/v1/users:batch", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+
+		h.BatchUsers(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Equal(t, "{\"description\":\""+description+"\"}", w.Body.String(), body)
+	}
+}
+
+func TestBatchUsersReservedNicknames(t *testing.T) {
+	mongoMock, notifyMock := batchMocks()
+	h := handler.UserHandler{Repository: mongoMock, NotifyHandler: notifyMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("POST", "/v1/users:batch", bytes.NewBufferString(`{"operations":[
+		{"op":"create","user":{"nickname":"export","email":"new@test.com","country":"UK","firstName":"New","lastName":"User","password":"secret"}},
+		{"op":"update","nickname":"test1","set":{"nickname":"import"}}
+	]}`))
+	w := httptest.NewRecorder()
+
+	h.BatchUsers(w, r)
+
+	response := batchResponse(t, w)
+	assert.Equal(t, []int{400, 400}, statuses(response))
+	assert.Equal(t, "nickname export is reserved", response.Results[0].Error)
+	assert.Equal(t, "nickname import is reserved", response.Results[1].Error)
+	mongoMock.AssertNotCalled(t, "Save", mock2.Anything)
+	mongoMock.AssertNotCalled(t, "FindAndUpdateById", mock2.Anything, mock2.Anything)
+}
+
+func TestBatchUsersUnchangedUpdateIsNotWritten(t *testing.T) {
+	mongoMock, notifyMock := batchMocks()
+	auditMock := &mock.AuditMock{}
+	h := handler.UserHandler{Repository: mongoMock, NotifyHandler: notifyMock, Logger: logger.ConfigureLogger(), Audit: auditMock}
+
+	body := `{"operations":[{"op":"update","nickname":"test1","set":{"country":"UK"}}]}`
+
+	r, _ := http.NewRequest("POST", "/v1/users:batch", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	h.BatchUsers(w, r)
+
+	assert.Equal(t, []int{200}, statuses(batchResponse(t, w)))
+	mongoMock.AssertNotCalled(t, "FindAndUpdateById", mock2.Anything, mock2.Anything)
+	auditMock.AssertNotCalled(t, "Record", mock2.Anything)
+	notifyMock.AssertNotCalled(t, "Publish", mock2.Anything, mock2.Anything)
+}
+
+func TestBatchUsersUpdateDiffsAgainstTheUserWritten(t *testing.T) {
+	mongoMock := &mock.MongoMock{}
+	auditMock := &mock.AuditMock{}
+	notifyMock := &mock.NotifyMock{}
+	h := handler.UserHandler{Repository: mongoMock, NotifyHandler: notifyMock, Logger: logger.ConfigureLogger(), Audit: auditMock}
+
+	// another request set the same country between the read and the write
+	written := storedUser()
+	written.Country = "BR"
+
+	mongoMock.On("FindByNickname", "test1").Return(storedUser(), nil)
+	mongoMock.On("FindAndUpdateById", storedUser().Id, mock2.Anything).Return(written, nil)
+
+	body := `{"operations":[{"op":"update","nickname":"test1","set":{"country":"BR"}}]}`
+
+	r, _ := http.NewRequest("POST", "/v1/users:batch", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	h.BatchUsers(w, r)
+
+	assert.Equal(t, []int{200}, statuses(batchResponse(t, w)))
+	auditMock.AssertNotCalled(t, "Record", mock2.Anything)
+	notifyMock.AssertNotCalled(t, "Publish", mock2.Anything, mock2.Anything)
+}
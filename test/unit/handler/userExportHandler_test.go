@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"compress/gzip"
+	"errors"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/query"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func exportedUsers() []model.User {
+	created := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	first := storedUser()
+	first.CreatedAt = &created
+	second := storedUser()
+	second.Nickname = "test2"
+	second.LastName = "O'Neil, Jr"
+	return []model.User{*first, *second}
+}
+
+func TestExportUsersNdjson(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/export?country=UK", nil)
+	w := httptest.NewRecorder()
+
+	mongoMock.On("Stream", &model.Filter{Query: countryUK()}).Return(exportedUsers(), nil)
+	h.ExportUsers(w, r)
+
+	res := w.Result()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+	assert.Equal(t, "{\"id\":\""+userId+"\",\"email\":\"test@test.com\",\"country\":\"UK\",\"nickname\":\"test1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\",\"createdAt\":\"2020-07-01T10:00:00Z\"}\n"+
+		"{\"id\":\""+userId+"\",\"email\":\"test@test.com\",\"country\":\"UK\",\"nickname\":\"test2\",\"lastName\":\"O'Neil, Jr\",\"firstName\":\"firstName\"}\n", w.Body.String())
+	assert.Equal(t, "2", res.Trailer.Get(handler.RecordCountTrailer))
+	assert.NotContains(t, w.Body.String(), "hash")
+}
+
+func TestExportUsersCsvFields(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/export?fields=nickname,lastName,createdAt", nil)
+	r.Header.Set("Accept", "application/json;q=0.9, text/csv")
+	w := httptest.NewRecorder()
+
+	mongoMock.On("Stream", &model.Filter{Fields: []string{"nickname", "lastName", "createdAt"}}).Return(exportedUsers(), nil)
+	h.ExportUsers(w, r)
+
+	res := w.Result()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/csv", res.Header.Get("Content-Type"))
+	assert.Equal(t, "nickname,lastName,createdAt\ntest1,lastName,2020-07-01T10:00:00Z\ntest2,\"O'Neil, Jr\",\n", w.Body.String())
+	assert.Equal(t, "2", res.Trailer.Get(handler.RecordCountTrailer))
+}
+
+func TestExportUsersGzip(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/export?fields=nickname", nil)
+	r.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+	w := httptest.NewRecorder()
+
+	mongoMock.On("Stream", &model.Filter{Fields: []string{"nickname"}}).Return(exportedUsers(), nil)
+	h.ExportUsers(w, r)
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	gz, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	body, err := ioutil.ReadAll(gz)
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"nickname\":\"test1\"}\n{\"nickname\":\"test2\"}\n", string(body))
+}
+
+func TestExportUsersEmpty(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/export?fields=nickname,email", nil)
+	r.Header.Set("Accept", "text/*")
+	w := httptest.NewRecorder()
+
+	mongoMock.On("Stream", &model.Filter{Fields: []string{"nickname", "email"}}).Return([]model.User{}, nil)
+	h.ExportUsers(w, r)
+
+	res := w.Result()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "nickname,email\n", w.Body.String())
+	assert.Equal(t, "0", res.Trailer.Get(handler.RecordCountTrailer))
+}
+
+func TestExportUsersNotAcceptable(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/export", nil)
+	r.Header.Set("Accept", "application/xml, text/csv;q=0")
+	w := httptest.NewRecorder()
+
+	h.ExportUsers(w, r)
+
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Equal(t, "{\"description\":\"users can only be exported as application/x-ndjson or text/csv\"}", w.Body.String())
+}
+
+func TestExportUsersErrorBeforeFirstUser(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/export", nil)
+	w := httptest.NewRecorder()
+
+	mongoMock.On("Stream", &model.Filter{}).Return([]model.User{}, errors.New("error on mongo"))
+	h.ExportUsers(w, r)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "{\"description\":\"error on mongo\"}", w.Body.String())
+}
+
+func TestExportUsersErrorMidway(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/export?fields=nickname", nil)
+	w := httptest.NewRecorder()
+
+	mongoMock.On("Stream", &model.Filter{Fields: []string{"nickname"}}).Return(exportedUsers(), errors.New("cursor killed"))
+	h.ExportUsers(w, r)
+
+	res := w.Result()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "{\"nickname\":\"test1\"}\n{\"nickname\":\"test2\"}\n", w.Body.String())
+	assert.Equal(t, "2", res.Trailer.Get(handler.RecordCountTrailer))
+	assert.Equal(t, "cursor killed", res.Trailer.Get(handler.ExportErrorTrailer))
+}
+
+func countryUK() query.Node {
+	return query.In{Field: "country", Values: []string{"UK"}}
+}
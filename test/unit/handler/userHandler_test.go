@@ -3,17 +3,24 @@ package handler
 import (
 	"bytes"
 	"errors"
+	"github.com/bernardoms/user-api/internal/cache"
 	"github.com/bernardoms/user-api/internal/handler"
 	"github.com/bernardoms/user-api/internal/logger"
 	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/query"
+	"github.com/bernardoms/user-api/internal/repository"
 	"github.com/bernardoms/user-api/test/unit/mock"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	mock2 "github.com/stretchr/testify/mock"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetAllUsersSuccessNoFilter(t *testing.T) {
@@ -39,7 +46,7 @@ func TestGetAllUsersSuccessNoFilter(t *testing.T) {
 	h.GetAllUsers(w, r)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, "[{\"email\":\"test@test.com\",\"country\":\"UK\",\"nickname\":\"test1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\",\"password\":\"password\"},{\"email\":\"test2@test.com\",\"country\":\"UK\",\"nickname\":\"test1\",\"lastName\":\"lastName2\",\"firstName\":\"firstName2\",\"password\":\"password2\"}]", w.Body.String())
+	assert.Equal(t, "[{\"id\":\"5ea7208049e00ddb76994ede\",\"email\":\"test@test.com\",\"country\":\"UK\",\"nickname\":\"test1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\"},{\"id\":\"5ea7208049e00ddb76994eda\",\"email\":\"test2@test.com\",\"country\":\"UK\",\"nickname\":\"test1\",\"lastName\":\"lastName2\",\"firstName\":\"firstName2\"}]", w.Body.String())
 }
 
 func TestGetAllUsersSuccessFilters(t *testing.T) {
@@ -50,11 +57,13 @@ func TestGetAllUsersSuccessFilters(t *testing.T) {
 
 	filter := new(model.Filter)
 
-	filter.Nickname = "test1"
-	filter.FirstName = "firstName"
-	filter.LastName = "lastName"
-	filter.Country = "UK"
-	filter.Email = "test@test.com"
+	filter.Query = query.And{Nodes: []query.Node{
+		query.In{Field: "nickname", Values: []string{"test1"}},
+		query.In{Field: "email", Values: []string{"test@test.com"}},
+		query.In{Field: "firstName", Values: []string{"firstName"}},
+		query.In{Field: "lastName", Values: []string{"lastName"}},
+		query.In{Field: "country", Values: []string{"UK"}},
+	}}
 
 	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
 
@@ -72,7 +81,7 @@ func TestGetAllUsersSuccessFilters(t *testing.T) {
 	h.GetAllUsers(w, r)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, "[{\"email\":\"test@test.com\",\"country\":\"UK\",\"nickname\":\"test1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\",\"password\":\"password\"}]", w.Body.String())
+	assert.Equal(t, "[{\"id\":\"5ea7208049e00ddb76994ede\",\"email\":\"test@test.com\",\"country\":\"UK\",\"nickname\":\"test1\",\"lastName\":\"lastName\",\"firstName\":\"firstName\"}]", w.Body.String())
 }
 
 func TestGetAllUsersErrorOnMongo(t *testing.T) {
@@ -83,11 +92,13 @@ func TestGetAllUsersErrorOnMongo(t *testing.T) {
 
 	filter := new(model.Filter)
 
-	filter.Nickname = "test1"
-	filter.FirstName = "firstName"
-	filter.LastName = "lastName"
-	filter.Country = "UK"
-	filter.Email = "test@test.com"
+	filter.Query = query.And{Nodes: []query.Node{
+		query.In{Field: "nickname", Values: []string{"test1"}},
+		query.In{Field: "email", Values: []string{"test@test.com"}},
+		query.In{Field: "firstName", Values: []string{"firstName"}},
+		query.In{Field: "lastName", Values: []string{"lastName"}},
+		query.In{Field: "country", Values: []string{"UK"}},
+	}}
 
 	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
 
@@ -108,6 +119,206 @@ func TestGetAllUsersErrorOnMongo(t *testing.T) {
 	assert.Equal(t, "{\"description\":\"error on mongo\"}", w.Body.String())
 }
 
+func TestGetAllUsersCachingHeaders(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users?country=UK", nil)
+	w := httptest.NewRecorder()
+
+	older := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	newer := time.Date(2020, 7, 2, 10, 0, 0, 0, time.UTC)
+
+	var users = []model.User{
+		{Nickname: "test1", Country: "UK", UpdatedAt: &newer},
+		{Nickname: "test2", Country: "UK", UpdatedAt: &older},
+	}
+
+	mongoMock.On("FindAllByFilter", &model.Filter{Query: query.In{Field: "country", Values: []string{"UK"}}}).Return(users, nil)
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "", w.Header().Get("Last-Modified"))
+	assert.Regexp(t, "^\"[0-9a-f]{32}\"$", w.Header().Get("ETag"))
+}
+
+func TestGetAllUsersNotModifiedByETag(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	var users = []model.User{{Nickname: "test1", Country: "UK"}}
+
+	mongoMock.On("FindAllByFilter", &model.Filter{}).Return(users, nil)
+
+	r, _ := http.NewRequest("GET", "/v1/users", nil)
+	first := httptest.NewRecorder()
+	h.GetAllUsers(first, r)
+
+	r, _ = http.NewRequest("GET", "/v1/users", nil)
+	r.Header.Set("If-None-Match", "\"other\", W/"+first.Header().Get("ETag"))
+	w := httptest.NewRecorder()
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, first.Header().Get("ETag"), w.Header().Get("ETag"))
+	assert.Equal(t, "", w.Body.String())
+}
+
+func TestGetAllUsersModifiedETag(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	updatedAt := time.Date(2020, 7, 2, 10, 0, 0, 0, time.UTC)
+
+	var users = []model.User{{Nickname: "test1", Country: "UK", UpdatedAt: &updatedAt}}
+
+	mongoMock.On("FindAllByFilter", &model.Filter{}).Return(users, nil)
+
+	r, _ := http.NewRequest("GET", "/v1/users", nil)
+	r.Header.Set("If-None-Match", "\"stale\"")
+	r.Header.Set("If-Modified-Since", "Fri, 03 Jul 2020 10:00:00 GMT")
+	w := httptest.NewRecorder()
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "\"nickname\":\"test1\"")
+}
+
+func TestGetAllUsersIgnoresIfModifiedSince(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	updatedAt := time.Date(2020, 7, 2, 10, 0, 0, 0, time.UTC)
+
+	// another user, updated later, was deleted since the previous response: the list changed without getting newer
+	var users = []model.User{{Nickname: "test1", Country: "UK", UpdatedAt: &updatedAt}}
+
+	mongoMock.On("FindAllByFilter", &model.Filter{}).Return(users, nil)
+
+	r, _ := http.NewRequest("GET", "/v1/users", nil)
+	r.Header.Set("If-Modified-Since", "Fri, 03 Jul 2020 10:00:00 GMT")
+	w := httptest.NewRecorder()
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "\"nickname\":\"test1\"")
+}
+
+func TestGetAllUsersTimeRangeFilters(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users?updatedSince=2020-07-01T00:00:00Z&createdBefore=2020-06-01T00:00:00Z", nil)
+	w := httptest.NewRecorder()
+
+	updatedSince := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	createdBefore := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	mongoMock.On("FindAllByFilter", &model.Filter{UpdatedSince: &updatedSince, CreatedBefore: &createdBefore}).Return([]model.User{}, nil)
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]", w.Body.String())
+}
+
+func TestGetAllUsersInvalidTimeRangeFilter(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users?updatedSince=yesterday", nil)
+	w := httptest.NewRecorder()
+
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid query parameters")
+	mongoMock.AssertNotCalled(t, "FindAllByFilter", mock2.Anything)
+}
+
+func TestGetAllUsersFilterExpression(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users?country=!UK&filter="+url.QueryEscape("nickname=te* or lastName=Lee,Kim"), nil)
+	w := httptest.NewRecorder()
+
+	filter := &model.Filter{Query: query.And{Nodes: []query.Node{
+		query.Not{Node: query.In{Field: "country", Values: []string{"UK"}}},
+		query.Or{Nodes: []query.Node{
+			query.Prefix{Field: "nickname", Value: "te"},
+			query.In{Field: "lastName", Values: []string{"Lee", "Kim"}},
+		}},
+	}}}
+
+	mongoMock.On("FindAllByFilter", filter).Return([]model.User{}, nil)
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]", w.Body.String())
+}
+
+func TestGetAllUsersInvalidFilterExpression(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users?filter="+url.QueryEscape("country=UK and (nickname=bob"), nil)
+	w := httptest.NewRecorder()
+
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"description\":\"invalid query parameters: parameter filter: expected ) at position 29, the end of the filter\"}", w.Body.String())
+	mongoMock.AssertNotCalled(t, "FindAllByFilter", mock2.Anything)
+}
+
+func TestGetAllUsersFilterTooDeep(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users?filter="+url.QueryEscape(strings.Repeat("not ", 200)+"country=UK"), nil)
+	w := httptest.NewRecorder()
+
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "nested deeper than 32 levels")
+	mongoMock.AssertNotCalled(t, "FindAllByFilter", mock2.Anything)
+}
+
 func TestGetUserByNickNameSuccess(t *testing.T) {
 
 	mongoMock := mock.MongoMock{}
@@ -121,6 +332,7 @@ func TestGetUserByNickNameSuccess(t *testing.T) {
 	user.LastName = "lastName"
 	user.FirstName = "firstName"
 	user.Password = "password"
+	user.Id, _ = primitive.ObjectIDFromHex("5ea7208049e00ddb76994ede")
 
 	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
 
@@ -138,7 +350,34 @@ func TestGetUserByNickNameSuccess(t *testing.T) {
 	h.GetUserByNickname(w, r)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, "{\"email\":\"test@test.com\",\"country\":\"UK\",\"nickname\":\"\",\"lastName\":\"lastName\",\"firstName\":\"firstName\",\"password\":\"password\"}", w.Body.String())
+	assert.Equal(t, "{\"id\":\"5ea7208049e00ddb76994ede\",\"email\":\"test@test.com\",\"country\":\"UK\",\"nickname\":\"\",\"lastName\":\"lastName\",\"firstName\":\"firstName\"}", w.Body.String())
+}
+
+func TestGetUserByNickNameFromCache(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	user := &model.User{Email: "test@test.com", Country: "UK", Nickname: "testnickname", LastName: "lastName", FirstName: "firstName", Password: "password"}
+	user.Id, _ = primitive.ObjectIDFromHex("5ea7208049e00ddb76994ede")
+
+	cached := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+
+	h := handler.UserHandler{Repository: cached, Cache: cached, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	mongoMock.On("FindByNickname", "testnickname").Return(user, nil)
+
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", "/v1/users/testnickname", nil)
+		r = mux.SetURLVars(r, map[string]string{"nickname": "testnickname"})
+		w := httptest.NewRecorder()
+
+		h.GetUserByNickname(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "{\"id\":\"5ea7208049e00ddb76994ede\",\"email\":\"test@test.com\",\"country\":\"UK\",\"nickname\":\"testnickname\",\"lastName\":\"lastName\",\"firstName\":\"firstName\"}", w.Body.String())
+	}
+
+	mongoMock.AssertNumberOfCalls(t, "FindByNickname", 1)
 }
 
 func TestGetUserByNickNameErrorOnMongo(t *testing.T) {
@@ -219,7 +458,57 @@ func TestDeleteUserByNickName(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	mongoMock.On("Delete", "testnickname").Return(nil)
+	mongoMock.On("Delete", "testnickname").Return(int64(1), nil)
+	h.DeleteUserByNickname(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "", w.Body.String())
+}
+
+func TestDeleteUserByNickNameNotFound(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("DELETE", "/v1/users", nil)
+
+	vars := map[string]string{
+		"nickname": "testnickname",
+	}
+
+	r = mux.SetURLVars(r, vars)
+
+	w := httptest.NewRecorder()
+
+	mongoMock.On("Delete", "testnickname").Return(int64(0), nil)
+	h.DeleteUserByNickname(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "{\"description\":\"user with nickname testnickname not found!\"}", w.Body.String())
+}
+
+func TestDeleteUserByNickNameNotFoundIdempotent(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("DELETE", "/v1/users?idempotent=true", nil)
+
+	vars := map[string]string{
+		"nickname": "testnickname",
+	}
+
+	r = mux.SetURLVars(r, vars)
+
+	w := httptest.NewRecorder()
+
+	mongoMock.On("Delete", "testnickname").Return(int64(0), nil)
 	h.DeleteUserByNickname(w, r)
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
@@ -244,7 +533,7 @@ func TestDeleteUserByNickNameErrorOnMongo(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	mongoMock.On("Delete", "testnickname").Return(errors.New("error on mongo"))
+	mongoMock.On("Delete", "testnickname").Return(int64(0), errors.New("error on mongo"))
 	h.DeleteUserByNickname(w, r)
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
@@ -266,6 +555,7 @@ func TestSaveUserSuccess(t *testing.T) {
 	user.FirstName = "firstName"
 	user.Password = "password"
 	user.Nickname = "testnickname"
+	user.Id, _ = primitive.ObjectIDFromHex("5ea7208049e00ddb76994ede")
 
 	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
 
@@ -281,7 +571,34 @@ func TestSaveUserSuccess(t *testing.T) {
 
 	assert.Equal(t, http.StatusCreated, w.Code)
 	assert.Equal(t, "", w.Body.String())
-	assert.Equal(t, "v1/users/testnickname", w.Header().Get("Location"))
+	assert.Equal(t, "v1/users/id/5ea7208049e00ddb76994ede", w.Header().Get("Location"))
+}
+
+func TestSaveUserSetsPrincipal(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	var notFoundNick *model.User
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	jsonStr := []byte(`{"email":"test@test.com", "country" : "UK", "lastName" : "lastName", "firstName":"firstName", "password":"password", "nickname": "testnickname", "createdBy": "someone-else"}`)
+
+	r, _ := http.NewRequest("POST", "/v1/users", bytes.NewBuffer(jsonStr))
+	r.Header.Set(handler.PrincipalHeader, "backoffice")
+
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "testnickname").Return(notFoundNick, nil)
+	mongoMock.On("Save", mock2.MatchedBy(func(u *model.User) bool {
+		return u.CreatedBy == "backoffice" && u.UpdatedBy == "backoffice"
+	})).Return(&model.User{Nickname: "testnickname"}, nil)
+	handler.WithPrincipal(http.HandlerFunc(h.SaveUser)).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	mongoMock.AssertExpectations(t)
 }
 
 func TestSaveValidationError(t *testing.T) {
@@ -313,7 +630,7 @@ func TestSaveValidationError(t *testing.T) {
 	h.SaveUser(w, r)
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
-	assert.Equal(t, "{\"description\":\"Key: 'User.Email' Error:Field validation for 'Email' failed on the 'required' tag\\nKey: 'User.Country' Error:Field validation for 'Country' failed on the 'required' tag\\nKey: 'User.Nickname' Error:Field validation for 'Nickname' failed on the 'required' tag\\nKey: 'User.LastName' Error:Field validation for 'LastName' failed on the 'required' tag\\nKey: 'User.FirstName' Error:Field validation for 'FirstName' failed on the 'required' tag\\nKey: 'User.Password' Error:Field validation for 'Password' failed on the 'required' tag\"}", w.Body.String())
+	assert.Equal(t, "{\"description\":\"Key: 'UserRequest.Email' Error:Field validation for 'Email' failed on the 'required' tag\\nKey: 'UserRequest.Country' Error:Field validation for 'Country' failed on the 'required' tag\\nKey: 'UserRequest.Nickname' Error:Field validation for 'Nickname' failed on the 'required' tag\\nKey: 'UserRequest.LastName' Error:Field validation for 'LastName' failed on the 'required' tag\\nKey: 'UserRequest.FirstName' Error:Field validation for 'FirstName' failed on the 'required' tag\\nKey: 'UserRequest.Password' Error:Field validation for 'Password' failed on the 'required' tag\"}", w.Body.String())
 }
 
 func TestSaveUserErrorOnFindNickName(t *testing.T) {
@@ -380,6 +697,41 @@ func TestSaveUserErrorOnSave(t *testing.T) {
 	assert.Equal(t, "{\"description\":\"error on mongo\"}", w.Body.String())
 }
 
+func TestSaveUserMalformedBody(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("POST", "/v1/users", bytes.NewBuffer([]byte(`{"email":`)))
+	w := httptest.NewRecorder()
+
+	h.SaveUser(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mongoMock.AssertNotCalled(t, "Save", mock2.Anything)
+}
+
+func TestSaveUserReservedNickname(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	for _, nickname := range model.ReservedNicknames {
+		jsonStr := []byte(`{"email":"test@test.com", "country" : "UK", "lastName" : "lastName", "firstName":"firstName", "password":"password", "nickname": "` + nickname + `"}`)
+
+		r, _ := http.NewRequest("POST", "/v1/users", bytes.NewBuffer(jsonStr))
+		w := httptest.NewRecorder()
+
+		h.SaveUser(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, nickname)
+		assert.Equal(t, "{\"description\":\"nickname "+nickname+" is reserved\"}", w.Body.String())
+	}
+	mongoMock.AssertNotCalled(t, "Save", mock2.Anything)
+}
+
 func TestSaveUserNickNameAlreadyExists(t *testing.T) {
 
 	mongoMock := mock.MongoMock{}
@@ -438,14 +790,46 @@ func TestUpdateUserSuccess(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	mongoMock.On("UpdateByNickname", "testnickname", mock2.Anything).Return(int64(1), nil)
-	snsMock.On("Publish", mock2.Anything).Return(nil)
+	stored := storedUser()
+	stored.Nickname = "testnickname"
+	mongoMock.On("FindByNickname", "testnickname").Return(stored, nil)
+	mongoMock.On("FindAndUpdateById", stored.Id, mock2.Anything).Return(stored, nil)
+	snsMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
 	h.UpdateUser(w, r)
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
 	assert.Equal(t, "", w.Body.String())
 }
 
+func TestUpdateUserSetsAnonymousPrincipal(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	jsonStr := []byte(`{"email":"test@test.com", "country" : "UK", "lastName" : "lastName", "firstName":"firstName", "password":"password", "nickname": "testnickname"}`)
+
+	r, _ := http.NewRequest("PUT", "/v1/users", bytes.NewBuffer(jsonStr))
+
+	r = mux.SetURLVars(r, map[string]string{"nickname": "testnickname"})
+
+	w := httptest.NewRecorder()
+
+	stored := storedUser()
+	stored.Nickname = "testnickname"
+	mongoMock.On("FindByNickname", "testnickname").Return(stored, nil)
+	mongoMock.On("FindAndUpdateById", stored.Id, mock2.MatchedBy(func(u *model.User) bool {
+		return u.UpdatedBy == "anonymous"
+	})).Return(stored, nil)
+	snsMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+	handler.WithPrincipal(http.HandlerFunc(h.UpdateUser)).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mongoMock.AssertExpectations(t)
+}
+
 func TestUpdateUserMongoErrorOnUpdate(t *testing.T) {
 
 	mongoMock := mock.MongoMock{}
@@ -474,14 +858,41 @@ func TestUpdateUserMongoErrorOnUpdate(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	mongoMock.On("UpdateByNickname", "testnickname", mock2.Anything).Return(int64(0), errors.New("mongo error"))
-	snsMock.On("Publish", mock2.Anything).Return(nil)
+	stored := storedUser()
+	stored.Nickname = "testnickname"
+	mongoMock.On("FindByNickname", "testnickname").Return(stored, nil)
+	mongoMock.On("FindAndUpdateById", stored.Id, mock2.Anything).Return((*model.User)(nil), errors.New("mongo error"))
+	snsMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
 	h.UpdateUser(w, r)
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 	assert.Equal(t, "{\"description\":\"mongo error\"}", w.Body.String())
 }
 
+func TestUpdateUserInvalid(t *testing.T) {
+
+	bodies := []string{
+		`{"email":"test@test.com","country":"UK","nickname":"","lastName":"lastName","firstName":"firstName","password":"password"}`,
+		`{"email":"test@test.com","country":"UK","nickname":"testnickname","lastName":"lastName","firstName":"firstName"}`,
+		`not json`,
+	}
+
+	for _, body := range bodies {
+		mongoMock := mock.MongoMock{}
+
+		h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+		r, _ := http.NewRequest("PUT", "/v1/users/testnickname", bytes.NewBuffer([]byte(body)))
+		r = mux.SetURLVars(r, map[string]string{"nickname": "testnickname"})
+		w := httptest.NewRecorder()
+
+		h.UpdateUser(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		mongoMock.AssertNotCalled(t, "FindAndUpdateById", mock2.Anything, mock2.Anything)
+	}
+}
+
 func TestUpdateUserNotifyError(t *testing.T) {
 
 	mongoMock := mock.MongoMock{}
@@ -510,8 +921,11 @@ func TestUpdateUserNotifyError(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	mongoMock.On("UpdateByNickname", "testnickname", mock2.Anything).Return(int64(1), nil)
-	snsMock.On("Publish", mock2.Anything).Return(errors.New("error on notify"))
+	stored := storedUser()
+	stored.Nickname = "testnickname"
+	mongoMock.On("FindByNickname", "testnickname").Return(stored, nil)
+	mongoMock.On("FindAndUpdateById", stored.Id, mock2.Anything).Return(stored, nil)
+	snsMock.On("Publish", mock2.Anything, mock2.Anything).Return(errors.New("error on notify"))
 	h.UpdateUser(w, r)
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
@@ -546,10 +960,131 @@ func TestUpdateUserSuccessWithoutNotify(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	mongoMock.On("UpdateByNickname", "testnickname", mock2.Anything).Return(int64(0), nil)
-	snsMock.AssertNotCalled(t, mock2.Anything)
+	mongoMock.On("FindByNickname", "testnickname").Return((*model.User)(nil), nil)
 	h.UpdateUser(w, r)
+	snsMock.AssertNotCalled(t, "Publish", mock2.Anything, mock2.Anything)
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
 	assert.Equal(t, "", w.Body.String())
 }
+
+func TestUpdateUserUnchangedIsNotNotified(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	auditMock := mock.AuditMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger(), Audit: &auditMock}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	before := &model.User{Nickname: "test1", Email: "test@test.com", Country: "UK", LastName: "lastName", FirstName: "firstName", Password: string(hash)}
+
+	jsonStr := []byte(`{"email":"test@test.com", "country" : "UK", "lastName" : "lastName", "firstName":"firstName", "password":"password", "nickname": "test1"}`)
+
+	r, _ := http.NewRequest("PUT", "/v1/users/test1", bytes.NewBuffer(jsonStr))
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(before, nil)
+	h.UpdateUser(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mongoMock.AssertNotCalled(t, "FindAndUpdateById", mock2.Anything, mock2.Anything)
+	auditMock.AssertNotCalled(t, "Record", mock2.Anything)
+	snsMock.AssertNotCalled(t, "Publish", mock2.Anything, mock2.Anything)
+}
+
+func TestUpdateUserNotifiesTheChanges(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	jsonStr := []byte(`{"email":"test@test.com", "country" : "BR", "lastName" : "lastName", "firstName":"firstName", "password":"password", "nickname": "renamed"}`)
+
+	r, _ := http.NewRequest("PUT", "/v1/users/test1", bytes.NewBuffer(jsonStr))
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	before := storedUser()
+	mongoMock.On("FindByNickname", "renamed").Return((*model.User)(nil), nil)
+	mongoMock.On("FindByNickname", "test1").Return(before, nil)
+	mongoMock.On("FindAndUpdateById", before.Id, mock2.Anything).Return(before, nil)
+	snsMock.On("Publish", mock2.MatchedBy(func(u *model.User) bool { return u.Id == before.Id && u.Nickname == "renamed" }), []model.FieldChange{
+		{Field: "country", Before: "UK", After: "BR"},
+		{Field: "nickname", Before: "test1", After: "renamed"},
+		model.PasswordChange(true),
+	}).Return(nil)
+	h.UpdateUser(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	snsMock.AssertExpectations(t)
+}
+
+func TestUpdateUserRenameToTakenNickname(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	jsonStr := []byte(`{"email":"test@test.com", "country" : "BR", "lastName" : "lastName", "firstName":"firstName", "password":"password", "nickname": "taken"}`)
+
+	r, _ := http.NewRequest("PUT", "/v1/users/test1", bytes.NewBuffer(jsonStr))
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(storedUser(), nil)
+	mongoMock.On("FindByNickname", "taken").Return(&model.User{Nickname: "taken"}, nil)
+	h.UpdateUser(w, r)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	mongoMock.AssertNotCalled(t, "FindAndUpdateById", mock2.Anything, mock2.Anything)
+}
+
+func TestGetAllUsersSparseFields(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users?fields=nickname,email", nil)
+	w := httptest.NewRecorder()
+
+	updated := time.Date(2020, 7, 2, 10, 0, 0, 0, time.UTC)
+
+	var users = []model.User{
+		{Nickname: "test1", Email: "test@test.com", UpdatedAt: &updated},
+	}
+
+	mongoMock.On("FindAllByFilter", &model.Filter{Fields: []string{"nickname", "email"}}).Return(users, nil)
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[{\"nickname\":\"test1\",\"email\":\"test@test.com\"}]", w.Body.String())
+}
+
+func TestGetAllUsersPasswordIsNotSelectable(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	for _, fields := range []string{"password", "nickname,password", "nickname,unknown"} {
+		r, _ := http.NewRequest("GET", "/v1/users?fields="+fields, nil)
+		w := httptest.NewRecorder()
+
+		h.GetAllUsers(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, fields)
+		assert.Contains(t, w.Body.String(), "for parameter fields", fields)
+	}
+	mongoMock.AssertNotCalled(t, "FindAllByFilter", mock2.Anything)
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetUserHistory(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	auditMock := mock.AuditMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), Audit: &auditMock}
+
+	id, _ := primitive.ObjectIDFromHex("5ea7208049e00ddb76994ede")
+	timestamp := time.Date(2020, 7, 2, 10, 0, 0, 0, time.UTC)
+
+	entries := []model.AuditEntry{
+		{UserId: id, Nickname: "test1", Operation: "update", Actor: "backoffice", RequestId: "req-1", Timestamp: timestamp,
+			Changes: []model.FieldChange{{Field: "email", Before: "old@test.com", After: "new@test.com"}, model.PasswordChange(true)}},
+	}
+
+	mongoMock.On("FindByNickname", "test1").Return(&model.User{Id: id, Nickname: "test1"}, nil)
+	auditMock.On("FindByUser", id, "test1", 2, 1).Return(entries, int64(3), nil)
+
+	r, _ := http.NewRequest("GET", "/v1/users/test1/history?page=2&size=1", nil)
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	h.GetUserHistory(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"items\":[{\"nickname\":\"test1\",\"operation\":\"update\",\"actor\":\"backoffice\",\"requestId\":\"req-1\",\"timestamp\":\"2020-07-02T10:00:00Z\",\"changes\":[{\"field\":\"email\",\"before\":\"old@test.com\",\"after\":\"new@test.com\"},{\"field\":\"password\",\"before\":\"***\",\"after\":\"***\"}]}],\"page\":2,\"size\":1,\"total\":3}", w.Body.String())
+}
+
+func TestGetUserHistoryOfDeletedUser(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	auditMock := mock.AuditMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), Audit: &auditMock}
+
+	var notFound *model.User
+
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil)
+	auditMock.On("FindByUser", primitive.NilObjectID, "test1", 1, 20).Return([]model.AuditEntry{}, int64(0), nil)
+
+	r, _ := http.NewRequest("GET", "/v1/users/test1/history", nil)
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	h.GetUserHistory(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"items\":[],\"page\":1,\"size\":20,\"total\":0}", w.Body.String())
+}
+
+func TestGetUserHistoryInvalidPage(t *testing.T) {
+
+	h := handler.UserHandler{Repository: &mock.MongoMock{}, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), Audit: &mock.AuditMock{}}
+
+	r, _ := http.NewRequest("GET", "/v1/users/test1/history?size=1000", nil)
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	h.GetUserHistory(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"description\":\"invalid value 1000 for parameter size, it must be between 1 and 100\"}", w.Body.String())
+}
+
+func TestGetUserHistoryDisabled(t *testing.T) {
+
+	h := handler.UserHandler{Repository: &mock.MongoMock{}, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/test1/history", nil)
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	h.GetUserHistory(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUpdateUserRecordsAudit(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	auditMock := mock.AuditMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger(), Audit: &auditMock}
+
+	id, _ := primitive.ObjectIDFromHex("5ea7208049e00ddb76994ede")
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+
+	before := &model.User{Id: id, Nickname: "test1", Email: "old@test.com", Country: "UK", LastName: "lastName", FirstName: "firstName", Password: string(hash)}
+
+	jsonStr := []byte(`{"email":"new@test.com", "country" : "UK", "lastName" : "lastName", "firstName":"firstName", "password":"password", "nickname": "test1"}`)
+
+	r, _ := http.NewRequest("PUT", "/v1/users/test1", bytes.NewBuffer(jsonStr))
+	r.Header.Set(handler.PrincipalHeader, "backoffice")
+	r.Header.Set(handler.RequestIDHeader, "req-1")
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(before, nil)
+	mongoMock.On("FindAndUpdateById", before.Id, mock2.Anything).Return(before, nil)
+	snsMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+	auditMock.On("Record", &model.AuditEntry{
+		UserId:    id,
+		Nickname:  "test1",
+		Operation: model.OperationUpdate,
+		Actor:     "backoffice",
+		RequestId: "req-1",
+		Changes:   []model.FieldChange{{Field: "email", Before: "old@test.com", After: "new@test.com"}},
+	}).Return(nil)
+
+	handler.WithRequestID(handler.WithPrincipal(http.HandlerFunc(h.UpdateUser))).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "req-1", w.Header().Get(handler.RequestIDHeader))
+	auditMock.AssertExpectations(t)
+}
+
+func TestUpdateUserRecordsMaskedPasswordChange(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	auditMock := mock.AuditMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger(), Audit: &auditMock}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+
+	before := &model.User{Nickname: "test1", Email: "test@test.com", Country: "UK", LastName: "lastName", FirstName: "firstName", Password: string(hash)}
+
+	jsonStr := []byte(`{"email":"test@test.com", "country" : "UK", "lastName" : "lastName", "firstName":"firstName", "password":"password2", "nickname": "test1"}`)
+
+	r, _ := http.NewRequest("PUT", "/v1/users/test1", bytes.NewBuffer(jsonStr))
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(before, nil)
+	mongoMock.On("FindAndUpdateById", before.Id, mock2.Anything).Return(before, nil)
+	snsMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+	auditMock.On("Record", mock2.MatchedBy(func(e *model.AuditEntry) bool {
+		return len(e.Changes) == 1 && e.Changes[0] == model.FieldChange{Field: "password", Before: "***", After: "***"}
+	})).Return(nil)
+
+	h.UpdateUser(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	auditMock.AssertExpectations(t)
+}
+
+func TestDeleteUserRecordsAudit(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	auditMock := mock.AuditMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), Audit: &auditMock}
+
+	before := &model.User{Nickname: "test1", Email: "test@test.com", Country: "UK", LastName: "lastName", FirstName: "firstName", Password: "hash"}
+
+	r, _ := http.NewRequest("DELETE", "/v1/users/test1", nil)
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(before, nil)
+	mongoMock.On("Delete", "test1").Return(int64(1), nil)
+	auditMock.On("Record", mock2.MatchedBy(func(e *model.AuditEntry) bool {
+		return e.Operation == model.OperationDelete && e.Actor == "anonymous" && len(e.Changes) == 5 && e.Changes[0].After == nil
+	})).Return(errors.New("error on mongo"))
+
+	h.DeleteUserByNickname(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	auditMock.AssertExpectations(t)
+}
+
+func TestSaveUserRecordsAudit(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	auditMock := mock.AuditMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), Audit: &auditMock}
+
+	var notFound *model.User
+
+	jsonStr := []byte(`{"email":"test@test.com", "country" : "UK", "lastName" : "lastName", "firstName":"firstName", "password":"password", "nickname": "test1"}`)
+
+	r, _ := http.NewRequest("POST", "/v1/users", bytes.NewBuffer(jsonStr))
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil)
+	mongoMock.On("Save", mock2.Anything).Return(&model.User{Nickname: "test1"}, nil)
+	auditMock.On("Record", mock2.MatchedBy(func(e *model.AuditEntry) bool {
+		last := e.Changes[len(e.Changes)-1]
+		return e.Operation == model.OperationCreate && !e.UserId.IsZero() && len(e.Changes) == 6 &&
+			last == model.FieldChange{Field: "password", After: "***"}
+	})).Return(nil)
+
+	h.SaveUser(w, r)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	auditMock.AssertExpectations(t)
+}
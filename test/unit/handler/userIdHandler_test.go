@@ -0,0 +1,311 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const userId = "5ea7208049e00ddb76994ede"
+
+func storedUser() *model.User {
+	id, _ := primitive.ObjectIDFromHex(userId)
+	return &model.User{Id: id, Nickname: "test1", Password: "hash", LastName: "lastName", FirstName: "firstName", Country: "UK", Email: "test@test.com"}
+}
+
+func TestGetUserByIdSuccess(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/id/"+userId, nil)
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindById", storedUser().Id).Return(storedUser(), nil)
+	h.GetUserById(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "\"id\":\""+userId+"\"")
+}
+
+func TestGetUserByIdInvalid(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/id/test1", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "test1"})
+	w := httptest.NewRecorder()
+
+	h.GetUserById(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"description\":\"invalid user id test1\"}", w.Body.String())
+}
+
+func TestGetUserByIdNotFound(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	var notFound *model.User
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/id/"+userId, nil)
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindById", storedUser().Id).Return(notFound, nil)
+	h.GetUserById(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "{\"description\":\"user with id "+userId+" not found!\"}", w.Body.String())
+}
+
+func TestUpdateUserByIdRenames(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	jsonStr := []byte(`{"email":"test@test.com", "country" : "UK", "lastName" : "lastName", "firstName":"firstName", "password":"password", "nickname": "renamed"}`)
+
+	r, _ := http.NewRequest("PUT", "/v1/users/id/"+userId, bytes.NewBuffer(jsonStr))
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindById", storedUser().Id).Return(storedUser(), nil)
+	mongoMock.On("FindByNickname", "renamed").Return((*model.User)(nil), nil)
+	mongoMock.On("FindAndUpdateById", storedUser().Id, mock2.MatchedBy(func(u *model.User) bool {
+		return u.Nickname == "renamed" && bcrypt.CompareHashAndPassword([]byte(u.Password), []byte("password")) == nil
+	})).Return(storedUser(), nil)
+	snsMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+	h.UpdateUserById(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mongoMock.AssertExpectations(t)
+}
+
+func TestPatchUserByIdKeepsOtherFields(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("PATCH", "/v1/users/id/"+userId, bytes.NewBuffer([]byte(`{"country":"BR"}`)))
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	patched := storedUser()
+	patched.Country = "BR"
+
+	mongoMock.On("FindById", storedUser().Id).Return(storedUser(), nil)
+	mongoMock.On("FindAndUpdateById", storedUser().Id, mock2.MatchedBy(func(u *model.User) bool {
+		return u.Country == "BR" && u.Email == "test@test.com" && u.Password == "hash"
+	})).Return(storedUser(), nil)
+	snsMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+	h.PatchUserById(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mongoMock.AssertExpectations(t)
+}
+
+func TestPatchUserByIdRenameToReservedNickname(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("PATCH", "/v1/users/id/"+userId, bytes.NewBuffer([]byte(`{"nickname":"search"}`)))
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindById", storedUser().Id).Return(storedUser(), nil)
+	h.PatchUserById(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"description\":\"nickname search is reserved\"}", w.Body.String())
+	mongoMock.AssertNotCalled(t, "FindAndUpdateById", mock2.Anything, mock2.Anything)
+}
+
+func TestPatchUserByIdUnchangedIsNotWritten(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	snsMock := mock.NotifyMock{}
+
+	auditMock := mock.AuditMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &snsMock, Logger: logger.ConfigureLogger(), Audit: &auditMock}
+
+	r, _ := http.NewRequest("PATCH", "/v1/users/id/"+userId, bytes.NewBuffer([]byte(`{"country":"UK"}`)))
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindById", storedUser().Id).Return(storedUser(), nil)
+	h.PatchUserById(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mongoMock.AssertNotCalled(t, "FindAndUpdateById", mock2.Anything, mock2.Anything)
+	auditMock.AssertNotCalled(t, "Record", mock2.Anything)
+	snsMock.AssertNotCalled(t, "Publish", mock2.Anything, mock2.Anything)
+}
+
+func TestPatchUserByIdValidation(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("PATCH", "/v1/users/id/"+userId, bytes.NewBuffer([]byte(`{"email":"not an email"}`)))
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindById", storedUser().Id).Return(storedUser(), nil)
+	h.PatchUserById(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mongoMock.AssertNotCalled(t, "FindAndUpdateById", mock2.Anything, mock2.Anything)
+}
+
+func TestUpdateUserByIdInvalid(t *testing.T) {
+
+	bodies := []string{
+		`{"email":"not an email","country":"UK","nickname":"test1","lastName":"lastName","firstName":"firstName","password":"password"}`,
+		`{"country":"UK","nickname":"test1","lastName":"lastName","firstName":"firstName","password":"password"}`,
+		`{"email":`,
+	}
+
+	for _, body := range bodies {
+		mongoMock := mock.MongoMock{}
+
+		h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+		r, _ := http.NewRequest("PUT", "/v1/users/id/"+userId, bytes.NewBuffer([]byte(body)))
+		r = mux.SetURLVars(r, map[string]string{"id": userId})
+		w := httptest.NewRecorder()
+
+		h.UpdateUserById(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		mongoMock.AssertNotCalled(t, "FindById", mock2.Anything)
+		mongoMock.AssertNotCalled(t, "FindAndUpdateById", mock2.Anything, mock2.Anything)
+	}
+}
+
+func TestUpdateUserByIdRenameToTakenNickname(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("PATCH", "/v1/users/id/"+userId, bytes.NewBuffer([]byte(`{"nickname":"taken"}`)))
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindById", storedUser().Id).Return(storedUser(), nil)
+	mongoMock.On("FindByNickname", "taken").Return(&model.User{Id: primitive.NewObjectID(), Nickname: "taken"}, nil)
+	h.PatchUserById(w, r)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "{\"description\":\"user with nick name taken already exist!\"}", w.Body.String())
+	mongoMock.AssertNotCalled(t, "FindAndUpdateById", mock2.Anything, mock2.Anything)
+}
+
+func TestUpdateUserByIdRenameRacingWithCreate(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("PATCH", "/v1/users/id/"+userId, bytes.NewBuffer([]byte(`{"nickname":"taken"}`)))
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	duplicate := mongo.CommandError{Code: 11000, Message: "E11000 duplicate key error"}
+
+	mongoMock.On("FindById", storedUser().Id).Return(storedUser(), nil)
+	mongoMock.On("FindByNickname", "taken").Return((*model.User)(nil), nil)
+	mongoMock.On("FindAndUpdateById", storedUser().Id, mock2.Anything).Return((*model.User)(nil), duplicate)
+	h.PatchUserById(w, r)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestUpdateUserByIdDeletedMeanwhile(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("PATCH", "/v1/users/id/"+userId, bytes.NewBuffer([]byte(`{"country":"BR"}`)))
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindById", storedUser().Id).Return(storedUser(), nil)
+	mongoMock.On("FindAndUpdateById", storedUser().Id, mock2.Anything).Return((*model.User)(nil), nil)
+	h.PatchUserById(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDeleteUserById(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("DELETE", "/v1/users/id/"+userId, nil)
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("DeleteById", storedUser().Id).Return(storedUser(), nil)
+	h.DeleteUserById(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mongoMock.AssertNotCalled(t, "Delete", mock2.Anything)
+}
+
+func TestDeleteUserByIdNotFound(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	var notFound *model.User
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("DELETE", "/v1/users/id/"+userId, nil)
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("DeleteById", storedUser().Id).Return(notFound, nil)
+	h.DeleteUserById(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	r, _ = http.NewRequest("DELETE", "/v1/users/id/"+userId+"?idempotent=true", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": userId})
+	w = httptest.NewRecorder()
+
+	h.DeleteUserById(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func importHandler(mongoMock *mock.MongoMock, batchSize int) handler.UserHandler {
+	return handler.UserHandler{Repository: mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(),
+		Import: &config.ImportConfig{BatchSize: batchSize, Workers: 2, MaxBytes: 1 << 20}}
+}
+
+func importReport(t *testing.T, w *httptest.ResponseRecorder) model.ImportReport {
+	var report model.ImportReport
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &report))
+	return report
+}
+
+func TestImportUsersCsv(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := importHandler(&mongoMock, 100)
+
+	file := "nickname,email,country,firstName,lastName,password\n" +
+		"new1,new1@test.com,UK,First,Last,secret1\n" +
+		"bad,not an email,UK,First,Last,secret\n" +
+		"new1,again@test.com,UK,First,Last,secret\n" +
+		"taken,taken@test.com,UK,First,Last,secret\n" +
+		"raced,raced@test.com,BR,First,Last,secret\n" +
+		"short,short@test.com\n" +
+		"id,id@test.com,UK,First,Last,secret\n"
+
+	r, _ := http.NewRequest("POST", "/v1/users/import", bytes.NewBufferString(file))
+	r.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	w := httptest.NewRecorder()
+
+	duplicate := mongo.BulkWriteError{WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "E11000 duplicate key"}}
+
+	mongoMock.On("FindNicknames", []string{"new1", "taken", "raced"}).Return([]string{"taken"}, nil)
+	mongoMock.On("SaveMany", mock2.MatchedBy(func(users []*model.User) bool {
+		return len(users) == 2 && users[0].Nickname == "new1" && users[1].Nickname == "raced" &&
+			bcrypt.CompareHashAndPassword([]byte(users[0].Password), []byte("secret1")) == nil &&
+			users[0].CreatedBy == "anonymous" && !users[0].Id.IsZero()
+	})).Return([]error{nil, duplicate}, nil)
+	h.ImportUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	report := importReport(t, w)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 3, report.Conflicted)
+	assert.Equal(t, 3, report.Invalid)
+	assert.False(t, report.DryRun)
+
+	statuses := make([]string, 0)
+	lines := make([]int, 0)
+	for _, row := range report.Rows {
+		statuses = append(statuses, row.Status)
+		lines = append(lines, row.Line)
+	}
+	assert.Equal(t, []string{"created", "invalid", "conflict", "conflict", "conflict", "invalid", "invalid"}, statuses)
+	assert.Equal(t, []int{2, 3, 4, 5, 6, 7, 8}, lines)
+	assert.Len(t, report.Rows[0].Id, 24)
+	assert.Equal(t, "nickname new1 is repeated in the file", report.Rows[2].Error)
+	assert.Equal(t, "user with nick name taken already exist!", report.Rows[3].Error)
+	assert.Equal(t, "expected 6 columns, found 2", report.Rows[5].Error)
+	assert.Equal(t, "nickname id is reserved", report.Rows[6].Error)
+	assert.NotContains(t, w.Body.String(), "secret")
+}
+
+func TestImportUsersNdjsonDryRun(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := importHandler(&mongoMock, 100)
+
+	file := `{"email":"a@test.com","country":"UK","nickname":"a","lastName":"Last","firstName":"First","password":"secret"}
+
+{"email":"b@test.com","nickname":"b"
+{"email":"c@test.com","country":"UK","nickname":"c","lastName":"Last","firstName":"First","password":"secret","admin":true}
+`
+
+	r, _ := http.NewRequest("POST", "/v1/users/import?dryRun=true", bytes.NewBufferString(file))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindNicknames", []string{"a"}).Return([]string{}, nil)
+	h.ImportUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	report := importReport(t, w)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, report.Valid)
+	assert.Equal(t, 2, report.Invalid)
+	assert.Equal(t, model.ImportRow{Line: 1, Nickname: "a", Status: "valid"}, report.Rows[0])
+	assert.Equal(t, 3, report.Rows[1].Line)
+	assert.Contains(t, report.Rows[2].Error, "unknown field")
+	mongoMock.AssertNotCalled(t, "SaveMany", mock2.Anything)
+}
+
+func TestImportUsersInBatches(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := importHandler(&mongoMock, 2)
+
+	file := "nickname,email,country,firstName,lastName,password\n" +
+		"u1,u1@test.com,UK,First,Last,secret\n" +
+		"u2,u2@test.com,UK,First,Last,secret\n" +
+		"u1,u3@test.com,UK,First,Last,secret\n"
+
+	r, _ := http.NewRequest("POST", "/v1/users/import", bytes.NewBufferString(file))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindNicknames", []string{"u1", "u2"}).Return([]string{}, nil)
+	mongoMock.On("FindNicknames", []string(nil)).Return([]string{}, nil)
+	mongoMock.On("SaveMany", mock2.Anything).Return([]error{nil, nil}, nil)
+	h.ImportUsers(w, r)
+
+	report := importReport(t, w)
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 1, report.Conflicted)
+	mongoMock.AssertNumberOfCalls(t, "FindNicknames", 2)
+	mongoMock.AssertNumberOfCalls(t, "SaveMany", 1)
+}
+
+func TestImportUsersSaveError(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := importHandler(&mongoMock, 100)
+
+	file := "nickname,email,country,firstName,lastName,password\nu1,u1@test.com,UK,First,Last,secret\n"
+
+	r, _ := http.NewRequest("POST", "/v1/users/import", bytes.NewBufferString(file))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindNicknames", []string{"u1"}).Return([]string{}, nil)
+	mongoMock.On("SaveMany", mock2.Anything).Return([]error{nil}, errors.New("error on mongo"))
+	h.ImportUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []model.ImportRow{{Line: 2, Nickname: "u1", Status: "failed", Error: "error on mongo"}}, importReport(t, w).Rows)
+}
+
+func TestImportUsersUnsupportedType(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := importHandler(&mongoMock, 100)
+
+	r, _ := http.NewRequest("POST", "/v1/users/import", bytes.NewBufferString("{}"))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	h.ImportUsers(w, r)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal(t, "{\"description\":\"users can only be imported from text/csv or application/x-ndjson\"}", w.Body.String())
+}
+
+func TestImportUsersWithoutBatchSizeStops(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := importHandler(&mongoMock, 0)
+
+	r, _ := http.NewRequest("POST", "/v1/users/import", bytes.NewBufferString("nickname\ntest1\n"))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+
+	h.ImportUsers(w, r)
+
+	assert.Equal(t, "invalid import file: the import batch size must be positive, not 0", importReport(t, w).Error)
+	mongoMock.AssertNotCalled(t, "SaveMany", mock2.Anything)
+}
+
+func TestImportUsersUnknownColumn(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := importHandler(&mongoMock, 100)
+
+	r, _ := http.NewRequest("POST", "/v1/users/import", bytes.NewBufferString("nickname,role\nu1,admin\n"))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+
+	h.ImportUsers(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"description\":\"invalid import file: unknown column role, columns must be any of email, country, nickname, lastName, firstName, password\"}", w.Body.String())
+}
+
+func TestImportUsersTooLarge(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := importHandler(&mongoMock, 1)
+	h.Import.MaxBytes = 150
+
+	file := "nickname,email,country,firstName,lastName,password\n" +
+		"u1,u1@test.com,UK,First,Last,secret\n" + strings.Repeat("u2,u2@test.com,UK,First,Last,secret\n", 5)
+
+	r, _ := http.NewRequest("POST", "/v1/users/import", bytes.NewBufferString(file))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindNicknames", mock2.Anything).Return([]string{}, nil)
+	mongoMock.On("SaveMany", mock2.Anything).Return([]error{nil}, nil)
+	h.ImportUsers(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	report := importReport(t, w)
+	assert.Contains(t, report.Error, "request body too large")
+	assert.NotEmpty(t, report.Rows)
+}
@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/pkg/eventsig"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const topic = "arn:aws:sns:us-east-1:000000000000:user_update_notify"
+
+// snsServer stands in for SNS, answering publishes and counting the connections opened to it.
+func snsServer(status int) (*httptest.Server, *int32, chan url.Values) {
+	var connections int32
+	published := make(chan url.Values, 100)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		select {
+		case published <- r.PostForm:
+		default:
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = w.Write([]byte(`<PublishResponse><PublishResult><MessageId>1</MessageId></PublishResult></PublishResponse>`))
+		} else {
+			_, _ = w.Write([]byte(`<ErrorResponse><Error><Type>Receiver</Type><Code>InternalError</Code><Message>Internal error</Message></Error></ErrorResponse>`))
+		}
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+
+	return server, &connections, published
+}
+
+func snsConfig(endpoint string) *config.SnsConfig {
+	return &config.SnsConfig{Topic: topic, Region: "us-east-1", Endpoint: endpoint, MaxRetries: 2, Timeout: time.Second,
+		ConnectTimeout: time.Second, MaxIdleConns: 4, Credentials: config.SnsCredentialsStatic, AccessKeyId: "test",
+		SecretAccessKey: "test"}
+}
+
+func structuredEvents() *event.Encoder {
+	return &event.Encoder{Mode: config.EventModeStructured, Source: "/user-api", SchemaUrl: "https://schemas", Version: "v1"}
+}
+
+func TestSnsReusesTheClientAndConnections(t *testing.T) {
+	server, connections, published := snsServer(http.StatusOK)
+	defer server.Close()
+
+	s, err := handler.NewSNS(snsConfig(server.URL), structuredEvents(), logger.ConfigureLogger())
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, s.Publish(&model.User{Nickname: "test1", Password: "hash"}, nil))
+	}
+
+	form := <-published
+	assert.Equal(t, "Publish", form.Get("Action"))
+	assert.Equal(t, topic, form.Get("TopicArn"))
+	assert.NotContains(t, form.Get("Message"), "hash")
+	assert.Empty(t, form.Get("MessageAttributes.entry.1.Name"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(connections))
+
+	var ev event.Event
+	assert.Nil(t, json.Unmarshal([]byte(form.Get("Message")), &ev))
+	assert.Equal(t, event.TypeUserUpdated, ev.Type)
+	assert.Equal(t, "https://schemas/user/v1", ev.DataSchema)
+	assert.JSONEq(t, `{"id":"000000000000000000000000","email":"","country":"","nickname":"test1","lastName":"","firstName":""}`, string(ev.Data))
+}
+
+func TestSnsSendsBinaryEvents(t *testing.T) {
+	server, _, published := snsServer(http.StatusOK)
+	defer server.Close()
+
+	events := structuredEvents()
+	events.Mode = config.EventModeBinary
+	s, _ := handler.NewSNS(snsConfig(server.URL), events, logger.ConfigureLogger())
+	user := &model.User{Id: primitive.NewObjectID(), Nickname: "test1"}
+
+	assert.Nil(t, s.Publish(user, nil))
+
+	form := <-published
+	attributes := map[string]string{}
+	for n := 1; form.Get(fmt.Sprintf("MessageAttributes.entry.%d.Name", n)) != ""; n++ {
+		attributes[form.Get(fmt.Sprintf("MessageAttributes.entry.%d.Name", n))] = form.Get(fmt.Sprintf("MessageAttributes.entry.%d.Value.StringValue", n))
+	}
+
+	assert.Len(t, attributes, 8)
+	assert.Equal(t, "1.0", attributes["ce_specversion"])
+	assert.Equal(t, user.Id.Hex(), attributes["ce_subject"])
+	assert.Equal(t, "/user-api", attributes["ce_source"])
+	assert.Equal(t, "application/json", attributes["ce_datacontenttype"])
+
+	var data model.User
+	assert.Nil(t, json.Unmarshal([]byte(form.Get("Message")), &data))
+	assert.Equal(t, "test1", data.Nickname)
+}
+
+func TestSnsRetriesFailedPublishes(t *testing.T) {
+	server, _, published := snsServer(http.StatusInternalServerError)
+	defer server.Close()
+
+	s, _ := handler.NewSNS(snsConfig(server.URL), structuredEvents(), logger.ConfigureLogger())
+
+	err := s.Publish(&model.User{Nickname: "test1"}, nil)
+
+	assert.Error(t, err)
+	assert.Len(t, published, 3)
+}
+
+func TestNewSnsRejectsIncompleteCredentials(t *testing.T) {
+	cases := map[string]string{
+		config.SnsCredentialsStatic:      "static sns credentials need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY",
+		config.SnsCredentialsProfile:     "profile sns credentials need AWS_PROFILE",
+		config.SnsCredentialsWebIdentity: "web identity sns credentials need AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE",
+		"vault":                          "unknown sns credentials vault, it must be default, static, profile or web-identity",
+	}
+
+	for credentials, description := range cases {
+		c := &config.SnsConfig{Region: "us-east-1", Credentials: credentials}
+
+		s, err := handler.NewSNS(c, structuredEvents(), logger.ConfigureLogger())
+
+		assert.Nil(t, s, credentials)
+		assert.EqualError(t, err, description, credentials)
+	}
+}
+
+func BenchmarkSnsPublish(b *testing.B) {
+	server, _, _ := snsServer(http.StatusOK)
+	defer server.Close()
+
+	quiet, _ := logger.New(&config.LoggerConfig{Level: "error"})
+	s, _ := handler.NewSNS(snsConfig(server.URL), structuredEvents(), quiet)
+	user := &model.User{Nickname: "test1"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.Publish(user, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSnsPublishNewSession publishes as it was done before the client was shared, building a session and a
+// client for each publish, to compare with BenchmarkSnsPublish.
+func BenchmarkSnsPublishNewSession(b *testing.B) {
+	server, _, _ := snsServer(http.StatusOK)
+	defer server.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sess := session.Must(session.NewSession(&aws.Config{
+			Endpoint:    aws.String(server.URL),
+			Region:      aws.String("us-east-1"),
+			Credentials: credentials.NewStaticCredentials("test", "test", "")},
+		))
+
+		_, err := sns.New(sess).Publish(&sns.PublishInput{Message: aws.String(`{"nickname":"test1"}`), TopicArn: aws.String(topic)})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSnsGroupsFifoEventsByUser(t *testing.T) {
+	server, _, published := snsServer(http.StatusOK)
+	defer server.Close()
+
+	c := snsConfig(server.URL)
+	c.Topic, c.Fifo = topic+".fifo", true
+	s, err := handler.NewSNS(c, structuredEvents(), logger.ConfigureLogger())
+	assert.Nil(t, err)
+
+	updatedAt := time.Unix(1593597600, 5)
+	user := &model.User{Id: primitive.NewObjectID(), Nickname: "test1", UpdatedAt: &updatedAt}
+	assert.Nil(t, s.Publish(user, nil))
+	assert.Nil(t, s.Publish(user, nil))
+
+	updatedAgain := updatedAt.Add(time.Second)
+	user.UpdatedAt = &updatedAgain
+	assert.Nil(t, s.Publish(user, nil))
+
+	first, retried, next := <-published, <-published, <-published
+
+	assert.Equal(t, user.Id.Hex(), first.Get("MessageGroupId"))
+	assert.Equal(t, user.Id.Hex(), next.Get("MessageGroupId"))
+	assert.Equal(t, user.Id.Hex()+"-1593597600000000005", first.Get("MessageDeduplicationId"))
+	assert.Equal(t, first.Get("MessageDeduplicationId"), retried.Get("MessageDeduplicationId"))
+	assert.Equal(t, user.Id.Hex()+"-1593597601000000005", next.Get("MessageDeduplicationId"))
+}
+
+func TestSnsDeduplicatesReplayedFifoEventsById(t *testing.T) {
+	server, _, published := snsServer(http.StatusOK)
+	defer server.Close()
+
+	c := snsConfig(server.URL)
+	c.Topic, c.Fifo = topic+".fifo", true
+	s, _ := handler.NewSNS(c, structuredEvents(), logger.ConfigureLogger())
+
+	updatedAt := time.Unix(1593597600, 5)
+	user := &model.User{Id: primitive.NewObjectID(), Nickname: "test1", UpdatedAt: &updatedAt}
+	assert.Nil(t, s.Publish(user, nil))
+	assert.Nil(t, s.PublishReplay(user, nil))
+
+	first, replayed := <-published, <-published
+
+	var ev event.Event
+	assert.Nil(t, json.Unmarshal([]byte(replayed.Get("Message")), &ev))
+	assert.Equal(t, user.Id.Hex()+"-1593597600000000005", first.Get("MessageDeduplicationId"))
+	assert.Equal(t, ev.Id, replayed.Get("MessageDeduplicationId"))
+	assert.Equal(t, user.Id.Hex(), replayed.Get("MessageGroupId"))
+}
+
+func TestSnsDeduplicatesFifoEventsOfUnknownVersionById(t *testing.T) {
+	server, _, published := snsServer(http.StatusOK)
+	defer server.Close()
+
+	c := snsConfig(server.URL)
+	c.Topic, c.Fifo = topic+".fifo", true
+	s, _ := handler.NewSNS(c, structuredEvents(), logger.ConfigureLogger())
+
+	assert.Nil(t, s.Publish(&model.User{Id: primitive.NewObjectID()}, nil))
+
+	form := <-published
+	var ev event.Event
+	assert.Nil(t, json.Unmarshal([]byte(form.Get("Message")), &ev))
+	assert.Equal(t, ev.Id, form.Get("MessageDeduplicationId"))
+}
+
+func TestSnsStandardTopicsHaveNoGroup(t *testing.T) {
+	server, _, published := snsServer(http.StatusOK)
+	defer server.Close()
+
+	s, _ := handler.NewSNS(snsConfig(server.URL), structuredEvents(), logger.ConfigureLogger())
+
+	assert.Nil(t, s.Publish(&model.User{Id: primitive.NewObjectID()}, nil))
+
+	form := <-published
+	assert.NotContains(t, form, "MessageGroupId")
+	assert.NotContains(t, form, "MessageDeduplicationId")
+}
+
+func TestSnsSignsTheEvents(t *testing.T) {
+	server, _, published := snsServer(http.StatusOK)
+	defer server.Close()
+
+	_, private, _ := ed25519.GenerateKey(nil)
+	c := snsConfig(server.URL)
+	c.SigningKeys = "ed:ed25519:" + base64.StdEncoding.EncodeToString(private)
+	s, err := handler.NewSNS(c, structuredEvents(), logger.ConfigureLogger())
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Publish(&model.User{Id: primitive.NewObjectID(), Nickname: "test1"}, nil))
+
+	public := []eventsig.Key{eventsig.NewEd25519PublicKey("ed", private.Public().(ed25519.PublicKey))}
+	assert.Nil(t, eventsig.VerifyStructured([]byte((<-published).Get("Message")), public))
+}
+
+func TestNewSnsRejectsPublicSigningKeys(t *testing.T) {
+	public, _, _ := ed25519.GenerateKey(nil)
+	c := snsConfig("")
+	c.SigningKeys = "ed:ed25519:" + base64.StdEncoding.EncodeToString(public)
+
+	s, err := handler.NewSNS(c, structuredEvents(), logger.ConfigureLogger())
+
+	assert.Nil(t, s)
+	assert.EqualError(t, err, "invalid SNS_SIGNING_KEYS: signing key ed is an ed25519 public key, it can't sign")
+}
+
+func TestNewSnsRejectsFifoOnStandardTopic(t *testing.T) {
+	c := snsConfig("")
+	c.Fifo = true
+
+	s, err := handler.NewSNS(c, structuredEvents(), logger.ConfigureLogger())
+
+	assert.Nil(t, s)
+	assert.EqualError(t, err, "SNS_FIFO needs a FIFO topic, "+topic+" doesn't end in .fifo")
+}
+
+func TestSnsConfigRejectsNonPositiveTimeouts(t *testing.T) {
+	_ = os.Setenv("SNS_TIMEOUT", "0s")
+	_ = os.Setenv("SNS_CONNECT_TIMEOUT", "-1s")
+	defer os.Unsetenv("SNS_TIMEOUT")
+	defer os.Unsetenv("SNS_CONNECT_TIMEOUT")
+
+	c := config.NewSnsConfig()
+
+	assert.Equal(t, 5*time.Second, c.Timeout)
+	assert.Equal(t, time.Second, c.ConnectTimeout)
+}
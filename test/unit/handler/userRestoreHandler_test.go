@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"errors"
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRestoreUserSuccess(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	auditMock := mock.AuditMock{}
+
+	var notFound *model.User
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), Audit: &auditMock, AdminToken: "secret"}
+
+	r, _ := http.NewRequest("POST", "/v1/users/test1:restore", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil)
+	mongoMock.On("Restore", "test1").Return(&model.User{Nickname: "test1"}, nil)
+	auditMock.On("Record", mock2.MatchedBy(func(e *model.AuditEntry) bool {
+		return e.Operation == model.OperationRestore && e.Nickname == "test1"
+	})).Return(nil)
+
+	h.RestoreUser(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	auditMock.AssertExpectations(t)
+}
+
+func TestRestoreUserNotAdmin(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), AdminToken: "secret"}
+
+	r, _ := http.NewRequest("POST", "/v1/users/test1:restore", nil)
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	h.RestoreUser(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "{\"description\":\"only admins can restore users\"}", w.Body.String())
+	mongoMock.AssertNotCalled(t, "Restore", mock2.Anything)
+}
+
+func TestRestoreUserNicknameTaken(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), AdminToken: "secret"}
+
+	r, _ := http.NewRequest("POST", "/v1/users/test1:restore", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(&model.User{Nickname: "test1"}, nil)
+
+	h.RestoreUser(w, r)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	mongoMock.AssertNotCalled(t, "Restore", mock2.Anything)
+}
+
+func TestRestoreUserRacingWithCreate(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	var notFound *model.User
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), AdminToken: "secret"}
+
+	r, _ := http.NewRequest("POST", "/v1/users/test1:restore", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil)
+	mongoMock.On("Restore", "test1").Return(notFound, mongo.CommandError{Code: 11000, Message: "duplicate key"})
+
+	h.RestoreUser(w, r)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "{\"description\":\"user with nick name test1 already exist!\"}", w.Body.String())
+}
+
+func TestRestoreUserNotDeleted(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	var notFound *model.User
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), AdminToken: "secret"}
+
+	r, _ := http.NewRequest("POST", "/v1/users/test1:restore", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil)
+	mongoMock.On("Restore", "test1").Return(notFound, nil)
+
+	h.RestoreUser(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "{\"description\":\"deleted user with nickname test1 not found!\"}", w.Body.String())
+}
+
+func TestRestoreUserErrorOnMongo(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	var notFound *model.User
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), AdminToken: "secret"}
+
+	r, _ := http.NewRequest("POST", "/v1/users/test1:restore", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r = mux.SetURLVars(r, map[string]string{"nickname": "test1"})
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil)
+	mongoMock.On("Restore", "test1").Return(notFound, errors.New("error on mongo"))
+
+	h.RestoreUser(w, r)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestGetAllUsersIncludeDeletedNotAdmin(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), AdminToken: "secret"}
+
+	r, _ := http.NewRequest("GET", "/v1/users?includeDeleted=true", nil)
+	w := httptest.NewRecorder()
+
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mongoMock.AssertNotCalled(t, "FindAllByFilter", mock2.Anything)
+}
+
+func TestGetAllUsersIncludeDeletedAdmin(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger(), AdminToken: "secret"}
+
+	r, _ := http.NewRequest("GET", "/v1/users?includeDeleted=true", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	mongoMock.On("FindAllByFilter", &model.Filter{IncludeDeleted: true}).Return([]model.User{}, nil)
+
+	h.GetAllUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
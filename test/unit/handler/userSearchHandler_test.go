@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"github.com/bernardoms/user-api/internal/handler"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/search"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearchUsersText(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/search?q=jose&size=10", nil)
+	w := httptest.NewRecorder()
+
+	hit := model.UserHit{User: *storedUser(), Score: 1.5}
+	hit.FirstName = "José"
+	hit.Password = "hash"
+
+	mongoMock.On("Search", &model.UserSearch{Query: "jose", Mode: model.SearchText, Page: 1, Size: 10}).
+		Return([]model.UserHit{hit}, int64(1), nil)
+	h.SearchUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"items\":[{\"id\":\""+userId+"\",\"email\":\"test@test.com\",\"country\":\"UK\",\"nickname\":\"test1\",\"lastName\":\"lastName\",\"firstName\":\"José\",\"score\":1.5,\"highlights\":{\"firstName\":\"\\u003cem\\u003eJosé\\u003c/em\\u003e\"}}],\"page\":1,\"size\":10,\"total\":1}", w.Body.String())
+}
+
+func TestSearchUsersPrefix(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/search?q=tes&mode=prefix&page=2", nil)
+	w := httptest.NewRecorder()
+
+	mongoMock.On("Search", &model.UserSearch{Query: "tes", Mode: model.SearchPrefix, Page: 2, Size: 20}).
+		Return([]model.UserHit{{User: *storedUser()}}, int64(21), nil)
+	h.SearchUsers(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "\"highlights\":{\"nickname\":\"\\u003cem\\u003etes\\u003c/em\\u003et1\"}")
+	assert.NotContains(t, w.Body.String(), "hash")
+	assert.Contains(t, w.Body.String(), "\"total\":21")
+}
+
+func TestSearchUsersMissingQuery(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/search?q=%20", nil)
+	w := httptest.NewRecorder()
+
+	h.SearchUsers(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"description\":\"missing parameter q\"}", w.Body.String())
+}
+
+func TestSearchUsersInvalidMode(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/search?q=test&mode=fuzzy", nil)
+	w := httptest.NewRecorder()
+
+	h.SearchUsers(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"description\":\"invalid value fuzzy for parameter mode, it must be text, prefix or substring\"}", w.Body.String())
+	mongoMock.AssertNotCalled(t, "Search")
+}
+
+func TestSearchUsersQueryTooLong(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/search?q="+strings.Repeat("é", search.MaxQueryLength+1), nil)
+	w := httptest.NewRecorder()
+
+	h.SearchUsers(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"description\":\"parameter q is longer than 200 characters\"}", w.Body.String())
+	mongoMock.AssertNotCalled(t, "Search")
+}
+
+func TestSearchUsersPageTooFar(t *testing.T) {
+
+	mongoMock := mock.MongoMock{}
+
+	h := handler.UserHandler{Repository: &mongoMock, NotifyHandler: &mock.NotifyMock{}, Logger: logger.ConfigureLogger()}
+
+	r, _ := http.NewRequest("GET", "/v1/users/search?q=test&page=9223372036854775807", nil)
+	w := httptest.NewRecorder()
+
+	h.SearchUsers(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"description\":\"invalid value 9223372036854775807 for parameter page, it must be between 1 and 10000\"}", w.Body.String())
+	mongoMock.AssertNotCalled(t, "Search")
+}
@@ -0,0 +1,230 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/job"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/requestctx"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func newPool(jobMock *mock.JobMock, runner job.RunnerFunc) *job.Pool {
+	c := &config.JobsConfig{Workers: 1, PollInterval: time.Hour, Lease: 30 * time.Millisecond, MaxAttempts: 3, CancelCheck: time.Hour}
+	return job.NewPool(jobMock, map[string]job.Runner{model.JobExport: runner}, c, logger.ConfigureLogger())
+}
+
+func claimed() *model.Job {
+	return &model.Job{Id: primitive.NewObjectID(), Type: model.JobExport, Status: model.JobRunning, Owner: "owner",
+		CreatedBy: "alice", RequestId: "request-1", Attempts: 1}
+}
+
+func TestSubmitQueuesJob(t *testing.T) {
+	jobMock := mock.JobMock{}
+	jobMock.On("Create", mock2.MatchedBy(func(j *model.Job) bool { return j.Status == model.JobQueued })).Return(nil)
+
+	p := newPool(&jobMock, nil)
+
+	assert.Nil(t, p.Submit(&model.Job{Type: model.JobExport}))
+	assert.EqualError(t, p.Submit(&model.Job{Type: "unknown"}), "unknown job type unknown")
+	jobMock.AssertNumberOfCalls(t, "Create", 1)
+}
+
+func TestRunJobSucceeds(t *testing.T) {
+	jobMock := mock.JobMock{}
+	j := claimed()
+
+	jobMock.On("Finish", mock2.MatchedBy(func(f *model.Job) bool {
+		return f.Status == model.JobSucceeded && f.Progress == model.JobProgress{Total: 3, Done: 3, Failed: 1} &&
+			len(f.Errors) == 1 && f.Result == "file" && f.ResultType == "text/csv"
+	})).Return(nil)
+
+	p := newPool(&jobMock, func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+		assert.Equal(t, "alice", requestctx.Principal(ctx))
+		assert.Equal(t, "request-1", requestctx.RequestID(ctx))
+		progress.Reset(3)
+		progress.Add(2, 0)
+		progress.Add(1, 1)
+		progress.Fail("bob: error")
+		progress.SetResult("file", "text/csv")
+		return nil
+	})
+
+	p.RunJob(context.Background(), j)
+
+	jobMock.AssertExpectations(t)
+}
+
+func TestRunJobFails(t *testing.T) {
+	jobMock := mock.JobMock{}
+
+	jobMock.On("Finish", mock2.MatchedBy(func(f *model.Job) bool {
+		return f.Status == model.JobFailed && f.Error == "error on mongo"
+	})).Return(nil)
+
+	p := newPool(&jobMock, func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+		return errors.New("error on mongo")
+	})
+
+	p.RunJob(context.Background(), claimed())
+
+	jobMock.AssertExpectations(t)
+}
+
+func TestRunJobFailsWhenTheRunnerPanics(t *testing.T) {
+	jobMock := mock.JobMock{}
+
+	jobMock.On("Finish", mock2.MatchedBy(func(f *model.Job) bool {
+		return f.Status == model.JobFailed && f.Error == "the job panicked: runtime error: index out of range [3] with length 0"
+	})).Return(nil)
+
+	p := newPool(&jobMock, func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+		var users []string
+		_ = users[3]
+		return nil
+	})
+
+	p.RunJob(context.Background(), claimed())
+
+	jobMock.AssertExpectations(t)
+}
+
+func TestRunJobGivesUpAfterMaxAttempts(t *testing.T) {
+	jobMock := mock.JobMock{}
+	j := claimed()
+	j.Attempts = 4
+
+	jobMock.On("Finish", mock2.MatchedBy(func(f *model.Job) bool {
+		return f.Status == model.JobFailed && f.Error == "the job was stopped 3 times without finishing, giving up"
+	})).Return(nil)
+
+	p := newPool(&jobMock, func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+		t.Fatal("a job over its attempts must not run")
+		return nil
+	})
+
+	p.RunJob(context.Background(), j)
+
+	jobMock.AssertExpectations(t)
+}
+
+func TestRunJobSavesProgressAndStopsWhenCancelled(t *testing.T) {
+	jobMock := mock.JobMock{}
+
+	jobMock.On("Heartbeat", mock2.MatchedBy(func(h *model.Job) bool { return h.Progress.Done == 1 }), mock2.Anything).
+		Return(&model.Job{CancelRequested: true}, nil)
+	jobMock.On("Finish", mock2.MatchedBy(func(f *model.Job) bool {
+		return f.Status == model.JobCancelled && f.Progress.Done == 1
+	})).Return(nil)
+
+	p := newPool(&jobMock, func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+		progress.Add(1, 0)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	p.RunJob(context.Background(), claimed())
+
+	jobMock.AssertExpectations(t)
+}
+
+func TestRunJobChecksCancellationBetweenItems(t *testing.T) {
+	jobMock := mock.JobMock{}
+	j := claimed()
+
+	jobMock.On("FindById", j.Id).Return(&model.Job{Id: j.Id, CancelRequested: true}, nil)
+	jobMock.On("Finish", mock2.MatchedBy(func(f *model.Job) bool { return f.Status == model.JobCancelled })).Return(nil)
+
+	p := newPool(&jobMock, func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+		for ctx.Err() == nil {
+			progress.Add(1, 0)
+			time.Sleep(time.Millisecond)
+		}
+		return ctx.Err()
+	})
+	p.Config.Lease, p.Config.CancelCheck = time.Hour, time.Millisecond
+
+	p.RunJob(context.Background(), j)
+
+	jobMock.AssertExpectations(t)
+	jobMock.AssertNotCalled(t, "Heartbeat", mock2.Anything, mock2.Anything)
+}
+
+func TestRunJobStopsWhenLeaseLost(t *testing.T) {
+	jobMock := mock.JobMock{}
+
+	jobMock.On("Heartbeat", mock2.Anything, mock2.Anything).Return((*model.Job)(nil), nil)
+
+	p := newPool(&jobMock, func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	p.RunJob(context.Background(), claimed())
+
+	jobMock.AssertNotCalled(t, "Finish", mock2.Anything)
+	jobMock.AssertNotCalled(t, "Release", mock2.Anything)
+}
+
+func TestRunJobIsReleasedWhenThePoolStops(t *testing.T) {
+	jobMock := mock.JobMock{}
+
+	jobMock.On("Heartbeat", mock2.Anything, mock2.Anything).Return(claimed(), nil).Maybe()
+	jobMock.On("Release", mock2.MatchedBy(func(r *model.Job) bool { return r.Checkpoint == 10 })).Return(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := newPool(&jobMock, func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+		progress.Checkpoint(10)
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	p.RunJob(ctx, claimed())
+
+	jobMock.AssertExpectations(t)
+	jobMock.AssertNotCalled(t, "Finish", mock2.Anything)
+}
+
+func TestRunClaimsJobsUntilStopped(t *testing.T) {
+	jobMock := mock.JobMock{}
+	ran := make(chan struct{}, 1)
+
+	jobMock.On("Claim", mock2.Anything, mock2.Anything).Return(claimed(), nil).Once()
+	jobMock.On("Claim", mock2.Anything, mock2.Anything).Return((*model.Job)(nil), nil)
+	jobMock.On("Finish", mock2.Anything).Return(nil)
+
+	p := newPool(&jobMock, func(ctx context.Context, j *model.Job, progress *job.Progress) error {
+		ran <- struct{}{}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("job not run")
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pool not stopped")
+	}
+}
@@ -0,0 +1,175 @@
+package logger
+
+import (
+	"bytes"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewWithDefaults(t *testing.T) {
+	l, err := logger.New(&config.LoggerConfig{})
+
+	level, until := l.Level()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "info", level)
+	assert.True(t, until.IsZero())
+}
+
+func TestNewInvalidLevel(t *testing.T) {
+	_, err := logger.New(&config.LoggerConfig{Level: "loud"})
+
+	assert.Error(t, err)
+}
+
+func TestNewInvalidFormat(t *testing.T) {
+	_, err := logger.New(&config.LoggerConfig{Format: "xml"})
+
+	assert.Error(t, err)
+}
+
+func TestNewFileOutputWithoutPath(t *testing.T) {
+	_, err := logger.New(&config.LoggerConfig{Output: "file"})
+
+	assert.Error(t, err)
+}
+
+func TestLogfmtFormat(t *testing.T) {
+	l, _ := logger.New(&config.LoggerConfig{Format: "logfmt"})
+	b := &bytes.Buffer{}
+	l.Log.Out = b
+
+	l.LogWithFields(nil, "info", map[string]interface{}{"msg": "user saved", "nickname": "test1"})
+
+	assert.Contains(t, b.String(), "level=info")
+	assert.Contains(t, b.String(), "msg=\"user saved\"")
+	assert.Contains(t, b.String(), "nickname=test1")
+}
+
+func TestTextFormat(t *testing.T) {
+	l, _ := logger.New(&config.LoggerConfig{Format: "text"})
+	b := &bytes.Buffer{}
+	l.Log.Out = b
+
+	l.LogWithFields(nil, "warn", map[string]interface{}{"msg": "user saved", "nickname": "test1"})
+
+	assert.Regexp(t, "^\\S+ WARNING user saved nickname=test1\n$", b.String())
+}
+
+func TestLogWithFieldsRedactsCredentials(t *testing.T) {
+	l := logger.ConfigureLogger()
+	out := &bytes.Buffer{}
+	l.Log.Out = out
+
+	req, _ := http.NewRequest("PUT", "/v1/admin/log-level", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Request-Id", "abc")
+
+	l.LogWithFields(req, "info", map[string]interface{}{"msg": "level changed"})
+
+	assert.NotContains(t, out.String(), "secret")
+	assert.Contains(t, out.String(), "[REDACTED]")
+	assert.Contains(t, out.String(), "abc")
+	assert.Equal(t, "Bearer admin-secret", req.Header.Get("Authorization"))
+}
+
+func TestSetLevelWithoutTTL(t *testing.T) {
+	l, _ := logger.New(&config.LoggerConfig{Level: "warn"})
+
+	err := l.SetLevel("debug", 0)
+	level, until := l.Level()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "debug", level)
+	assert.True(t, until.IsZero())
+}
+
+func TestSetLevelRevertsAfterTTL(t *testing.T) {
+	l, _ := logger.New(&config.LoggerConfig{Level: "warn"})
+
+	err := l.SetLevel("debug", 20*time.Millisecond)
+	level, until := l.Level()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "debug", level)
+	assert.False(t, until.IsZero())
+
+	assert.Eventually(t, func() bool {
+		level, _ := l.Level()
+		return level == "warning"
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestSetLevelInvalid(t *testing.T) {
+	l := logger.ConfigureLogger()
+
+	assert.Error(t, l.SetLevel("loud", 0))
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "logs")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "api.log")
+
+	r, err := logger.NewRotatingFile(path, 10, 2)
+	assert.Nil(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = r.Write([]byte(line))
+		assert.Nil(t, err)
+	}
+	_ = r.Close()
+
+	current, _ := ioutil.ReadFile(path)
+	backup1, _ := ioutil.ReadFile(path + ".1")
+	backup2, _ := ioutil.ReadFile(path + ".2")
+	_, err = os.Stat(path + ".3")
+
+	assert.Equal(t, "fourth\n", string(current))
+	assert.Equal(t, "third\n", string(backup1))
+	assert.Equal(t, "second\n", string(backup2))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotatingFileKeepsWritingWhenRotationFails(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "logs")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "api.log")
+
+	// the backup can't be replaced by the file, it is a directory that isn't empty
+	_ = os.MkdirAll(filepath.Join(path+".1", "taken"), 0755)
+
+	r, err := logger.NewRotatingFile(path, 10, 1)
+	assert.Nil(t, err)
+
+	for _, line := range []string{"first\n", "second\n"} {
+		_, err = r.Write([]byte(line))
+		assert.Nil(t, err)
+	}
+	_ = r.Close()
+
+	current, _ := ioutil.ReadFile(path)
+
+	assert.Equal(t, "first\nsecond\n", string(current))
+}
+
+func TestSetLevelReplacesTemporaryLevel(t *testing.T) {
+	l, _ := logger.New(&config.LoggerConfig{Level: "warn"})
+
+	_ = l.SetLevel("debug", 10*time.Millisecond)
+	_ = l.SetLevel("error", time.Hour)
+
+	time.Sleep(30 * time.Millisecond)
+	level, until := l.Level()
+
+	assert.Equal(t, "error", level)
+	assert.False(t, until.IsZero())
+}
@@ -0,0 +1,42 @@
+package mock
+
+import (
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AuditMock struct {
+	mock.Mock
+}
+
+func (a *AuditMock) Record(entry *model.AuditEntry) error {
+	args := a.Called(entry)
+	return args.Error(0)
+}
+
+func (a *AuditMock) FindByUser(userId primitive.ObjectID, nickname string, page int, size int) ([]model.AuditEntry, int64, error) {
+	args := a.Called(userId, nickname, page, size)
+	return args.Get(0).([]model.AuditEntry), args.Get(1).(int64), args.Error(2)
+}
+
+func (a *AuditMock) Count(filter *model.AuditFilter) (int64, error) {
+	args := a.Called(filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (a *AuditMock) Stream(filter *model.AuditFilter, fn func(entry *model.AuditEntry) error) error {
+	args := a.Called(filter)
+	for _, entry := range args.Get(0).([]model.AuditEntry) {
+		entry := entry
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (a *AuditMock) FindAfter(entry *model.AuditEntry) ([]model.AuditEntry, error) {
+	args := a.Called(entry)
+	return args.Get(0).([]model.AuditEntry), args.Error(1)
+}
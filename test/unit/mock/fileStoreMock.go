@@ -0,0 +1,68 @@
+package mock
+
+import (
+	"bytes"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// FileStore keeps the files in memory, only once they are closed as in GridFS.
+type FileStore struct {
+	mu    sync.Mutex
+	Files map[string][]byte
+}
+
+func NewFileStore() *FileStore {
+	return &FileStore{Files: map[string][]byte{}}
+}
+
+func (f *FileStore) Create(name string) (string, io.WriteCloser, error) {
+	id := primitive.NewObjectID().Hex()
+	return id, &file{store: f, id: id}, nil
+}
+
+func (f *FileStore) Open(id string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	content, ok := f.Files[id]
+	if !ok {
+		return nil, errors.New("file not found")
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (f *FileStore) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.Files, id)
+	return nil
+}
+
+// Put stores a file, returning its id.
+func (f *FileStore) Put(content string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := primitive.NewObjectID().Hex()
+	f.Files[id] = []byte(content)
+	return id
+}
+
+type file struct {
+	bytes.Buffer
+	store *FileStore
+	id    string
+}
+
+func (w *file) Close() error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+
+	w.store.Files[w.id] = w.Bytes()
+	return nil
+}
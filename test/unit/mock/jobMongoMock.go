@@ -0,0 +1,59 @@
+package mock
+
+import (
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type JobMock struct {
+	mock.Mock
+}
+
+func (m *JobMock) Create(job *model.Job) error {
+	args := m.Called(job)
+	return args.Error(0)
+}
+
+func (m *JobMock) FindById(id primitive.ObjectID) (*model.Job, error) {
+	args := m.Called(id)
+	return args.Get(0).(*model.Job), args.Error(1)
+}
+
+func (m *JobMock) Claim(owner string, leaseUntil time.Time) (*model.Job, error) {
+	args := m.Called(owner, leaseUntil)
+	return args.Get(0).(*model.Job), args.Error(1)
+}
+
+func (m *JobMock) Heartbeat(job *model.Job, leaseUntil time.Time) (*model.Job, error) {
+	args := m.Called(job, leaseUntil)
+	return args.Get(0).(*model.Job), args.Error(1)
+}
+
+func (m *JobMock) Finish(job *model.Job) error {
+	args := m.Called(job)
+	return args.Error(0)
+}
+
+func (m *JobMock) Release(job *model.Job) error {
+	args := m.Called(job)
+	return args.Error(0)
+}
+
+func (m *JobMock) Cancel(id primitive.ObjectID) (*model.Job, error) {
+	args := m.Called(id)
+	return args.Get(0).(*model.Job), args.Error(1)
+}
+
+type JobQueueMock struct {
+	mock.Mock
+}
+
+func (m *JobQueueMock) Submit(job *model.Job) error {
+	args := m.Called(job)
+	if job.Id.IsZero() {
+		job.Id = primitive.NewObjectID()
+	}
+	return args.Error(0)
+}
@@ -0,0 +1,101 @@
+package mock
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NatsMessage is a message published to a NatsServer.
+type NatsMessage struct {
+	Subject string
+	Data    []byte
+}
+
+// NatsServer is an in-process stand-in for NATS speaking enough of the client protocol for publishing: INFO,
+// CONNECT, PING and PUB.
+type NatsServer struct {
+	Url string
+
+	listener net.Listener
+	mu       sync.Mutex
+	messages []NatsMessage
+}
+
+// NewNatsServer starts the server on a random local port.
+func NewNatsServer() (*NatsServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &NatsServer{Url: "nats://" + l.Addr().String(), listener: l}
+	go s.serve()
+	return s, nil
+}
+
+// Messages returns the messages published so far.
+func (s *NatsServer) Messages() []NatsMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]NatsMessage(nil), s.messages...)
+}
+
+func (s *NatsServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *NatsServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *NatsServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	addr := s.listener.Addr().(*net.TCPAddr)
+	_, _ = fmt.Fprintf(conn, "INFO {\"server_id\":\"stand-in\",\"version\":\"2.1.0\",\"host\":\"127.0.0.1\",\"port\":%d,\"max_payload\":1048576,\"proto\":1}\r\n", addr.Port)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			_, _ = io.WriteString(conn, "PONG\r\n")
+		case "PUB":
+			// PUB <subject> [reply-to] <size>
+			size, err := strconv.Atoi(args[len(args)-1])
+			if err != nil || len(args) < 3 {
+				_, _ = io.WriteString(conn, "-ERR 'Unknown Protocol Operation'\r\n")
+				return
+			}
+
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.messages = append(s.messages, NatsMessage{Subject: args[1], Data: data[:size]})
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package mock
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisServer is an in-process stand-in for Redis speaking enough RESP for the cache: AUTH, SELECT, PING, GET,
+// SET with PX, and DEL.
+type RedisServer struct {
+	Addr     string
+	Password string
+
+	listener net.Listener
+	mu       sync.Mutex
+	data     map[string]redisValue
+	commands []string
+}
+
+type redisValue struct {
+	value   string
+	expires time.Time
+}
+
+// NewRedisServer starts the server on a random local port. An empty password disables authentication.
+func NewRedisServer(password string) (*RedisServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RedisServer{Addr: l.Addr().String(), Password: password, listener: l, data: make(map[string]redisValue)}
+	go s.serve()
+	return s, nil
+}
+
+// Commands returns the names of the commands received so far.
+func (s *RedisServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *RedisServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *RedisServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *RedisServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authenticated := s.Password == ""
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		name := strings.ToUpper(args[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, name)
+		s.mu.Unlock()
+
+		if name == "AUTH" {
+			if len(args) == 2 && args[1] == s.Password {
+				authenticated = true
+				_, _ = conn.Write([]byte("+OK\r\n"))
+			} else {
+				_, _ = conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+			}
+			continue
+		}
+
+		if !authenticated {
+			_, _ = conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+
+		_, _ = conn.Write([]byte(s.exec(name, args[1:])))
+	}
+}
+
+func (s *RedisServer) exec(name string, args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch name {
+	case "PING", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		v, ok := s.data[args[0]]
+		if !ok || (!v.expires.IsZero() && time.Now().After(v.expires)) {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v.value), v.value)
+	case "SET":
+		v := redisValue{value: args[1]}
+		nx := false
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "PX":
+				ms, _ := strconv.Atoi(args[i+1])
+				v.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+				i++
+			case "NX":
+				nx = true
+			}
+		}
+		if old, ok := s.data[args[0]]; nx && ok && (old.expires.IsZero() || time.Now().Before(old.expires)) {
+			return "$-1\r\n"
+		}
+		s.data[args[0]] = v
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, k := range args {
+			if _, ok := s.data[k]; ok {
+				delete(s.data, k)
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	}
+	return "-ERR unknown command '" + name + "'\r\n"
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || line[0] != '*' || n < 1 {
+		return nil, fmt.Errorf("malformed command %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
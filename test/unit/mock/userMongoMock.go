@@ -2,7 +2,10 @@ package mock
 
 import (
 	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/repository"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 type MongoMock struct {
@@ -19,6 +22,11 @@ func (m *MongoMock) FindByNickname(nickname string) (*model.User, error) {
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
+func (m *MongoMock) FindById(id primitive.ObjectID) (*model.User, error) {
+	args := m.Called(id)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
 func (m *MongoMock) Save(user *model.User) (*model.User, error) {
 	args := m.Called(user)
 	return args.Get(0).(*model.User), args.Error(1)
@@ -29,12 +37,78 @@ func (m *MongoMock) UpdateByNickname(nickname string, user *model.User) (int64,
 	return args.Get(0).(int64), args.Error(1)
 }
 
+func (m *MongoMock) FindAndUpdateByNickname(nickname string, user *model.User) (*model.User, error) {
+	args := m.Called(nickname, user)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func (m *MongoMock) FindAndUpdateById(id primitive.ObjectID, user *model.User) (*model.User, error) {
+	args := m.Called(id, user)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func (m *MongoMock) DeleteById(id primitive.ObjectID) (*model.User, error) {
+	args := m.Called(id)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
 func (m *MongoMock) FindAllByFilter(filter *model.Filter) ([]model.User, error) {
 	args := m.Called(filter)
 	return args.Get(0).([]model.User), args.Error(1)
 }
 
-func (m *MongoMock) Delete(nickname string) error {
+// Stream calls fn with the users returned by the mock, stopping at the first error.
+func (m *MongoMock) Stream(filter *model.Filter, fn func(user *model.User) error) error {
+	args := m.Called(filter)
+	for _, u := range args.Get(0).([]model.User) {
+		u := u
+		if err := fn(&u); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MongoMock) SaveMany(users []*model.User) ([]error, error) {
+	args := m.Called(users)
+	return args.Get(0).([]error), args.Error(1)
+}
+
+func (m *MongoMock) FindNicknames(nicknames []string) ([]string, error) {
+	args := m.Called(nicknames)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MongoMock) Count(filter *model.Filter) (int64, error) {
+	args := m.Called(filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MongoMock) Search(search *model.UserSearch) ([]model.UserHit, int64, error) {
+	args := m.Called(search)
+	return args.Get(0).([]model.UserHit), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *MongoMock) Delete(nickname string) (int64, error) {
+	args := m.Called(nickname)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MongoMock) Restore(nickname string) (*model.User, error) {
 	args := m.Called(nickname)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func (m *MongoMock) Purge(deletedBefore time.Time) (int64, error) {
+	args := m.Called(deletedBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// InTransaction runs fn on the mock itself, returning the error set with On("InTransaction") when fn succeeds.
+func (m *MongoMock) InTransaction(fn func(tx repository.UserRepository) error) error {
+	if err := fn(m); err != nil {
+		return err
+	}
+	args := m.Called()
 	return args.Error(0)
 }
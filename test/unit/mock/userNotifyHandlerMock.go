@@ -9,7 +9,7 @@ type NotifyMock struct {
 	mock.Mock
 }
 
-func (n *NotifyMock) Publish(user *model.User) error {
-	args := n.Called(user)
+func (n *NotifyMock) Publish(user *model.User, changes []model.FieldChange) error {
+	args := n.Called(user, changes)
 	return args.Error(0)
 }
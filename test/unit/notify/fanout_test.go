@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/notify"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFanoutPublishesToEverySink(t *testing.T) {
+	sns, webhook := &mock.NotifyMock{}, &mock.NotifyMock{}
+	sns.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+	webhook.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+
+	f := notify.Fanout{Logger: logger.ConfigureLogger(), Sinks: []notify.Sink{
+		{Name: "sns", Publisher: sns, Policy: config.NotifyRequired},
+		{Name: "webhook", Publisher: webhook, Policy: config.NotifyBestEffort},
+	}}
+
+	assert.Nil(t, f.Publish(user(), nil))
+	sns.AssertNumberOfCalls(t, "Publish", 1)
+	webhook.AssertNumberOfCalls(t, "Publish", 1)
+}
+
+func TestFanoutIgnoresBestEffortFailures(t *testing.T) {
+	sns, webhook := &mock.NotifyMock{}, &mock.NotifyMock{}
+	sns.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+	webhook.On("Publish", mock2.Anything, mock2.Anything).Return(errors.New("timeout"))
+
+	f := notify.Fanout{Logger: logger.ConfigureLogger(), Sinks: []notify.Sink{
+		{Name: "sns", Publisher: sns, Policy: config.NotifyRequired},
+		{Name: "webhook", Publisher: webhook, Policy: config.NotifyBestEffort, Retries: 2},
+	}}
+
+	assert.Nil(t, f.Publish(user(), nil))
+	webhook.AssertNumberOfCalls(t, "Publish", 3)
+}
+
+func TestFanoutFailsWhenARequiredSinkFails(t *testing.T) {
+	sns, kafka := &mock.NotifyMock{}, &mock.NotifyMock{}
+	sns.On("Publish", mock2.Anything, mock2.Anything).Return(errors.New("throttled"))
+	kafka.On("Publish", mock2.Anything, mock2.Anything).Return(errors.New("no leader")).Once()
+	kafka.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+
+	f := notify.Fanout{Logger: logger.ConfigureLogger(), Sinks: []notify.Sink{
+		{Name: "sns", Publisher: sns, Policy: config.NotifyRequired},
+		{Name: "kafka", Publisher: kafka, Policy: config.NotifyRequired, Retries: 1},
+	}}
+
+	assert.EqualError(t, f.Publish(user(), nil), "notifying failed on sns: throttled")
+	sns.AssertNumberOfCalls(t, "Publish", 1)
+	kafka.AssertNumberOfCalls(t, "Publish", 2)
+}
+
+func TestFanoutBacksOffBetweenRetries(t *testing.T) {
+	webhook := &mock.NotifyMock{}
+	webhook.On("Publish", mock2.Anything, mock2.Anything).Return(errors.New("timeout")).Twice()
+	webhook.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+
+	f := notify.Fanout{Logger: logger.ConfigureLogger(), Sinks: []notify.Sink{
+		{Name: "webhook", Publisher: webhook, Policy: config.NotifyRequired, Retries: 2, Backoff: 20 * time.Millisecond},
+	}}
+
+	start := time.Now()
+
+	assert.Nil(t, f.Publish(user(), nil))
+	assert.True(t, time.Since(start) >= 60*time.Millisecond, "waits 20ms then 40ms before the retries")
+	webhook.AssertNumberOfCalls(t, "Publish", 3)
+}
+
+func TestFanoutSendsTheSameEventToEverySink(t *testing.T) {
+	var first, second bytes.Buffer
+
+	f := notify.Fanout{Events: structured(), Logger: logger.ConfigureLogger(), Sinks: []notify.Sink{
+		{Name: "file", Publisher: &notify.File{Writer: &first, Events: structured()}, Policy: config.NotifyRequired},
+		{Name: "stdout", Publisher: &notify.File{Writer: &second, Events: structured()}, Policy: config.NotifyRequired},
+	}}
+
+	assert.Nil(t, f.Publish(user(), nil))
+
+	var a, b event.Event
+	assert.Nil(t, json.Unmarshal(first.Bytes(), &a))
+	assert.Nil(t, json.Unmarshal(second.Bytes(), &b))
+	assert.NotEmpty(t, a.Id)
+	assert.Equal(t, a.Id, b.Id)
+	assert.Equal(t, a.Time, b.Time)
+}
+
+func TestRetryBackoffIsClamped(t *testing.T) {
+	sink := notify.Sink{Backoff: 200 * time.Millisecond}
+
+	assert.Equal(t, 200*time.Millisecond, sink.RetryBackoff(1))
+	assert.Equal(t, 400*time.Millisecond, sink.RetryBackoff(2))
+	assert.Equal(t, notify.MaxBackoff, sink.RetryBackoff(20))
+	assert.Equal(t, notify.MaxBackoff, sink.RetryBackoff(100), "doesn't overflow")
+
+	sink.Backoff = time.Minute
+	assert.Equal(t, time.Minute, sink.RetryBackoff(5), "a longer backoff is kept as it is")
+}
+
+// replaySink records whether it was sent the events as replays.
+type replaySink struct {
+	published, replayed []*event.Event
+}
+
+func (s *replaySink) Publish(user *model.User, changes []model.FieldChange) error {
+	return errors.New("the event is built by the fan-out")
+}
+
+func (s *replaySink) PublishEvent(user *model.User, ev *event.Event) error {
+	s.published = append(s.published, ev)
+	return nil
+}
+
+func (s *replaySink) PublishReplayEvent(user *model.User, ev *event.Event) error {
+	s.replayed = append(s.replayed, ev)
+	return nil
+}
+
+func TestFanoutSendsReplaysAsReplays(t *testing.T) {
+	sns := &replaySink{}
+	var file bytes.Buffer
+
+	f := notify.Fanout{Events: structured(), Logger: logger.ConfigureLogger(), Sinks: []notify.Sink{
+		{Name: "sns", Publisher: sns, Policy: config.NotifyRequired},
+		{Name: "file", Publisher: &notify.File{Writer: &file, Events: structured()}, Policy: config.NotifyRequired},
+	}}
+
+	assert.Nil(t, f.Publish(user(), nil))
+	assert.Nil(t, f.PublishReplay(user(), nil))
+
+	assert.Len(t, sns.published, 1)
+	assert.Len(t, sns.replayed, 1)
+	assert.Len(t, strings.Split(strings.TrimSpace(file.String()), "\n"), 2, "sinks without replays publish them as events")
+}
@@ -0,0 +1,365 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/notify"
+	"github.com/bernardoms/user-api/pkg/eventsig"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/segmentio/kafka-go"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func user() *model.User {
+	return &model.User{Id: primitive.NewObjectID(), Nickname: "test1", Email: "test@test.com", Password: "hash", Country: "UK"}
+}
+
+func structured() *event.Encoder {
+	return &event.Encoder{Mode: config.EventModeStructured, Source: "/user-api", SchemaUrl: "https://schemas", Version: "v1"}
+}
+
+func binary() *event.Encoder {
+	return &event.Encoder{Mode: config.EventModeBinary, Source: "/user-api", SchemaUrl: "https://schemas", Version: "v1"}
+}
+
+// decoded is the user of a structured event.
+func decoded(t *testing.T, body []byte) model.User {
+	var ev event.Event
+	assert.Nil(t, json.Unmarshal(body, &ev))
+	assert.Equal(t, "1.0", ev.SpecVersion)
+	assert.Equal(t, event.TypeUserUpdated, ev.Type)
+
+	var u model.User
+	assert.Nil(t, json.Unmarshal(ev.Data, &u))
+	assert.Equal(t, u.Id.Hex(), ev.Subject)
+	return u
+}
+
+// sqsServer stands in for SQS, keeping the last message sent.
+func sqsServer(sent *url.Values) *httptest.Server {
+	_ = os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	_ = os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		*sent = r.PostForm
+		sum := md5.Sum([]byte(r.PostForm.Get("MessageBody")))
+		_, _ = fmt.Fprintf(w, `<SendMessageResponse><SendMessageResult><MD5OfMessageBody>%s</MD5OfMessageBody>`+
+			`<MessageId>1</MessageId></SendMessageResult></SendMessageResponse>`, hex.EncodeToString(sum[:]))
+	}))
+}
+
+func TestSQSSendsTheUserToTheQueue(t *testing.T) {
+	var sent url.Values
+	server := sqsServer(&sent)
+	defer server.Close()
+
+	s, err := notify.NewSQS(server.URL+"/queue/users", "us-east-1", server.URL, time.Second, structured())
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Publish(user(), nil))
+	assert.Equal(t, "SendMessage", sent.Get("Action"))
+	assert.Equal(t, server.URL+"/queue/users", sent.Get("QueueUrl"))
+	assert.Equal(t, "test1", decoded(t, []byte(sent.Get("MessageBody"))).Nickname)
+	assert.NotContains(t, sent.Get("MessageBody"), "hash")
+	assert.Empty(t, sent.Get("MessageAttribute.1.Name"))
+}
+
+func TestSQSSendsBinaryEventAttributes(t *testing.T) {
+	var sent url.Values
+	server := sqsServer(&sent)
+	defer server.Close()
+
+	s, _ := notify.NewSQS(server.URL+"/queue/users", "us-east-1", server.URL, time.Second, binary())
+	u := user()
+
+	assert.Nil(t, s.Publish(u, nil))
+
+	attributes := map[string]string{}
+	for n := 1; sent.Get(fmt.Sprintf("MessageAttribute.%d.Name", n)) != ""; n++ {
+		attributes[sent.Get(fmt.Sprintf("MessageAttribute.%d.Name", n))] = sent.Get(fmt.Sprintf("MessageAttribute.%d.Value.StringValue", n))
+	}
+
+	assert.Equal(t, u.Id.Hex(), attributes["ce_subject"])
+	assert.Equal(t, event.TypeUserUpdated, attributes["ce_type"])
+	assert.Equal(t, "application/json", attributes["ce_datacontenttype"])
+
+	var data model.User
+	assert.Nil(t, json.Unmarshal([]byte(sent.Get("MessageBody")), &data))
+	assert.Equal(t, "test1", data.Nickname)
+}
+
+func TestSQSNeedsTheQueueUrl(t *testing.T) {
+	_, err := notify.NewSQS("", "us-east-1", "", time.Second, structured())
+
+	assert.EqualError(t, err, "the sqs sink needs SQS_QUEUE_URL")
+}
+
+type kafkaWriter struct {
+	messages []kafka.Message
+	err      error
+}
+
+func (k *kafkaWriter) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("no timeout")
+	}
+	k.messages = append(k.messages, messages...)
+	return k.err
+}
+
+func TestKafkaWritesTheUserKeyedById(t *testing.T) {
+	w := &kafkaWriter{}
+	k := notify.Kafka{Writer: w, Timeout: time.Second, Events: structured()}
+	u := user()
+
+	assert.Nil(t, k.Publish(u, nil))
+	assert.Len(t, w.messages, 1)
+	assert.Equal(t, u.Id.Hex(), string(w.messages[0].Key))
+	assert.Equal(t, "test1", decoded(t, w.messages[0].Value).Nickname)
+	assert.Equal(t, []kafka.Header{{Key: "content-type", Value: []byte("application/cloudevents+json")}}, w.messages[0].Headers)
+}
+
+func TestKafkaSendsBinaryEventHeaders(t *testing.T) {
+	w := &kafkaWriter{}
+	k := notify.Kafka{Writer: w, Timeout: time.Second, Events: binary()}
+	u := user()
+
+	assert.Nil(t, k.Publish(u, nil))
+
+	headers := map[string]string{}
+	for _, h := range w.messages[0].Headers {
+		headers[h.Key] = string(h.Value)
+	}
+
+	assert.Equal(t, "application/json", headers["content-type"])
+	assert.Equal(t, u.Id.Hex(), headers["ce_subject"])
+	assert.Equal(t, "https://schemas/user/v1", headers["ce_dataschema"])
+}
+
+func TestKafkaNeedsBrokersAndTopic(t *testing.T) {
+	_, err := notify.NewKafka(nil, "users", time.Second, structured())
+
+	assert.EqualError(t, err, "the kafka sink needs KAFKA_BROKERS and KAFKA_TOPIC")
+}
+
+func TestNATSPublishesToTheSubject(t *testing.T) {
+	server, _ := mock.NewNatsServer()
+	defer server.Close()
+
+	n, err := notify.NewNATS(server.Url, "users.updated", time.Second, binary())
+	assert.Nil(t, err)
+	defer n.Close()
+
+	assert.Nil(t, n.Publish(user(), nil))
+
+	messages := server.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "users.updated", messages[0].Subject)
+	assert.Equal(t, "test1", decoded(t, messages[0].Data).Nickname)
+}
+
+func TestNATSStartsWithoutTheServer(t *testing.T) {
+	n, err := notify.NewNATS("nats://127.0.0.1:1", "users.updated", 50*time.Millisecond, structured())
+	assert.Nil(t, err)
+	defer n.Close()
+
+	assert.Error(t, n.Publish(user(), nil))
+}
+
+type amqpChannel struct {
+	published []amqp.Publishing
+	err       error
+	closed    bool
+}
+
+func (c *amqpChannel) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *amqpChannel) Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.published = append(c.published, msg)
+	return nil
+}
+
+func TestAMQPReconnectsWhenTheChannelIsClosed(t *testing.T) {
+	closed := &amqpChannel{err: amqp.ErrClosed}
+	open := &amqpChannel{}
+	channels := []*amqpChannel{closed, open}
+
+	a := notify.AMQP{Exchange: "users", RoutingKey: "updated", Events: structured(), Connect: func() (notify.AMQPChannel, error) {
+		c := channels[0]
+		channels = channels[1:]
+		return c, nil
+	}}
+
+	assert.Nil(t, a.Publish(user(), nil))
+	assert.True(t, closed.closed, "the connection of the closed channel is closed")
+	assert.False(t, open.closed)
+	assert.Len(t, open.published, 1)
+	assert.Equal(t, "application/cloudevents+json", open.published[0].ContentType)
+	assert.Equal(t, amqp.Persistent, open.published[0].DeliveryMode)
+
+	assert.Nil(t, a.Publish(user(), nil))
+	assert.Len(t, open.published, 2)
+}
+
+func TestAMQPFailsWhenTheBrokerDoesntConfirm(t *testing.T) {
+	channel := &amqpChannel{err: errors.New("the amqp broker didn't take the message")}
+	a := notify.AMQP{Events: structured(), Connect: func() (notify.AMQPChannel, error) { return channel, nil }}
+
+	assert.EqualError(t, a.Publish(user(), nil), "the amqp broker didn't take the message")
+	assert.False(t, channel.closed)
+}
+
+func TestAMQPFailsWhenItCantConnect(t *testing.T) {
+	a := notify.AMQP{Events: structured(), Connect: func() (notify.AMQPChannel, error) { return nil, errors.New("connection refused") }}
+
+	assert.EqualError(t, a.Publish(user(), nil), "connection refused")
+}
+
+func TestAMQPSendsBinaryEventHeaders(t *testing.T) {
+	channel := &amqpChannel{}
+	a := notify.AMQP{Events: binary(), Connect: func() (notify.AMQPChannel, error) { return channel, nil }}
+	u := user()
+
+	assert.Nil(t, a.Publish(u, nil))
+
+	published := channel.published[0]
+	assert.Equal(t, "application/json", published.ContentType)
+	assert.Equal(t, u.Id.Hex(), published.Headers["cloudEvents:subject"])
+	assert.Equal(t, published.MessageId, published.Headers["cloudEvents:id"])
+}
+
+func TestWebhookPostsTheUser(t *testing.T) {
+	var body []byte
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		contentType = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	h, _ := notify.NewWebhook(server.URL, time.Second, structured())
+
+	assert.Nil(t, h.Publish(user(), nil))
+	assert.Equal(t, "application/cloudevents+json", contentType)
+	assert.Equal(t, "test1", decoded(t, body).Nickname)
+}
+
+func TestWebhookSendsBinaryEventHeaders(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		header = r.Header
+	}))
+	defer server.Close()
+
+	h, _ := notify.NewWebhook(server.URL, time.Second, binary())
+	u := user()
+
+	assert.Nil(t, h.Publish(u, nil))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, "1.0", header.Get("ce-specversion"))
+	assert.Equal(t, u.Id.Hex(), header.Get("ce-subject"))
+	assert.Equal(t, "/user-api", header.Get("ce-source"))
+
+	var data model.User
+	assert.Nil(t, json.Unmarshal(body, &data))
+	assert.Equal(t, "test1", data.Nickname)
+}
+
+func TestSinksSignWithTheirOwnKeys(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		header = r.Header
+	}))
+	defer server.Close()
+
+	secret := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	c := &config.NotifyConfig{WebhookUrl: server.URL, Timeout: time.Second,
+		SigningKeys: map[string]string{"webhook": "2020-07:hmac-sha256:" + secret}}
+
+	h, err := notify.NewSink("webhook", c, binary())
+	assert.Nil(t, err)
+	assert.Nil(t, h.Publish(user(), nil))
+
+	attributes := map[string]string{}
+	for name := range header {
+		if strings.HasPrefix(strings.ToLower(name), "ce-") {
+			attributes[strings.ToLower(name)[3:]] = header.Get(name)
+		}
+	}
+
+	keys, _ := eventsig.ParseKeys(c.SigningKeys["webhook"])
+	assert.True(t, strings.HasPrefix(attributes["signature"], "2020-07="))
+	assert.Nil(t, eventsig.Verify(attributes, body, keys))
+
+	c.SigningKeys["webhook"] = "2020-07:hmac-sha256:c2hvcnQ="
+	_, err = notify.NewSink("webhook", c, binary())
+	assert.EqualError(t, err, "invalid signing keys of notify sink webhook: signing key 2020-07 is too short, hmac-sha256 keys need at least 32 bytes")
+}
+
+func TestWebhookFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	h, _ := notify.NewWebhook(server.URL, time.Second, structured())
+
+	assert.EqualError(t, h.Publish(user(), nil), "webhook answered 503 Service Unavailable")
+}
+
+func TestFileAppendsJsonLines(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "notify")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.ndjson")
+
+	f, err := notify.NewFile(path, binary())
+	assert.Nil(t, err)
+
+	assert.Nil(t, f.Publish(user(), nil))
+	assert.Nil(t, f.Publish(user(), nil))
+
+	content, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "test1", decoded(t, []byte(lines[1])).Nickname)
+}
+
+func TestFileWritesToAWriter(t *testing.T) {
+	var b bytes.Buffer
+	f := notify.File{Writer: &b, Events: structured()}
+
+	assert.Nil(t, f.Publish(user(), nil))
+	assert.True(t, strings.HasSuffix(b.String(), "}\n"))
+}
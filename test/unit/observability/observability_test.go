@@ -0,0 +1,216 @@
+package observability
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/bernardoms/user-api/config"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/observability"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewWithoutProviderAndLicenseIsNoop(t *testing.T) {
+	p := observability.New(&config.ObservabilityConfig{}, logger.ConfigureLogger())
+
+	assert.IsType(t, observability.Noop{}, p)
+}
+
+func TestNewWithUnknownProviderIsNoop(t *testing.T) {
+	p := observability.New(&config.ObservabilityConfig{Provider: "datadog"}, logger.ConfigureLogger())
+
+	assert.IsType(t, observability.Noop{}, p)
+}
+
+func TestNewWithUnknownOtelExporterIsNoop(t *testing.T) {
+	p := observability.New(&config.ObservabilityConfig{Provider: "otel", OtelExporter: "carrier-pigeon"}, logger.ConfigureLogger())
+
+	assert.IsType(t, observability.Noop{}, p)
+}
+
+func TestNewOtel(t *testing.T) {
+	p := observability.New(&config.ObservabilityConfig{Provider: "otel"}, logger.ConfigureLogger())
+
+	assert.IsType(t, &observability.Otel{}, p)
+}
+
+func TestOtelInstrumentRecordsSpanPerRoute(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	p := observability.NewOtelWithProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), "test")
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/users/{nickname}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	p.Instrument(r)
+
+	req, _ := http.NewRequest("GET", "/v1/users/test1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	spans := recorder.Ended()
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "GET /v1/users/{nickname}", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), attribute.Int("http.status_code", http.StatusNotFound))
+}
+
+func TestOtelInstrumentKeepsTheWriterFlushable(t *testing.T) {
+	p := observability.NewOtelWithProvider(sdktrace.NewTracerProvider(), "test")
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/users/export", func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		assert.True(t, ok)
+		_, _ = w.Write([]byte("nickname"))
+		f.Flush()
+	}).Methods("GET")
+	p.Instrument(r)
+
+	req, _ := http.NewRequest("GET", "/v1/users/export", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.True(t, w.Flushed)
+}
+
+func TestNewOtelWithOtlpExporter(t *testing.T) {
+	p := observability.New(&config.ObservabilityConfig{Provider: "otel", OtelExporter: "otlp",
+		Otlp: config.OtlpConfig{Endpoint: "http://localhost:4318/v1/traces", Timeout: time.Second}}, logger.ConfigureLogger())
+
+	assert.IsType(t, &observability.Otel{}, p)
+}
+
+func TestNewOtlpUnsupportedProtocol(t *testing.T) {
+	_, err := observability.NewOTLP(config.OtlpConfig{Protocol: "grpc"})
+
+	assert.EqualError(t, err, "unsupported otlp protocol grpc, only http/json is")
+}
+
+func TestOtlpExportsSpans(t *testing.T) {
+	var request *http.Request
+	var body []byte
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer collector.Close()
+
+	exporter, _ := observability.NewOTLP(config.OtlpConfig{Endpoint: collector.URL + "/v1/traces",
+		Headers: map[string]string{"api-key": "secret"}, Timeout: time.Second})
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := tp.Tracer("test").Start(context.Background(), "GET /v1/users", trace.WithSpanKind(trace.SpanKindServer))
+	span.SetAttributes(attribute.Int("http.status_code", 500), attribute.String("http.method", "GET"))
+	span.SetStatus(codes.Error, "Internal Server Error")
+	span.End()
+
+	assert.Equal(t, "/v1/traces", request.URL.Path)
+	assert.Equal(t, "application/json", request.Header.Get("Content-Type"))
+	assert.Equal(t, "secret", request.Header.Get("api-key"))
+
+	var exported struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Scope struct{ Name string }
+				Spans []struct {
+					TraceId    string
+					SpanId     string
+					Name       string
+					Kind       int
+					Attributes []map[string]interface{}
+					Status     struct {
+						Code    int
+						Message string
+					}
+				}
+			}
+		}
+	}
+	assert.Nil(t, json.Unmarshal(body, &exported))
+
+	exportedSpan := exported.ResourceSpans[0].ScopeSpans[0].Spans[0]
+
+	assert.Equal(t, "test", exported.ResourceSpans[0].ScopeSpans[0].Scope.Name)
+	assert.Equal(t, span.SpanContext().TraceID().String(), exportedSpan.TraceId)
+	assert.Equal(t, span.SpanContext().SpanID().String(), exportedSpan.SpanId)
+	assert.Equal(t, "GET /v1/users", exportedSpan.Name)
+	assert.Equal(t, 2, exportedSpan.Kind)
+	assert.Equal(t, 2, exportedSpan.Status.Code)
+	assert.Equal(t, "Internal Server Error", exportedSpan.Status.Message)
+	assert.Contains(t, exportedSpan.Attributes, map[string]interface{}{"key": "http.status_code", "value": map[string]interface{}{"intValue": "500"}})
+	assert.Contains(t, exportedSpan.Attributes, map[string]interface{}{"key": "http.method", "value": map[string]interface{}{"stringValue": "GET"}})
+}
+
+func TestOtlpExportFailsOnErrorStatus(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exporter, _ := observability.NewOTLP(config.OtlpConfig{Endpoint: collector.URL, Timeout: time.Second})
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+	span.End()
+
+	err := exporter.ExportSpans(context.Background(), recorder.Ended())
+
+	assert.EqualError(t, err, "otlp export to "+collector.URL+" answered 503")
+}
+
+func TestOtelInstrumentContinuesTheCallerTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	p := observability.NewOtelWithProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), "test")
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/users", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+	p.Instrument(r)
+
+	req, _ := http.NewRequest("GET", "/v1/users", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+}
+
+func TestOtlpConfigFromEnvironment(t *testing.T) {
+	for k, v := range map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT":       "http://collector:4318/",
+		"OTEL_EXPORTER_OTLP_HEADERS":        "api-key=general,tenant=a%20b",
+		"OTEL_EXPORTER_OTLP_TRACES_HEADERS": "api-key=traces",
+		"OTEL_EXPORTER_OTLP_TIMEOUT":        "2500",
+	} {
+		_ = os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	c := config.NewOtlpConfig()
+
+	assert.Equal(t, "http://collector:4318/v1/traces", c.Endpoint)
+	assert.Equal(t, map[string]string{"api-key": "traces", "tenant": "a b"}, c.Headers)
+	assert.Equal(t, 2500*time.Millisecond, c.Timeout)
+
+	_ = os.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "http://traces:4318/custom")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+
+	assert.Equal(t, "http://traces:4318/custom", config.NewOtlpConfig().Endpoint)
+}
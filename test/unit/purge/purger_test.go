@@ -0,0 +1,54 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"github.com/bernardoms/user-api/internal/logger"
+	"github.com/bernardoms/user-api/internal/purge"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestPurgeOnceUsesRetention(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+
+	now := time.Date(2020, 7, 31, 10, 0, 0, 0, time.UTC)
+	mongoMock.On("Purge", time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)).Return(int64(2), nil)
+
+	p := purge.Purger{Repository: &mongoMock, Retention: 30 * 24 * time.Hour, Interval: time.Hour, Logger: logger.ConfigureLogger()}
+	p.PurgeOnce(now)
+
+	mongoMock.AssertExpectations(t)
+}
+
+func TestPurgeOnceError(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("Purge", mock2.Anything).Return(int64(0), errors.New("error on mongo"))
+
+	p := purge.Purger{Repository: &mongoMock, Retention: time.Hour, Interval: time.Hour, Logger: logger.ConfigureLogger()}
+
+	assert.NotPanics(t, func() { p.PurgeOnce(time.Now()) })
+}
+
+func TestRunPurgesUntilCancelled(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("Purge", mock2.Anything).Return(int64(0), nil)
+
+	p := purge.Purger{Repository: &mongoMock, Retention: time.Hour, Interval: 5 * time.Millisecond, Logger: logger.ConfigureLogger()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+	<-done
+
+	assert.True(t, len(mongoMock.Calls) >= 2)
+}
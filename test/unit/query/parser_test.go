@@ -0,0 +1,165 @@
+package query
+
+import (
+	"github.com/bernardoms/user-api/internal/query"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) *time.Time {
+	t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+func TestParseValueList(t *testing.T) {
+	n, err := query.ParseValue("country", "UK,BR")
+
+	assert.Nil(t, err)
+	assert.Equal(t, query.In{Field: "country", Values: []string{"UK", "BR"}}, n)
+}
+
+func TestParseValueNegated(t *testing.T) {
+	n, err := query.ParseValue("country", "!UK,BR")
+
+	assert.Nil(t, err)
+	assert.Equal(t, query.Not{Node: query.In{Field: "country", Values: []string{"UK", "BR"}}}, n)
+}
+
+func TestParseValuePrefixes(t *testing.T) {
+	n, err := query.ParseValue("nickname", "bob*,alice,ann*")
+
+	assert.Nil(t, err)
+	assert.Equal(t, query.Or{Nodes: []query.Node{
+		query.In{Field: "nickname", Values: []string{"alice"}},
+		query.Prefix{Field: "nickname", Value: "bob"},
+		query.Prefix{Field: "nickname", Value: "ann"},
+	}}, n)
+}
+
+func TestParseValueQuoted(t *testing.T) {
+	n, err := query.ParseValue("lastName", `"da Silva, Jr*",Lee`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, query.In{Field: "lastName", Values: []string{"da Silva, Jr*", "Lee"}}, n)
+}
+
+func TestParseValueRanges(t *testing.T) {
+	n, err := query.ParseValue("createdAt", "2020-01-01..2020-02-01")
+
+	assert.Nil(t, err)
+	assert.Equal(t, query.Range{Field: "createdAt", From: date(2020, 1, 1), To: date(2020, 2, 1)}, n)
+
+	n, err = query.ParseValue("updatedAt", "..2020-07-01T10:00:00Z")
+
+	assert.Nil(t, err)
+	to := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, query.Range{Field: "updatedAt", To: &to}, n)
+
+	n, err = query.ParseValue("createdAt", "2020-01-31")
+
+	assert.Nil(t, err)
+	assert.Equal(t, query.Range{Field: "createdAt", From: date(2020, 1, 31), To: date(2020, 2, 1)}, n)
+}
+
+func TestParseExpression(t *testing.T) {
+	n, err := query.Parse(`country=UK,BR and (nickname=bob* or not email="bob@test.com") or firstName!=Ann`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, query.Or{Nodes: []query.Node{
+		query.And{Nodes: []query.Node{
+			query.In{Field: "country", Values: []string{"UK", "BR"}},
+			query.Or{Nodes: []query.Node{
+				query.Prefix{Field: "nickname", Value: "bob"},
+				query.Not{Node: query.In{Field: "email", Values: []string{"bob@test.com"}}},
+			}},
+		}},
+		query.Not{Node: query.In{Field: "firstName", Values: []string{"Ann"}}},
+	}}, n)
+}
+
+func TestParseKeywordsAreCaseInsensitive(t *testing.T) {
+	n, err := query.Parse("NOT country=UK AND nickname=bob")
+
+	assert.Nil(t, err)
+	assert.Equal(t, query.And{Nodes: []query.Node{
+		query.Not{Node: query.In{Field: "country", Values: []string{"UK"}}},
+		query.In{Field: "nickname", Values: []string{"bob"}},
+	}}, n)
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := map[string]string{
+		"password=secret":           `unknown field at position 1 near "password"`,
+		"country UK":                `expected = or != at position 9 near "UK"`,
+		"country=UK nickname=bob":   `expected and, or or the end of the filter at position 12 near "nickname"`,
+		"(country=UK":               `expected ) at position 12, the end of the filter`,
+		"country=":                  `expected a value at position 9, the end of the filter`,
+		"country!=!UK":              `unexpected ! after != at position 10 near "!"`,
+		"nickname=*":                `invalid prefix at position 10 near "*"`,
+		"createdAt=2020-13-01":      `invalid date, expected YYYY-MM-DD or RFC 3339 at position 11 near "2020-13-01"`,
+		"createdAt=2020-01-01..bad": `invalid end of range, expected YYYY-MM-DD or RFC 3339 at position 11 near "2020-01-01..bad"`,
+		"createdAt=..":              `range without bounds at position 11 near ".."`,
+		`email="bob`:                `unterminated quoted value at position 7 near "\"bob"`,
+	}
+
+	for expression, expected := range cases {
+		_, err := query.Parse(expression)
+
+		if assert.Error(t, err, expression) {
+			assert.Equal(t, expected, err.Error(), expression)
+		}
+	}
+}
+
+func TestFromParams(t *testing.T) {
+	params := url.Values{}
+	params.Set("country", "UK,BR")
+	params.Set("createdAt", "2020-01-01..")
+	params.Set("filter", "not nickname=bob")
+	params.Set("page", "2")
+
+	n, err := query.FromParams(params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, query.And{Nodes: []query.Node{
+		query.In{Field: "country", Values: []string{"UK", "BR"}},
+		query.Range{Field: "createdAt", From: date(2020, 1, 1)},
+		query.Not{Node: query.In{Field: "nickname", Values: []string{"bob"}}},
+	}}, n)
+}
+
+func TestFromParamsNone(t *testing.T) {
+	n, err := query.FromParams(url.Values{"page": {"1"}})
+
+	assert.Nil(t, err)
+	assert.Nil(t, n)
+}
+
+func TestFromParamsNamesTheParameter(t *testing.T) {
+	_, err := query.FromParams(url.Values{"country": {"UK,"}})
+
+	assert.EqualError(t, err, "parameter country: expected a value at position 4, the end of the filter")
+}
+
+func TestParseLimitsTheDepth(t *testing.T) {
+	expression := strings.Repeat("(", query.MaxDepth) + "country=UK" + strings.Repeat(")", query.MaxDepth)
+	_, err := query.Parse(expression)
+	assert.Nil(t, err)
+
+	_, err = query.Parse(strings.Repeat("not ", query.MaxDepth+1) + "country=UK")
+	assert.EqualError(t, err, `nested deeper than 32 levels at position 129 near "not"`)
+
+	_, err = query.Parse(strings.Repeat("(", 40) + "country=UK" + strings.Repeat(")", 40))
+	assert.EqualError(t, err, `nested deeper than 32 levels at position 33 near "("`)
+}
+
+func TestParseLimitsTheLength(t *testing.T) {
+	_, err := query.Parse("nickname=" + strings.Repeat("a", query.MaxLength))
+	assert.EqualError(t, err, "longer than the 2048 bytes allowed")
+
+	_, err = query.FromParams(url.Values{"country": {strings.Repeat("UK,", query.MaxLength)}})
+	assert.EqualError(t, err, "parameter country: longer than the 2048 bytes allowed")
+}
@@ -0,0 +1,245 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"github.com/bernardoms/user-api/internal/event"
+	"github.com/bernardoms/user-api/internal/job"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/query"
+	"github.com/bernardoms/user-api/internal/replay"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+var (
+	userId = primitive.NewObjectID()
+	july   = time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func current() *model.User {
+	return &model.User{Id: userId, Nickname: "bob2", Country: "BR", Email: "bob@test.com", Password: "hash"}
+}
+
+// history renames bob to bob2 then moves him from UK to BR.
+func history() []model.AuditEntry {
+	return []model.AuditEntry{
+		{Id: primitive.NewObjectID(), UserId: userId, Nickname: "bob2", Operation: model.OperationUpdate, Actor: "ann",
+			Timestamp: july, Changes: []model.FieldChange{{Field: "nickname", Before: "bob", After: "bob2"}}},
+		{Id: primitive.NewObjectID(), UserId: userId, Nickname: "bob2", Operation: model.OperationUpdate, Actor: "joe",
+			Timestamp: july.Add(time.Hour), Changes: []model.FieldChange{{Field: "country", Before: "UK", After: "BR"}}},
+	}
+}
+
+func TestReplayAuditPublishesTheUserAsItWas(t *testing.T) {
+	mongoMock, auditMock, notifyMock := &mock.MongoMock{}, &mock.AuditMock{}, &mock.NotifyMock{}
+	entries := history()
+	from := july
+
+	filter := &model.AuditFilter{Nickname: "bob2", Operations: []string{model.OperationUpdate}, From: &from}
+	auditMock.On("Count", filter).Return(int64(2), nil)
+	auditMock.On("Stream", filter).Return(entries, nil)
+	// an email change not replayed, in between
+	hidden := model.AuditEntry{Id: primitive.NewObjectID(), UserId: userId, Nickname: "bob2", Operation: model.OperationRestore,
+		Timestamp: july.Add(time.Minute), Changes: []model.FieldChange{{Field: "email", Before: "old@test.com", After: "bob@test.com"}}}
+
+	auditMock.On("FindAfter", &entries[0]).Return([]model.AuditEntry{entries[1], hidden}, nil)
+	mongoMock.On("FindById", userId).Return(current(), nil)
+	notifyMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+
+	p := &replay.Replayer{Users: mongoMock, Audit: auditMock, Publisher: notifyMock}
+	j := &model.Job{}
+
+	err := p.Run(context.Background(), &model.ReplayRequest{Source: model.ReplayAudit, From: &from, Nickname: "bob2"}, 0, job.NewProgress(j))
+
+	assert.Nil(t, err)
+	assert.Equal(t, model.JobProgress{Total: 2, Done: 2}, j.Progress)
+	assert.Equal(t, int64(2), j.Checkpoint)
+
+	renamed := notifyMock.Calls[0].Arguments.Get(0).(*model.User)
+	assert.Equal(t, "bob2", renamed.Nickname)
+	assert.Equal(t, "UK", renamed.Country)
+	assert.Equal(t, "old@test.com", renamed.Email)
+	assert.Equal(t, "ann", renamed.UpdatedBy)
+	assert.Equal(t, july, *renamed.UpdatedAt)
+	assert.Empty(t, renamed.Password)
+	assert.Equal(t, entries[0].Changes, notifyMock.Calls[0].Arguments.Get(1))
+
+	moved := notifyMock.Calls[1].Arguments.Get(0).(*model.User)
+	assert.Equal(t, "BR", moved.Country)
+	assert.Equal(t, "bob@test.com", moved.Email)
+	assert.Equal(t, "joe", moved.UpdatedBy)
+
+	mongoMock.AssertNumberOfCalls(t, "FindById", 1)
+	auditMock.AssertNumberOfCalls(t, "FindAfter", 1)
+}
+
+func TestReplayAuditSkipsEntriesWithoutChanges(t *testing.T) {
+	mongoMock, auditMock, notifyMock := &mock.MongoMock{}, &mock.AuditMock{}, &mock.NotifyMock{}
+	entries := history()
+	entries[0].Changes = []model.FieldChange{}
+
+	auditMock.On("Count", mock2.Anything).Return(int64(2), nil)
+	auditMock.On("Stream", mock2.Anything).Return(entries, nil)
+	auditMock.On("FindAfter", &entries[1]).Return([]model.AuditEntry{}, nil)
+	mongoMock.On("FindById", userId).Return(current(), nil)
+	notifyMock.On("Publish", mock2.Anything, entries[1].Changes).Return(nil)
+
+	p := &replay.Replayer{Users: mongoMock, Audit: auditMock, Publisher: notifyMock}
+	j := &model.Job{}
+
+	err := p.Run(context.Background(), &model.ReplayRequest{Source: model.ReplayAudit}, 0, job.NewProgress(j))
+
+	assert.Nil(t, err)
+	assert.Equal(t, model.JobProgress{Total: 2, Done: 2}, j.Progress)
+	assert.Equal(t, int64(2), j.Checkpoint)
+	notifyMock.AssertNumberOfCalls(t, "Publish", 1)
+}
+
+func TestReplayAuditCountsFailuresAndGoesOn(t *testing.T) {
+	mongoMock, auditMock, notifyMock := &mock.MongoMock{}, &mock.AuditMock{}, &mock.NotifyMock{}
+	entries := history()
+	entries[0].UserId = primitive.NewObjectID()
+
+	auditMock.On("Count", mock2.Anything).Return(int64(2), nil)
+	auditMock.On("Stream", mock2.Anything).Return(entries, nil)
+	auditMock.On("FindAfter", mock2.Anything).Return([]model.AuditEntry{}, nil)
+	mongoMock.On("FindById", entries[0].UserId).Return((*model.User)(nil), nil)
+	mongoMock.On("FindById", userId).Return(current(), nil)
+	notifyMock.On("Publish", mock2.Anything, mock2.Anything).Return(errors.New("throttled"))
+
+	p := &replay.Replayer{Users: mongoMock, Audit: auditMock, Publisher: notifyMock}
+	j := &model.Job{}
+
+	err := p.Run(context.Background(), &model.ReplayRequest{Source: model.ReplayAudit}, 0, job.NewProgress(j))
+
+	assert.Nil(t, err)
+	assert.Equal(t, model.JobProgress{Total: 2, Done: 2, Failed: 2}, j.Progress)
+	assert.Equal(t, []string{
+		"bob2 at 2020-07-01T00:00:00Z: the user no longer exists",
+		"bob2 at 2020-07-01T01:00:00Z: throttled",
+	}, j.Errors)
+}
+
+func TestReplayAuditReadsADeletedUserOnce(t *testing.T) {
+	mongoMock, auditMock, notifyMock := &mock.MongoMock{}, &mock.AuditMock{}, &mock.NotifyMock{}
+	entries := history()
+
+	auditMock.On("Count", mock2.Anything).Return(int64(2), nil)
+	auditMock.On("Stream", mock2.Anything).Return(entries, nil)
+	// deleted after its last update, the delete isn't replayed
+	deleted := model.AuditEntry{Id: primitive.NewObjectID(), UserId: userId, Nickname: "bob2", Operation: model.OperationDelete,
+		Timestamp: july.Add(2 * time.Hour), Changes: []model.FieldChange{{Field: "nickname", Before: "bob2"}}}
+	auditMock.On("FindAfter", &entries[0]).Return([]model.AuditEntry{deleted, entries[1]}, nil)
+	mongoMock.On("FindById", userId).Return((*model.User)(nil), nil)
+
+	p := &replay.Replayer{Users: mongoMock, Audit: auditMock, Publisher: notifyMock}
+	j := &model.Job{}
+
+	request := &model.ReplayRequest{Source: model.ReplayAudit, Types: []string{event.TypeUserUpdated}}
+	assert.Nil(t, p.Run(context.Background(), request, 0, job.NewProgress(j)))
+
+	assert.Equal(t, model.JobProgress{Total: 2, Done: 2, Failed: 2}, j.Progress)
+	mongoMock.AssertNumberOfCalls(t, "FindById", 1)
+	auditMock.AssertNumberOfCalls(t, "FindAfter", 1)
+	notifyMock.AssertNotCalled(t, "Publish", mock2.Anything, mock2.Anything)
+}
+
+func TestReplayRejectsUnknownEventTypes(t *testing.T) {
+	mongoMock, auditMock := &mock.MongoMock{}, &mock.AuditMock{}
+	p := &replay.Replayer{Users: mongoMock, Audit: auditMock, Publisher: &mock.NotifyMock{}}
+
+	request := &model.ReplayRequest{Source: model.ReplayAudit, Types: []string{"com.bernardoms.user.deleted"}}
+	err := p.Run(context.Background(), request, 0, job.NewProgress(&model.Job{}))
+
+	assert.EqualError(t, err, "unknown event type com.bernardoms.user.deleted, it must be one of "+event.TypeUserUpdated)
+	auditMock.AssertNotCalled(t, "Count", mock2.Anything)
+}
+
+func TestReplayAuditNeedsTheHistory(t *testing.T) {
+	p := &replay.Replayer{Users: &mock.MongoMock{}, Publisher: &mock.NotifyMock{}}
+
+	err := p.Run(context.Background(), &model.ReplayRequest{Source: model.ReplayAudit}, 0, job.NewProgress(&model.Job{}))
+
+	assert.EqualError(t, err, "the history is not recorded, only snapshots can be replayed")
+}
+
+func TestReplaySnapshotsResumesAfterCheckpoint(t *testing.T) {
+	mongoMock, notifyMock := &mock.MongoMock{}, &mock.NotifyMock{}
+	to := july
+
+	filter := &model.Filter{UpdatedBefore: &to, Query: query.In{Field: "nickname", Values: []string{"bob"}}}
+	mongoMock.On("Count", filter).Return(int64(2), nil)
+	mongoMock.On("Stream", filter).Return([]model.User{{Nickname: "bob"}, {Nickname: "bob"}}, nil)
+	notifyMock.On("Publish", mock2.Anything, ([]model.FieldChange)(nil)).Return(nil)
+
+	p := &replay.Replayer{Users: mongoMock, Publisher: notifyMock}
+	j := &model.Job{}
+
+	request := &model.ReplayRequest{Source: model.ReplaySnapshot, To: &to, Nickname: "bob", Types: []string{event.TypeUserUpdated}}
+	err := p.Run(context.Background(), request, 1, job.NewProgress(j))
+
+	assert.Nil(t, err)
+	assert.Equal(t, model.JobProgress{Total: 2, Done: 2}, j.Progress)
+	notifyMock.AssertNumberOfCalls(t, "Publish", 1)
+}
+
+func TestReplayIsThrottled(t *testing.T) {
+	mongoMock, notifyMock := &mock.MongoMock{}, &mock.NotifyMock{}
+	mongoMock.On("Count", mock2.Anything).Return(int64(4), nil)
+	mongoMock.On("Stream", mock2.Anything).Return(make([]model.User, 4), nil)
+	notifyMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+
+	p := &replay.Replayer{Users: mongoMock, Publisher: notifyMock, Rate: 100}
+
+	start := time.Now()
+	err := p.Run(context.Background(), &model.ReplayRequest{Source: model.ReplaySnapshot, Rate: 20}, 0, job.NewProgress(&model.Job{}))
+
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= 150*time.Millisecond, "4 events at 20 per second take at least 150ms")
+}
+
+func TestReplayStopsWhenCancelled(t *testing.T) {
+	mongoMock, notifyMock := &mock.MongoMock{}, &mock.NotifyMock{}
+	mongoMock.On("Count", mock2.Anything).Return(int64(3), nil)
+	mongoMock.On("Stream", mock2.Anything).Return(make([]model.User, 3), nil)
+	notifyMock.On("Publish", mock2.Anything, mock2.Anything).Return(nil)
+
+	p := &replay.Replayer{Users: mongoMock, Publisher: notifyMock, Rate: 1}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := p.Run(ctx, &model.ReplayRequest{Source: model.ReplaySnapshot}, 0, job.NewProgress(&model.Job{}))
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	notifyMock.AssertNumberOfCalls(t, "Publish", 1)
+}
+
+// replayPublisher records the users it was sent as replays.
+type replayPublisher struct {
+	mock.NotifyMock
+	replayed []*model.User
+}
+
+func (p *replayPublisher) PublishReplay(user *model.User, changes []model.FieldChange) error {
+	p.replayed = append(p.replayed, user)
+	return nil
+}
+
+func TestReplayPublishesAsReplays(t *testing.T) {
+	mongoMock, publisher := &mock.MongoMock{}, &replayPublisher{}
+	mongoMock.On("Count", mock2.Anything).Return(int64(2), nil)
+	mongoMock.On("Stream", mock2.Anything).Return([]model.User{{Nickname: "bob"}, {Nickname: "ann"}}, nil)
+
+	p := &replay.Replayer{Users: mongoMock, Publisher: publisher}
+	j := &model.Job{}
+
+	assert.Nil(t, p.Run(context.Background(), &model.ReplayRequest{Source: model.ReplaySnapshot}, 0, job.NewProgress(j)))
+	assert.Len(t, publisher.replayed, 2)
+	publisher.AssertNotCalled(t, "Publish", mock2.Anything, mock2.Anything)
+}
@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"errors"
+	"github.com/bernardoms/user-api/internal/cache"
+	"github.com/bernardoms/user-api/internal/model"
+	"github.com/bernardoms/user-api/internal/repository"
+	"github.com/bernardoms/user-api/test/unit/mock"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+// responseOf is the user as answered by the api.
+func responseOf(user *model.User) *model.UserResponse {
+	response := model.NewUserResponse(user)
+	return &response
+}
+
+func newUser() *model.User {
+	id, _ := primitive.ObjectIDFromHex("5ea7208049e00ddb76994ede")
+	return &model.User{Id: id, Nickname: "test1", Password: "password", LastName: "lastName", FirstName: "firstName", Country: "UK", Email: "test@test.com"}
+}
+
+func TestFindByNicknameReadsThrough(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil).Once()
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+
+	first, err1 := c.FindResponseByNickname("test1")
+	second, err2 := c.FindResponseByNickname("test1")
+
+	assert.Nil(t, err1)
+	assert.Nil(t, err2)
+	assert.Equal(t, responseOf(newUser()), first)
+	assert.Equal(t, responseOf(newUser()), second)
+	assert.Equal(t, model.CacheStats{Hits: 1, Misses: 1, HitRatio: 0.5}, c.Stats())
+	mongoMock.AssertNumberOfCalls(t, "FindByNickname", 1)
+}
+
+func TestFindByNicknameCachesNotFound(t *testing.T) {
+	var notFound *model.User
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil).Once()
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+
+	first, _ := c.FindResponseByNickname("test1")
+	second, _ := c.FindResponseByNickname("test1")
+
+	assert.Nil(t, first)
+	assert.Nil(t, second)
+	assert.Equal(t, uint64(1), c.Stats().NegativeHits)
+	mongoMock.AssertNumberOfCalls(t, "FindByNickname", 1)
+}
+
+func TestFindByNicknameWithoutNegativeTTL(t *testing.T) {
+	var notFound *model.User
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil)
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, 0)
+
+	_, _ = c.FindResponseByNickname("test1")
+	_, _ = c.FindResponseByNickname("test1")
+
+	mongoMock.AssertNumberOfCalls(t, "FindByNickname", 2)
+}
+
+func TestFindByNicknameDoesNotCacheErrors(t *testing.T) {
+	var notFound *model.User
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(notFound, errors.New("error on mongo"))
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+
+	_, err := c.FindResponseByNickname("test1")
+	_, _ = c.FindResponseByNickname("test1")
+
+	assert.EqualError(t, err, "error on mongo")
+	mongoMock.AssertNumberOfCalls(t, "FindByNickname", 2)
+}
+
+func TestFindByNicknameBypassesFailingCache(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil)
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewRedis("127.0.0.1:1", "", 0, 50*time.Millisecond, 1), time.Minute, time.Minute)
+
+	user, err := c.FindResponseByNickname("test1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, responseOf(newUser()), user)
+	assert.Equal(t, uint64(2), c.Stats().Errors)
+}
+
+func TestSaveInvalidatesNotFound(t *testing.T) {
+	var notFound *model.User
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil).Once()
+	mongoMock.On("Save", newUser()).Return(newUser(), nil)
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil).Once()
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+
+	_, _ = c.FindResponseByNickname("test1")
+	_, _ = c.Save(newUser())
+	user, _ := c.FindResponseByNickname("test1")
+
+	assert.Equal(t, responseOf(newUser()), user)
+}
+
+func TestUpdateInvalidatesOldAndNewNickname(t *testing.T) {
+	renamed := newUser()
+	renamed.Nickname = "test2"
+
+	var notFound *model.User
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil).Once()
+	mongoMock.On("FindByNickname", "test2").Return(notFound, nil).Once()
+	mongoMock.On("UpdateByNickname", "test1", renamed).Return(int64(1), nil)
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil).Once()
+	mongoMock.On("FindByNickname", "test2").Return(renamed, nil).Once()
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+
+	_, _ = c.FindResponseByNickname("test1")
+	_, _ = c.FindResponseByNickname("test2")
+	_, _ = c.UpdateByNickname("test1", renamed)
+	old, _ := c.FindResponseByNickname("test1")
+	current, _ := c.FindResponseByNickname("test2")
+
+	assert.Nil(t, old)
+	assert.Equal(t, responseOf(renamed), current)
+}
+
+func TestFindAndUpdateInvalidatesOldAndNewNickname(t *testing.T) {
+	renamed := newUser()
+	renamed.Nickname = "test2"
+
+	var notFound *model.User
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil).Once()
+	mongoMock.On("FindByNickname", "test2").Return(notFound, nil).Once()
+	mongoMock.On("FindAndUpdateByNickname", "test1", renamed).Return(newUser(), nil)
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil).Once()
+	mongoMock.On("FindByNickname", "test2").Return(renamed, nil).Once()
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+
+	_, _ = c.FindResponseByNickname("test1")
+	_, _ = c.FindResponseByNickname("test2")
+	before, _ := c.FindAndUpdateByNickname("test1", renamed)
+	old, _ := c.FindResponseByNickname("test1")
+	current, _ := c.FindResponseByNickname("test2")
+
+	assert.Equal(t, newUser(), before)
+	assert.Nil(t, old)
+	assert.Equal(t, responseOf(renamed), current)
+}
+
+func TestDeleteInvalidates(t *testing.T) {
+	var notFound *model.User
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil).Once()
+	mongoMock.On("Delete", "test1").Return(int64(1), nil)
+	mongoMock.On("FindByNickname", "test1").Return(notFound, nil).Once()
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+
+	_, _ = c.FindResponseByNickname("test1")
+	_, _ = c.Delete("test1")
+	user, _ := c.FindResponseByNickname("test1")
+
+	assert.Nil(t, user)
+}
+
+func TestReadInFlightDuringWriteIsNotCached(t *testing.T) {
+	var c *repository.CachedRepository
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("Delete", "test1").Return(int64(1), nil)
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil).Run(func(args mock2.Arguments) {
+		// the user is deleted while it is read
+		_, _ = c.Delete("test1")
+	}).Once()
+	mongoMock.On("FindByNickname", "test1").Return((*model.User)(nil), nil)
+
+	c = repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+
+	stale, _ := c.FindResponseByNickname("test1")
+	user, _ := c.FindResponseByNickname("test1")
+	again, _ := c.FindResponseByNickname("test1")
+
+	assert.Equal(t, responseOf(newUser()), stale)
+	assert.Nil(t, user)
+	assert.Nil(t, again)
+	mongoMock.AssertNumberOfCalls(t, "FindByNickname", 3)
+}
+
+func TestFindByNicknameWithRedis(t *testing.T) {
+	server, _ := mock.NewRedisServer("")
+	defer server.Close()
+
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil).Once()
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewRedis(server.Addr, "", 0, time.Second, 2), time.Minute, time.Minute)
+
+	_, _ = c.FindResponseByNickname("test1")
+	user, err := c.FindResponseByNickname("test1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, responseOf(newUser()), user)
+	assert.Equal(t, uint64(1), c.Stats().Hits)
+}
+
+func TestInTransactionBypassesCacheAndInvalidatesWrites(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil)
+	mongoMock.On("Delete", "test1").Return(int64(1), nil)
+	mongoMock.On("InTransaction").Return(nil)
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+	_, _ = c.FindResponseByNickname("test1")
+
+	err := c.InTransaction(func(tx repository.UserRepository) error {
+		_, _ = tx.FindByNickname("test1")
+		_, err := tx.Delete("test1")
+		return err
+	})
+
+	_, _ = c.FindResponseByNickname("test1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, model.CacheStats{Misses: 2}, c.Stats())
+	mongoMock.AssertNumberOfCalls(t, "FindByNickname", 3)
+}
+
+func TestFindByNicknameIsNotCached(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil)
+
+	c := repository.NewCachedRepository(&mongoMock, cache.NewLRU(10), time.Minute, time.Minute)
+
+	_, _ = c.FindResponseByNickname("test1")
+	first, _ := c.FindByNickname("test1")
+	second, _ := c.FindByNickname("test1")
+
+	assert.Equal(t, newUser(), first)
+	assert.Equal(t, newUser(), second)
+	mongoMock.AssertNumberOfCalls(t, "FindByNickname", 3)
+}
+
+func TestCachedUserHasNoPassword(t *testing.T) {
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil)
+
+	lru := cache.NewLRU(10)
+	c := repository.NewCachedRepository(&mongoMock, lru, time.Minute, time.Minute)
+
+	_, _ = c.FindResponseByNickname("test1")
+	cached, found, _ := lru.Get("user:nickname:test1")
+
+	assert.True(t, found)
+	assert.NotContains(t, string(cached), "password")
+	assert.NotContains(t, string(cached), newUser().Password)
+}
+
+func TestReadDoesNotReplaceWriteMark(t *testing.T) {
+	lru := cache.NewLRU(10)
+
+	var c *repository.CachedRepository
+	mongoMock := mock.MongoMock{}
+	mongoMock.On("Delete", "test1").Return(int64(1), nil)
+	mongoMock.On("FindByNickname", "test1").Return(newUser(), nil).Run(func(args mock2.Arguments) {
+		// the user is deleted after the read looked at the cache and before it adds to it
+		_, _ = c.Delete("test1")
+	}).Once()
+
+	c = repository.NewCachedRepository(&mongoMock, lru, time.Minute, time.Minute)
+
+	_, _ = c.FindResponseByNickname("test1")
+	cached, _, _ := lru.Get("user:nickname:test1")
+
+	assert.Equal(t, []byte{0}, cached)
+}
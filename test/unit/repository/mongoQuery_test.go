@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"github.com/bernardoms/user-api/internal/query"
+	"github.com/bernardoms/user-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func TestMongoQuery(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	q := repository.MongoQuery(query.And{Nodes: []query.Node{
+		query.In{Field: "country", Values: []string{"UK", "BR"}},
+		query.Not{Node: query.In{Field: "nickname", Values: []string{"bob"}}},
+		query.Or{Nodes: []query.Node{
+			query.Prefix{Field: "email", Value: "bob.s+"},
+			query.Range{Field: "createdAt", From: &from},
+		}},
+	}})
+
+	assert.Equal(t, bson.M{"$and": bson.A{
+		bson.M{"country": bson.M{"$in": []string{"UK", "BR"}}},
+		bson.M{"$nor": bson.A{bson.M{"nickname": "bob"}}},
+		bson.M{"$or": bson.A{
+			bson.M{"email": primitive.Regex{Pattern: `^bob\.s\+`}},
+			bson.M{"createdAt": bson.M{"$gte": from}},
+		}},
+	}}, q)
+}
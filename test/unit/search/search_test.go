@@ -0,0 +1,57 @@
+package search
+
+import (
+	"github.com/bernardoms/user-api/internal/search"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	assert.Equal(t, "jose muller", search.Fold("José Müller"))
+	assert.Equal(t, "francois", search.Fold("FRANÇOIS"))
+	assert.Equal(t, len([]rune("Ñandú")), len([]rune(search.Fold("Ñandú"))))
+}
+
+func TestPatternMatchesDiacritics(t *testing.T) {
+	re := regexp.MustCompile("(?i)" + search.Pattern("jose", search.Anywhere))
+
+	assert.True(t, re.MatchString("José"))
+	assert.True(t, re.MatchString("JOSÉ"))
+	assert.True(t, re.MatchString("Maria Jose"))
+	assert.False(t, re.MatchString("Joao"))
+}
+
+func TestPatternPrefix(t *testing.T) {
+	re := regexp.MustCompile("(?i)" + search.Pattern("mul", search.FieldStart))
+
+	assert.True(t, re.MatchString("Müller"))
+	assert.False(t, re.MatchString("Schmuller"))
+}
+
+func TestPatternEscapesQuery(t *testing.T) {
+	re := regexp.MustCompile("(?i)" + search.Pattern("a.b(", search.Anywhere))
+
+	assert.True(t, re.MatchString("xa.b(y"))
+	assert.False(t, re.MatchString("axb("))
+}
+
+func TestHighlight(t *testing.T) {
+	assert.Equal(t, "Maria <em>José</em>", search.Highlight("Maria José", []string{"jose"}, search.Anywhere))
+	assert.Equal(t, "<em>Mül</em>ler", search.Highlight("Müller", []string{"MUL"}, search.FieldStart))
+	assert.Equal(t, "", search.Highlight("Schmüller", []string{"mul"}, search.FieldStart))
+	assert.Equal(t, "Sch<em>mül</em>ler", search.Highlight("Schmüller", []string{"mul"}, search.Anywhere))
+}
+
+func TestHighlightWordStart(t *testing.T) {
+	assert.Equal(t, "<em>test</em>1@<em>test</em>.com", search.Highlight("test1@test.com", []string{"test"}, search.WordStart))
+	assert.Equal(t, "", search.Highlight("latest", []string{"test"}, search.WordStart))
+	assert.Equal(t, "<em>Ana</em> <em>Lu</em>", search.Highlight("Ana Lu", []string{"ana", "lu"}, search.WordStart))
+}
+
+func TestHighlightEscapesMarkup(t *testing.T) {
+	assert.Equal(t, "&lt;script&gt;<em>bob</em>&lt;/script&gt;",
+		search.Highlight("<script>bob</script>", []string{"bob"}, search.Anywhere))
+	assert.Equal(t, "<em>&lt;scr</em>ipt&gt;alert(&#34;x&#34;)",
+		search.Highlight(`<script>alert("x")`, []string{"<scr"}, search.FieldStart))
+}